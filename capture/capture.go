@@ -0,0 +1,189 @@
+// Package capture implements mockway's record/replay proxy modes. Recorder
+// forwards requests to a real (or stand-in) Scaleway endpoint and writes
+// each exchange to a fixture file with credentials redacted; Replayer serves
+// a directory of those fixtures back so their wire shapes can be diffed
+// against mockway's own handlers.
+package capture
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces secrets in fixtures.
+const Redacted = "REDACTED"
+
+// redactedHeaders are blanked in recorded request and response headers.
+var redactedHeaders = []string{"X-Auth-Token", "Authorization", "Cookie", "Set-Cookie"}
+
+// redactedFields are blanked wherever they appear in JSON bodies. content
+// carries downloaded files: kubeconfigs with their bearer token, and TLS
+// certificates.
+var redactedFields = map[string]bool{
+	"secret_key":    true,
+	"password":      true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"private_key":   true,
+	"client_secret": true,
+	"kubeconfig":    true,
+	"content":       true,
+}
+
+// droppedHeaders vary between runs and carry no shape information.
+var droppedHeaders = map[string]bool{
+	"Date":            true,
+	"Content-Length":  true,
+	"Connection":      true,
+	"User-Agent":      true,
+	"Accept-Encoding": true,
+}
+
+var uuidPattern = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+// Fixture is one recorded exchange as stored on disk.
+type Fixture struct {
+	Method          string            `json:"method"`
+	Path            string            `json:"path"`
+	NormalizedPath  string            `json:"normalized_path"`
+	Query           string            `json:"query,omitempty"`
+	BodyHash        string            `json:"body_hash"`
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`
+	RequestBody     json.RawMessage   `json:"request_body,omitempty"`
+	Status          int               `json:"status"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	ResponseBody    json.RawMessage   `json:"response_body,omitempty"`
+}
+
+// NormalizePath replaces UUIDs with {id} so fixtures recorded against one
+// set of resources match requests for another.
+func NormalizePath(p string) string {
+	return uuidPattern.ReplaceAllString(p, "{id}")
+}
+
+// BodyHash identifies a request body independently of key order, UUIDs and
+// redacted values. Empty bodies hash to "".
+func BodyHash(body []byte) string {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return ""
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err == nil {
+		v = normalizeValue(redactValue(v))
+		body, _ = json.Marshal(v)
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:8])
+}
+
+func normalizeValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			t[k] = normalizeValue(child)
+		}
+		return t
+	case []any:
+		for i, child := range t {
+			t[i] = normalizeValue(child)
+		}
+		return t
+	case string:
+		return uuidPattern.ReplaceAllString(t, "{id}")
+	default:
+		return v
+	}
+}
+
+func redactValue(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			if redactedFields[k] {
+				if _, isString := child.(string); isString {
+					t[k] = Redacted
+					continue
+				}
+			}
+			t[k] = redactValue(child)
+		}
+		return t
+	case []any:
+		for i, child := range t {
+			t[i] = redactValue(child)
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+// RedactBody returns body with secret fields blanked. Bodies that are not
+// JSON are stored as a JSON string.
+func RedactBody(body []byte) json.RawMessage {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		quoted, _ := json.Marshal(string(body))
+		return quoted
+	}
+	out, _ := json.Marshal(redactValue(v))
+	return out
+}
+
+// rawBody turns a fixture body back into wire bytes.
+func rawBody(m json.RawMessage) []byte {
+	if len(m) == 0 {
+		return nil
+	}
+	var s string
+	if m[0] == '"' && json.Unmarshal(m, &s) == nil {
+		return []byte(s)
+	}
+	return m
+}
+
+func recordHeaders(h http.Header) map[string]string {
+	out := map[string]string{}
+	for k, vs := range h {
+		k = http.CanonicalHeaderKey(k)
+		if droppedHeaders[k] || len(vs) == 0 {
+			continue
+		}
+		out[k] = strings.Join(vs, ", ")
+	}
+	for _, k := range redactedHeaders {
+		if _, ok := out[k]; ok {
+			out[k] = Redacted
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// fileName builds a readable, ordered fixture name such as
+// 0003_POST_instance_v1_zones_fr-par-1_servers.json.
+func fileName(seq int, method, normalizedPath string) string {
+	slug := strings.Trim(normalizedPath, "/")
+	slug = strings.NewReplacer("/", "_", "{", "", "}", "").Replace(slug)
+	if len(slug) > 100 {
+		slug = slug[:100]
+	}
+	if slug == "" {
+		slug = "root"
+	}
+	return fmt.Sprintf("%04d_%s_%s.json", seq, method, slug)
+}
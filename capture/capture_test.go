@@ -0,0 +1,142 @@
+package capture_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/redscaresu/mockway/capture"
+	"github.com/redscaresu/mockway/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func do(t *testing.T, base, method, path string, body any) (*http.Response, map[string]any) {
+	t.Helper()
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		require.NoError(t, err)
+	}
+	req, err := http.NewRequest(method, base+path, bytes.NewReader(payload))
+	require.NoError(t, err)
+	req.Header.Set("X-Auth-Token", "super-secret-token")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	out := map[string]any{}
+	if resp.StatusCode != http.StatusNoContent {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+	}
+	return resp, out
+}
+
+// TestRecordAndReplay records against mockway itself as the stand-in
+// upstream, then replays the fixtures without it.
+func TestRecordAndReplay(t *testing.T) {
+	upstream, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+	dir := t.TempDir()
+
+	rec, err := capture.NewRecorder(upstream.URL, dir)
+	require.NoError(t, err)
+	proxy := httptest.NewServer(rec)
+	defer proxy.Close()
+
+	_, app := do(t, proxy.URL, http.MethodPost, "/iam/v1alpha1/applications", map[string]any{"name": "rec"})
+	resp, key := do(t, proxy.URL, http.MethodPost, "/iam/v1alpha1/api-keys", map[string]any{"application_id": app["id"]})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotEqual(t, capture.Redacted, key["secret_key"], "the client still gets the real secret")
+
+	_, vpc := do(t, proxy.URL, http.MethodPost, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "v1"})
+	vpcPath := "/vpc/v2/regions/fr-par/vpcs/" + vpc["id"].(string)
+	do(t, proxy.URL, http.MethodGet, vpcPath, nil)
+	do(t, proxy.URL, http.MethodPatch, vpcPath, map[string]any{"name": "v2"})
+	do(t, proxy.URL, http.MethodGet, vpcPath, nil)
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	require.NoError(t, err)
+	require.Len(t, names, 6)
+	require.Equal(t, "0002_POST_iam_v1alpha1_api-keys.json", filepath.Base(names[1]))
+	require.Equal(t, "0004_GET_vpc_v2_regions_fr-par_vpcs_id.json", filepath.Base(names[3]))
+
+	raw, err := os.ReadFile(names[1])
+	require.NoError(t, err)
+	require.NotContains(t, string(raw), "super-secret-token")
+	require.NotContains(t, string(raw), key["secret_key"].(string))
+	var f capture.Fixture
+	require.NoError(t, json.Unmarshal(raw, &f))
+	require.Equal(t, capture.Redacted, f.RequestHeaders["X-Auth-Token"])
+	require.Equal(t, "/iam/v1alpha1/api-keys", f.NormalizedPath)
+
+	rp, err := capture.NewReplayer(dir)
+	require.NoError(t, err)
+	replay := httptest.NewServer(rp)
+	defer replay.Close()
+
+	resp, got := do(t, replay.URL, http.MethodPost, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "v1"})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "exact", resp.Header.Get(capture.ReplayHeader))
+	require.Equal(t, vpc, got)
+
+	// Different UUID, same operation: served in recording order, last repeats.
+	otherPath := "/vpc/v2/regions/fr-par/vpcs/11111111-2222-3333-4444-555555555555"
+	_, got = do(t, replay.URL, http.MethodGet, otherPath, nil)
+	require.Equal(t, "v1", got["name"])
+	_, got = do(t, replay.URL, http.MethodGet, otherPath, nil)
+	require.Equal(t, "v2", got["name"])
+	_, got = do(t, replay.URL, http.MethodGet, otherPath, nil)
+	require.Equal(t, "v2", got["name"])
+
+	resp, _ = do(t, replay.URL, http.MethodPost, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "other"})
+	require.Equal(t, "path", resp.Header.Get(capture.ReplayHeader))
+
+	resp, got = do(t, replay.URL, http.MethodGet, "/lb/v1/zones/fr-par-1/lbs", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Equal(t, "miss", resp.Header.Get(capture.ReplayHeader))
+	require.True(t, strings.HasPrefix(got["message"].(string), "no recorded fixture"))
+}
+
+func TestRecordRedactsKubeconfig(t *testing.T) {
+	upstream, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+	dir := t.TempDir()
+
+	rec, err := capture.NewRecorder(upstream.URL, dir)
+	require.NoError(t, err)
+	proxy := httptest.NewServer(rec)
+	defer proxy.Close()
+
+	_, cluster := do(t, proxy.URL, http.MethodPost, "/k8s/v1/regions/fr-par/clusters", map[string]any{"name": "k"})
+	resp, kubeconfig := do(t, proxy.URL, http.MethodGet, "/k8s/v1/regions/fr-par/clusters/"+cluster["id"].(string)+"/kubeconfig", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	content := kubeconfig["content"].(string)
+	require.NotEqual(t, capture.Redacted, content, "the client still gets the real kubeconfig")
+
+	names, err := filepath.Glob(filepath.Join(dir, "*_kubeconfig.json"))
+	require.NoError(t, err)
+	require.Len(t, names, 1)
+	raw, err := os.ReadFile(names[0])
+	require.NoError(t, err)
+	require.NotContains(t, string(raw), content)
+	var f capture.Fixture
+	require.NoError(t, json.Unmarshal(raw, &f))
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(f.ResponseBody, &body))
+	require.Equal(t, capture.Redacted, body["content"])
+	require.Equal(t, "kubeconfig", body["name"])
+}
+
+func TestBodyHashIgnoresKeyOrderAndIDs(t *testing.T) {
+	a := capture.BodyHash([]byte(`{"name":"x","vpc_id":"11111111-1111-1111-1111-111111111111"}`))
+	b := capture.BodyHash([]byte(`{"vpc_id":"22222222-2222-2222-2222-222222222222","name":"x"}`))
+	require.Equal(t, a, b)
+	require.NotEqual(t, a, capture.BodyHash([]byte(`{"name":"y"}`)))
+	require.Equal(t, "", capture.BodyHash(nil))
+}
@@ -0,0 +1,115 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Recorder is a reverse proxy that writes every exchange with the upstream
+// to dir as a Fixture. Credentials are forwarded upstream untouched but
+// never written to disk.
+type Recorder struct {
+	dir   string
+	proxy *httputil.ReverseProxy
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewRecorder proxies to upstream and records into dir, creating it if
+// needed. Numbering continues after any fixtures already in dir.
+func NewRecorder(upstream, dir string) (*Recorder, error) {
+	target, err := url.Parse(upstream)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("invalid upstream URL %q", upstream)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create record dir: %w", err)
+	}
+	existing, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	rec := &Recorder{dir: dir, seq: len(existing)}
+	rec.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			// Ask for plain bodies so fixtures stay readable.
+			pr.Out.Header.Del("Accept-Encoding")
+		},
+	}
+	return rec, nil
+}
+
+// capturingWriter keeps a copy of what the proxy sends back to the client.
+type capturingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *capturingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var reqBody []byte
+	if r.Body != nil {
+		reqBody, _ = io.ReadAll(r.Body)
+		_ = r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+	cw := &capturingWriter{ResponseWriter: w}
+	rec.proxy.ServeHTTP(cw, r)
+
+	f := Fixture{
+		Method:          r.Method,
+		Path:            r.URL.Path,
+		NormalizedPath:  NormalizePath(r.URL.Path),
+		Query:           r.URL.RawQuery,
+		BodyHash:        BodyHash(reqBody),
+		RequestHeaders:  recordHeaders(r.Header),
+		RequestBody:     RedactBody(reqBody),
+		Status:          cw.status,
+		ResponseHeaders: recordHeaders(cw.Header()),
+		ResponseBody:    RedactBody(cw.body.Bytes()),
+	}
+	if err := rec.write(f); err != nil {
+		// The client already has its response; a failed write only loses
+		// the fixture.
+		log.Printf("[record] %s %s: %v", r.Method, r.URL.Path, err)
+	}
+}
+
+func (rec *Recorder) write(f Fixture) error {
+	rec.mu.Lock()
+	rec.seq++
+	name := fileName(rec.seq, f.Method, f.NormalizedPath)
+	rec.mu.Unlock()
+
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(rec.dir, name), append(b, '\n'), 0o644)
+}
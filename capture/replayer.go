@@ -0,0 +1,111 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ReplayHeader tells the client how a replayed response was matched:
+// "exact" (method + path + body), "path" (method + path, body differed)
+// or "miss".
+const ReplayHeader = "X-Mockway-Replay"
+
+// Replayer serves recorded fixtures. Requests are matched by method,
+// normalized path and request body hash, falling back to method + path.
+// When several fixtures share a key they are served in recording order,
+// the last one repeating, so polling sequences replay faithfully.
+type Replayer struct {
+	byBody map[string][]*Fixture
+	byPath map[string][]*Fixture
+
+	mu     sync.Mutex
+	served map[string]int
+}
+
+// NewReplayer loads every fixture in dir.
+func NewReplayer(dir string) (*Replayer, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no fixtures in %s", dir)
+	}
+	sort.Strings(names)
+	rp := &Replayer{byBody: map[string][]*Fixture{}, byPath: map[string][]*Fixture{}, served: map[string]int{}}
+	for _, name := range names {
+		raw, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		f := &Fixture{}
+		if err := json.Unmarshal(raw, f); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", filepath.Base(name), err)
+		}
+		if f.NormalizedPath == "" {
+			f.NormalizedPath = NormalizePath(f.Path)
+		}
+		pathKey := f.Method + " " + f.NormalizedPath
+		rp.byPath[pathKey] = append(rp.byPath[pathKey], f)
+		rp.byBody[pathKey+" "+f.BodyHash] = append(rp.byBody[pathKey+" "+f.BodyHash], f)
+	}
+	return rp, nil
+}
+
+// next returns the fixture to serve for key and advances its sequence.
+func (rp *Replayer) next(key string, candidates []*Fixture) *Fixture {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	i := rp.served[key]
+	if i >= len(candidates) {
+		i = len(candidates) - 1
+	}
+	rp.served[key]++
+	return candidates[i]
+}
+
+func (rp *Replayer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+	}
+	pathKey := r.Method + " " + NormalizePath(r.URL.Path)
+	bodyKey := pathKey + " " + BodyHash(body)
+
+	var f *Fixture
+	match := "exact"
+	if fs := rp.byBody[bodyKey]; len(fs) > 0 {
+		f = rp.next(bodyKey, fs)
+	} else if fs := rp.byPath[pathKey]; len(fs) > 0 {
+		f = rp.next(pathKey, fs)
+		match = "path"
+	}
+	if f == nil {
+		w.Header().Set(ReplayHeader, "miss")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"message": fmt.Sprintf("no recorded fixture for %s %s", r.Method, r.URL.Path),
+			"type":    "not_found",
+		})
+		return
+	}
+	for k, v := range f.ResponseHeaders {
+		if v != Redacted {
+			w.Header().Set(k, v)
+		}
+	}
+	w.Header().Set(ReplayHeader, match)
+	status := f.Status
+	if status == 0 {
+		status = http.StatusBadGateway
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(rawBody(f.ResponseBody))
+}
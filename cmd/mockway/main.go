@@ -6,11 +6,13 @@ import (
 	"log"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/redscaresu/mockway/capture"
 	"github.com/redscaresu/mockway/handlers"
 	"github.com/redscaresu/mockway/repository"
 )
@@ -25,29 +27,193 @@ func run() error {
 	port := flag.Int("port", 8080, "HTTP port")
 	dbPath := flag.String("db", ":memory:", "SQLite database path")
 	echoOnly := flag.Bool("echo", false, "Run catch-all echo server for provider path discovery")
+	lifecycleDelay := flag.Duration("lifecycle-delay", 0, "How long long-running resources stay in transient states (0 = settle immediately)")
+	lifecycleDelays := flag.String("lifecycle-delays", "", "Per-kind lifecycle delay overrides, e.g. lb=5s,k8s_cluster=30s")
+	enforceIAM := flag.Bool("enforce-iam", false, "Require X-Auth-Token to be a stored IAM API key secret (or the admin key) and evaluate its policies")
+	iamAdminKey := flag.String("iam-admin-key", repository.DefaultIAMAdminKey, "Secret key that bypasses IAM evaluation in --enforce-iam mode")
+	quotasPath := flag.String("quotas", "", "YAML file with per-project resource quotas")
+	pricingPath := flag.String("pricing", "", "YAML file with price overrides for /mock/cost")
+	ipPools := flag.String("ip-pools", "", "Public IP pools as zone=cidr pairs, zone default sets the fallback, e.g. default=51.15.0.0/16,nl-ams-1=51.158.0.0/16")
+	var deterministic deterministicFlag
+	flag.Var(&deterministic, "deterministic", "Generate IDs and secrets from a seeded PRNG and freeze the clock (advance it with POST /mock/clock); --deterministic=N seeds it with N")
+	deterministicSeed := flag.Int64("deterministic-seed", 1, "PRNG seed for --deterministic, named so because --seed loads a state document")
+	seedPath := flag.String("seed", "", "JSON state document (as GET /mock/export returns it) to load at startup")
+	rateLimits := flag.String("rate-limit", "", "Token-bucket rate limits as key=rate[:burst], key = global, token or a service prefix, e.g. global=50:100,token=10")
+	journalSize := flag.Int("journal-size", handlers.DefaultJournalSize, "How many requests /mock/requests keeps")
+	maxSandboxes := flag.Int("max-sandboxes", repository.DefaultMaxSandboxes, "How many sandboxes may exist before creating another answers 409")
+	proxyTo := flag.String("proxy-to", "", "Forward every request to this upstream URL instead of mocking (requires --record)")
+	recordDir := flag.String("record", "", "Directory to write redacted request/response fixtures to in --proxy-to mode")
+	replayDir := flag.String("replay", "", "Serve the fixtures recorded in this directory instead of mocking")
+	validateResponses := flag.Bool("validate-responses", false, "Check JSON responses against the bundled OpenAPI specs and record violations at /mock/violations")
+	strictResponses := flag.Bool("validate-responses-strict", false, "Like --validate-responses, but answer 500 instead of a response that violates its schema")
+	strictRequests := flag.Bool("strict-requests", false, "Reject request bodies and query parameters that violate the bundled OpenAPI specs with a 400 invalid_arguments")
+	genericCRUD := flag.Bool("generic-crud", false, "Serve spec operations that have no handler with a generic CRUD engine instead of 501")
 	flag.Parse()
 
 	if *echoOnly {
 		return runEcho(*port)
 	}
+	if (*proxyTo == "") != (*recordDir == "") {
+		return fmt.Errorf("--proxy-to and --record must be used together")
+	}
+	if *proxyTo != "" {
+		rec, err := capture.NewRecorder(*proxyTo, *recordDir)
+		if err != nil {
+			return err
+		}
+		log.Printf("[record] proxying to %s, writing fixtures to %s", *proxyTo, *recordDir)
+		return serve(*port, loggedHandler(rec))
+	}
+	if *replayDir != "" {
+		rp, err := capture.NewReplayer(*replayDir)
+		if err != nil {
+			return err
+		}
+		log.Printf("[replay] serving fixtures from %s", *replayDir)
+		return serve(*port, loggedHandler(rp))
+	}
 
-	repo, err := repository.New(*dbPath)
+	var (
+		repo *repository.Repository
+		err  error
+	)
+	if deterministic.seeded {
+		*deterministicSeed = deterministic.seed
+	}
+	if deterministic.on {
+		repo, err = repository.NewDeterministic(*dbPath, *deterministicSeed)
+	} else {
+		repo, err = repository.New(*dbPath)
+	}
 	if err != nil {
 		return err
 	}
 	defer repo.Close()
+	if deterministic.on {
+		log.Printf("[deterministic] seed %d, clock frozen at %s", *deterministicSeed, repository.DeterministicEpoch.Format(time.RFC3339))
+	}
+
+	perKind, err := repository.ParseLifecycleDelays(*lifecycleDelays)
+	if err != nil {
+		return err
+	}
+	if err := repo.SetLifecycle(repository.LifecycleConfig{Default: *lifecycleDelay, PerKind: perKind}); err != nil {
+		return err
+	}
+
+	if *quotasPath != "" {
+		quotas, err := repository.LoadQuotaConfig(*quotasPath)
+		if err != nil {
+			return err
+		}
+		if err := repo.SetQuotas(quotas); err != nil {
+			return err
+		}
+	}
+
+	if *pricingPath != "" {
+		pricing, err := repository.LoadPricing(*pricingPath)
+		if err != nil {
+			return err
+		}
+		if err := repo.SetPricing(pricing); err != nil {
+			return err
+		}
+	}
+
+	pools, err := repository.ParseIPPools(*ipPools)
+	if err != nil {
+		return err
+	}
+	if err := repo.SetIPPools(pools); err != nil {
+		return err
+	}
+
+	if *seedPath != "" {
+		state, err := repository.LoadState(*seedPath)
+		if err != nil {
+			return err
+		}
+		counts, err := repo.ImportState(state, true)
+		if err != nil {
+			return fmt.Errorf("seed %s: %w", *seedPath, err)
+		}
+		total := 0
+		for _, n := range counts {
+			total += n
+		}
+		log.Printf("[seed] loaded %d resources from %s", total, *seedPath)
+	}
+
+	repo.SetIAMEnforcement(repository.IAMConfig{Enforce: *enforceIAM, AdminKey: *iamAdminKey})
+	repo.SetMaxSandboxes(*maxSandboxes)
 
 	app := handlers.NewApplication(repo)
+	app.SetJournalSize(*journalSize)
+	app.SetStrictRequests(*strictRequests)
+	app.SetGenericCRUD(*genericCRUD)
+	switch {
+	case *strictResponses:
+		_ = app.SetResponseValidation(handlers.ValidationStrict)
+	case *validateResponses:
+		_ = app.SetResponseValidation(handlers.ValidationLog)
+	}
+	limits, err := handlers.ParseRateLimits(*rateLimits)
+	if err != nil {
+		return err
+	}
+	if err := app.SetRateLimits(limits); err != nil {
+		return err
+	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	app.RegisterRoutes(r)
-	r.NotFound(handlers.UnimplementedHandler)
+	r.NotFound(app.Fallback)
 	r.MethodNotAllowed(handlers.UnimplementedHandler)
 
+	return serve(*port, r)
+}
+
+// deterministicFlag is --deterministic: a switch that also takes the seed,
+// as --deterministic=42, since --seed already names the state document.
+type deterministicFlag struct {
+	on     bool
+	seeded bool
+	seed   int64
+}
+
+func (f *deterministicFlag) IsBoolFlag() bool { return true }
+
+func (f *deterministicFlag) String() string {
+	if f == nil || !f.on {
+		return "false"
+	}
+	if f.seeded {
+		return strconv.FormatInt(f.seed, 10)
+	}
+	return "true"
+}
+
+// Set takes a seed, or true or false as a plain switch. A number is always
+// a seed, so --deterministic=0 seeds with 0 rather than turning it off.
+func (f *deterministicFlag) Set(s string) error {
+	if seed, err := strconv.ParseInt(s, 10, 64); err == nil {
+		f.on, f.seeded, f.seed = true, true, seed
+		return nil
+	}
+	on, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("expected true, false or a numeric seed")
+	}
+	f.on = on
+	return nil
+}
+
+func serve(port int, h http.Handler) error {
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", *port),
-		Handler:      r,
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      h,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 60 * time.Second,
 		IdleTimeout:  120 * time.Second,
@@ -55,6 +221,13 @@ func run() error {
 	return srv.ListenAndServe()
 }
 
+func loggedHandler(h http.Handler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.Logger)
+	r.Handle("/*", h)
+	return r
+}
+
 func runEcho(port int) error {
 	r := chi.NewRouter()
 	r.MethodFunc(http.MethodGet, "/*", logRequestAndOK)
@@ -64,14 +237,7 @@ func runEcho(port int) error {
 	r.MethodFunc(http.MethodDelete, "/*", logRequestAndOK)
 	r.MethodFunc(http.MethodHead, "/*", logRequestAndOK)
 	r.MethodFunc(http.MethodOptions, "/*", logRequestAndOK)
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", port),
-		Handler:      r,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 60 * time.Second,
-		IdleTimeout:  120 * time.Second,
-	}
-	return srv.ListenAndServe()
+	return serve(port, r)
 }
 
 func logRequestAndOK(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,39 @@
+# BROKEN: VPC's project_id set to the project's name, not its ID.
+#
+# Same autocomplete trap as security_group_name_not_id: .name and .id are
+# both string attributes, and the config reads naturally either way.
+#
+# ── Why standard tooling does not catch this ─────────────────────────────────
+#
+#   terraform validate  ✓ passes — project_id is typed as string
+#   terraform plan      ✓ passes — the reference resolves; Terraform cannot
+#                                  know the value isn't a UUID
+#
+# ── What mockway catches ──────────────────────────────────────────────────────
+#
+#   $ terraform apply
+#   ...
+#   scaleway_account_project.app: Creation complete
+#   Error: creating scaleway_vpc.broken
+#     scaleway-sdk-go: http error 404: not_found: referenced resource not found
+#
+#   mockway validates project_id against the Account v3 projects it holds.
+#   "example-project" (the name) never matches a project ID.
+#
+# ── Fix ───────────────────────────────────────────────────────────────────────
+#
+#   Change:
+#     project_id = scaleway_account_project.app.name   # wrong
+#   To:
+#     project_id = scaleway_account_project.app.id     # correct
+
+resource "scaleway_account_project" "app" {
+  name = "example-project"
+}
+
+resource "scaleway_vpc" "broken" {
+  name = "example-vpc"
+
+  # Wrong: .name resolves to "example-project", not a project UUID.
+  project_id = scaleway_account_project.app.name
+}
@@ -0,0 +1,8 @@
+variable "project_description" {
+  type = string
+}
+
+resource "scaleway_account_project" "app" {
+  name        = "example-project"
+  description = var.project_description
+}
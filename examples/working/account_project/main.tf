@@ -0,0 +1,31 @@
+# Happy path: dedicated project → VPC → private network inside it.
+#
+#   scaleway_vpc.vpc                  depends on  scaleway_account_project.app
+#   scaleway_vpc_private_network.pn   depends on  scaleway_vpc.vpc
+#
+# mockway checks that every project_id sent on create names a project that
+# exists (the default project 00000000-... is always present), and refuses
+# to delete a project while resources still belong to it.
+#
+# Destroy order (automatic via resource references):
+#   private network → VPC → project
+
+resource "scaleway_account_project" "app" {
+  name        = "example-project"
+  description = "Project owning the example network"
+}
+
+resource "scaleway_vpc" "vpc" {
+  name       = "example-vpc"
+  project_id = scaleway_account_project.app.id
+}
+
+resource "scaleway_vpc_private_network" "pn" {
+  name       = "example-pn"
+  vpc_id     = scaleway_vpc.vpc.id
+  project_id = scaleway_account_project.app.id
+}
+
+output "project_id" {
+  value = scaleway_account_project.app.id
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (app *Application) CreateAccountProject(w http.ResponseWriter, r *http.Request) {
+	body, err := decodeBody(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	out, err := app.repoFor(r).CreateProject(body)
+	if err != nil {
+		writeCreateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (app *Application) GetAccountProject(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "project_id")
+	out, err := app.repoFor(r).GetProject(projectID)
+	if err != nil {
+		writeDomainErrorFor(w, err, "project", projectID)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (app *Application) ListAccountProjects(w http.ResponseWriter, r *http.Request) {
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListProjects(filters...)
+	if err != nil {
+		writeDomainError(w, err)
+		return
+	}
+	writeList(w, r, "projects", items)
+}
+
+func (app *Application) UpdateAccountProject(w http.ResponseWriter, r *http.Request) {
+	body, err := decodeBody(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	projectID := chi.URLParam(r, "project_id")
+	out, err := app.repoFor(r).UpdateProject(projectID, body)
+	if err != nil {
+		writeDomainErrorFor(w, err, "project", projectID)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// DeleteAccountProject answers 409 while the project still owns resources,
+// matching the real API's "project must be empty" rule, and 412 for the
+// default project.
+func (app *Application) DeleteAccountProject(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "project_id")
+	if err := app.repoFor(r).DeleteProject(projectID); err != nil {
+		writeDomainErrorFor(w, err, "project", projectID)
+		return
+	}
+	writeNoContent(w)
+}
@@ -1,39 +1,116 @@
 package handlers
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/redscaresu/mockway/models"
+	"github.com/redscaresu/mockway/repository"
 )
 
-func (app *Application) ResetState(w http.ResponseWriter, _ *http.Request) {
-	if err := app.repo.Reset(); err != nil {
+func (app *Application) ResetState(w http.ResponseWriter, r *http.Request) {
+	if err := app.repoFor(r).Reset(); err != nil {
 		writeDomainError(w, err)
 		return
 	}
 	writeNoContent(w)
 }
 
-func (app *Application) SnapshotState(w http.ResponseWriter, _ *http.Request) {
-	if err := app.repo.Snapshot(); err != nil {
+func (app *Application) SnapshotState(w http.ResponseWriter, r *http.Request) {
+	if err := app.repoFor(r).Snapshot(); err != nil {
 		writeDomainError(w, err)
 		return
 	}
 	writeNoContent(w)
 }
 
-func (app *Application) RestoreState(w http.ResponseWriter, _ *http.Request) {
-	if err := app.repo.Restore(); err != nil {
+func (app *Application) RestoreState(w http.ResponseWriter, r *http.Request) {
+	if err := app.repoFor(r).Restore(); err != nil {
 		writeDomainError(w, err)
 		return
 	}
 	writeNoContent(w)
 }
 
-func (app *Application) GetState(w http.ResponseWriter, _ *http.Request) {
-	state, err := app.repo.FullState()
+// writeSnapshotError maps named-snapshot errors; bad names are a 400.
+func writeSnapshotError(w http.ResponseWriter, err error, name string) {
+	if errors.Is(err, repository.ErrInvalidSnapshotName) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": err.Error(), "type": "invalid_argument"})
+		return
+	}
+	writeDomainErrorFor(w, err, "snapshot", name)
+}
+
+// ListSnapshots handles GET /mock/snapshots.
+func (app *Application) ListSnapshots(w http.ResponseWriter, r *http.Request) {
+	items, err := app.repoFor(r).ListNamedSnapshots()
+	if err != nil {
+		writeDomainError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"snapshots": items, "total_count": len(items)})
+}
+
+// GetSnapshot handles GET /mock/snapshots/{name}.
+func (app *Application) GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	info, err := app.repoFor(r).GetNamedSnapshot(name)
+	if err != nil {
+		writeSnapshotError(w, err, name)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// CreateSnapshot handles POST /mock/snapshots/{name}. An existing snapshot
+// of the same name is replaced.
+func (app *Application) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	info, err := app.repoFor(r).CreateNamedSnapshot(name)
+	if err != nil {
+		writeSnapshotError(w, err, name)
+		return
+	}
+	writeJSON(w, http.StatusCreated, info)
+}
+
+// RestoreSnapshot handles POST /mock/snapshots/{name}/restore.
+func (app *Application) RestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := app.repoFor(r).RestoreNamedSnapshot(name); err != nil {
+		writeSnapshotError(w, err, name)
+		return
+	}
+	writeNoContent(w)
+}
+
+// DeleteSnapshot handles DELETE /mock/snapshots/{name}.
+func (app *Application) DeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := app.repoFor(r).DeleteNamedSnapshot(name); err != nil {
+		writeSnapshotError(w, err, name)
+		return
+	}
+	writeNoContent(w)
+}
+
+func (app *Application) GetState(w http.ResponseWriter, r *http.Request) {
+	state, err := app.repoFor(r).FullState()
+	if err != nil {
+		writeDomainError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+// ExportState handles GET /mock/export: the /mock/state document with API
+// key secrets included, so POST /mock/state?replace=true reproduces it.
+func (app *Application) ExportState(w http.ResponseWriter, r *http.Request) {
+	state, err := app.repoFor(r).ExportState()
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -41,9 +118,54 @@ func (app *Application) GetState(w http.ResponseWriter, _ *http.Request) {
 	writeJSON(w, http.StatusOK, state)
 }
 
+// ImportState handles POST /mock/state. The body is a state document as
+// GET /mock/state or GET /mock/export return it; ?replace=true wipes the
+// current state first instead of adding to it.
+func (app *Application) ImportState(w http.ResponseWriter, r *http.Request) {
+	var state map[string]any
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	counts, err := app.repoFor(r).ImportState(state, r.URL.Query().Get("replace") == "true")
+	if err != nil {
+		writeImportError(w, err)
+		return
+	}
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"resource_counts": counts, "total_resources": total})
+}
+
+// writeImportError names the offending object of a rejected state document.
+func writeImportError(w http.ResponseWriter, err error) {
+	var fe *repository.FixtureError
+	if !errors.As(err, &fe) {
+		writeDomainError(w, err)
+		return
+	}
+	body := map[string]any{"message": fe.Error(), "path": fe.Path}
+	switch {
+	case errors.Is(err, repository.ErrInvalidFixture):
+		body["type"] = "invalid_argument"
+		writeJSON(w, http.StatusBadRequest, body)
+	case errors.Is(err, models.ErrNotFound):
+		body["type"] = "not_found"
+		writeJSON(w, http.StatusNotFound, body)
+	case errors.Is(err, models.ErrConflict):
+		body["type"] = "conflict"
+		writeJSON(w, http.StatusConflict, body)
+	default:
+		writeDomainError(w, err)
+	}
+}
+
 func (app *Application) GetServiceState(w http.ResponseWriter, r *http.Request) {
 	service := chi.URLParam(r, "service")
-	state, err := app.repo.ServiceState(service)
+	state, err := app.repoFor(r).ServiceState(service)
 	if err != nil {
 		if errors.Is(err, models.ErrNotFound) {
 			writeJSON(w, http.StatusNotFound, map[string]any{"message": "unknown service", "type": "not_found"})
@@ -54,3 +176,254 @@ func (app *Application) GetServiceState(w http.ResponseWriter, r *http.Request)
 	}
 	writeJSON(w, http.StatusOK, state)
 }
+
+// GetGraph handles GET /mock/graph: every resource and the references
+// between them, as JSON (the default), Graphviz DOT or a Mermaid flowchart.
+func (app *Application) GetGraph(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "dot" && format != "mermaid" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"message": fmt.Sprintf("unknown format %q (valid: json, dot, mermaid)", format),
+			"type":    "invalid_argument",
+		})
+		return
+	}
+	g, err := app.repoFor(r).Graph()
+	if err != nil {
+		writeDomainError(w, err)
+		return
+	}
+	switch format {
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		g.WriteDOT(w)
+	case "mermaid":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		g.WriteMermaid(w)
+	default:
+		writeJSON(w, http.StatusOK, g)
+	}
+}
+
+func lifecycleBody(cfg repository.LifecycleConfig) map[string]any {
+	perKind := map[string]any{}
+	for kind, d := range cfg.PerKind {
+		perKind[kind] = d.String()
+	}
+	return map[string]any{
+		"default":  cfg.Default.String(),
+		"per_kind": perKind,
+		"kinds":    repository.LifecycleKinds(),
+	}
+}
+
+// GetLifecycle handles GET /mock/lifecycle and reports the transient-state
+// delays currently in effect.
+func (app *Application) GetLifecycle(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, lifecycleBody(app.repoFor(r).Lifecycle()))
+}
+
+// SetLifecycle handles PUT /mock/lifecycle. Durations use Go syntax ("5s",
+// "1m30s"); omitted per_kind entries fall back to default. Only resources
+// created or deleted after the change pick up the new delays.
+func (app *Application) SetLifecycle(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Default string            `json:"default"`
+		PerKind map[string]string `json:"per_kind"`
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	cfg := repository.LifecycleConfig{PerKind: map[string]time.Duration{}}
+	if body.Default != "" {
+		d, err := time.ParseDuration(body.Default)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid default: " + err.Error(), "type": "invalid_argument"})
+			return
+		}
+		cfg.Default = d
+	}
+	for kind, raw := range body.PerKind {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid delay for " + kind + ": " + err.Error(), "type": "invalid_argument"})
+			return
+		}
+		cfg.PerKind[kind] = d
+	}
+	if err := app.repoFor(r).SetLifecycle(cfg); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": err.Error(), "type": "invalid_argument"})
+		return
+	}
+	writeJSON(w, http.StatusOK, lifecycleBody(app.repoFor(r).Lifecycle()))
+}
+
+// GetClock handles GET /mock/clock.
+func (app *Application) GetClock(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, app.repoFor(r).Clock())
+}
+
+// MoveClock handles POST /mock/clock: {"advance":"90s"} steps the clock
+// forward, {"now":"2025-01-02T00:00:00Z"} sets it. Lifecycle transitions
+// that fall due settle on the next read.
+func (app *Application) MoveClock(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Advance string `json:"advance"`
+		Now     string `json:"now"`
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	if body.Now != "" {
+		t, err := time.Parse(time.RFC3339Nano, body.Now)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid now: " + err.Error(), "type": "invalid_argument"})
+			return
+		}
+		app.repoFor(r).SetClock(t)
+	}
+	if body.Advance != "" {
+		d, err := time.ParseDuration(body.Advance)
+		if err != nil || d < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"message": "advance must be a non-negative duration such as 90s", "type": "invalid_argument"})
+			return
+		}
+		app.repoFor(r).AdvanceClock(d)
+	}
+	writeJSON(w, http.StatusOK, app.repoFor(r).Clock())
+}
+
+func iamBody(cfg repository.IAMConfig) map[string]any {
+	return map[string]any{"enforce": cfg.Enforce, "admin_key": cfg.AdminKey}
+}
+
+// GetIAMEnforcement handles GET /mock/iam.
+func (app *Application) GetIAMEnforcement(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, iamBody(app.repoFor(r).IAMEnforcement()))
+}
+
+// SetIAMEnforcement handles PUT /mock/iam. With enforce on, X-Auth-Token
+// must be the admin key or the secret_key of a stored IAM API key, and the
+// bearer's policies must grant the request. An omitted admin_key resets it
+// to repository.DefaultIAMAdminKey.
+func (app *Application) SetIAMEnforcement(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Enforce  bool   `json:"enforce"`
+		AdminKey string `json:"admin_key"`
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	app.repoFor(r).SetIAMEnforcement(repository.IAMConfig{Enforce: body.Enforce, AdminKey: body.AdminKey})
+	writeJSON(w, http.StatusOK, iamBody(app.repoFor(r).IAMEnforcement()))
+}
+
+func quotasBody(cfg repository.QuotaConfig) map[string]any {
+	def := cfg.Default
+	if def == nil {
+		def = map[string]int{}
+	}
+	projects := cfg.PerProject
+	if projects == nil {
+		projects = map[string]map[string]int{}
+	}
+	return map[string]any{"default": def, "projects": projects, "kinds": repository.QuotaKinds()}
+}
+
+// GetQuotas handles GET /mock/quotas.
+func (app *Application) GetQuotas(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, quotasBody(app.repoFor(r).Quotas()))
+}
+
+// SetQuotas handles PUT /mock/quotas, e.g.
+// {"default":{"instances":10},"projects":{"<project_id>":{"lbs":1}}}.
+// The body replaces the whole config; an empty object lifts every quota.
+func (app *Application) SetQuotas(w http.ResponseWriter, r *http.Request) {
+	var cfg repository.QuotaConfig
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	if err := app.repoFor(r).SetQuotas(cfg); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": err.Error(), "type": "invalid_argument"})
+		return
+	}
+	writeJSON(w, http.StatusOK, quotasBody(app.repoFor(r).Quotas()))
+}
+
+// GetIPPools handles GET /mock/ip-pools.
+func (app *Application) GetIPPools(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, app.repoFor(r).IPPools())
+}
+
+// SetIPPools handles PUT /mock/ip-pools, e.g.
+// {"default":"51.15.0.0/16","zones":{"nl-ams-1":"51.158.0.0/16"}}.
+// The body replaces the whole config; an empty object restores the default
+// pool everywhere.
+func (app *Application) SetIPPools(w http.ResponseWriter, r *http.Request) {
+	var cfg repository.IPPoolConfig
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	if err := app.repoFor(r).SetIPPools(cfg); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": err.Error(), "type": "invalid_argument"})
+		return
+	}
+	writeJSON(w, http.StatusOK, app.repoFor(r).IPPools())
+}
+
+// GetTables handles GET /mock/tables: the repository table catalog with
+// row counts.
+func (app *Application) GetTables(w http.ResponseWriter, r *http.Request) {
+	tables, err := app.repoFor(r).TableInfo()
+	if err != nil {
+		writeDomainError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tables": tables})
+}
+
+// GetCost handles GET /mock/cost: an hourly and monthly estimate of the
+// stored resources at the prices in effect.
+func (app *Application) GetCost(w http.ResponseWriter, r *http.Request) {
+	est, err := app.repoFor(r).Cost()
+	if err != nil {
+		writeDomainError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, est)
+}
+
+// GetPricing handles GET /mock/pricing.
+func (app *Application) GetPricing(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, app.repoFor(r).Pricing())
+}
+
+// SetPricing handles PUT /mock/pricing, e.g.
+// {"servers":{"DEV1-S":0.02},"public_ip":0.005}. Prices the body leaves out
+// keep their bundled value; an empty object restores every default.
+func (app *Application) SetPricing(w http.ResponseWriter, r *http.Request) {
+	var overrides repository.PricingTable
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&overrides); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	if err := app.repoFor(r).SetPricing(overrides); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": err.Error(), "type": "invalid_argument"})
+		return
+	}
+	writeJSON(w, http.StatusOK, app.repoFor(r).Pricing())
+}
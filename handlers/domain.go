@@ -12,7 +12,7 @@ func (app *Application) CreateDNSZone(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateDNSZone(body)
+	out, err := app.repoFor(r).CreateDNSZone(body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -22,7 +22,7 @@ func (app *Application) CreateDNSZone(w http.ResponseWriter, r *http.Request) {
 
 func (app *Application) GetDNSZone(w http.ResponseWriter, r *http.Request) {
 	dnsZone := chi.URLParam(r, "dns_zone")
-	out, err := app.repo.GetDNSZone(dnsZone)
+	out, err := app.repoFor(r).GetDNSZone(dnsZone)
 	if err != nil {
 		writeDomainErrorFor(w, err, "dns_zone", dnsZone)
 		return
@@ -34,7 +34,7 @@ func (app *Application) ListDNSZones(w http.ResponseWriter, r *http.Request) {
 	domain := r.URL.Query().Get("domain")
 	dnsZone := r.URL.Query().Get("dns_zone")
 
-	zones, err := app.repo.ListDNSZones(domain)
+	zones, err := app.repoFor(r).ListDNSZones(domain)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -58,10 +58,7 @@ func (app *Application) ListDNSZones(w http.ResponseWriter, r *http.Request) {
 		zones = filtered
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"dns_zones":   zones,
-		"total_count": len(zones),
-	})
+	writeList(w, r, "dns_zones", zones)
 }
 
 func (app *Application) UpdateDNSZone(w http.ResponseWriter, r *http.Request) {
@@ -71,7 +68,7 @@ func (app *Application) UpdateDNSZone(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	dnsZone := chi.URLParam(r, "dns_zone")
-	out, err := app.repo.UpdateDNSZone(dnsZone, body)
+	out, err := app.repoFor(r).UpdateDNSZone(dnsZone, body)
 	if err != nil {
 		writeDomainErrorFor(w, err, "dns_zone", dnsZone)
 		return
@@ -81,7 +78,7 @@ func (app *Application) UpdateDNSZone(w http.ResponseWriter, r *http.Request) {
 
 func (app *Application) DeleteDNSZone(w http.ResponseWriter, r *http.Request) {
 	dnsZone := chi.URLParam(r, "dns_zone")
-	if err := app.repo.DeleteDNSZone(dnsZone); err != nil {
+	if err := app.repoFor(r).DeleteDNSZone(dnsZone); err != nil {
 		writeDomainErrorFor(w, err, "dns_zone", dnsZone)
 		return
 	}
@@ -96,7 +93,7 @@ func (app *Application) PatchDomainRecords(w http.ResponseWriter, r *http.Reques
 	}
 	dnsZone := chi.URLParam(r, "dns_zone")
 	changes, _ := body["changes"].([]any)
-	records, err := app.repo.PatchDomainRecords(dnsZone, changes)
+	records, err := app.repoFor(r).PatchDomainRecords(dnsZone, changes)
 	if err != nil {
 		writeDomainErrorFor(w, err, "dns_zone", dnsZone)
 		return
@@ -106,13 +103,10 @@ func (app *Application) PatchDomainRecords(w http.ResponseWriter, r *http.Reques
 
 func (app *Application) ListDomainRecords(w http.ResponseWriter, r *http.Request) {
 	dnsZone := chi.URLParam(r, "dns_zone")
-	records, err := app.repo.ListDomainRecords(dnsZone)
+	records, err := app.repoFor(r).ListDomainRecords(dnsZone)
 	if err != nil {
 		writeDomainErrorFor(w, err, "dns_zone", dnsZone)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{
-		"records":     records,
-		"total_count": len(records),
-	})
+	writeList(w, r, "records", records)
 }
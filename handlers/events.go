@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redscaresu/mockway/repository"
+)
+
+// eventPollInterval is how often an open stream settles due lifecycle
+// transitions, so status changes arrive without anyone reading the resource.
+const eventPollInterval = 250 * time.Millisecond
+
+// eventKeepAlive is how often an idle stream sends a comment line so proxies
+// do not time it out.
+const eventKeepAlive = 15 * time.Second
+
+// publishEvents flushes the changes a request made to the event stream once
+// it has been handled, so an event never arrives before the response that
+// caused it.
+func (app *Application) publishEvents(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if err := app.repoFor(r).FlushEvents(); err != nil {
+			log.Printf("[events] flush: %v", err)
+		}
+	})
+}
+
+// StreamEvents handles GET /mock/events, a server-sent event stream of
+// resource changes. Optional filters: type and service, each a
+// comma-separated list (e.g. ?type=created,deleted&service=instance).
+func (app *Application) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	types := splitList(q.Get("type"))
+	for _, t := range types {
+		if !slices.Contains(repository.EventTypes, t) {
+			writeJSON(w, http.StatusBadRequest, map[string]any{
+				"message": fmt.Sprintf("unknown event type %q (valid: %s)", t, strings.Join(repository.EventTypes, ", ")),
+				"type":    "invalid_argument",
+			})
+			return
+		}
+	}
+	services := splitList(q.Get("service"))
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"message": "streaming unsupported", "type": "internal"})
+		return
+	}
+
+	events, cancel := app.repoFor(r).SubscribeEvents()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	poll := time.NewTicker(eventPollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-poll.C:
+			if err := app.repoFor(r).PollEvents(); err != nil {
+				log.Printf("[events] poll: %v", err)
+			}
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case ev, ok := <-events:
+			if !ok {
+				// Closed: the repository shut down or this client fell too
+				// far behind. It can reconnect and re-read /mock/state.
+				return
+			}
+			if len(types) > 0 && !slices.Contains(types, ev.Type) {
+				continue
+			}
+			if len(services) > 0 && ev.Service != "" && !slices.Contains(services, ev.Service) {
+				continue
+			}
+			b, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Type, b)
+			flusher.Flush()
+		}
+	}
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
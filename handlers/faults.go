@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// faultRule is one registered fault. Matching is on method + route pattern
+// (chi syntax: {param} matches one segment, a trailing * the rest), plus an
+// optional resource id that must appear as a path segment. The rule starts
+// firing on the nth_call-th matching request, fires with the given
+// probability, and is removed after max_hits firings.
+type faultRule struct {
+	ID          string          `json:"id"`
+	Method      string          `json:"method"`
+	Route       string          `json:"route"`
+	ResourceID  string          `json:"resource_id,omitempty"`
+	NthCall     int             `json:"nth_call,omitempty"`
+	Probability float64         `json:"probability,omitempty"`
+	Status      int             `json:"status,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	Latency     string          `json:"latency,omitempty"`
+	Abort       bool            `json:"abort,omitempty"`
+	MaxHits     int             `json:"max_hits,omitempty"`
+	Matched     int             `json:"matched"`
+	Hits        int             `json:"hits"`
+
+	latency time.Duration
+}
+
+func (f *faultRule) validate() error {
+	if f.Route == "" || !strings.HasPrefix(f.Route, "/") {
+		return fmt.Errorf("route must be a path pattern starting with /")
+	}
+	if f.Status == 0 && f.Latency == "" && !f.Abort {
+		return fmt.Errorf("one of status, latency or abort is required")
+	}
+	if f.Status != 0 && (f.Status < 100 || f.Status > 599) {
+		return fmt.Errorf("status must be a valid HTTP status code")
+	}
+	if f.Probability < 0 || f.Probability > 1 {
+		return fmt.Errorf("probability must be between 0 and 1")
+	}
+	if f.NthCall < 0 || f.MaxHits < 0 {
+		return fmt.Errorf("nth_call and max_hits must not be negative")
+	}
+	if f.Latency != "" {
+		d, err := time.ParseDuration(f.Latency)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid latency %q", f.Latency)
+		}
+		f.latency = d
+	}
+	f.Method = strings.ToUpper(f.Method)
+	return nil
+}
+
+func (f *faultRule) matches(r *http.Request) bool {
+	if f.Method != "" && f.Method != r.Method {
+		return false
+	}
+	if !matchRoute(f.Route, r.URL.Path) {
+		return false
+	}
+	if f.ResourceID == "" {
+		return true
+	}
+	for _, seg := range strings.Split(r.URL.Path, "/") {
+		if seg == f.ResourceID {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRoute matches path against a chi-style pattern.
+func matchRoute(pattern, path string) bool {
+	pp := strings.Split(strings.Trim(pattern, "/"), "/")
+	sp := strings.Split(strings.Trim(path, "/"), "/")
+	for i, p := range pp {
+		if p == "*" && i == len(pp)-1 {
+			return true
+		}
+		if i >= len(sp) {
+			return false
+		}
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			if sp[i] == "" {
+				return false
+			}
+			continue
+		}
+		if p != sp[i] {
+			return false
+		}
+	}
+	return len(pp) == len(sp)
+}
+
+// faultSet holds the registered rules in registration order.
+type faultSet struct {
+	mu    sync.Mutex
+	rules []*faultRule
+}
+
+func (s *faultSet) add(f *faultRule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, f)
+}
+
+func (s *faultSet) list() []faultRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]faultRule, 0, len(s.rules))
+	for _, f := range s.rules {
+		out = append(out, *f)
+	}
+	return out
+}
+
+func (s *faultSet) remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.rules {
+		if f.ID == id {
+			s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *faultSet) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = nil
+}
+
+// fire returns a copy of the first rule that fires for r, counting the hit
+// and expiring the rule once it reaches max_hits. roll draws the number
+// probabilistic rules compare against their probability.
+func (s *faultSet) fire(r *http.Request, roll func() float64) (faultRule, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.rules {
+		if !f.matches(r) {
+			continue
+		}
+		f.Matched++
+		if f.NthCall > 0 && f.Matched < f.NthCall {
+			continue
+		}
+		if f.Probability > 0 && roll() >= f.Probability {
+			continue
+		}
+		f.Hits++
+		if f.MaxHits > 0 && f.Hits >= f.MaxHits {
+			s.rules = append(s.rules[:i], s.rules[i+1:]...)
+		}
+		return *f, true
+	}
+	return faultRule{}, false
+}
+
+// injectFaults applies registered fault rules to every non-admin request.
+// Latency-only rules delay the request and then let it through.
+func (app *Application) injectFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/mock/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		f, ok := app.stateFor(r).faults.fire(r, app.repoFor(r).RandFloat64)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if f.latency > 0 {
+			select {
+			case <-time.After(f.latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		switch {
+		case f.Abort:
+			// Drops the connection without a response; net/http does not
+			// log ErrAbortHandler panics.
+			panic(http.ErrAbortHandler)
+		case f.Status != 0:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(f.Status)
+			if len(f.Body) > 0 {
+				_, _ = w.Write(f.Body)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"message": "fault injected by mockway",
+				"type":    "unknown_error",
+			})
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// CreateFault handles POST /mock/faults.
+func (app *Application) CreateFault(w http.ResponseWriter, r *http.Request) {
+	var f faultRule
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	if err := f.validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": err.Error(), "type": "invalid_argument"})
+		return
+	}
+	f.ID = app.repoFor(r).NewID()
+	f.Matched, f.Hits = 0, 0
+	app.stateFor(r).faults.add(&f)
+	writeJSON(w, http.StatusOK, f)
+}
+
+// ListFaults handles GET /mock/faults. Expired rules are not listed.
+func (app *Application) ListFaults(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"faults": app.stateFor(r).faults.list()})
+}
+
+// DeleteFault handles DELETE /mock/faults/{fault_id}.
+func (app *Application) DeleteFault(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "fault_id")
+	if !app.stateFor(r).faults.remove(id) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"message": fmt.Sprintf("fault %q not found", id), "type": "not_found"})
+		return
+	}
+	writeNoContent(w)
+}
+
+// ClearFaults handles DELETE /mock/faults.
+func (app *Application) ClearFaults(w http.ResponseWriter, r *http.Request) {
+	app.stateFor(r).faults.clear()
+	writeNoContent(w)
+}
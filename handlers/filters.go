@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/redscaresu/mockway/repository"
+	"github.com/redscaresu/mockway/specs"
+)
+
+// listControlParams are query parameters handled by writeList rather than
+// turned into filters.
+var listControlParams = map[string]bool{
+	"page":      true,
+	"per_page":  true,
+	"page_size": true,
+	"order_by":  true,
+	"order":     true,
+}
+
+// scopeParams are the ownership query parameters every list endpoint honours,
+// even on routes without a spec (vpc/v1, domain, block, ipam, vpc-gw).
+var scopeParams = []string{"project_id", "organization_id"}
+
+// listFilters derives repository filters from the query parameters the
+// Scaleway spec declares for the matched List operation. Only parameters that
+// name a top-level field of the listed resource are applied; anything else
+// (private_ip, without_ip, ...) is ignored as before. project_id and
+// organization_id scope the list on routes whose spec does not declare them.
+func listFilters(r *http.Request) ([]repository.Filter, error) {
+	catalog, err := specs.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	q := r.URL.Query()
+	var filters []repository.Filter
+	declared := map[string]bool{}
+	if op := catalog.Find(r.Method, r.URL.Path); op != nil {
+		if _, item, ok := op.ListItems(); ok {
+			for _, p := range op.QueryParameters() {
+				declared[p.Name] = true
+				if listControlParams[p.Name] {
+					continue
+				}
+				values := splitQueryValues(q[p.Name])
+				if len(values) == 0 {
+					continue
+				}
+				f, ok, err := filterFor(p, item, values)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					filters = append(filters, f)
+				}
+			}
+		}
+	}
+
+	for _, name := range scopeParams {
+		if declared[name] {
+			continue
+		}
+		if v := q.Get(name); v != "" {
+			filters = append(filters, repository.Filter{Field: name, Op: repository.FilterEquals, Values: []any{v}})
+		}
+	}
+	return filters, nil
+}
+
+// filterParamError marks a query parameter value that cannot be parsed; the
+// caller answers 400 instead of 500.
+type filterParamError struct{ msg string }
+
+func (e filterParamError) Error() string { return e.msg }
+
+func filterFor(p specs.Parameter, item *specs.Schema, values []string) (repository.Filter, bool, error) {
+	prop, isField := item.Properties[p.Name]
+	switch {
+	case p.Name == "name" && isField:
+		return repository.Filter{Field: "name", Op: repository.FilterContains, Values: []any{values[0]}}, true, nil
+	case isField && prop.Resolve().Type == "array":
+		return repository.Filter{Field: p.Name, Op: repository.FilterHasAll, Values: stringsToAny(values)}, true, nil
+	case isField:
+		v, err := typedQueryValue(p, values[0])
+		if err != nil {
+			return repository.Filter{}, false, err
+		}
+		return repository.Filter{Field: p.Name, Op: repository.FilterEquals, Values: []any{v}}, true, nil
+	case strings.HasSuffix(p.Name, "_ids"):
+		field := strings.TrimSuffix(p.Name, "s")
+		if _, ok := item.Properties[field]; !ok {
+			field = "id"
+		}
+		return repository.Filter{Field: field, Op: repository.FilterIn, Values: stringsToAny(values)}, true, nil
+	}
+	return repository.Filter{}, false, nil
+}
+
+func typedQueryValue(p specs.Parameter, raw string) (any, error) {
+	t := ""
+	if s := p.Schema.Resolve(); s != nil {
+		t = s.Type
+	}
+	switch t {
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, filterParamError{fmt.Sprintf("%s must be a boolean", p.Name)}
+		}
+		return b, nil
+	case "integer", "number":
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, filterParamError{fmt.Sprintf("%s must be a number", p.Name)}
+		}
+		return n, nil
+	}
+	return raw, nil
+}
+
+// splitQueryValues accepts both repeated (?tags=a&tags=b) and comma-separated
+// (?tags=a,b) forms.
+func splitQueryValues(raw []string) []string {
+	var out []string
+	for _, v := range raw {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+func stringsToAny(in []string) []any {
+	out := make([]any, len(in))
+	for i, v := range in {
+		out[i] = v
+	}
+	return out
+}
+
+// writeFilterError answers a listFilters failure.
+func writeFilterError(w http.ResponseWriter, err error) {
+	var perr filterParamError
+	if errors.As(err, &perr) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": err.Error(), "type": "invalid_argument"})
+		return
+	}
+	writeDomainError(w, err)
+}
@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/redscaresu/mockway/models"
+	"github.com/redscaresu/mockway/repository"
+	"github.com/redscaresu/mockway/specs"
+)
+
+// The generic CRUD engine answers spec operations that have no hand-written
+// route. It infers the operation's role from its operationId and path shape:
+//
+//	POST   .../widgets              Create* -> store a new object
+//	GET    .../widgets              List*   -> list the collection
+//	GET    .../widgets/{widget_id}          -> get
+//	PATCH  .../widgets/{widget_id}          -> merge the body and store
+//	PUT    .../widgets/{widget_id}          -> same as PATCH
+//	DELETE .../widgets/{widget_id}          -> delete (with sub-collections)
+//
+// Anything else (actions such as POST .../{id}/reboot, singleton reads) gets
+// a body synthesized from the response schema, overlaid with the object it
+// acts on when the generic store has one. Objects are synthesized from the
+// response schema too: request fields win, then path parameters, ids,
+// timestamps, schema defaults and zero values.
+
+// SetGenericCRUD turns the spec-driven fallback on or off for the server
+// and the sandboxes created after. When off, unregistered routes answer 501
+// as before.
+func (app *Application) SetGenericCRUD(on bool) {
+	app.state.generic.Store(on)
+}
+
+// GetGenericCRUD handles GET /mock/generic.
+func (app *Application) GetGenericCRUD(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"enabled": app.stateFor(r).generic.Load()})
+}
+
+// PutGenericCRUD handles PUT /mock/generic, e.g. {"enabled":true}.
+func (app *Application) PutGenericCRUD(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Enabled bool `json:"enabled"`
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	state := app.stateFor(r)
+	state.generic.Store(body.Enabled)
+	writeJSON(w, http.StatusOK, map[string]any{"enabled": state.generic.Load()})
+}
+
+// Fallback is the router's NotFound handler. Operations the specs declare
+// are served by the generic engine when it is enabled; everything else gets
+// UnimplementedHandler's 501. Paths that match a hand-written route with a
+// different method never reach it (chi sends those to MethodNotAllowed), so
+// hand-written resources stay authoritative.
+func (app *Application) Fallback(w http.ResponseWriter, r *http.Request) {
+	if !app.stateFor(r).generic.Load() {
+		UnimplementedHandler(w, r)
+		return
+	}
+	catalog, err := specs.Load()
+	if err != nil {
+		UnimplementedHandler(w, r)
+		return
+	}
+	op := catalog.Find(r.Method, r.URL.Path)
+	if op == nil {
+		UnimplementedHandler(w, r)
+		return
+	}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { app.serveGeneric(w, r, op) })
+	app.requireAuthToken(app.validateRequests(h)).ServeHTTP(w, r)
+}
+
+func (app *Application) serveGeneric(w http.ResponseWriter, r *http.Request, op *specs.Operation) {
+	tmpl := strings.Split(strings.Trim(op.Path, "/"), "/")
+	byID := strings.HasPrefix(tmpl[len(tmpl)-1], "{")
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	g := genericRequest{op: op, path: path, params: pathParams(tmpl, path)}
+
+	switch {
+	case r.Method == http.MethodPost && !byID && strings.HasPrefix(op.OperationID, "Create"):
+		app.genericCreate(w, r, g)
+	case r.Method == http.MethodGet && !byID && strings.HasPrefix(op.OperationID, "List"):
+		app.genericList(w, r, g)
+	case r.Method == http.MethodGet && byID:
+		app.genericGet(w, r, g)
+	case (r.Method == http.MethodPatch || r.Method == http.MethodPut) && byID:
+		app.genericUpdate(w, r, g)
+	case r.Method == http.MethodDelete && byID:
+		app.genericDelete(w, r, g)
+	default:
+		app.genericAction(w, r, g)
+	}
+}
+
+// genericRequest is what the engine knows about one request.
+type genericRequest struct {
+	op     *specs.Operation
+	path   string
+	params map[string]string
+}
+
+// pathParams maps template placeholders to the concrete path segments, e.g.
+// {"zone": "fr-par-1", "placement_group_id": "..."}.
+func pathParams(tmpl []string, path string) map[string]string {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	out := map[string]string{}
+	for i, t := range tmpl {
+		if i < len(segs) && strings.HasPrefix(t, "{") {
+			out[strings.Trim(t, "{}")] = segs[i]
+		}
+	}
+	return out
+}
+
+// successStatus is the first 2xx status the operation declares.
+func successStatus(op *specs.Operation) int {
+	for _, code := range []int{http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent} {
+		if _, ok := op.Responses[strconv.Itoa(code)]; ok {
+			return code
+		}
+	}
+	return http.StatusOK
+}
+
+// resourceSchema unwraps responses that nest the object under a single key,
+// as the Instance API does ({"placement_group": {...}}). key is "" when the
+// response is the object itself.
+func resourceSchema(s *specs.Schema) (key string, obj *specs.Schema) {
+	s = s.Resolve()
+	if s == nil {
+		return "", nil
+	}
+	if _, ok := s.Properties["id"]; ok || len(s.Properties) != 1 {
+		return "", s
+	}
+	for k, prop := range s.Properties {
+		if p := prop.Resolve(); p != nil && p.Type == "object" {
+			return k, p
+		}
+	}
+	return "", s
+}
+
+func wrap(key string, obj map[string]any) map[string]any {
+	if key == "" {
+		return obj
+	}
+	return map[string]any{key: obj}
+}
+
+// resourceName labels not_found errors with the singular resource name: the
+// key wrapping the object in the success response (placement_group), else
+// the operationId without its verb (GetCategory -> category).
+func resourceName(g genericRequest) string {
+	if key, _ := resourceSchema(g.op.SuccessResponse()); key != "" {
+		return key
+	}
+	name := g.op.OperationID
+	for _, verb := range []string{"Get", "Update", "Delete", "Set"} {
+		if rest, ok := strings.CutPrefix(name, verb); ok && rest != "" {
+			name = rest
+			break
+		}
+	}
+	if name == "" {
+		return "resource"
+	}
+	return snakeCase(name)
+}
+
+// snakeCase turns a CamelCase name into snake_case, keeping acronyms
+// together: SecurityGroupRule -> security_group_rule, DNSZone -> dns_zone.
+func snakeCase(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, c := range runes {
+		if unicode.IsUpper(c) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	return b.String()
+}
+
+func (app *Application) genericCreate(w http.ResponseWriter, r *http.Request, g genericRequest) {
+	body, err := decodeBody(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	key, schema := resourceSchema(g.op.SuccessResponse())
+	repo := app.repoFor(r)
+	id := repo.NewID()
+	obj, _ := synthesize(schema, body, g.params, id, repo.Now(), 0).(map[string]any)
+	if obj == nil {
+		obj = map[string]any{}
+	}
+	obj["id"] = id
+	if err := repo.CreateGenericResource(g.path, id, obj); err != nil {
+		writeCreateError(w, err)
+		return
+	}
+	writeJSON(w, successStatus(g.op), wrap(key, obj))
+}
+
+func (app *Application) genericList(w http.ResponseWriter, r *http.Request, g genericRequest) {
+	listKey, _, ok := g.op.ListItems()
+	if !ok {
+		app.genericAction(w, r, g)
+		return
+	}
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListGenericResources(g.path, filters...)
+	if err != nil {
+		writeDomainError(w, err)
+		return
+	}
+	writeList(w, r, listKey, items)
+}
+
+func (app *Application) genericGet(w http.ResponseWriter, r *http.Request, g genericRequest) {
+	obj, err := app.repoFor(r).GetGenericResource(g.path)
+	if err != nil {
+		writeDomainErrorFor(w, err, resourceName(g), lastSegment(g.path))
+		return
+	}
+	key, _ := resourceSchema(g.op.SuccessResponse())
+	writeJSON(w, http.StatusOK, wrap(key, obj))
+}
+
+func (app *Application) genericUpdate(w http.ResponseWriter, r *http.Request, g genericRequest) {
+	body, err := decodeBody(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	obj, err := app.repoFor(r).GetGenericResource(g.path)
+	if err != nil {
+		writeDomainErrorFor(w, err, resourceName(g), lastSegment(g.path))
+		return
+	}
+	key, schema := resourceSchema(g.op.SuccessResponse())
+	for k, v := range body {
+		if k == "id" {
+			continue
+		}
+		if schema == nil || schema.Properties[k] != nil {
+			obj[k] = v
+		}
+	}
+	if schema != nil && schema.Properties["updated_at"] != nil {
+		obj["updated_at"] = app.repoFor(r).Now().Format(time.RFC3339)
+	}
+	out, err := app.repoFor(r).UpdateGenericResource(g.path, obj)
+	if err != nil {
+		writeDomainErrorFor(w, err, resourceName(g), lastSegment(g.path))
+		return
+	}
+	writeJSON(w, http.StatusOK, wrap(key, out))
+}
+
+func (app *Application) genericDelete(w http.ResponseWriter, r *http.Request, g genericRequest) {
+	obj, err := app.repoFor(r).GetGenericResource(g.path)
+	if err == nil {
+		err = app.repoFor(r).DeleteGenericResource(g.path)
+	}
+	if err != nil {
+		writeDomainErrorFor(w, err, resourceName(g), lastSegment(g.path))
+		return
+	}
+	if successStatus(g.op) == http.StatusNoContent || g.op.SuccessResponse() == nil {
+		writeNoContent(w)
+		return
+	}
+	key, _ := resourceSchema(g.op.SuccessResponse())
+	writeJSON(w, successStatus(g.op), wrap(key, obj))
+}
+
+// genericAction answers operations that are not plain CRUD. The response is
+// synthesized from the schema, seeded with the stored object the path points
+// into (if any) and the request body.
+func (app *Application) genericAction(w http.ResponseWriter, r *http.Request, g genericRequest) {
+	body, err := decodeBody(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	overlay := map[string]any{}
+	target, err := app.repoFor(r).GenericAncestor(g.path)
+	switch {
+	case err == nil:
+		for k, v := range target {
+			overlay[k] = v
+		}
+	case !errors.Is(err, models.ErrNotFound):
+		writeDomainError(w, err)
+		return
+	}
+	for k, v := range body {
+		overlay[k] = v
+	}
+	status := successStatus(g.op)
+	schema := g.op.SuccessResponse()
+	if status == http.StatusNoContent || schema == nil {
+		writeNoContent(w)
+		return
+	}
+	key, obj := resourceSchema(schema)
+	id, _ := overlay["id"].(string)
+	if id == "" {
+		id = app.repoFor(r).NewID()
+	}
+	out, _ := synthesize(obj, overlay, g.params, id, app.repoFor(r).Now(), 0).(map[string]any)
+	writeJSON(w, status, wrap(key, out))
+}
+
+func lastSegment(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// synthesize builds a value for schema s. Object properties are taken from
+// overlay when present, otherwise invented by fillValue; timestamps are now.
+func synthesize(s *specs.Schema, overlay any, params map[string]string, id string, now time.Time, depth int) any {
+	s = s.Resolve()
+	if s == nil || depth > 8 {
+		return overlay
+	}
+	if s.Type != "object" && len(s.Properties) == 0 {
+		if overlay != nil {
+			return overlay
+		}
+		return nil
+	}
+	src, _ := overlay.(map[string]any)
+	out := map[string]any{}
+	for name, prop := range s.Properties {
+		if strings.HasPrefix(name, "<") {
+			continue
+		}
+		if v, ok := src[name]; ok {
+			out[name] = v
+			continue
+		}
+		out[name] = fillValue(name, prop.Resolve(), params, id, now, depth)
+	}
+	return out
+}
+
+// fillValue invents a plausible value for a property the client did not
+// send.
+func fillValue(name string, p *specs.Schema, params map[string]string, id string, now time.Time, depth int) any {
+	if p == nil {
+		return nil
+	}
+	switch {
+	case name == "id":
+		return id
+	case params[name] != "":
+		return params[name]
+	case name == "project_id" || name == "project":
+		return repository.DefaultProjectID
+	case name == "organization_id" || name == "organization":
+		return repository.DefaultOrganizationID
+	case p.Format == "date-time":
+		if name == "created_at" || name == "updated_at" || !p.Nullable {
+			return now.Format(time.RFC3339)
+		}
+		return nil
+	case len(p.Enum) > 0:
+		return enumValue(name, p)
+	case p.Default != nil:
+		return p.Default
+	case p.Nullable:
+		return nil
+	}
+	switch p.Type {
+	case "string":
+		return ""
+	case "integer", "number":
+		return 0
+	case "boolean":
+		return false
+	case "array":
+		return []any{}
+	case "object":
+		if len(p.Properties) == 0 {
+			return map[string]any{}
+		}
+		return synthesize(p, nil, params, "", now, depth+1)
+	}
+	return nil
+}
+
+// enumValue picks the schema default, "ready" for status fields, or the
+// first value that is not an unknown_* placeholder.
+func enumValue(name string, p *specs.Schema) any {
+	if p.Default != nil && !strings.HasPrefix(toString(p.Default), "unknown") {
+		return p.Default
+	}
+	if name == "status" || name == "state" {
+		for _, v := range p.Enum {
+			if v == "ready" {
+				return v
+			}
+		}
+	}
+	for _, v := range p.Enum {
+		if !strings.HasPrefix(toString(v), "unknown") {
+			return v
+		}
+	}
+	return p.Enum[0]
+}
+
+func toString(v any) string {
+	s, _ := v.(string)
+	return s
+}
@@ -1,11 +1,18 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/redscaresu/mockway/models"
@@ -13,23 +20,110 @@ import (
 )
 
 type Application struct {
-	repo *repository.Repository
+	repo    *repository.Repository
+	state   *mockState
+	metrics *httpMetrics
+
+	sandboxMu     sync.Mutex
+	sandboxStates map[string]*mockState
+}
+
+// mockState is what the admin routes configure and record besides stored
+// resources and metrics: fault rules, rate limits, the request journal, the
+// generic engine toggle and validation. The server has one and so does
+// each sandbox; stateFor picks the request's.
+type mockState struct {
+	faults  *faultSet
+	limiter *rateLimiter
+	journal *journal
+	generic atomic.Bool
+
+	validation *validationState
+
+	// retryMu lets one request at a time retry a transaction that lost
+	// to a concurrent writer (see transact).
+	retryMu sync.Mutex
 }
 
 func NewApplication(repo *repository.Repository) *Application {
-	return &Application{repo: repo}
+	return &Application{
+		repo:          repo,
+		state:         newMockState(DefaultJournalSize),
+		metrics:       newHTTPMetrics(),
+		sandboxStates: map[string]*mockState{},
+	}
+}
+
+func newMockState(journalSize int) *mockState {
+	return &mockState{
+		faults:  &faultSet{},
+		limiter: &rateLimiter{buckets: map[string]*bucket{}},
+		journal: newJournal(journalSize),
+
+		validation: &validationState{responses: ValidationOff},
+	}
 }
 
 func (app *Application) RegisterRoutes(r chi.Router) {
+	r.Use(app.selectSandbox)
+	r.Use(app.collectMetrics)
+	r.Use(app.publishEvents)
+	r.Use(app.recordRequests)
+	r.Use(app.injectFaults)
+	r.Use(app.limitRate)
+	r.Use(app.transact)
+	r.Use(app.validateResponses)
+
 	// Admin routes do not require auth.
 	r.Post("/mock/reset", app.ResetState)
 	r.Post("/mock/snapshot", app.SnapshotState)
 	r.Post("/mock/restore", app.RestoreState)
+	r.Get("/mock/snapshots", app.ListSnapshots)
+	r.Get("/mock/snapshots/{name}", app.GetSnapshot)
+	r.Post("/mock/snapshots/{name}", app.CreateSnapshot)
+	r.Post("/mock/snapshots/{name}/restore", app.RestoreSnapshot)
+	r.Delete("/mock/snapshots/{name}", app.DeleteSnapshot)
 	r.Get("/mock/state", app.GetState)
+	r.Post("/mock/state", app.ImportState)
+	r.Get("/mock/export", app.ExportState)
 	r.Get("/mock/state/{service}", app.GetServiceState)
+	r.Get("/mock/graph", app.GetGraph)
+	r.Get("/mock/tables", app.GetTables)
+	r.Get("/mock/sandboxes", app.ListSandboxes)
+	r.Delete("/mock/sandboxes/{name}", app.DeleteSandbox)
+	r.Get("/mock/lifecycle", app.GetLifecycle)
+	r.Put("/mock/lifecycle", app.SetLifecycle)
+	r.Get("/mock/events", app.StreamEvents)
+	r.Get("/mock/metrics", app.Metrics)
+	r.Get("/mock/clock", app.GetClock)
+	r.Post("/mock/clock", app.MoveClock)
+	r.Get("/mock/iam", app.GetIAMEnforcement)
+	r.Put("/mock/iam", app.SetIAMEnforcement)
+	r.Get("/mock/quotas", app.GetQuotas)
+	r.Put("/mock/quotas", app.SetQuotas)
+	r.Get("/mock/ip-pools", app.GetIPPools)
+	r.Put("/mock/ip-pools", app.SetIPPools)
+	r.Get("/mock/cost", app.GetCost)
+	r.Get("/mock/pricing", app.GetPricing)
+	r.Put("/mock/pricing", app.SetPricing)
+	r.Post("/mock/faults", app.CreateFault)
+	r.Get("/mock/faults", app.ListFaults)
+	r.Delete("/mock/faults", app.ClearFaults)
+	r.Delete("/mock/faults/{fault_id}", app.DeleteFault)
+	r.Get("/mock/ratelimit", app.GetRateLimits)
+	r.Put("/mock/ratelimit", app.PutRateLimits)
+	r.Get("/mock/requests", app.ListRequests)
+	r.Delete("/mock/requests", app.ClearRequests)
+	r.Get("/mock/validation", app.GetValidation)
+	r.Put("/mock/validation", app.PutValidation)
+	r.Get("/mock/violations", app.ListViolations)
+	r.Delete("/mock/violations", app.ClearViolations)
+	r.Get("/mock/generic", app.GetGenericCRUD)
+	r.Put("/mock/generic", app.PutGenericCRUD)
 
 	r.Group(func(r chi.Router) {
 		r.Use(app.requireAuthToken)
+		r.Use(app.validateRequests)
 
 		r.Route("/marketplace/v2", func(r chi.Router) {
 			r.Get("/local-images", app.ListMarketplaceLocalImages)
@@ -443,6 +537,14 @@ func (app *Application) RegisterRoutes(r chi.Router) {
 			r.Get("/dns-zones/{dns_zone}/records", app.ListDomainRecords)
 		})
 
+		r.Route("/account/v3", func(r chi.Router) {
+			r.Post("/projects", app.CreateAccountProject)
+			r.Get("/projects", app.ListAccountProjects)
+			r.Get("/projects/{project_id}", app.GetAccountProject)
+			r.Patch("/projects/{project_id}", app.UpdateAccountProject)
+			r.Delete("/projects/{project_id}", app.DeleteAccountProject)
+		})
+
 		// Legacy alias for scaleway_account_ssh_key.
 		r.Route("/account/v2alpha1", func(r chi.Router) {
 			r.Post("/ssh-keys", app.CreateIAMSSHKey)
@@ -455,17 +557,108 @@ func (app *Application) RegisterRoutes(r chi.Router) {
 
 func (app *Application) requireAuthToken(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("X-Auth-Token") == "" {
+		token := r.Header.Get("X-Auth-Token")
+		if token == "" {
 			writeJSON(w, http.StatusUnauthorized, map[string]any{
 				"message": "missing or empty X-Auth-Token",
 				"type":    "denied_authentication",
 			})
 			return
 		}
+		if repo := app.repoFor(r); repo.IAMEnforcement().Enforce {
+			req, err := accessRequest(repo, r)
+			var projects []string
+			if err == nil {
+				projects, err = repo.Authorize(token, req)
+			}
+			var denied *repository.PermissionDeniedError
+			switch {
+			case errors.Is(err, models.ErrUnauthenticated):
+				writeJSON(w, http.StatusUnauthorized, map[string]any{
+					"message": "invalid X-Auth-Token",
+					"type":    "denied_authentication",
+				})
+				return
+			case errors.As(err, &denied):
+				writeJSON(w, http.StatusForbidden, map[string]any{
+					"message": "insufficient permissions",
+					"type":    "permissions_denied",
+					"details": []any{map[string]any{"resource": denied.Product, "action": denied.Action}},
+				})
+				return
+			case err != nil:
+				writeDomainError(w, err)
+				return
+			}
+			if projects != nil {
+				r = r.WithContext(context.WithValue(r.Context(), allowedProjectsKey{}, projects))
+			}
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// allowedProjectsKey holds the projects a bearer's project-scoped rules
+// confine a read to; writeList drops resources of any other project.
+type allowedProjectsKey struct{}
+
+// inProject reports whether item belongs to one of projects. A resource
+// naming no project is the default project's, as quotas count it; entries
+// without an id, such as catalog ones, belong to every project.
+func inProject(item any, projects []string) bool {
+	obj, ok := item.(map[string]any)
+	if !ok {
+		return true
+	}
+	for _, key := range []string{"project_id", "project"} {
+		if id, _ := obj[key].(string); id != "" {
+			return slices.Contains(projects, id)
+		}
+	}
+	if _, ok := obj["id"]; !ok {
+		return true
+	}
+	return slices.Contains(projects, repository.DefaultProjectID)
+}
+
+// accessRequest describes r for IAM evaluation: the product is the first
+// path segment and anything but GET/HEAD is a write. A request whose path
+// names an existing resource is in that resource's project, whatever the
+// query or body say; only creates and collection lists take the project
+// from the project_id or project query parameter or body field.
+func accessRequest(repo *repository.Repository, r *http.Request) (repository.AccessRequest, error) {
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	req := repository.AccessRequest{
+		Product: segments[0],
+		Write:   r.Method != http.MethodGet && r.Method != http.MethodHead,
+	}
+	project, err := repo.ResourceProject(segments[1:]...)
+	if err != nil || project != "" {
+		req.ProjectID = project
+		return req, err
+	}
+	req.ProjectID = r.URL.Query().Get("project_id")
+	if req.ProjectID == "" {
+		req.ProjectID = r.URL.Query().Get("project")
+	}
+	if req.ProjectID == "" && r.Body != nil && req.Write {
+		raw, err := io.ReadAll(r.Body)
+		_ = r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(raw))
+		if err == nil {
+			var body map[string]any
+			if json.Unmarshal(raw, &body) == nil {
+				if id, ok := body["project_id"].(string); ok {
+					req.ProjectID = id
+				} else if id, ok := body["project"].(string); ok {
+					req.ProjectID = id
+				}
+			}
+		}
+	}
+	return req, nil
+}
+
 func decodeBody(r *http.Request) (map[string]any, error) {
 	defer r.Body.Close()
 	if r.Body == nil {
@@ -494,10 +687,24 @@ func writeNoContent(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func writeList(w http.ResponseWriter, key string, items []map[string]any) {
+// writeList writes a Scaleway list response: the requested page of items
+// under key, plus the pre-pagination total in both total_count and the
+// X-Total-Count header (used by the instance API and scaleway-sdk-go's
+// auto-pagination).
+func writeList[T any](w http.ResponseWriter, r *http.Request, key string, items []T) {
+	p, err := parsePageParams(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": err.Error(), "type": "invalid_argument"})
+		return
+	}
+	if allowed, ok := r.Context().Value(allowedProjectsKey{}).([]string); ok {
+		items = slices.DeleteFunc(slices.Clone(items), func(item T) bool { return !inProject(item, allowed) })
+	}
+	total := len(items)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	writeJSON(w, http.StatusOK, map[string]any{
-		key:           items,
-		"total_count": len(items),
+		key:           paginate(items, p),
+		"total_count": total,
 	})
 }
 
@@ -510,7 +717,21 @@ func writeDomainError(w http.ResponseWriter, err error) {
 // those into Error() formatting; without them the SDK formats as
 // "resource  with ID  is not found" (note the empty fields).
 func writeDomainErrorFor(w http.ResponseWriter, err error, resource, resourceID string) {
+	var quota *repository.QuotaExceededError
+	var stock *repository.OutOfStockError
 	switch {
+	case errors.As(err, &quota):
+		writeJSON(w, http.StatusForbidden, map[string]any{
+			"message": "quota(s) exceeded for this resource",
+			"type":    "quotas_exceeded",
+			"details": []any{map[string]any{"resource": quota.Resource, "quota": quota.Quota, "current": quota.Current}},
+		})
+	case errors.As(err, &stock):
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"message":  stock.Error(),
+			"type":     "out_of_stock",
+			"resource": "ip",
+		})
 	case errors.Is(err, models.ErrNotFound):
 		body := map[string]any{"type": "not_found"}
 		if resource != "" && resourceID != "" {
@@ -521,8 +742,12 @@ func writeDomainErrorFor(w http.ResponseWriter, err error, resource, resourceID
 			body["message"] = "resource not found"
 		}
 		writeJSON(w, http.StatusNotFound, body)
+	case errors.Is(err, models.ErrConcurrentUpdate):
+		writeJSON(w, http.StatusConflict, map[string]any{"message": models.ErrConcurrentUpdate.Error(), "type": "conflict"})
 	case errors.Is(err, models.ErrConflict):
 		writeJSON(w, http.StatusConflict, map[string]any{"message": "cannot delete: dependents exist", "type": "conflict"})
+	case errors.Is(err, models.ErrPreconditionFailed):
+		writeJSON(w, http.StatusPreconditionFailed, map[string]any{"message": err.Error(), "type": "precondition_failed"})
 	default:
 		writeJSON(w, http.StatusInternalServerError, map[string]any{"message": "internal server error", "type": "internal"})
 	}
@@ -1,16 +1,28 @@
 package handlers_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/require"
 
+	"github.com/redscaresu/mockway/capture"
+	"github.com/redscaresu/mockway/handlers"
+	"github.com/redscaresu/mockway/repository"
 	"github.com/redscaresu/mockway/testutil"
 )
 
@@ -994,7 +1006,7 @@ func TestUnknownServiceState404(t *testing.T) {
 	require.Equal(t, "not_found", body["type"])
 	require.Equal(t, "unknown service", body["message"])
 
-	status, body = testutil.DoGet(t, ts, "/mock/state/account")
+	status, body = testutil.DoGet(t, ts, "/mock/state/billing")
 	require.Equal(t, 404, status)
 	require.Equal(t, "not_found", body["type"])
 	require.Equal(t, "unknown service", body["message"])
@@ -6885,3 +6897,1525 @@ func TestDomainNotFoundCarriesResourceFields(t *testing.T) {
 	status = testutil.DoDelete(t, ts, "/domain/v2beta1/dns-zones/missing.example.com")
 	require.Equal(t, 404, status)
 }
+
+func TestAdminLifecycleConfig(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	status, body := testutil.DoGet(t, ts, "/mock/lifecycle")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "0s", body["default"])
+	require.Contains(t, body["kinds"], "k8s_cluster")
+
+	status, body = testutil.DoPut(t, ts, "/mock/lifecycle", map[string]any{
+		"default":  "1h",
+		"per_kind": map[string]any{"lb": "30m"},
+	})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "1h0m0s", body["default"])
+	require.Equal(t, "30m0s", body["per_kind"].(map[string]any)["lb"])
+
+	status, body = testutil.DoPut(t, ts, "/mock/lifecycle", map[string]any{"per_kind": map[string]any{"bogus": "1s"}})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "invalid_argument", body["type"])
+
+	status, _ = testutil.DoPut(t, ts, "/mock/lifecycle", map[string]any{"default": "soon"})
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestLifecycleTransientStatesOverHTTP(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	status, _ := testutil.DoPut(t, ts, "/mock/lifecycle", map[string]any{"default": "1h"})
+	require.Equal(t, http.StatusOK, status)
+
+	status, cluster := testutil.DoCreate(t, ts, "/k8s/v1/regions/fr-par/clusters", map[string]any{
+		"name": "c1", "version": "1.31", "cni": "cilium",
+	})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "creating", cluster["status"])
+	clusterID := cluster["id"].(string)
+
+	status, got := testutil.DoGet(t, ts, "/k8s/v1/regions/fr-par/clusters/"+clusterID)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "creating", got["status"])
+
+	_, server := testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/servers", map[string]any{
+		"name": "web", "commercial_type": "DEV1-S", "image": "ubuntu_noble",
+	})
+	serverID := unwrapInstanceResource(server)["id"].(string)
+	status, _ = testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/servers/"+serverID+"/action",
+		map[string]any{"action": "poweron"})
+	require.Equal(t, http.StatusOK, status)
+	_, got = testutil.DoGet(t, ts, "/instance/v1/zones/fr-par-1/servers/"+serverID)
+	require.Equal(t, "starting", got["server"].(map[string]any)["state"])
+
+	// Deleted clusters stay visible as "deleting" until the delay elapses.
+	status = testutil.DoDelete(t, ts, "/k8s/v1/regions/fr-par/clusters/"+clusterID)
+	require.Equal(t, http.StatusOK, status)
+	status, got = testutil.DoGet(t, ts, "/k8s/v1/regions/fr-par/clusters/"+clusterID)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "deleting", got["status"])
+}
+
+func TestListPagination(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	for _, name := range []string{"charlie", "alpha", "echo", "bravo", "delta"} {
+		status, _ := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": name})
+		require.Equal(t, http.StatusOK, status)
+	}
+	names := func(body map[string]any) []string {
+		out := []string{}
+		for _, v := range body["vpcs"].([]any) {
+			out = append(out, v.(map[string]any)["name"].(string))
+		}
+		return out
+	}
+
+	status, body := testutil.DoList(t, ts, "/vpc/v2/regions/fr-par/vpcs?page_size=2")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(5), body["total_count"])
+	require.Equal(t, []string{"charlie", "alpha"}, names(body))
+
+	_, body = testutil.DoList(t, ts, "/vpc/v2/regions/fr-par/vpcs?page_size=2&page=3")
+	require.Equal(t, float64(5), body["total_count"])
+	require.Equal(t, []string{"delta"}, names(body))
+
+	_, body = testutil.DoList(t, ts, "/vpc/v2/regions/fr-par/vpcs?page_size=2&page=4")
+	require.Equal(t, []string{}, names(body))
+
+	_, body = testutil.DoList(t, ts, "/vpc/v2/regions/fr-par/vpcs?order_by=name_asc&page_size=3")
+	require.Equal(t, []string{"alpha", "bravo", "charlie"}, names(body))
+
+	_, body = testutil.DoList(t, ts, "/vpc/v2/regions/fr-par/vpcs?order_by=name_desc&page=2&page_size=3")
+	require.Equal(t, []string{"bravo", "alpha"}, names(body))
+
+	// created_at has second precision; insertion order breaks ties.
+	_, body = testutil.DoList(t, ts, "/vpc/v2/regions/fr-par/vpcs?order_by=created_at_desc")
+	require.Equal(t, []string{"delta", "bravo", "echo", "alpha", "charlie"}, names(body))
+}
+
+func TestListPaginationInstancePerPageAndHeader(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		status, _ := testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/ips", map[string]any{})
+		require.Equal(t, http.StatusOK, status)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/instance/v1/zones/fr-par-1/ips?per_page=1&page=2", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Auth-Token", "test-token")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "3", resp.Header.Get("X-Total-Count"))
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Len(t, body["ips"], 1)
+	require.Equal(t, float64(3), body["total_count"])
+}
+
+func TestListPaginationStaticCatalogs(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	for _, tc := range []struct {
+		path  string
+		key   string
+		total int
+	}{
+		{"/rdb/v1/regions/fr-par/node-types", "node_types", 4},
+		{"/redis/v1/zones/fr-par-1/cluster-versions", "versions", 2},
+		{"/redis/v1/zones/fr-par-1/node-types", "node_types", 3},
+		{"/block/v1alpha1/zones/fr-par-1/volume-types", "volume_types", 3},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+tc.path+"?per_page=1&page=2", nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Auth-Token", "test-token")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, tc.path)
+		require.Equal(t, strconv.Itoa(tc.total), resp.Header.Get("X-Total-Count"), tc.path)
+		require.Len(t, body[tc.key], 1, tc.path)
+		require.Equal(t, float64(tc.total), body["total_count"], tc.path)
+
+		status, _ := testutil.DoList(t, ts, tc.path+"?page=0")
+		require.Equal(t, http.StatusBadRequest, status, tc.path)
+	}
+}
+
+func TestListPaginationRejectsInvalidParams(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	for _, query := range []string{"page=0", "page=abc", "per_page=0", "page_size=101", "order_by=name"} {
+		status, body := testutil.DoList(t, ts, "/vpc/v2/regions/fr-par/vpcs?"+query)
+		require.Equal(t, http.StatusBadRequest, status, query)
+		require.Equal(t, "invalid_argument", body["type"], query)
+	}
+}
+
+func TestListFiltersFromSpec(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	for _, s := range []map[string]any{
+		{"name": "web-1", "commercial_type": "DEV1-S", "image": "ubuntu_noble", "tags": []any{"web", "prod"}},
+		{"name": "web-2", "commercial_type": "DEV1-M", "image": "ubuntu_noble", "tags": []any{"web"}},
+		{"name": "db-1", "commercial_type": "DEV1-S", "image": "ubuntu_noble", "tags": []any{"prod"}},
+	} {
+		status, _ := testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/servers", s)
+		require.Equal(t, http.StatusOK, status)
+	}
+	serverNames := func(path string) []string {
+		status, body := testutil.DoList(t, ts, path)
+		require.Equal(t, http.StatusOK, status)
+		out := []string{}
+		for _, v := range body["servers"].([]any) {
+			out = append(out, v.(map[string]any)["name"].(string))
+		}
+		require.Equal(t, float64(len(out)), body["total_count"])
+		return out
+	}
+
+	require.Equal(t, []string{"web-1", "web-2"}, serverNames("/instance/v1/zones/fr-par-1/servers?name=WEB"))
+	require.Equal(t, []string{"web-1", "db-1"}, serverNames("/instance/v1/zones/fr-par-1/servers?tags=prod"))
+	require.Equal(t, []string{"web-1"}, serverNames("/instance/v1/zones/fr-par-1/servers?tags=prod,web"))
+	require.Equal(t, []string{"web-2"}, serverNames("/instance/v1/zones/fr-par-1/servers?commercial_type=DEV1-M"))
+	// Parameters that don't map onto a resource field are ignored.
+	require.Len(t, serverNames("/instance/v1/zones/fr-par-1/servers?without_ip=true"), 3)
+
+	_, vpcA := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "a"})
+	_, vpcB := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "b"})
+	_, pn1 := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/private-networks", map[string]any{"name": "pn1", "vpc_id": vpcA["id"]})
+	_, pn2 := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/private-networks", map[string]any{"name": "pn2", "vpc_id": vpcB["id"]})
+
+	status, body := testutil.DoList(t, ts, "/vpc/v2/regions/fr-par/private-networks?vpc_id="+vpcB["id"].(string))
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(1), body["total_count"])
+	require.Equal(t, pn2["id"], body["private_networks"].([]any)[0].(map[string]any)["id"])
+
+	status, body = testutil.DoList(t, ts, "/vpc/v2/regions/fr-par/private-networks?private_network_ids="+pn1["id"].(string))
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(1), body["total_count"])
+	require.Equal(t, pn1["id"], body["private_networks"].([]any)[0].(map[string]any)["id"])
+
+	status, body = testutil.DoList(t, ts, "/vpc/v2/regions/fr-par/private-networks?dhcp_enabled=maybe")
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "invalid_argument", body["type"])
+}
+
+func TestAccountProjectCRUD(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	// The default project exists from startup.
+	status, body := testutil.DoGet(t, ts, "/account/v3/projects/00000000-0000-0000-0000-000000000000")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "default", body["name"])
+	status = testutil.DoDelete(t, ts, "/account/v3/projects/00000000-0000-0000-0000-000000000000")
+	require.Equal(t, http.StatusPreconditionFailed, status)
+
+	orgID := "11111111-1111-1111-1111-111111111111"
+	status, project := testutil.DoCreate(t, ts, "/account/v3/projects", map[string]any{
+		"name": "team-a", "organization_id": orgID, "description": "Team A",
+	})
+	require.Equal(t, http.StatusOK, status)
+	projectID := project["id"].(string)
+	require.Equal(t, orgID, project["organization_id"])
+	require.NotEmpty(t, project["created_at"])
+
+	status, updated := testutil.DoPatch(t, ts, "/account/v3/projects/"+projectID, map[string]any{"name": "team-a2", "description": nil})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "team-a2", updated["name"])
+	require.Equal(t, "Team A", updated["description"])
+
+	status, list := testutil.DoList(t, ts, "/account/v3/projects?organization_id="+orgID)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(1), list["total_count"])
+	require.Equal(t, projectID, list["projects"].([]any)[0].(map[string]any)["id"])
+
+	status = testutil.DoDelete(t, ts, "/account/v3/projects/"+projectID)
+	require.Equal(t, http.StatusNoContent, status)
+	status, body = testutil.DoGet(t, ts, "/account/v3/projects/"+projectID)
+	require.Equal(t, http.StatusNotFound, status)
+	require.Equal(t, "project", body["resource"])
+}
+
+func TestAccountProjectScoping(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	_, projectA := testutil.DoCreate(t, ts, "/account/v3/projects", map[string]any{"name": "a"})
+	_, projectB := testutil.DoCreate(t, ts, "/account/v3/projects", map[string]any{"name": "b"})
+	projectAID := projectA["id"].(string)
+
+	// Unknown project -> 404 on create, for both project_id and the Instance
+	// API's project field.
+	missing := "22222222-2222-2222-2222-222222222222"
+	status, _ := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "x", "project_id": missing})
+	require.Equal(t, http.StatusNotFound, status)
+	status, _ = testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/ips", map[string]any{"project": missing})
+	require.Equal(t, http.StatusNotFound, status)
+
+	status, vpc := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "a-vpc", "project_id": projectAID})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "b-vpc", "project_id": projectB["id"]})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "default-vpc"})
+	require.Equal(t, http.StatusOK, status)
+
+	// Resources without a project_id land in the default project, with the
+	// organization taken from it.
+	status, ns := testutil.DoCreate(t, ts, "/registry/v1/regions/fr-par/namespaces", map[string]any{"name": "ns"})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "00000000-0000-0000-0000-000000000000", ns["project_id"])
+	require.Equal(t, "00000000-0000-0000-0000-000000000000", ns["organization_id"])
+
+	status, list := testutil.DoList(t, ts, "/vpc/v2/regions/fr-par/vpcs?project_id="+projectAID)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(1), list["total_count"])
+	require.Equal(t, vpc["id"], list["vpcs"].([]any)[0].(map[string]any)["id"])
+
+	// vpc/v1 has no spec; project_id still scopes the list.
+	status, list = testutil.DoList(t, ts, "/vpc/v1/regions/fr-par/vpcs?project_id="+projectAID)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(1), list["total_count"])
+
+	// A project that still owns resources cannot be deleted.
+	status = testutil.DoDelete(t, ts, "/account/v3/projects/"+projectAID)
+	require.Equal(t, http.StatusConflict, status)
+	status = testutil.DoDelete(t, ts, "/vpc/v2/regions/fr-par/vpcs/"+vpc["id"].(string))
+	require.Equal(t, http.StatusNoContent, status)
+	status = testutil.DoDelete(t, ts, "/account/v3/projects/"+projectAID)
+	require.Equal(t, http.StatusNoContent, status)
+}
+
+func TestIAMEnforcement(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+	// Default admin key and default project share the all-zeros UUID.
+	const zeroID = "00000000-0000-0000-0000-000000000000"
+
+	status, cfg := testutil.DoPut(t, ts, "/mock/iam", map[string]any{"enforce": true})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, true, cfg["enforce"])
+	adminKey := cfg["admin_key"].(string)
+	require.Equal(t, zeroID, adminKey)
+
+	// Any token is no longer enough.
+	status, body := testutil.DoGet(t, ts, "/instance/v1/zones/fr-par-1/servers")
+	require.Equal(t, http.StatusUnauthorized, status)
+	require.Equal(t, "denied_authentication", body["type"])
+
+	// The admin key provisions an application with read-only Instance access.
+	status, app := testutil.DoWithToken(t, ts, http.MethodPost, "/iam/v1alpha1/applications", adminKey, map[string]any{"name": "ci"})
+	require.Equal(t, http.StatusOK, status)
+	status, key := testutil.DoWithToken(t, ts, http.MethodPost, "/iam/v1alpha1/api-keys", adminKey, map[string]any{"application_id": app["id"]})
+	require.Equal(t, http.StatusOK, status)
+	appSecret := key["secret_key"].(string)
+	status, _ = testutil.DoWithToken(t, ts, http.MethodPost, "/iam/v1alpha1/policies", adminKey, map[string]any{
+		"name":           "instances-ro",
+		"application_id": app["id"],
+		"rules": []any{map[string]any{
+			"permission_set_names": []any{"InstancesReadOnly"},
+			"project_ids":          []any{zeroID},
+		}},
+	})
+	require.Equal(t, http.StatusOK, status)
+
+	status, _ = testutil.DoWithToken(t, ts, http.MethodGet, "/instance/v1/zones/fr-par-1/servers", appSecret, nil)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutil.DoWithToken(t, ts, http.MethodGet, "/marketplace/v2/local-images", appSecret, nil)
+	require.Equal(t, http.StatusOK, status)
+
+	status, body = testutil.DoWithToken(t, ts, http.MethodPost, "/instance/v1/zones/fr-par-1/ips", appSecret, map[string]any{"project": zeroID})
+	require.Equal(t, http.StatusForbidden, status)
+	require.Equal(t, "permissions_denied", body["type"])
+	details := body["details"].([]any)
+	require.Equal(t, map[string]any{"resource": "instance", "action": "write"}, details[0])
+
+	status, body = testutil.DoWithToken(t, ts, http.MethodGet, "/vpc/v2/regions/fr-par/vpcs", appSecret, nil)
+	require.Equal(t, http.StatusForbidden, status)
+	require.Equal(t, "permissions_denied", body["type"])
+
+	// A user gets VPC access through a group policy scoped to one project.
+	status, project := testutil.DoWithToken(t, ts, http.MethodPost, "/account/v3/projects", adminKey, map[string]any{"name": "net"})
+	require.Equal(t, http.StatusOK, status)
+	projectID := project["id"].(string)
+	_, user := testutil.DoWithToken(t, ts, http.MethodPost, "/iam/v1alpha1/users", adminKey, map[string]any{"email": "net@example.com"})
+	_, group := testutil.DoWithToken(t, ts, http.MethodPost, "/iam/v1alpha1/groups", adminKey, map[string]any{"name": "net"})
+	status, _ = testutil.DoWithToken(t, ts, http.MethodPost, "/iam/v1alpha1/groups/"+group["id"].(string)+"/add-member", adminKey, map[string]any{"user_id": user["id"]})
+	require.Equal(t, http.StatusOK, status)
+	_, userKey := testutil.DoWithToken(t, ts, http.MethodPost, "/iam/v1alpha1/api-keys", adminKey, map[string]any{"user_id": user["id"]})
+	userSecret := userKey["secret_key"].(string)
+	status, _ = testutil.DoWithToken(t, ts, http.MethodPost, "/iam/v1alpha1/policies", adminKey, map[string]any{
+		"name":     "net-admin",
+		"group_id": group["id"],
+		"rules": []any{map[string]any{
+			"permission_set_names": []any{"VPCFullAccess"},
+			"project_ids":          []any{projectID},
+		}},
+	})
+	require.Equal(t, http.StatusOK, status)
+
+	status, ownVPC := testutil.DoWithToken(t, ts, http.MethodPost, "/vpc/v2/regions/fr-par/vpcs", userSecret, map[string]any{"name": "v", "project_id": projectID})
+	require.Equal(t, http.StatusOK, status)
+	status, body = testutil.DoWithToken(t, ts, http.MethodPost, "/vpc/v2/regions/fr-par/vpcs", userSecret, map[string]any{"name": "v", "project_id": zeroID})
+	require.Equal(t, http.StatusForbidden, status)
+	require.Equal(t, "permissions_denied", body["type"])
+	// Naming no project means the default one.
+	status, _ = testutil.DoWithToken(t, ts, http.MethodPost, "/vpc/v2/regions/fr-par/vpcs", userSecret, map[string]any{"name": "v"})
+	require.Equal(t, http.StatusForbidden, status)
+
+	// Requests by ID are checked against the resource's own project, and
+	// lists show only the projects the rules cover.
+	status, otherVPC := testutil.DoWithToken(t, ts, http.MethodPost, "/vpc/v2/regions/fr-par/vpcs", adminKey, map[string]any{"name": "other"})
+	require.Equal(t, http.StatusOK, status)
+	otherPath := "/vpc/v2/regions/fr-par/vpcs/" + otherVPC["id"].(string)
+	status, _ = testutil.DoWithToken(t, ts, http.MethodGet, otherPath, userSecret, nil)
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = testutil.DoWithToken(t, ts, http.MethodPatch, otherPath, userSecret, map[string]any{"name": "mine"})
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = testutil.DoWithToken(t, ts, http.MethodDelete, otherPath, userSecret, nil)
+	require.Equal(t, http.StatusForbidden, status)
+	// Naming an allowed project in the query or body does not change the
+	// project of the resource the path names.
+	status, _ = testutil.DoWithToken(t, ts, http.MethodGet, otherPath+"?project_id="+projectID, userSecret, nil)
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = testutil.DoWithToken(t, ts, http.MethodPatch, otherPath+"?project_id="+projectID, userSecret, map[string]any{"name": "mine", "project_id": projectID})
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = testutil.DoWithToken(t, ts, http.MethodDelete, otherPath+"?project="+projectID, userSecret, nil)
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = testutil.DoWithToken(t, ts, http.MethodGet, "/vpc/v2/regions/fr-par/vpcs/"+ownVPC["id"].(string), userSecret, nil)
+	require.Equal(t, http.StatusOK, status)
+	status, body = testutil.DoWithToken(t, ts, http.MethodGet, "/vpc/v2/regions/fr-par/vpcs", userSecret, nil)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(1), body["total_count"])
+	require.Equal(t, ownVPC["id"], body["vpcs"].([]any)[0].(map[string]any)["id"])
+	status, _ = testutil.DoWithToken(t, ts, http.MethodGet, "/vpc/v2/regions/fr-par/vpcs?project_id="+zeroID, userSecret, nil)
+	require.Equal(t, http.StatusForbidden, status)
+
+	// The Instance API names the project in "project".
+	status, _ = testutil.DoWithToken(t, ts, http.MethodGet, "/instance/v1/zones/fr-par-1/servers?project="+projectID, appSecret, nil)
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = testutil.DoWithToken(t, ts, http.MethodGet, "/iam/v1alpha1/users", userSecret, nil)
+	require.Equal(t, http.StatusForbidden, status)
+
+	// Switching enforcement off restores the accept-any-token behaviour.
+	status, _ = testutil.DoPut(t, ts, "/mock/iam", map[string]any{"enforce": false})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutil.DoGet(t, ts, "/vpc/v2/regions/fr-par/vpcs")
+	require.Equal(t, http.StatusOK, status)
+}
+
+func TestQuotaEnforcement(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	status, _ := testutil.DoPut(t, ts, "/mock/quotas", map[string]any{"default": map[string]any{"bogus": 1}})
+	require.Equal(t, http.StatusBadRequest, status)
+
+	status, project := testutil.DoCreate(t, ts, "/account/v3/projects", map[string]any{"name": "big"})
+	require.Equal(t, http.StatusOK, status)
+	bigProject := project["id"].(string)
+
+	status, cfg := testutil.DoPut(t, ts, "/mock/quotas", map[string]any{
+		"default":  map[string]any{"vpcs": 1, "instance_ips": 1},
+		"projects": map[string]any{bigProject: map[string]any{"vpcs": 2}},
+	})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, map[string]any{"vpcs": float64(1), "instance_ips": float64(1)}, cfg["default"])
+
+	status, _ = testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "a"})
+	require.Equal(t, http.StatusOK, status)
+	status, body := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "b"})
+	require.Equal(t, http.StatusForbidden, status)
+	require.Equal(t, "quotas_exceeded", body["type"])
+	require.Equal(t, []any{map[string]any{"resource": "vpcs", "quota": float64(1), "current": float64(1)}}, body["details"])
+
+	// The per-project override applies to the other project only.
+	for _, name := range []string{"c", "d"} {
+		status, _ = testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": name, "project_id": bigProject})
+		require.Equal(t, http.StatusOK, status)
+	}
+	status, _ = testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "e", "project_id": bigProject})
+	require.Equal(t, http.StatusForbidden, status)
+
+	// Instance resources carry their project in "project".
+	status, ip := testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/ips", map[string]any{"project": "00000000-0000-0000-0000-000000000000"})
+	require.Equal(t, http.StatusOK, status)
+	status, body = testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/ips", map[string]any{"project": "00000000-0000-0000-0000-000000000000"})
+	require.Equal(t, http.StatusForbidden, status)
+	require.Equal(t, "quotas_exceeded", body["type"])
+
+	// Deleting frees the slot.
+	status = testutil.DoDelete(t, ts, "/instance/v1/zones/fr-par-1/ips/"+ip["ip"].(map[string]any)["id"].(string))
+	require.Equal(t, http.StatusNoContent, status)
+	status, _ = testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/ips", map[string]any{"project": "00000000-0000-0000-0000-000000000000"})
+	require.Equal(t, http.StatusOK, status)
+
+	// An empty config lifts every quota.
+	status, _ = testutil.DoPut(t, ts, "/mock/quotas", map[string]any{})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "f"})
+	require.Equal(t, http.StatusOK, status)
+}
+
+func TestFaultInjection(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	status, _ := testutil.DoCreate(t, ts, "/mock/faults", map[string]any{"route": "/vpc/v2/regions/{region}/vpcs"})
+	require.Equal(t, http.StatusBadRequest, status)
+
+	// 503 on the second VPC create only.
+	status, rule := testutil.DoCreate(t, ts, "/mock/faults", map[string]any{
+		"method":   "post",
+		"route":    "/vpc/v2/regions/{region}/vpcs",
+		"nth_call": 2,
+		"max_hits": 1,
+		"status":   503,
+		"body":     map[string]any{"message": "try again", "type": "service_unavailable"},
+	})
+	require.Equal(t, http.StatusOK, status)
+	require.NotEmpty(t, rule["id"])
+
+	status, _ = testutil.DoList(t, ts, "/vpc/v2/regions/fr-par/vpcs")
+	require.Equal(t, http.StatusOK, status)
+	status, vpc := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "a"})
+	require.Equal(t, http.StatusOK, status)
+	status, body := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "b"})
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Equal(t, "try again", body["message"])
+
+	// The rule expired after its single hit.
+	status, body = testutil.DoList(t, ts, "/mock/faults")
+	require.Equal(t, http.StatusOK, status)
+	require.Empty(t, body["faults"])
+	status, _ = testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "c"})
+	require.Equal(t, http.StatusOK, status)
+
+	// Resource-scoped rule with the default body; other VPCs are unaffected.
+	vpcID := vpc["id"].(string)
+	status, rule = testutil.DoCreate(t, ts, "/mock/faults", map[string]any{
+		"method":      "GET",
+		"route":       "/vpc/v2/regions/{region}/vpcs/{vpc_id}",
+		"resource_id": vpcID,
+		"status":      500,
+	})
+	require.Equal(t, http.StatusOK, status)
+	status, body = testutil.DoGet(t, ts, "/vpc/v2/regions/fr-par/vpcs/"+vpcID)
+	require.Equal(t, http.StatusInternalServerError, status)
+	require.Equal(t, "unknown_error", body["type"])
+	status, body = testutil.DoList(t, ts, "/mock/faults")
+	require.Equal(t, http.StatusOK, status)
+	listed := body["faults"].([]any)[0].(map[string]any)
+	require.Equal(t, float64(1), listed["hits"])
+	require.Equal(t, http.StatusNoContent, testutil.DoDelete(t, ts, "/mock/faults/"+rule["id"].(string)))
+	require.Equal(t, http.StatusNotFound, testutil.DoDelete(t, ts, "/mock/faults/"+rule["id"].(string)))
+	status, _ = testutil.DoGet(t, ts, "/vpc/v2/regions/fr-par/vpcs/"+vpcID)
+	require.Equal(t, http.StatusOK, status)
+
+	// Abort drops the connection.
+	status, _ = testutil.DoCreate(t, ts, "/mock/faults", map[string]any{"route": "/instance/v1/zones/*", "abort": true})
+	require.Equal(t, http.StatusOK, status)
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/instance/v1/zones/fr-par-1/servers", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Auth-Token", "test-token")
+	_, err = http.DefaultClient.Do(req)
+	require.Error(t, err)
+	resp, err := http.Get(ts.URL + "/mock/metrics")
+	require.NoError(t, err)
+	var metricsBody bytes.Buffer
+	_, err = metricsBody.ReadFrom(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Contains(t, metricsBody.String(), `mockway_http_requests_total{service="instance",route="/instance/v1/zones/{zone}/servers",method="GET",status="aborted",sandbox=""}`)
+
+	require.Equal(t, http.StatusNoContent, testutil.DoDelete(t, ts, "/mock/faults"))
+	status, _ = testutil.DoList(t, ts, "/instance/v1/zones/fr-par-1/servers")
+	require.Equal(t, http.StatusOK, status)
+}
+
+func TestRateLimiting(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	status, _ := testutil.DoPut(t, ts, "/mock/ratelimit", map[string]any{"global": map[string]any{"rate": 1}})
+	require.Equal(t, http.StatusBadRequest, status)
+
+	// Near-zero refill so the bursts decide: 2 requests per token, 3 for vpc.
+	status, cfg := testutil.DoPut(t, ts, "/mock/ratelimit", map[string]any{
+		"per_token":   map[string]any{"rate": 0.001, "burst": 2},
+		"per_service": map[string]any{"vpc": map[string]any{"rate": 0.001, "burst": 3}},
+	})
+	require.Equal(t, http.StatusOK, status)
+	require.Contains(t, cfg["per_service"], "vpc")
+
+	get := func(token, path string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Auth-Token", token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get("a", "/vpc/v2/regions/fr-par/vpcs")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "2", resp.Header.Get("X-RateLimit-Limit"))
+	require.Equal(t, "1", resp.Header.Get("X-RateLimit-Remaining"))
+	require.Equal(t, http.StatusOK, get("a", "/vpc/v2/regions/fr-par/vpcs").StatusCode)
+
+	resp = get("a", "/vpc/v2/regions/fr-par/vpcs")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.NotEmpty(t, resp.Header.Get("Retry-After"))
+	require.Equal(t, "0", resp.Header.Get("X-RateLimit-Remaining"))
+
+	// Token b has its own bucket but shares the vpc service bucket.
+	require.Equal(t, http.StatusOK, get("b", "/vpc/v2/regions/fr-par/vpcs").StatusCode)
+	require.Equal(t, http.StatusTooManyRequests, get("b", "/vpc/v2/regions/fr-par/vpcs").StatusCode)
+	require.Equal(t, http.StatusOK, get("b", "/instance/v1/zones/fr-par-1/servers").StatusCode)
+
+	status, body := testutil.DoWithToken(t, ts, http.MethodGet, "/instance/v1/zones/fr-par-1/servers", "a", nil)
+	require.Equal(t, http.StatusTooManyRequests, status)
+	require.Equal(t, "too_many_requests", body["type"])
+
+	// Admin routes are never limited; an empty config lifts the limits.
+	status, _ = testutil.DoPut(t, ts, "/mock/ratelimit", map[string]any{})
+	require.Equal(t, http.StatusOK, status)
+	resp = get("a", "/vpc/v2/regions/fr-par/vpcs")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, resp.Header.Get("X-RateLimit-Limit"))
+}
+
+func TestRequestJournal(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	_, vpc := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "j"})
+	vpcID := vpc["id"].(string)
+	status, _ := testutil.DoGet(t, ts, "/vpc/v2/regions/fr-par/vpcs/"+vpcID+"?foo=bar")
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutil.DoList(t, ts, "/instance/v1/zones/fr-par-1/servers")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, http.StatusNoContent, testutil.DoDelete(t, ts, "/vpc/v2/regions/fr-par/vpcs/"+vpcID))
+
+	// Admin calls are not journaled.
+	status, body := testutil.DoList(t, ts, "/mock/requests")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(4), body["total_count"])
+	entries := body["requests"].([]any)
+	first := entries[0].(map[string]any)
+	require.Equal(t, "POST", first["method"])
+	require.Equal(t, "vpc", first["service"])
+	// The token is recorded as a fingerprint, never in full.
+	sum := sha256.Sum256([]byte("test-token"))
+	require.Equal(t, hex.EncodeToString(sum[:])[:8], first["token"])
+	require.Equal(t, map[string]any{"name": "j"}, first["request_body"])
+	require.Equal(t, float64(200), first["status"])
+	require.Equal(t, vpcID, first["response_body"].(map[string]any)["id"])
+	require.Equal(t, "foo=bar", entries[1].(map[string]any)["query"])
+
+	status, body = testutil.DoList(t, ts, "/mock/requests?service=vpc&method=delete")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(1), body["total_count"])
+	require.Equal(t, float64(204), body["requests"].([]any)[0].(map[string]any)["status"])
+
+	status, body = testutil.DoList(t, ts, "/mock/requests?path=/vpc/v2/regions/*/vpcs/*")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(2), body["total_count"])
+
+	lastID := int(entries[3].(map[string]any)["id"].(float64))
+	testutil.DoList(t, ts, "/instance/v1/zones/fr-par-1/ips")
+	status, body = testutil.DoList(t, ts, "/mock/requests?since="+strconv.Itoa(lastID))
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(1), body["total_count"])
+	require.Equal(t, "/instance/v1/zones/fr-par-1/ips", body["requests"].([]any)[0].(map[string]any)["path"])
+
+	status, _ = testutil.DoList(t, ts, "/mock/requests?since=yesterday")
+	require.Equal(t, http.StatusBadRequest, status)
+
+	testutil.DoWithToken(t, ts, http.MethodGet, "/instance/v1/zones/fr-par-1/volumes", "other-token", nil)
+	_, body = testutil.DoList(t, ts, "/mock/requests?path=/instance/v1/zones/fr-par-1/volumes")
+	other := body["requests"].([]any)[0].(map[string]any)["token"]
+	require.Len(t, other, 8)
+	require.NotEqual(t, first["token"], other)
+	require.NotContains(t, other, "other")
+
+	// Secrets are blanked in both bodies.
+	_, app := testutil.DoCreate(t, ts, "/iam/v1alpha1/applications", map[string]any{"name": "j"})
+	status, key := testutil.DoCreate(t, ts, "/iam/v1alpha1/api-keys", map[string]any{"application_id": app["id"]})
+	require.Equal(t, http.StatusOK, status)
+	require.NotEqual(t, capture.Redacted, key["secret_key"])
+	testutil.DoCreate(t, ts, "/rdb/v1/regions/fr-par/instances", map[string]any{
+		"name": "j", "engine": "PostgreSQL-15", "node_type": "DB-DEV-S", "user_name": "admin", "password": "hunter2",
+	})
+	status, body = testutil.DoList(t, ts, "/mock/requests?path=/iam/v1alpha1/api-keys")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, capture.Redacted, body["requests"].([]any)[0].(map[string]any)["response_body"].(map[string]any)["secret_key"])
+	status, body = testutil.DoList(t, ts, "/mock/requests?path=/rdb/v1/regions/fr-par/instances")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, capture.Redacted, body["requests"].([]any)[0].(map[string]any)["request_body"].(map[string]any)["password"])
+
+	require.Equal(t, http.StatusNoContent, testutil.DoDelete(t, ts, "/mock/requests"))
+	status, body = testutil.DoList(t, ts, "/mock/requests")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(0), body["total_count"])
+}
+
+func TestRequestJournalRing(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+	app := handlers.NewApplication(repo)
+	app.SetJournalSize(3)
+	r := chi.NewRouter()
+	app.RegisterRoutes(r)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// Once full, each request overwrites the oldest; the list stays in order.
+	for i := range 5 {
+		testutil.DoList(t, ts, fmt.Sprintf("/vpc/v2/regions/fr-par/vpcs?page=%d", i+1))
+	}
+	_, body := testutil.DoList(t, ts, "/mock/requests")
+	var queries []string
+	for _, e := range body["requests"].([]any) {
+		queries = append(queries, e.(map[string]any)["query"].(string))
+	}
+	require.Equal(t, []string{"page=3", "page=4", "page=5"}, queries)
+
+	require.Equal(t, http.StatusNoContent, testutil.DoDelete(t, ts, "/mock/requests"))
+	testutil.DoList(t, ts, "/vpc/v2/regions/fr-par/vpcs?page=6")
+	_, body = testutil.DoList(t, ts, "/mock/requests")
+	require.Equal(t, float64(1), body["total_count"])
+	require.Equal(t, "page=6", body["requests"].([]any)[0].(map[string]any)["query"])
+}
+
+func TestResponseValidation(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	status, _ := testutil.DoPut(t, ts, "/mock/validation", map[string]any{"responses": "loud"})
+	require.Equal(t, http.StatusBadRequest, status)
+	status, cfg := testutil.DoPut(t, ts, "/mock/validation", map[string]any{"responses": "log"})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "log", cfg["responses"])
+
+	_, vpc := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "shape"})
+	vpcPath := "/vpc/v2/regions/fr-par/vpcs/" + vpc["id"].(string)
+	status, body := testutil.DoList(t, ts, "/mock/violations")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(0), body["total_count"])
+
+	// PATCH stores tags verbatim, so a string comes back where the spec
+	// wants an array. Log mode records it and still returns the response.
+	status, _ = testutil.DoPatch(t, ts, vpcPath, map[string]any{"tags": "not-a-list"})
+	require.Equal(t, http.StatusOK, status)
+	status, body = testutil.DoList(t, ts, "/mock/violations")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(1), body["total_count"])
+	rec := body["violations"].([]any)[0].(map[string]any)
+	require.Equal(t, "UpdateVPC", rec["operation_id"])
+	require.Equal(t, []any{map[string]any{"path": "$.tags", "message": "expected array, got string", "reason": "constraint"}}, rec["violations"])
+
+	// Strict mode replaces the response.
+	status, _ = testutil.DoPut(t, ts, "/mock/validation", map[string]any{"responses": "strict"})
+	require.Equal(t, http.StatusOK, status)
+	status, body = testutil.DoGet(t, ts, vpcPath)
+	require.Equal(t, http.StatusInternalServerError, status)
+	require.Equal(t, "response_validation_failed", body["type"])
+	require.Equal(t, "GetVPC", body["operation_id"])
+
+	require.Equal(t, http.StatusNoContent, testutil.DoDelete(t, ts, "/mock/violations"))
+	status, _ = testutil.DoPut(t, ts, "/mock/validation", map[string]any{"responses": "off"})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutil.DoGet(t, ts, vpcPath)
+	require.Equal(t, http.StatusOK, status)
+	status, body = testutil.DoList(t, ts, "/mock/violations")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(0), body["total_count"])
+}
+
+func TestStrictRequestValidation(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	// Permissive by default.
+	status, _ := testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/servers", map[string]any{"name": "loose", "bogus": true})
+	require.Equal(t, http.StatusOK, status)
+
+	status, cfg := testutil.DoPut(t, ts, "/mock/validation", map[string]any{"requests": true})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, true, cfg["requests"])
+	require.Equal(t, "off", cfg["responses"])
+
+	status, body := testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/servers", map[string]any{
+		"name":            "strict",
+		"commercial_type": "NOPE1-S",
+		"boot_type":       "sideways",
+		"bogus":           true,
+	})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "invalid_arguments", body["type"])
+	reasons := map[string]any{}
+	for _, d := range body["details"].([]any) {
+		detail := d.(map[string]any)
+		require.NotEmpty(t, detail["help_message"])
+		reasons[detail["argument_name"].(string)] = detail["reason"]
+	}
+	require.Equal(t, map[string]any{
+		"bogus":           "unknown",
+		"boot_type":       "constraint",
+		"commercial_type": "constraint",
+	}, reasons)
+
+	// Product names are matched case- and separator-insensitively.
+	status, _ = testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/servers", map[string]any{"name": "ok", "commercial_type": "dev1_s"})
+	require.Equal(t, http.StatusOK, status)
+
+	status, body = testutil.DoGet(t, ts, "/k8s/v1/regions/fr-par/clusters?order_by=sideways")
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "order_by", body["details"].([]any)[0].(map[string]any)["argument_name"])
+
+	// Routes without a spec are never checked.
+	status, _ = testutil.DoCreate(t, ts, "/vpc/v1/regions/fr-par/vpcs", map[string]any{"name": "v1", "bogus": true})
+	require.Equal(t, http.StatusOK, status)
+
+	status, _ = testutil.DoPut(t, ts, "/mock/validation", map[string]any{"requests": false})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/servers", map[string]any{"name": "loose-again", "bogus": true})
+	require.Equal(t, http.StatusOK, status)
+}
+
+func TestGenericCRUDFallback(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	const base = "/instance/v1/zones/fr-par-1/placement_groups"
+
+	// Off by default: spec operations without a handler stay 501.
+	status, _ := testutil.DoCreate(t, ts, base, map[string]any{"name": "pg"})
+	require.Equal(t, http.StatusNotImplemented, status)
+
+	status, cfg := testutil.DoPut(t, ts, "/mock/generic", map[string]any{"enabled": true})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, true, cfg["enabled"])
+
+	status, body := testutil.DoCreate(t, ts, base, map[string]any{"name": "pg", "policy_type": "low_latency"})
+	require.Equal(t, http.StatusCreated, status)
+	pg := body["placement_group"].(map[string]any)
+	id := pg["id"].(string)
+	require.NotEmpty(t, id)
+	require.Equal(t, "pg", pg["name"])
+	require.Equal(t, "low_latency", pg["policy_type"])
+	require.Equal(t, "optional", pg["policy_mode"], "schema default")
+	require.Equal(t, "fr-par-1", pg["zone"], "path parameter")
+	require.Equal(t, []any{}, pg["tags"])
+
+	status, body = testutil.DoGet(t, ts, base+"/"+id)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, pg, body["placement_group"])
+
+	_, _ = testutil.DoCreate(t, ts, base, map[string]any{"name": "other"})
+	status, body = testutil.DoList(t, ts, base+"?name=pg")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(1), body["total_count"])
+	status, body = testutil.DoList(t, ts, "/instance/v1/zones/nl-ams-1/placement_groups")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(0), body["total_count"], "collections are scoped by path")
+
+	status, body = testutil.DoPatch(t, ts, base+"/"+id, map[string]any{"name": "renamed", "id": "ignored"})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "renamed", body["placement_group"].(map[string]any)["name"])
+	require.Equal(t, id, body["placement_group"].(map[string]any)["id"])
+
+	// Sub-resource actions echo the object they act on.
+	status, body = testutil.DoGet(t, ts, base+"/"+id+"/servers")
+	require.Equal(t, http.StatusOK, status)
+	require.Contains(t, body, "servers")
+
+	status, full := testutil.DoGet(t, ts, "/mock/state/generic")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, full[base].([]any), 2)
+
+	require.Equal(t, http.StatusNoContent, testutil.DoDelete(t, ts, base+"/"+id))
+	status, body = testutil.DoGet(t, ts, base+"/"+id)
+	require.Equal(t, http.StatusNotFound, status)
+	require.Equal(t, "placement_group", body["resource"])
+	// Without a wrapping key the name comes from the operationId, so
+	// irregular plurals come out right.
+	status, body = testutil.DoGet(t, ts, "/marketplace/v2/categories/"+id)
+	require.Equal(t, http.StatusNotFound, status)
+	require.Equal(t, "category", body["resource"])
+
+	// Hand-written routes stay authoritative: a missing method on them is
+	// still 501, and paths outside the specs are untouched.
+	status, _ = testutil.DoPut(t, ts, "/vpc/v2/regions/fr-par/vpcs/"+id, map[string]any{})
+	require.Equal(t, http.StatusNotImplemented, status)
+	status, _ = testutil.DoGet(t, ts, "/nope/v1/things")
+	require.Equal(t, http.StatusNotImplemented, status)
+}
+
+func TestNamedSnapshotEndpoints(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	baseline := testutil.CreateSnapshot(t, ts, "baseline")
+	require.Equal(t, "baseline", baseline["name"])
+	require.NotEmpty(t, baseline["created_at"])
+
+	_, vpc := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "applied"})
+	applied := testutil.CreateSnapshot(t, ts, "post-apply")
+	require.Equal(t, float64(1), applied["resource_counts"].(map[string]any)["vpcs"])
+
+	snapshots := testutil.ListSnapshots(t, ts)
+	require.Len(t, snapshots, 2)
+
+	status, body := testutil.DoGet(t, ts, "/mock/snapshots/post-apply")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "post-apply", body["name"])
+
+	testutil.RestoreSnapshot(t, ts, "baseline")
+	status, _ = testutil.DoGet(t, ts, "/vpc/v2/regions/fr-par/vpcs/"+vpc["id"].(string))
+	require.Equal(t, http.StatusNotFound, status)
+	testutil.RestoreSnapshot(t, ts, "post-apply")
+	status, _ = testutil.DoGet(t, ts, "/vpc/v2/regions/fr-par/vpcs/"+vpc["id"].(string))
+	require.Equal(t, http.StatusOK, status)
+
+	testutil.DeleteSnapshot(t, ts, "baseline")
+	require.Len(t, testutil.ListSnapshots(t, ts), 1)
+
+	status, body = testutil.DoCreate(t, ts, "/mock/snapshots/baseline/restore", nil)
+	require.Equal(t, http.StatusNotFound, status)
+	require.Equal(t, "snapshot", body["resource"])
+	status, _ = testutil.DoCreate(t, ts, "/mock/snapshots/.hidden", nil)
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestStateImportExport(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	fixture := map[string]any{
+		"vpc": map[string]any{
+			"vpcs": []any{map[string]any{"id": "vpc-platform", "region": "fr-par", "name": "platform"}},
+			"private_networks": []any{map[string]any{
+				"id": "pn-platform", "vpc_id": "vpc-platform", "region": "fr-par", "name": "shared",
+			}},
+		},
+	}
+	status, body := testutil.DoCreate(t, ts, "/mock/state", fixture)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, float64(2), body["total_resources"])
+
+	// Seeded resources are reachable through the regular API.
+	status, pn := testutil.DoGet(t, ts, "/vpc/v2/regions/fr-par/private-networks/pn-platform")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "shared", pn["name"])
+	status, _ = testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/servers", map[string]any{"name": "srv", "commercial_type": "DEV1-S"})
+	require.Equal(t, http.StatusOK, status)
+
+	status, exported := testutil.DoGet(t, ts, "/mock/export")
+	require.Equal(t, http.StatusOK, status)
+
+	status, body = testutil.DoCreate(t, ts, "/mock/state", fixture)
+	require.Equal(t, http.StatusConflict, status)
+	require.Equal(t, "vpc.vpcs[0]", body["path"])
+
+	status, body = testutil.DoCreate(t, ts, "/mock/state", map[string]any{
+		"vpc": map[string]any{"private_networks": []any{map[string]any{"id": "pn-x", "vpc_id": "nope", "region": "fr-par"}}},
+	})
+	require.Equal(t, http.StatusNotFound, status)
+	require.Equal(t, "vpc.private_networks[0]", body["path"])
+	status, _ = testutil.DoCreate(t, ts, "/mock/state", map[string]any{"vpcs": []any{}})
+	require.Equal(t, http.StatusBadRequest, status)
+
+	testutil.ResetState(t, ts)
+	status, _ = testutil.DoCreate(t, ts, "/mock/state?replace=true", exported)
+	require.Equal(t, http.StatusOK, status)
+	status, again := testutil.DoGet(t, ts, "/mock/export")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, exported, again)
+}
+
+func TestClockEndpoint(t *testing.T) {
+	repo, err := repository.NewDeterministic(":memory:", 1)
+	require.NoError(t, err)
+	ts, cleanup := testutil.NewTestServerFor(t, repo)
+	defer cleanup()
+
+	status, body := testutil.DoGet(t, ts, "/mock/clock")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, true, body["deterministic"])
+	require.Equal(t, "2025-01-01T00:00:00Z", body["now"])
+
+	_, vpc := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "v"})
+	require.Equal(t, "2025-01-01T00:00:00Z", vpc["created_at"])
+
+	status, body = testutil.DoCreate(t, ts, "/mock/clock", map[string]any{"advance": "90m"})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "2025-01-01T01:30:00Z", body["now"])
+	_, vpc = testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "w"})
+	require.Equal(t, "2025-01-01T01:30:00Z", vpc["created_at"])
+
+	status, body = testutil.DoCreate(t, ts, "/mock/clock", map[string]any{"now": "2030-06-01T12:00:00Z"})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "2030-06-01T12:00:00Z", body["now"])
+
+	status, _ = testutil.DoCreate(t, ts, "/mock/clock", map[string]any{"advance": "-1h"})
+	require.Equal(t, http.StatusBadRequest, status)
+
+	// Each sandbox has a clock of its own.
+	status, body = testutil.DoGet(t, ts, "/sandbox/a/mock/clock")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "2025-01-01T00:00:00Z", body["now"])
+	require.Equal(t, true, body["deterministic"])
+
+	// Reset rewinds the clock along with the id sequence.
+	testutil.ResetState(t, ts)
+	_, body = testutil.DoGet(t, ts, "/mock/clock")
+	require.Equal(t, "2025-01-01T00:00:00Z", body["now"])
+}
+
+func TestDeterministicFaults(t *testing.T) {
+	// The same seed and requests inject the same faults with the same ids.
+	run := func() (string, []int) {
+		repo, err := repository.NewDeterministic(":memory:", 7)
+		require.NoError(t, err)
+		ts, cleanup := testutil.NewTestServerFor(t, repo)
+		defer cleanup()
+
+		status, rule := testutil.DoCreate(t, ts, "/mock/faults", map[string]any{"method": "GET", "route": "/vpc/v2/regions/{region}/vpcs", "status": 503, "probability": 0.5})
+		require.Equal(t, http.StatusOK, status)
+		var statuses []int
+		for range 20 {
+			status, _ := testutil.DoList(t, ts, "/vpc/v2/regions/fr-par/vpcs")
+			statuses = append(statuses, status)
+		}
+		return rule["id"].(string), statuses
+	}
+	id, statuses := run()
+	require.Contains(t, statuses, http.StatusOK)
+	require.Contains(t, statuses, http.StatusServiceUnavailable)
+	againID, again := run()
+	require.Equal(t, id, againID)
+	require.Equal(t, statuses, again)
+}
+
+func TestIPPoolsAndAllocation(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	status, body := testutil.DoGet(t, ts, "/mock/ip-pools")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, repository.DefaultPublicIPPool, body["default"])
+
+	status, _ = testutil.DoPut(t, ts, "/mock/ip-pools", map[string]any{"zones": map[string]any{"fr-par-1": "not-a-cidr"}})
+	require.Equal(t, http.StatusBadRequest, status)
+	status, body = testutil.DoPut(t, ts, "/mock/ip-pools", map[string]any{"zones": map[string]any{"fr-par-1": "192.0.2.0/30"}})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, map[string]any{"fr-par-1": "192.0.2.0/30"}, body["zones"])
+
+	status, ip := testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/ips", map[string]any{})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "192.0.2.1", unwrapInstanceResource(ip)["address"])
+	status, ip = testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/ips", map[string]any{})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "192.0.2.2", unwrapInstanceResource(ip)["address"])
+
+	status, body = testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/ips", map[string]any{})
+	require.Equal(t, http.StatusConflict, status)
+	require.Equal(t, "out_of_stock", body["type"])
+	require.Equal(t, "ip", body["resource"])
+
+	require.Equal(t, http.StatusNoContent, testutil.DoDelete(t, ts, "/instance/v1/zones/fr-par-1/ips/"+resourceID(ip)))
+	status, ip = testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/ips", map[string]any{})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "192.0.2.2", unwrapInstanceResource(ip)["address"])
+
+	// Private NICs get addresses from their private network's subnet.
+	_, vpc := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "vpc"})
+	_, pn := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/private-networks", map[string]any{"name": "pn", "vpc_id": vpc["id"]})
+	_, server := testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/servers", map[string]any{"name": "web-1"})
+	serverID := resourceID(server)
+	var addrs []any
+	for range 2 {
+		status, nic := testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/servers/"+serverID+"/private_nics", map[string]any{"private_network_id": pn["id"]})
+		require.Equal(t, http.StatusOK, status)
+		privateIPs := unwrapInstanceResource(nic)["private_ips"].([]any)
+		addrs = append(addrs, privateIPs[0].(map[string]any)["address"])
+	}
+	require.Equal(t, []any{"172.16.0.2", "172.16.0.3"}, addrs)
+}
+
+func TestEventStream(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	status, _ := testutil.DoGet(t, ts, "/mock/events?type=bogus")
+	require.Equal(t, http.StatusBadRequest, status)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/mock/events?service=instance", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := make(chan map[string]any, 16)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+				var ev map[string]any
+				if json.Unmarshal([]byte(data), &ev) == nil {
+					events <- ev
+				}
+			}
+		}
+		close(events)
+	}()
+	next := func() map[string]any {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+			return nil
+		}
+	}
+
+	// The vpc events are filtered out by ?service=instance.
+	_, vpc := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "vpc"})
+	_, pn := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/private-networks", map[string]any{"name": "pn", "vpc_id": vpc["id"]})
+	_, server := testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/servers", map[string]any{"name": "web-1"})
+	serverID := resourceID(server)
+	ev := next()
+	require.Equal(t, "created", ev["type"])
+	require.Equal(t, "instance", ev["service"])
+	require.Equal(t, "server", ev["resource_type"])
+	require.Equal(t, serverID, ev["id"])
+
+	_, nic := testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/servers/"+serverID+"/private_nics", map[string]any{"private_network_id": pn["id"]})
+	ev = next()
+	require.Equal(t, "created", ev["type"])
+	require.Equal(t, "private_nic", ev["resource_type"])
+
+	require.Equal(t, http.StatusNoContent, testutil.DoDelete(t, ts, "/instance/v1/zones/fr-par-1/servers/"+serverID))
+	seen := map[string]map[string]any{}
+	for len(seen) < 2 {
+		ev = next()
+		seen[ev["type"].(string)] = ev
+	}
+	require.Equal(t, serverID, seen["deleted"]["id"])
+	require.Equal(t, unwrapInstanceResource(nic)["id"], seen["cascade_deleted"]["id"])
+	require.Equal(t, map[string]any{"service": "instance", "resource_type": "server", "id": serverID}, seen["cascade_deleted"]["cause"])
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	status, _ := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "v"})
+	require.Equal(t, http.StatusOK, status)
+	for range 2 {
+		status, _ = testutil.DoGet(t, ts, "/instance/v1/zones/fr-par-1/placement_groups/11111111-1111-1111-1111-111111111111")
+		require.Equal(t, http.StatusNotImplemented, status)
+	}
+	// Paths neither a route nor the specs know share one series, whatever
+	// a client makes up.
+	for _, path := range []string{"/nope/v1/things/1", "/other/v9/x"} {
+		status, _ = testutil.DoGet(t, ts, path)
+		require.Equal(t, http.StatusNotImplemented, status)
+	}
+
+	resp, err := http.Get(ts.URL + "/mock/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(resp.Body)
+	require.NoError(t, err)
+	body := buf.String()
+
+	require.Contains(t, body, "# TYPE mockway_http_requests_total counter\n")
+	require.Contains(t, body, `mockway_http_requests_total{service="vpc",route="/vpc/v2/regions/{region}/vpcs",method="POST",status="200",sandbox=""} 1`)
+	require.Contains(t, body, `mockway_http_request_duration_seconds_count{service="vpc",route="/vpc/v2/regions/{region}/vpcs",method="POST",status="200",sandbox=""} 1`)
+	require.Contains(t, body, `mockway_http_request_duration_seconds_bucket{service="vpc",route="/vpc/v2/regions/{region}/vpcs",method="POST",status="200",sandbox="",le="+Inf"} 1`)
+	require.Contains(t, body, `mockway_unimplemented_requests_total{method="GET",path="/instance/v1/zones/{zone}/placement_groups/{placement_group_id}",sandbox=""} 2`)
+	require.Contains(t, body, `mockway_unimplemented_requests_total{method="GET",path="unmatched",sandbox=""} 2`)
+	require.Contains(t, body, `mockway_http_requests_total{service="unmatched",route="unmatched",method="GET",status="501",sandbox=""} 2`)
+	require.NotContains(t, body, "nope")
+	require.Contains(t, body, `mockway_resources{table="vpcs"} 1`)
+	require.Contains(t, body, `mockway_resources{table="lbs"} 0`)
+	require.Contains(t, body, `mockway_sqlite_duration_seconds_count{op="exec"}`)
+}
+
+func TestGraphEndpoint(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	status, vpc := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "v"})
+	require.Equal(t, http.StatusOK, status)
+	status, pn := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/private-networks", map[string]any{"name": "pn", "vpc_id": vpc["id"]})
+	require.Equal(t, http.StatusOK, status)
+	status, redis := testutil.DoCreate(t, ts, "/redis/v1/zones/fr-par-1/clusters", map[string]any{
+		"name":      "r",
+		"endpoints": []any{map[string]any{"private_network": map[string]any{"id": pn["id"]}}},
+	})
+	require.Equal(t, http.StatusOK, status)
+
+	status, g := testutil.DoGet(t, ts, "/mock/graph")
+	require.Equal(t, http.StatusOK, status)
+	pnKey := "vpc/private_network/" + pn["id"].(string)
+	var edges []string
+	for _, raw := range g["edges"].([]any) {
+		e := raw.(map[string]any)
+		edges = append(edges, e["from"].(string)+" "+e["label"].(string)+" "+e["to"].(string))
+	}
+	require.Contains(t, edges, pnKey+" vpc_id vpc/vpc/"+vpc["id"].(string))
+	require.Contains(t, edges, "redis/cluster/"+redis["id"].(string)+" endpoints[].private_network.id "+pnKey)
+
+	get := func(format string) (int, string) {
+		resp, err := http.Get(ts.URL + "/mock/graph?format=" + format)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var buf bytes.Buffer
+		_, err = buf.ReadFrom(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, buf.String()
+	}
+	status, body := get("dot")
+	require.Equal(t, http.StatusOK, status)
+	require.Contains(t, body, "digraph mockway {")
+	require.Contains(t, body, `"`+pnKey+`" -> "vpc/vpc/`+vpc["id"].(string)+`" [label="vpc_id"];`)
+	status, body = get("mermaid")
+	require.Equal(t, http.StatusOK, status)
+	require.Contains(t, body, "flowchart LR")
+	require.Contains(t, body, "-->|vpc_id|")
+	status, _ = get("png")
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestCostEndpoint(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	// The bundled prices agree with the server catalog.
+	status, catalog := testutil.DoGet(t, ts, "/instance/v1/zones/fr-par-1/products/servers")
+	require.Equal(t, http.StatusOK, status)
+	for name, raw := range catalog["servers"].(map[string]any) {
+		require.Equal(t, raw.(map[string]any)["hourly_price"], repository.DefaultPricing.Servers[name], name)
+	}
+
+	status, _ = testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/servers", map[string]any{"name": "web", "commercial_type": "DEV1-M"})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutil.DoCreate(t, ts, "/lb/v1/zones/fr-par-1/lbs", map[string]any{"name": "lb", "type": "LB-S"})
+	require.Equal(t, http.StatusOK, status)
+
+	status, cost := testutil.DoGet(t, ts, "/mock/cost")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "EUR", cost["currency"])
+	require.InDelta(t, 0.05, cost["total"].(map[string]any)["hourly"], 1e-9)
+	require.InDelta(t, 0.036, cost["services"].(map[string]any)["instance"].(map[string]any)["hourly"], 1e-9)
+	require.Len(t, cost["resources"], 2)
+
+	status, pricing := testutil.DoPut(t, ts, "/mock/pricing", map[string]any{"load_balancers": map[string]any{"LB-S": 0.1}})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, 0.1, pricing["load_balancers"].(map[string]any)["LB-S"])
+	require.Equal(t, 0.036, pricing["servers"].(map[string]any)["DEV1-M"])
+	status, cost = testutil.DoGet(t, ts, "/mock/cost")
+	require.Equal(t, http.StatusOK, status)
+	require.InDelta(t, 0.136, cost["total"].(map[string]any)["hourly"], 1e-9)
+
+	status, _ = testutil.DoPut(t, ts, "/mock/pricing", map[string]any{"public_ip": -1})
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestEmbeddedReferences(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	status, _ := testutil.DoCreate(t, ts, "/lb/v1/zones/fr-par-1/lbs", map[string]any{"name": "lb", "ip_id": "00000000-0000-0000-0000-000000000001"})
+	require.Equal(t, http.StatusNotFound, status)
+
+	_, vpc := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "v"})
+	_, pn := testutil.DoCreate(t, ts, "/vpc/v2/regions/fr-par/private-networks", map[string]any{"name": "pn", "vpc_id": vpc["id"]})
+	pnID := pn["id"].(string)
+	_, rdb := testutil.DoCreate(t, ts, "/rdb/v1/regions/fr-par/instances", map[string]any{"name": "db", "engine": "PostgreSQL-15"})
+	rdbID := rdb["id"].(string)
+	status, _ = testutil.DoCreate(t, ts, "/rdb/v1/regions/fr-par/instances/"+rdbID+"/endpoints", map[string]any{
+		"private_network": map[string]any{"id": pnID},
+	})
+	require.Equal(t, http.StatusOK, status)
+
+	status = testutil.DoDelete(t, ts, "/vpc/v2/regions/fr-par/private-networks/"+pnID)
+	require.Equal(t, http.StatusConflict, status)
+	status = testutil.DoDelete(t, ts, "/rdb/v1/regions/fr-par/instances/"+rdbID)
+	require.Equal(t, http.StatusOK, status)
+	status = testutil.DoDelete(t, ts, "/vpc/v2/regions/fr-par/private-networks/"+pnID)
+	require.Equal(t, http.StatusNoContent, status)
+}
+
+func TestTablesEndpoint(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	testutil.DoCreate(t, ts, "/instance/v1/zones/fr-par-1/servers", map[string]any{"name": "web"})
+
+	status, body := testutil.DoGet(t, ts, "/mock/tables")
+	require.Equal(t, http.StatusOK, status)
+	tables := body["tables"].([]any)
+	require.Len(t, tables, len(repository.Tables))
+	byName := map[string]map[string]any{}
+	for _, raw := range tables {
+		table := raw.(map[string]any)
+		byName[table["name"].(string)] = table
+	}
+	servers := byName["instance_servers"]
+	require.Equal(t, "instance", servers["service"])
+	require.Equal(t, "servers", servers["kind"])
+	require.Equal(t, "server", servers["type"])
+	require.Equal(t, "zone", servers["scope"])
+	require.Equal(t, []any{"id"}, servers["id"])
+	require.Equal(t, []any{"instance_security_groups"}, servers["parents"])
+	require.Equal(t, float64(1), servers["rows"])
+	require.Equal(t, true, byName["schema_versions"]["keep"])
+}
+
+func TestConcurrentWritesLoseNoUpdates(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	_, inst := testutil.DoCreate(t, ts, "/rdb/v1/regions/fr-par/instances", map[string]any{"name": "db", "engine": "PostgreSQL-15"})
+	instPath := "/rdb/v1/regions/fr-par/instances/" + inst["id"].(string)
+	_, before := testutil.DoGet(t, ts, instPath)
+	initial := len(before["endpoints"].([]any))
+	testutil.DoCreate(t, ts, "/domain/v2beta1/dns-zones", map[string]any{"domain": "example.com", "subdomain": ""})
+
+	// send is safe to call from any goroutine: it reports failures as a
+	// status instead of failing the test.
+	send := func(method, path string, body any) int {
+		payload, _ := json.Marshal(body)
+		req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(payload))
+		if err != nil {
+			return 0
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Auth-Token", "test-token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Each endpoint added and each rename rewrites the instance document
+	// from what it read; each "set" deletes the www record then adds one.
+	// A request may lose to a concurrent writer more often than the server
+	// retries it and answer 409, but nothing a 200 answered for is lost.
+	const n = 50
+	type result struct {
+		endpoint bool
+		status   int
+	}
+	results := make(chan result, 3*n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			results <- result{true, send(http.MethodPost, instPath+"/endpoints", map[string]any{"load_balancer": map[string]any{}})}
+		}()
+		go func() {
+			defer wg.Done()
+			results <- result{false, send(http.MethodPatch, instPath, map[string]any{"name": fmt.Sprintf("db-%d", i)})}
+		}()
+		go func() {
+			defer wg.Done()
+			results <- result{false, send(http.MethodPatch, "/domain/v2beta1/dns-zones/example.com/records", map[string]any{
+				"changes": []any{map[string]any{"set": map[string]any{
+					"id_fields": map[string]any{"name": "www", "type": "A"},
+					"records":   []any{map[string]any{"name": "www", "type": "A", "data": fmt.Sprintf("10.0.0.%d", i)}},
+				}}},
+			})}
+		}()
+	}
+	wg.Wait()
+	close(results)
+	added, conflicts := 0, 0
+	for res := range results {
+		require.Contains(t, []int{http.StatusOK, http.StatusConflict}, res.status)
+		switch {
+		case res.status == http.StatusConflict:
+			conflicts++
+		case res.endpoint:
+			added++
+		}
+	}
+	require.Less(t, conflicts, n/2, "retries absorb most conflicts")
+
+	status, after := testutil.DoGet(t, ts, instPath)
+	require.Equal(t, 200, status)
+	require.Equal(t, initial+added, len(after["endpoints"].([]any)), "every added endpoint survives the renames")
+	require.Regexp(t, `^db-\d+$`, after["name"])
+
+	status, records := testutil.DoGet(t, ts, "/domain/v2beta1/dns-zones/example.com/records?name=www")
+	require.Equal(t, 200, status)
+	www := 0
+	for _, rec := range records["records"].([]any) {
+		if rec.(map[string]any)["name"] == "www" {
+			www++
+		}
+	}
+	require.Equal(t, 1, www, "concurrent sets leave exactly one record")
+}
+
+func TestSandboxes(t *testing.T) {
+	ts, cleanup := testutil.NewTestServer(t)
+	defer cleanup()
+
+	// The header and the path prefix select the same sandbox.
+	payload, err := json.Marshal(map[string]any{"name": "in-a"})
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/vpc/v2/regions/fr-par/vpcs", bytes.NewReader(payload))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Auth-Token", "test-token")
+	req.Header.Set(handlers.SandboxHeader, "a")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	testutil.DoCreate(t, ts, "/sandbox/b/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "in-b"})
+
+	vpcNames := func(prefix string) []string {
+		status, body := testutil.DoGet(t, ts, prefix+"/vpc/v2/regions/fr-par/vpcs")
+		require.Equal(t, http.StatusOK, status)
+		names := []string{}
+		for _, v := range body["vpcs"].([]any) {
+			names = append(names, v.(map[string]any)["name"].(string))
+		}
+		return names
+	}
+	require.Equal(t, []string{"in-a"}, vpcNames("/sandbox/a"))
+	require.Equal(t, []string{"in-b"}, vpcNames("/sandbox/b"))
+	require.Empty(t, vpcNames(""))
+
+	// Admin endpoints act on the sandbox only.
+	status, state := testutil.DoGet(t, ts, "/sandbox/a/mock/state")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, state["vpc"].(map[string]any)["vpcs"], 1)
+	status, _ = testutil.DoCreate(t, ts, "/sandbox/a/mock/snapshots/baseline", nil)
+	require.Equal(t, http.StatusCreated, status)
+	require.Empty(t, testutil.ListSnapshots(t, ts))
+	status, _ = testutil.DoCreate(t, ts, "/sandbox/a/mock/reset", nil)
+	require.Equal(t, http.StatusNoContent, status)
+	require.Empty(t, vpcNames("/sandbox/a"))
+	require.Equal(t, []string{"in-b"}, vpcNames("/sandbox/b"))
+	status, _ = testutil.DoCreate(t, ts, "/sandbox/a/mock/snapshots/baseline/restore", nil)
+	require.Equal(t, http.StatusNoContent, status)
+	require.Equal(t, []string{"in-a"}, vpcNames("/sandbox/a"))
+
+	status, body := testutil.DoGet(t, ts, "/mock/sandboxes")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, []any{"a", "b"}, body["sandboxes"])
+
+	// Deleting a sandbox drops its state; naming it again starts afresh.
+	require.Equal(t, http.StatusNoContent, testutil.DoDelete(t, ts, "/mock/sandboxes/a"))
+	require.Equal(t, http.StatusNotFound, testutil.DoDelete(t, ts, "/mock/sandboxes/a"))
+	require.Empty(t, vpcNames("/sandbox/a"))
+	require.Equal(t, []string{"in-b"}, vpcNames("/sandbox/b"))
+
+	status, _ = testutil.DoGet(t, ts, "/sandbox/.hidden/vpc/v2/regions/fr-par/vpcs")
+	require.Equal(t, http.StatusBadRequest, status)
+	status, _ = testutil.DoGet(t, ts, "/sandbox//vpc/v2/regions/fr-par/vpcs")
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, http.StatusBadRequest, testutil.DoDelete(t, ts, "/mock/sandboxes/.hidden"))
+}
+
+func TestSandboxAdminState(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	repo.SetMaxSandboxes(2)
+	ts, cleanup := testutil.NewTestServerFor(t, repo)
+	defer cleanup()
+
+	// Faults, the journal and the generic toggle belong to the
+	// sandbox they were set or recorded in.
+	status, _ := testutil.DoCreate(t, ts, "/sandbox/a/mock/faults", map[string]any{
+		"route":  "/vpc/v2/regions/{region}/vpcs",
+		"status": 503,
+	})
+	require.Equal(t, http.StatusOK, status)
+	status, _ = testutil.DoGet(t, ts, "/sandbox/a/vpc/v2/regions/fr-par/vpcs")
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	status, _ = testutil.DoGet(t, ts, "/vpc/v2/regions/fr-par/vpcs")
+	require.Equal(t, http.StatusOK, status)
+	_, body := testutil.DoList(t, ts, "/mock/faults")
+	require.Empty(t, body["faults"])
+
+	testutil.DoCreate(t, ts, "/sandbox/b/vpc/v2/regions/fr-par/vpcs", map[string]any{"name": "in-b"})
+	_, body = testutil.DoList(t, ts, "/sandbox/b/mock/requests")
+	require.Len(t, body["requests"], 1)
+	_, body = testutil.DoList(t, ts, "/mock/requests")
+	require.Len(t, body["requests"], 1, "only the unsandboxed GET")
+
+	status, body = testutil.DoPut(t, ts, "/sandbox/a/mock/generic", map[string]any{"enabled": true})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, true, body["enabled"])
+	_, body = testutil.DoGet(t, ts, "/mock/generic")
+	require.Equal(t, false, body["enabled"])
+
+	metricsOf := func(prefix string) string {
+		resp, err := http.Get(ts.URL + prefix + "/mock/metrics")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var buf bytes.Buffer
+		_, err = buf.ReadFrom(resp.Body)
+		require.NoError(t, err)
+		return buf.String()
+	}
+	// Request metrics are the process's, labelled by sandbox; resource
+	// counts are the addressed repository's.
+	sandboxPost := `mockway_http_requests_total{service="vpc",route="/vpc/v2/regions/{region}/vpcs",method="POST",status="200",sandbox="b"} 1`
+	require.Contains(t, metricsOf(""), sandboxPost)
+	require.Contains(t, metricsOf("/sandbox/b"), sandboxPost)
+	require.Contains(t, metricsOf("/sandbox/b"), `mockway_resources{table="vpcs"} 1`)
+	require.Contains(t, metricsOf(""), `mockway_resources{table="vpcs"} 0`)
+	require.NotContains(t, metricsOf(""), `method="POST",status="200",sandbox=""}`)
+
+	// Deleting a sandbox drops its faults too, but not its metrics.
+	require.Equal(t, http.StatusNoContent, testutil.DoDelete(t, ts, "/mock/sandboxes/b"))
+	require.Contains(t, metricsOf(""), sandboxPost)
+	require.Equal(t, http.StatusNoContent, testutil.DoDelete(t, ts, "/mock/sandboxes/a"))
+	status, _ = testutil.DoGet(t, ts, "/sandbox/a/vpc/v2/regions/fr-par/vpcs")
+	require.Equal(t, http.StatusOK, status)
+
+	// Beyond --max-sandboxes a new name is refused; existing ones still work.
+	status, _ = testutil.DoGet(t, ts, "/sandbox/b/vpc/v2/regions/fr-par/vpcs")
+	require.Equal(t, http.StatusOK, status)
+	status, body = testutil.DoGet(t, ts, "/sandbox/c/vpc/v2/regions/fr-par/vpcs")
+	require.Equal(t, http.StatusConflict, status)
+	require.Equal(t, "conflict", body["type"])
+	status, _ = testutil.DoGet(t, ts, "/sandbox/a/vpc/v2/regions/fr-par/vpcs")
+	require.Equal(t, http.StatusOK, status)
+}
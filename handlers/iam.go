@@ -13,7 +13,7 @@ func (app *Application) CreateIAMApplication(w http.ResponseWriter, r *http.Requ
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateIAMApplication(body)
+	out, err := app.repoFor(r).CreateIAMApplication(body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -22,7 +22,7 @@ func (app *Application) CreateIAMApplication(w http.ResponseWriter, r *http.Requ
 }
 
 func (app *Application) GetIAMApplication(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetIAMApplication(chi.URLParam(r, "application_id"))
+	out, err := app.repoFor(r).GetIAMApplication(chi.URLParam(r, "application_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -30,13 +30,18 @@ func (app *Application) GetIAMApplication(w http.ResponseWriter, r *http.Request
 	writeJSON(w, http.StatusOK, out)
 }
 
-func (app *Application) ListIAMApplications(w http.ResponseWriter, _ *http.Request) {
-	items, err := app.repo.ListIAMApplications()
+func (app *Application) ListIAMApplications(w http.ResponseWriter, r *http.Request) {
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListIAMApplications(filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "applications", items)
+	writeList(w, r, "applications", items)
 }
 
 func (app *Application) UpdateIAMApplication(w http.ResponseWriter, r *http.Request) {
@@ -45,7 +50,7 @@ func (app *Application) UpdateIAMApplication(w http.ResponseWriter, r *http.Requ
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateIAMApplication(chi.URLParam(r, "application_id"), body)
+	out, err := app.repoFor(r).UpdateIAMApplication(chi.URLParam(r, "application_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -54,7 +59,7 @@ func (app *Application) UpdateIAMApplication(w http.ResponseWriter, r *http.Requ
 }
 
 func (app *Application) DeleteIAMApplication(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteIAMApplication(chi.URLParam(r, "application_id")); err != nil {
+	if err := app.repoFor(r).DeleteIAMApplication(chi.URLParam(r, "application_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -79,13 +84,13 @@ func (app *Application) CreateIAMAPIKey(w http.ResponseWriter, r *http.Request)
 	}
 	// Validate user_id exists if provided — real API rejects unknown user references.
 	if userID != "" {
-		if _, err := app.repo.GetIAMUser(userID); err != nil {
+		if _, err := app.repoFor(r).GetIAMUser(userID); err != nil {
 			writeCreateError(w, err)
 			return
 		}
 	}
 
-	out, err := app.repo.CreateIAMAPIKey(body)
+	out, err := app.repoFor(r).CreateIAMAPIKey(body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -94,7 +99,7 @@ func (app *Application) CreateIAMAPIKey(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *Application) GetIAMAPIKey(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetIAMAPIKey(chi.URLParam(r, "access_key"))
+	out, err := app.repoFor(r).GetIAMAPIKey(chi.URLParam(r, "access_key"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -102,13 +107,18 @@ func (app *Application) GetIAMAPIKey(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, out)
 }
 
-func (app *Application) ListIAMAPIKeys(w http.ResponseWriter, _ *http.Request) {
-	items, err := app.repo.ListIAMAPIKeys()
+func (app *Application) ListIAMAPIKeys(w http.ResponseWriter, r *http.Request) {
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListIAMAPIKeys(filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "api_keys", items)
+	writeList(w, r, "api_keys", items)
 }
 
 func (app *Application) UpdateIAMAPIKey(w http.ResponseWriter, r *http.Request) {
@@ -117,7 +127,7 @@ func (app *Application) UpdateIAMAPIKey(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateIAMAPIKey(chi.URLParam(r, "access_key"), body)
+	out, err := app.repoFor(r).UpdateIAMAPIKey(chi.URLParam(r, "access_key"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -126,7 +136,7 @@ func (app *Application) UpdateIAMAPIKey(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *Application) DeleteIAMAPIKey(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteIAMAPIKey(chi.URLParam(r, "access_key")); err != nil {
+	if err := app.repoFor(r).DeleteIAMAPIKey(chi.URLParam(r, "access_key")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -142,7 +152,7 @@ func (app *Application) CreateIAMPolicy(w http.ResponseWriter, r *http.Request)
 	if strings.TrimSpace(anyString(body["application_id"])) == "" {
 		delete(body, "application_id")
 	}
-	out, err := app.repo.CreateIAMPolicy(body)
+	out, err := app.repoFor(r).CreateIAMPolicy(body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -151,7 +161,7 @@ func (app *Application) CreateIAMPolicy(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *Application) GetIAMPolicy(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetIAMPolicy(chi.URLParam(r, "policy_id"))
+	out, err := app.repoFor(r).GetIAMPolicy(chi.URLParam(r, "policy_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -159,13 +169,18 @@ func (app *Application) GetIAMPolicy(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, out)
 }
 
-func (app *Application) ListIAMPolicies(w http.ResponseWriter, _ *http.Request) {
-	items, err := app.repo.ListIAMPolicies()
+func (app *Application) ListIAMPolicies(w http.ResponseWriter, r *http.Request) {
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListIAMPolicies(filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "policies", items)
+	writeList(w, r, "policies", items)
 }
 
 func (app *Application) UpdateIAMPolicy(w http.ResponseWriter, r *http.Request) {
@@ -174,7 +189,7 @@ func (app *Application) UpdateIAMPolicy(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateIAMPolicy(chi.URLParam(r, "policy_id"), body)
+	out, err := app.repoFor(r).UpdateIAMPolicy(chi.URLParam(r, "policy_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -183,7 +198,7 @@ func (app *Application) UpdateIAMPolicy(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *Application) DeleteIAMPolicy(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteIAMPolicy(chi.URLParam(r, "policy_id")); err != nil {
+	if err := app.repoFor(r).DeleteIAMPolicy(chi.URLParam(r, "policy_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -201,12 +216,12 @@ func (app *Application) SetIAMRules(w http.ResponseWriter, r *http.Request) {
 	if rules == nil {
 		rules = []any{}
 	}
-	result, err := app.repo.SetIAMRules(policyID, rules)
+	result, err := app.repoFor(r).SetIAMRules(policyID, rules)
 	if err != nil {
 		writeCreateError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"rules": result, "total_count": len(result)})
+	writeList(w, r, "rules", result)
 }
 
 func (app *Application) CreateIAMRule(w http.ResponseWriter, r *http.Request) {
@@ -215,7 +230,7 @@ func (app *Application) CreateIAMRule(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateIAMRule(body)
+	out, err := app.repoFor(r).CreateIAMRule(body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -226,15 +241,15 @@ func (app *Application) CreateIAMRule(w http.ResponseWriter, r *http.Request) {
 func (app *Application) ListIAMRules(w http.ResponseWriter, r *http.Request) {
 	policyID := r.URL.Query().Get("policy_id")
 	if policyID == "" {
-		writeList(w, "rules", []map[string]any{})
+		writeList(w, r, "rules", []map[string]any{})
 		return
 	}
-	items, err := app.repo.ListIAMRulesByPolicy(policyID)
+	items, err := app.repoFor(r).ListIAMRulesByPolicy(policyID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "rules", items)
+	writeList(w, r, "rules", items)
 }
 
 func (app *Application) CreateIAMSSHKey(w http.ResponseWriter, r *http.Request) {
@@ -243,7 +258,7 @@ func (app *Application) CreateIAMSSHKey(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateIAMSSHKey(body)
+	out, err := app.repoFor(r).CreateIAMSSHKey(body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -252,7 +267,7 @@ func (app *Application) CreateIAMSSHKey(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *Application) GetIAMSSHKey(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetIAMSSHKey(chi.URLParam(r, "ssh_key_id"))
+	out, err := app.repoFor(r).GetIAMSSHKey(chi.URLParam(r, "ssh_key_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -260,13 +275,18 @@ func (app *Application) GetIAMSSHKey(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, out)
 }
 
-func (app *Application) ListIAMSSHKeys(w http.ResponseWriter, _ *http.Request) {
-	items, err := app.repo.ListIAMSSHKeys()
+func (app *Application) ListIAMSSHKeys(w http.ResponseWriter, r *http.Request) {
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListIAMSSHKeys(filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "ssh_keys", items)
+	writeList(w, r, "ssh_keys", items)
 }
 
 func (app *Application) UpdateIAMSSHKey(w http.ResponseWriter, r *http.Request) {
@@ -275,7 +295,7 @@ func (app *Application) UpdateIAMSSHKey(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateIAMSSHKey(chi.URLParam(r, "ssh_key_id"), body)
+	out, err := app.repoFor(r).UpdateIAMSSHKey(chi.URLParam(r, "ssh_key_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -284,7 +304,7 @@ func (app *Application) UpdateIAMSSHKey(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *Application) DeleteIAMSSHKey(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteIAMSSHKey(chi.URLParam(r, "ssh_key_id")); err != nil {
+	if err := app.repoFor(r).DeleteIAMSSHKey(chi.URLParam(r, "ssh_key_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -304,7 +324,7 @@ func (app *Application) CreateIAMUser(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateIAMUser(body)
+	out, err := app.repoFor(r).CreateIAMUser(body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -313,7 +333,7 @@ func (app *Application) CreateIAMUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) GetIAMUser(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetIAMUser(chi.URLParam(r, "user_id"))
+	out, err := app.repoFor(r).GetIAMUser(chi.URLParam(r, "user_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -321,13 +341,18 @@ func (app *Application) GetIAMUser(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, out)
 }
 
-func (app *Application) ListIAMUsers(w http.ResponseWriter, _ *http.Request) {
-	items, err := app.repo.ListIAMUsers()
+func (app *Application) ListIAMUsers(w http.ResponseWriter, r *http.Request) {
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListIAMUsers(filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "users", items)
+	writeList(w, r, "users", items)
 }
 
 func (app *Application) UpdateIAMUser(w http.ResponseWriter, r *http.Request) {
@@ -336,7 +361,7 @@ func (app *Application) UpdateIAMUser(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateIAMUser(chi.URLParam(r, "user_id"), body)
+	out, err := app.repoFor(r).UpdateIAMUser(chi.URLParam(r, "user_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -351,7 +376,7 @@ func (app *Application) UpdateIAMUserUsername(w http.ResponseWriter, r *http.Req
 		return
 	}
 	username, _ := body["username"].(string)
-	out, err := app.repo.UpdateIAMUserUsername(chi.URLParam(r, "user_id"), username)
+	out, err := app.repoFor(r).UpdateIAMUserUsername(chi.URLParam(r, "user_id"), username)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -360,7 +385,7 @@ func (app *Application) UpdateIAMUserUsername(w http.ResponseWriter, r *http.Req
 }
 
 func (app *Application) DeleteIAMUser(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteIAMUser(chi.URLParam(r, "user_id")); err != nil {
+	if err := app.repoFor(r).DeleteIAMUser(chi.URLParam(r, "user_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -375,7 +400,7 @@ func (app *Application) CreateIAMGroup(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateIAMGroup(body)
+	out, err := app.repoFor(r).CreateIAMGroup(body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -384,7 +409,7 @@ func (app *Application) CreateIAMGroup(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) GetIAMGroup(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetIAMGroup(chi.URLParam(r, "group_id"))
+	out, err := app.repoFor(r).GetIAMGroup(chi.URLParam(r, "group_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -392,13 +417,18 @@ func (app *Application) GetIAMGroup(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, out)
 }
 
-func (app *Application) ListIAMGroups(w http.ResponseWriter, _ *http.Request) {
-	items, err := app.repo.ListIAMGroups()
+func (app *Application) ListIAMGroups(w http.ResponseWriter, r *http.Request) {
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListIAMGroups(filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "groups", items)
+	writeList(w, r, "groups", items)
 }
 
 func (app *Application) UpdateIAMGroup(w http.ResponseWriter, r *http.Request) {
@@ -407,7 +437,7 @@ func (app *Application) UpdateIAMGroup(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateIAMGroup(chi.URLParam(r, "group_id"), body)
+	out, err := app.repoFor(r).UpdateIAMGroup(chi.URLParam(r, "group_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -416,7 +446,7 @@ func (app *Application) UpdateIAMGroup(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) DeleteIAMGroup(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteIAMGroup(chi.URLParam(r, "group_id")); err != nil {
+	if err := app.repoFor(r).DeleteIAMGroup(chi.URLParam(r, "group_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -430,7 +460,7 @@ func (app *Application) AddIAMGroupMember(w http.ResponseWriter, r *http.Request
 		return
 	}
 	userID, _ := body["user_id"].(string)
-	out, err := app.repo.AddIAMGroupMember(chi.URLParam(r, "group_id"), userID)
+	out, err := app.repoFor(r).AddIAMGroupMember(chi.URLParam(r, "group_id"), userID)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -445,7 +475,7 @@ func (app *Application) RemoveIAMGroupMember(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	userID, _ := body["user_id"].(string)
-	out, err := app.repo.RemoveIAMGroupMember(chi.URLParam(r, "group_id"), userID)
+	out, err := app.repoFor(r).RemoveIAMGroupMember(chi.URLParam(r, "group_id"), userID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -472,7 +502,7 @@ func (app *Application) SetIAMGroupMembers(w http.ResponseWriter, r *http.Reques
 			userIDs = append(userIDs, s)
 		}
 	}
-	out, err := app.repo.SetIAMGroupMembers(chi.URLParam(r, "group_id"), userIDs)
+	out, err := app.repoFor(r).SetIAMGroupMembers(chi.URLParam(r, "group_id"), userIDs)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -130,7 +130,7 @@ func (app *Application) CreateServer(w http.ResponseWriter, r *http.Request) {
 	zone := chi.URLParam(r, "zone")
 	normalizeServerSecurityGroup(body)
 	normalizeServerImage(body, zone)
-	out, err := app.repo.CreateServer(zone, body)
+	out, err := app.repoFor(r).CreateServer(zone, body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -237,7 +237,7 @@ func normalizeServerImage(body map[string]any, zone string) {
 }
 
 func (app *Application) GetServer(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetServer(chi.URLParam(r, "server_id"))
+	out, err := app.repoFor(r).GetServer(chi.URLParam(r, "server_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -251,7 +251,7 @@ func (app *Application) UpdateServer(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateServer(chi.URLParam(r, "server_id"), body)
+	out, err := app.repoFor(r).UpdateServer(chi.URLParam(r, "server_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -260,7 +260,7 @@ func (app *Application) UpdateServer(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) ListServerUserData(w http.ResponseWriter, r *http.Request) {
-	if _, err := app.repo.GetServer(chi.URLParam(r, "server_id")); err != nil {
+	if _, err := app.repoFor(r).GetServer(chi.URLParam(r, "server_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -270,7 +270,7 @@ func (app *Application) ListServerUserData(w http.ResponseWriter, r *http.Reques
 // GetServerUserDataKey handles GET /servers/{server_id}/user_data/{key}.
 // We discard user_data on write so return an empty value stub.
 func (app *Application) GetServerUserDataKey(w http.ResponseWriter, r *http.Request) {
-	if _, err := app.repo.GetServer(chi.URLParam(r, "server_id")); err != nil {
+	if _, err := app.repoFor(r).GetServer(chi.URLParam(r, "server_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -280,7 +280,7 @@ func (app *Application) GetServerUserDataKey(w http.ResponseWriter, r *http.Requ
 
 func (app *Application) ServerAction(w http.ResponseWriter, r *http.Request) {
 	serverID := chi.URLParam(r, "server_id")
-	if _, err := app.repo.GetServer(serverID); err != nil {
+	if _, err := app.repoFor(r).GetServer(serverID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -292,12 +292,12 @@ func (app *Application) ServerAction(w http.ResponseWriter, r *http.Request) {
 	action, _ := body["action"].(string)
 	switch action {
 	case "terminate":
-		if err := app.repo.DeleteServer(serverID); err != nil {
+		if err := app.repoFor(r).DeleteServer(serverID); err != nil {
 			writeDomainError(w, err)
 			return
 		}
 	case "poweron":
-		if err := app.repo.SetServerState(serverID, "running"); err != nil {
+		if err := app.repoFor(r).SetServerState(serverID, "running"); err != nil {
 			writeDomainError(w, err)
 			return
 		}
@@ -306,12 +306,12 @@ func (app *Application) ServerAction(w http.ResponseWriter, r *http.Request) {
 		if action == "stop_in_place" {
 			state = "stopped_in_place"
 		}
-		if err := app.repo.SetServerState(serverID, state); err != nil {
+		if err := app.repoFor(r).SetServerState(serverID, state); err != nil {
 			writeDomainError(w, err)
 			return
 		}
 	case "reboot":
-		if err := app.repo.SetServerState(serverID, "running"); err != nil {
+		if err := app.repoFor(r).SetServerState(serverID, "running"); err != nil {
 			writeDomainError(w, err)
 			return
 		}
@@ -321,7 +321,7 @@ func (app *Application) ServerAction(w http.ResponseWriter, r *http.Request) {
 	}
 	writeJSON(w, http.StatusOK, map[string]any{
 		"task": map[string]any{
-			"id":          uuid.NewString(),
+			"id":          app.repoFor(r).NewID(),
 			"description": action,
 			"progress":    100,
 			"status":      "success",
@@ -330,7 +330,7 @@ func (app *Application) ServerAction(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) SetServerUserData(w http.ResponseWriter, r *http.Request) {
-	if _, err := app.repo.GetServer(chi.URLParam(r, "server_id")); err != nil {
+	if _, err := app.repoFor(r).GetServer(chi.URLParam(r, "server_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -339,7 +339,7 @@ func (app *Application) SetServerUserData(w http.ResponseWriter, r *http.Request
 }
 
 func (app *Application) GetVolume(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetInstanceVolume(chi.URLParam(r, "zone"), chi.URLParam(r, "volume_id"))
+	out, err := app.repoFor(r).GetInstanceVolume(chi.URLParam(r, "zone"), chi.URLParam(r, "volume_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -350,7 +350,7 @@ func (app *Application) GetVolume(w http.ResponseWriter, r *http.Request) {
 func (app *Application) DeleteVolume(w http.ResponseWriter, r *http.Request) {
 	// Standalone volumes can be deleted; embedded server volumes are no-op.
 	id := chi.URLParam(r, "volume_id")
-	if err := app.repo.DeleteStandaloneVolume(id); err != nil && !errors.Is(err, models.ErrNotFound) {
+	if err := app.repoFor(r).DeleteStandaloneVolume(id); err != nil && !errors.Is(err, models.ErrNotFound) {
 		writeDomainError(w, err)
 		return
 	}
@@ -363,7 +363,7 @@ func (app *Application) CreateVolume(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateInstanceVolume(chi.URLParam(r, "zone"), body)
+	out, err := app.repoFor(r).CreateInstanceVolume(chi.URLParam(r, "zone"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -372,12 +372,17 @@ func (app *Application) CreateVolume(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) ListVolumes(w http.ResponseWriter, r *http.Request) {
-	items, err := app.repo.ListInstanceVolumes(chi.URLParam(r, "zone"))
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListInstanceVolumes(chi.URLParam(r, "zone"), filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "volumes", items)
+	writeList(w, r, "volumes", items)
 }
 
 func (app *Application) PatchVolume(w http.ResponseWriter, r *http.Request) {
@@ -386,7 +391,7 @@ func (app *Application) PatchVolume(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateInstanceVolume(chi.URLParam(r, "volume_id"), body)
+	out, err := app.repoFor(r).UpdateInstanceVolume(chi.URLParam(r, "volume_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -395,16 +400,21 @@ func (app *Application) PatchVolume(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) ListServers(w http.ResponseWriter, r *http.Request) {
-	items, err := app.repo.ListServers(chi.URLParam(r, "zone"))
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListServers(chi.URLParam(r, "zone"), filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "servers", items)
+	writeList(w, r, "servers", items)
 }
 
 func (app *Application) DeleteServer(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteServer(chi.URLParam(r, "server_id")); err != nil {
+	if err := app.repoFor(r).DeleteServer(chi.URLParam(r, "server_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -417,7 +427,7 @@ func (app *Application) CreateIP(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateIP(chi.URLParam(r, "zone"), body)
+	out, err := app.repoFor(r).CreateIP(chi.URLParam(r, "zone"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -426,7 +436,7 @@ func (app *Application) CreateIP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) GetIP(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetIP(chi.URLParam(r, "ip_id"))
+	out, err := app.repoFor(r).GetIP(chi.URLParam(r, "ip_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -440,7 +450,7 @@ func (app *Application) UpdateInstanceIP(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateIP(chi.URLParam(r, "ip_id"), body)
+	out, err := app.repoFor(r).UpdateIP(chi.URLParam(r, "ip_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -449,16 +459,21 @@ func (app *Application) UpdateInstanceIP(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *Application) ListIPs(w http.ResponseWriter, r *http.Request) {
-	items, err := app.repo.ListIPs(chi.URLParam(r, "zone"))
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListIPs(chi.URLParam(r, "zone"), filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "ips", items)
+	writeList(w, r, "ips", items)
 }
 
 func (app *Application) DeleteIP(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteIP(chi.URLParam(r, "ip_id")); err != nil {
+	if err := app.repoFor(r).DeleteIP(chi.URLParam(r, "ip_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -471,7 +486,7 @@ func (app *Application) CreateSecurityGroup(w http.ResponseWriter, r *http.Reque
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateSecurityGroup(chi.URLParam(r, "zone"), body)
+	out, err := app.repoFor(r).CreateSecurityGroup(chi.URLParam(r, "zone"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -480,7 +495,7 @@ func (app *Application) CreateSecurityGroup(w http.ResponseWriter, r *http.Reque
 }
 
 func (app *Application) GetSecurityGroup(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetSecurityGroup(chi.URLParam(r, "sg_id"))
+	out, err := app.repoFor(r).GetSecurityGroup(chi.URLParam(r, "sg_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -515,16 +530,21 @@ func splitSecurityGroupRules(sg map[string]any) {
 }
 
 func (app *Application) ListSecurityGroups(w http.ResponseWriter, r *http.Request) {
-	items, err := app.repo.ListSecurityGroups(chi.URLParam(r, "zone"))
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListSecurityGroups(chi.URLParam(r, "zone"), filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "security_groups", items)
+	writeList(w, r, "security_groups", items)
 }
 
 func (app *Application) DeleteSecurityGroup(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteSecurityGroup(chi.URLParam(r, "sg_id")); err != nil {
+	if err := app.repoFor(r).DeleteSecurityGroup(chi.URLParam(r, "sg_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -537,7 +557,7 @@ func (app *Application) UpdateSecurityGroup(w http.ResponseWriter, r *http.Reque
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateSecurityGroup(chi.URLParam(r, "sg_id"), body)
+	out, err := app.repoFor(r).UpdateSecurityGroup(chi.URLParam(r, "sg_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -555,7 +575,7 @@ func (app *Application) SetSecurityGroupRules(w http.ResponseWriter, r *http.Req
 	if !ok {
 		rules = body
 	}
-	if _, err := app.repo.SetSecurityGroupRules(chi.URLParam(r, "sg_id"), rules); err != nil {
+	if _, err := app.repoFor(r).SetSecurityGroupRules(chi.URLParam(r, "sg_id"), rules); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -563,15 +583,12 @@ func (app *Application) SetSecurityGroupRules(w http.ResponseWriter, r *http.Req
 }
 
 func (app *Application) GetSecurityGroupRules(w http.ResponseWriter, r *http.Request) {
-	rules, err := app.repo.GetSecurityGroupRules(chi.URLParam(r, "sg_id"))
+	rules, err := app.repoFor(r).GetSecurityGroupRules(chi.URLParam(r, "sg_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{
-		"rules":       rules,
-		"total_count": len(rules),
-	})
+	writeList(w, r, "rules", rules)
 }
 
 func (app *Application) CreatePrivateNIC(w http.ResponseWriter, r *http.Request) {
@@ -580,7 +597,7 @@ func (app *Application) CreatePrivateNIC(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreatePrivateNIC(chi.URLParam(r, "zone"), chi.URLParam(r, "server_id"), body)
+	out, err := app.repoFor(r).CreatePrivateNIC(chi.URLParam(r, "zone"), chi.URLParam(r, "server_id"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -589,7 +606,7 @@ func (app *Application) CreatePrivateNIC(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *Application) GetPrivateNIC(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetPrivateNIC(chi.URLParam(r, "nic_id"))
+	out, err := app.repoFor(r).GetPrivateNIC(chi.URLParam(r, "nic_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -606,23 +623,23 @@ func (app *Application) GetPrivateNIC(w http.ResponseWriter, r *http.Request) {
 
 func (app *Application) ListPrivateNICs(w http.ResponseWriter, r *http.Request) {
 	serverID := chi.URLParam(r, "server_id")
-	if _, err := app.repo.GetServer(serverID); err != nil {
+	if _, err := app.repoFor(r).GetServer(serverID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	items, err := app.repo.ListPrivateNICsByServer(serverID)
+	items, err := app.repoFor(r).ListPrivateNICsByServer(serverID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "private_nics", items)
+	writeList(w, r, "private_nics", items)
 }
 
 func (app *Application) DeletePrivateNIC(w http.ResponseWriter, r *http.Request) {
 	nicID := chi.URLParam(r, "nic_id")
 	// Validate the NIC belongs to the server in the URL path.
 	if serverID := chi.URLParam(r, "server_id"); serverID != "" {
-		nic, err := app.repo.GetPrivateNIC(nicID)
+		nic, err := app.repoFor(r).GetPrivateNIC(nicID)
 		if err != nil {
 			writeDomainError(w, err)
 			return
@@ -632,7 +649,7 @@ func (app *Application) DeletePrivateNIC(w http.ResponseWriter, r *http.Request)
 			return
 		}
 	}
-	if err := app.repo.DeletePrivateNIC(nicID); err != nil {
+	if err := app.repoFor(r).DeletePrivateNIC(nicID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -12,7 +12,7 @@ func (app *Application) CreateIPAMIP(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateIPAMIP(chi.URLParam(r, "region"), body)
+	out, err := app.repoFor(r).CreateIPAMIP(chi.URLParam(r, "region"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -21,7 +21,7 @@ func (app *Application) CreateIPAMIP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) GetIPAMIP(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetIPAMIP(chi.URLParam(r, "ip_id"))
+	out, err := app.repoFor(r).GetIPAMIP(chi.URLParam(r, "ip_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -35,7 +35,7 @@ func (app *Application) UpdateIPAMIP(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateIPAMIP(chi.URLParam(r, "ip_id"), body)
+	out, err := app.repoFor(r).UpdateIPAMIP(chi.URLParam(r, "ip_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -44,7 +44,7 @@ func (app *Application) UpdateIPAMIP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) DeleteIPAMIP(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteIPAMIP(chi.URLParam(r, "ip_id")); err != nil {
+	if err := app.repoFor(r).DeleteIPAMIP(chi.URLParam(r, "ip_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -52,7 +52,7 @@ func (app *Application) DeleteIPAMIP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) DetachIPAMIP(w http.ResponseWriter, r *http.Request) {
-	if _, err := app.repo.GetIPAMIP(chi.URLParam(r, "ip_id")); err != nil {
+	if _, err := app.repoFor(r).GetIPAMIP(chi.URLParam(r, "ip_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -60,7 +60,7 @@ func (app *Application) DetachIPAMIP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) MoveIPAMIP(w http.ResponseWriter, r *http.Request) {
-	if _, err := app.repo.GetIPAMIP(chi.URLParam(r, "ip_id")); err != nil {
+	if _, err := app.repoFor(r).GetIPAMIP(chi.URLParam(r, "ip_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -74,9 +74,9 @@ func (app *Application) ListIPAMIPs(w http.ResponseWriter, r *http.Request) {
 	resourceType := r.URL.Query().Get("resource_type")
 
 	if resourceType == "instance_private_nic" && resourceID != "" {
-		nic, err := app.repo.GetPrivateNIC(resourceID)
+		nic, err := app.repoFor(r).GetPrivateNIC(resourceID)
 		if err != nil {
-			writeJSON(w, http.StatusOK, map[string]any{"ips": []any{}, "total_count": 0})
+			writeList(w, r, "ips", []any{})
 			return
 		}
 		privIPs, _ := nic["private_ips"].([]any)
@@ -96,16 +96,21 @@ func (app *Application) ListIPAMIPs(w http.ResponseWriter, r *http.Request) {
 				},
 			})
 		}
-		writeJSON(w, http.StatusOK, map[string]any{"ips": ips, "total_count": len(ips)})
+		writeList(w, r, "ips", ips)
 		return
 	}
 
 	// Fall through to stored IPAM IPs for normal (non-NIC) queries.
 	region := chi.URLParam(r, "region")
-	items, err := app.repo.ListIPAMIPs(region)
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListIPAMIPs(region, filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "ips", items)
+	writeList(w, r, "ips", items)
 }
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redscaresu/mockway/capture"
+)
+
+// DefaultJournalSize is how many requests the journal keeps when no size is
+// configured. Older entries are dropped first.
+const DefaultJournalSize = 1000
+
+// journalEntry is one recorded request/response pair. Bodies that are valid
+// JSON are kept as JSON so they read naturally in /mock/requests, with
+// secrets blanked as in capture fixtures.
+type journalEntry struct {
+	ID           int64           `json:"id"`
+	Time         time.Time       `json:"time"`
+	Service      string          `json:"service"`
+	Method       string          `json:"method"`
+	Path         string          `json:"path"`
+	Query        string          `json:"query,omitempty"`
+	Token        string          `json:"token,omitempty"`
+	RequestBody  json.RawMessage `json:"request_body,omitempty"`
+	Status       int             `json:"status"`
+	ResponseBody json.RawMessage `json:"response_body,omitempty"`
+	DurationMS   float64         `json:"duration_ms"`
+}
+
+// journal is a fixed-size ring buffer of recorded requests. Once full, each
+// entry overwrites the oldest, at head.
+type journal struct {
+	mu      sync.Mutex
+	size    int
+	nextID  int64
+	entries []journalEntry
+	head    int
+}
+
+func newJournal(size int) *journal {
+	if size <= 0 {
+		size = DefaultJournalSize
+	}
+	return &journal{size: size, nextID: 1}
+}
+
+func (j *journal) add(e journalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e.ID = j.nextID
+	j.nextID++
+	if len(j.entries) < j.size {
+		j.entries = append(j.entries, e)
+		return
+	}
+	j.entries[j.head] = e
+	j.head = (j.head + 1) % j.size
+}
+
+func (j *journal) clear() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = nil
+	j.head = 0
+}
+
+// SetJournalSize replaces the server's journal with an empty one keeping
+// size entries; sandboxes created after keep as many.
+func (app *Application) SetJournalSize(size int) {
+	app.state.journal = newJournal(size)
+}
+
+// journalFilter narrows /mock/requests. Since accepts either an RFC 3339
+// timestamp or an entry id (entries after that id are returned).
+type journalFilter struct {
+	service string
+	method  string
+	path    string
+	sinceID int64
+	since   time.Time
+}
+
+func (f journalFilter) match(e journalEntry) bool {
+	if f.service != "" && e.Service != f.service {
+		return false
+	}
+	if f.method != "" && !strings.EqualFold(e.Method, f.method) {
+		return false
+	}
+	if f.path != "" {
+		if ok, _ := path.Match(f.path, e.Path); !ok {
+			return false
+		}
+	}
+	if f.sinceID > 0 && e.ID <= f.sinceID {
+		return false
+	}
+	if !f.since.IsZero() && e.Time.Before(f.since) {
+		return false
+	}
+	return true
+}
+
+func (j *journal) list(f journalFilter) []journalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := []journalEntry{}
+	for i := range j.entries {
+		e := j.entries[(j.head+i)%len(j.entries)]
+		if f.match(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// journalToken identifies a token by the first 8 hex digits of its SHA-256,
+// so tests can tell callers apart: the journal is served to anyone who can
+// reach /mock/, so it keeps no credentials.
+func journalToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:4])
+}
+
+// recordingWriter captures the status and body written by the handler.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// recordRequests journals every Scaleway request. It sits in front of the
+// other middleware so injected faults and throttling are recorded as the
+// client saw them; aborted connections are recorded with status 0.
+func (app *Application) recordRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/mock/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var reqBody []byte
+		if r.Body != nil {
+			reqBody, _ = io.ReadAll(r.Body)
+			_ = r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(reqBody))
+		}
+		start := time.Now()
+		rec := &recordingWriter{ResponseWriter: w}
+		defer func() {
+			app.stateFor(r).journal.add(journalEntry{
+				Time:         start.UTC(),
+				Service:      strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0],
+				Method:       r.Method,
+				Path:         r.URL.Path,
+				Query:        r.URL.RawQuery,
+				Token:        journalToken(r.Header.Get("X-Auth-Token")),
+				RequestBody:  capture.RedactBody(reqBody),
+				Status:       rec.status,
+				ResponseBody: capture.RedactBody(rec.body.Bytes()),
+				DurationMS:   float64(time.Since(start).Microseconds()) / 1000,
+			})
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// ListRequests handles GET /mock/requests. Filters: service, method, path
+// (a path.Match glob, e.g. /vpc/v2/regions/*/vpcs/*) and since (RFC 3339
+// time or the id of the last entry already seen). Entries are oldest first.
+func (app *Application) ListRequests(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := journalFilter{service: q.Get("service"), method: q.Get("method"), path: q.Get("path")}
+	if f.path != "" {
+		if _, err := path.Match(f.path, ""); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid path glob: " + err.Error(), "type": "invalid_argument"})
+			return
+		}
+	}
+	if raw := q.Get("since"); raw != "" {
+		if id, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			f.sinceID = id
+		} else if t, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+			f.since = t
+		} else {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"message": "since must be an RFC 3339 time or an entry id", "type": "invalid_argument"})
+			return
+		}
+	}
+	items := app.stateFor(r).journal.list(f)
+	writeJSON(w, http.StatusOK, map[string]any{"requests": items, "total_count": len(items)})
+}
+
+// ClearRequests handles DELETE /mock/requests.
+func (app *Application) ClearRequests(w http.ResponseWriter, r *http.Request) {
+	app.stateFor(r).journal.clear()
+	writeNoContent(w)
+}
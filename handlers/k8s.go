@@ -53,7 +53,7 @@ func (app *Application) GetNode(w http.ResponseWriter, r *http.Request) {
 	nodeID := chi.URLParam(r, "node_id")
 	region := chi.URLParam(r, "region")
 
-	pools, err := app.repo.ListAllPools()
+	pools, err := app.repoFor(r).ListAllPools()
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -97,12 +97,12 @@ func (app *Application) GetNode(w http.ResponseWriter, r *http.Request) {
 
 func (app *Application) ListClusterNodes(w http.ResponseWriter, r *http.Request) {
 	clusterID := chi.URLParam(r, "cluster_id")
-	if _, err := app.repo.GetCluster(clusterID); err != nil {
+	if _, err := app.repoFor(r).GetCluster(clusterID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
 	// Return nodes based on existing pools for this cluster.
-	pools, err := app.repo.ListPoolsByCluster(clusterID)
+	pools, err := app.repoFor(r).ListPoolsByCluster(clusterID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -133,15 +133,12 @@ func (app *Application) ListClusterNodes(w http.ResponseWriter, r *http.Request)
 			})
 		}
 	}
-	writeJSON(w, http.StatusOK, map[string]any{
-		"nodes":       nodes,
-		"total_count": len(nodes),
-	})
+	writeList(w, r, "nodes", nodes)
 }
 
 func (app *Application) GetClusterKubeconfig(w http.ResponseWriter, r *http.Request) {
 	clusterID := chi.URLParam(r, "cluster_id")
-	if _, err := app.repo.GetCluster(clusterID); err != nil {
+	if _, err := app.repoFor(r).GetCluster(clusterID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -160,7 +157,7 @@ func (app *Application) CreateCluster(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateCluster(chi.URLParam(r, "region"), body)
+	out, err := app.repoFor(r).CreateCluster(chi.URLParam(r, "region"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -169,7 +166,7 @@ func (app *Application) CreateCluster(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) GetCluster(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetCluster(chi.URLParam(r, "cluster_id"))
+	out, err := app.repoFor(r).GetCluster(chi.URLParam(r, "cluster_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -178,12 +175,17 @@ func (app *Application) GetCluster(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) ListClusters(w http.ResponseWriter, r *http.Request) {
-	items, err := app.repo.ListClusters(chi.URLParam(r, "region"))
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListClusters(chi.URLParam(r, "region"), filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "clusters", items)
+	writeList(w, r, "clusters", items)
 }
 
 func (app *Application) UpdateCluster(w http.ResponseWriter, r *http.Request) {
@@ -192,7 +194,7 @@ func (app *Application) UpdateCluster(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateCluster(chi.URLParam(r, "cluster_id"), body)
+	out, err := app.repoFor(r).UpdateCluster(chi.URLParam(r, "cluster_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -204,12 +206,12 @@ func (app *Application) DeleteCluster(w http.ResponseWriter, r *http.Request) {
 	clusterID := chi.URLParam(r, "cluster_id")
 	// The Scaleway SDK expects DELETE to return the cluster object so it can
 	// poll for deletion completion using the cluster ID from the response.
-	out, err := app.repo.GetCluster(clusterID)
+	out, err := app.repoFor(r).GetCluster(clusterID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	if err := app.repo.DeleteCluster(clusterID); err != nil {
+	if err := app.repoFor(r).DeleteCluster(clusterID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -223,7 +225,7 @@ func (app *Application) CreatePool(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreatePool(chi.URLParam(r, "region"), chi.URLParam(r, "cluster_id"), body)
+	out, err := app.repoFor(r).CreatePool(chi.URLParam(r, "region"), chi.URLParam(r, "cluster_id"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -232,7 +234,7 @@ func (app *Application) CreatePool(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) GetPool(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetPool(chi.URLParam(r, "pool_id"))
+	out, err := app.repoFor(r).GetPool(chi.URLParam(r, "pool_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -242,16 +244,21 @@ func (app *Application) GetPool(w http.ResponseWriter, r *http.Request) {
 
 func (app *Application) ListPools(w http.ResponseWriter, r *http.Request) {
 	clusterID := chi.URLParam(r, "cluster_id")
-	if _, err := app.repo.GetCluster(clusterID); err != nil {
+	if _, err := app.repoFor(r).GetCluster(clusterID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	items, err := app.repo.ListPoolsByCluster(clusterID)
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListPoolsByCluster(clusterID, filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "pools", items)
+	writeList(w, r, "pools", items)
 }
 
 func (app *Application) UpdatePool(w http.ResponseWriter, r *http.Request) {
@@ -260,7 +267,7 @@ func (app *Application) UpdatePool(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdatePool(chi.URLParam(r, "pool_id"), body)
+	out, err := app.repoFor(r).UpdatePool(chi.URLParam(r, "pool_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -272,12 +279,12 @@ func (app *Application) DeletePool(w http.ResponseWriter, r *http.Request) {
 	poolID := chi.URLParam(r, "pool_id")
 	// The Scaleway SDK expects DELETE to return the pool object so it can
 	// poll for deletion completion using the pool ID from the response.
-	out, err := app.repo.GetPool(poolID)
+	out, err := app.repoFor(r).GetPool(poolID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	if err := app.repo.DeletePool(poolID); err != nil {
+	if err := app.repoFor(r).DeletePool(poolID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -321,7 +328,7 @@ func (app *Application) UpgradeCluster(w http.ResponseWriter, r *http.Request) {
 	if v, ok := body["version"].(string); ok && v != "" {
 		patch["version"] = v
 	}
-	out, err := app.repo.UpdateCluster(clusterID, patch)
+	out, err := app.repoFor(r).UpdateCluster(clusterID, patch)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -342,7 +349,7 @@ func (app *Application) UpgradePool(w http.ResponseWriter, r *http.Request) {
 	if v, ok := body["version"].(string); ok && v != "" {
 		patch["version"] = v
 	}
-	out, err := app.repo.UpdatePool(poolID, patch)
+	out, err := app.repoFor(r).UpdatePool(poolID, patch)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -363,7 +370,7 @@ func (app *Application) SetClusterType(w http.ResponseWriter, r *http.Request) {
 	if t, ok := body["type"].(string); ok && t != "" {
 		patch["type"] = t
 	}
-	out, err := app.repo.UpdateCluster(clusterID, patch)
+	out, err := app.repoFor(r).UpdateCluster(clusterID, patch)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -12,7 +12,7 @@ func (app *Application) CreateLBIP(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateLBIP(lbScope(r), body)
+	out, err := app.repoFor(r).CreateLBIP(lbScope(r), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -21,7 +21,7 @@ func (app *Application) CreateLBIP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) GetLBIP(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetLBIP(chi.URLParam(r, "ip_id"))
+	out, err := app.repoFor(r).GetLBIP(chi.URLParam(r, "ip_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -30,12 +30,17 @@ func (app *Application) GetLBIP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) ListLBIPs(w http.ResponseWriter, r *http.Request) {
-	items, err := app.repo.ListLBIPs(lbScope(r))
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListLBIPs(lbScope(r), filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "ips", items)
+	writeList(w, r, "ips", items)
 }
 
 func (app *Application) UpdateLBIP(w http.ResponseWriter, r *http.Request) {
@@ -44,7 +49,7 @@ func (app *Application) UpdateLBIP(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateLBIP(chi.URLParam(r, "ip_id"), body)
+	out, err := app.repoFor(r).UpdateLBIP(chi.URLParam(r, "ip_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -53,7 +58,7 @@ func (app *Application) UpdateLBIP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) DeleteLBIP(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteLBIP(chi.URLParam(r, "ip_id")); err != nil {
+	if err := app.repoFor(r).DeleteLBIP(chi.URLParam(r, "ip_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -74,7 +79,7 @@ func (app *Application) CreateLB(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateLB(lbScope(r), body)
+	out, err := app.repoFor(r).CreateLB(lbScope(r), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -83,7 +88,7 @@ func (app *Application) CreateLB(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) GetLB(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetLB(chi.URLParam(r, "lb_id"))
+	out, err := app.repoFor(r).GetLB(chi.URLParam(r, "lb_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -92,12 +97,17 @@ func (app *Application) GetLB(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) ListLBs(w http.ResponseWriter, r *http.Request) {
-	items, err := app.repo.ListLBs(lbScope(r))
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListLBs(lbScope(r), filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "lbs", items)
+	writeList(w, r, "lbs", items)
 }
 
 func (app *Application) UpdateLB(w http.ResponseWriter, r *http.Request) {
@@ -106,7 +116,7 @@ func (app *Application) UpdateLB(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateLB(chi.URLParam(r, "lb_id"), body)
+	out, err := app.repoFor(r).UpdateLB(chi.URLParam(r, "lb_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -115,7 +125,7 @@ func (app *Application) UpdateLB(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) DeleteLB(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteLB(chi.URLParam(r, "lb_id")); err != nil {
+	if err := app.repoFor(r).DeleteLB(chi.URLParam(r, "lb_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -131,7 +141,7 @@ func (app *Application) CreateFrontend(w http.ResponseWriter, r *http.Request) {
 	if lbID := chi.URLParam(r, "lb_id"); lbID != "" {
 		body["lb_id"] = lbID
 	}
-	out, err := app.repo.CreateFrontend(body)
+	out, err := app.repoFor(r).CreateFrontend(body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -140,7 +150,7 @@ func (app *Application) CreateFrontend(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) GetFrontend(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetFrontend(chi.URLParam(r, "frontend_id"))
+	out, err := app.repoFor(r).GetFrontend(chi.URLParam(r, "frontend_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -153,33 +163,38 @@ func (app *Application) ListFrontends(w http.ResponseWriter, r *http.Request) {
 	var items []map[string]any
 	var err error
 	if lbID != "" {
-		if _, err := app.repo.GetLB(lbID); err != nil {
+		if _, err := app.repoFor(r).GetLB(lbID); err != nil {
 			writeDomainError(w, err)
 			return
 		}
-		items, err = app.repo.ListFrontendsByLB(lbID)
+		filters, ferr := listFilters(r)
+		if ferr != nil {
+			writeFilterError(w, ferr)
+			return
+		}
+		items, err = app.repoFor(r).ListFrontendsByLB(lbID, filters...)
 	} else {
-		items, err = app.repo.ListFrontends()
+		items, err = app.repoFor(r).ListFrontends()
 	}
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "frontends", items)
+	writeList(w, r, "frontends", items)
 }
 
 func (app *Application) ListFrontendACLs(w http.ResponseWriter, r *http.Request) {
 	frontendID := chi.URLParam(r, "frontend_id")
-	if _, err := app.repo.GetFrontend(frontendID); err != nil {
+	if _, err := app.repoFor(r).GetFrontend(frontendID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	items, err := app.repo.ListLBACLsByFrontend(frontendID)
+	items, err := app.repoFor(r).ListLBACLsByFrontend(frontendID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "acls", items)
+	writeList(w, r, "acls", items)
 }
 
 func (app *Application) UpdateFrontend(w http.ResponseWriter, r *http.Request) {
@@ -188,7 +203,7 @@ func (app *Application) UpdateFrontend(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateFrontend(chi.URLParam(r, "frontend_id"), body)
+	out, err := app.repoFor(r).UpdateFrontend(chi.URLParam(r, "frontend_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -197,7 +212,7 @@ func (app *Application) UpdateFrontend(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) DeleteFrontend(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteFrontend(chi.URLParam(r, "frontend_id")); err != nil {
+	if err := app.repoFor(r).DeleteFrontend(chi.URLParam(r, "frontend_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -214,7 +229,7 @@ func (app *Application) CreateBackend(w http.ResponseWriter, r *http.Request) {
 	if lbID := chi.URLParam(r, "lb_id"); lbID != "" {
 		body["lb_id"] = lbID
 	}
-	out, err := app.repo.CreateBackend(body)
+	out, err := app.repoFor(r).CreateBackend(body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -223,7 +238,7 @@ func (app *Application) CreateBackend(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) GetBackend(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetBackend(chi.URLParam(r, "backend_id"))
+	out, err := app.repoFor(r).GetBackend(chi.URLParam(r, "backend_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -236,19 +251,24 @@ func (app *Application) ListBackends(w http.ResponseWriter, r *http.Request) {
 	var items []map[string]any
 	var err error
 	if lbID != "" {
-		if _, err := app.repo.GetLB(lbID); err != nil {
+		if _, err := app.repoFor(r).GetLB(lbID); err != nil {
 			writeDomainError(w, err)
 			return
 		}
-		items, err = app.repo.ListBackendsByLB(lbID)
+		filters, ferr := listFilters(r)
+		if ferr != nil {
+			writeFilterError(w, ferr)
+			return
+		}
+		items, err = app.repoFor(r).ListBackendsByLB(lbID, filters...)
 	} else {
-		items, err = app.repo.ListBackends()
+		items, err = app.repoFor(r).ListBackends()
 	}
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "backends", items)
+	writeList(w, r, "backends", items)
 }
 
 func (app *Application) UpdateBackend(w http.ResponseWriter, r *http.Request) {
@@ -257,7 +277,7 @@ func (app *Application) UpdateBackend(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateBackend(chi.URLParam(r, "backend_id"), body)
+	out, err := app.repoFor(r).UpdateBackend(chi.URLParam(r, "backend_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -266,7 +286,7 @@ func (app *Application) UpdateBackend(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) DeleteBackend(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteBackend(chi.URLParam(r, "backend_id")); err != nil {
+	if err := app.repoFor(r).DeleteBackend(chi.URLParam(r, "backend_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -285,13 +305,13 @@ func (app *Application) AttachLBPrivateNetwork(w http.ResponseWriter, r *http.Re
 	if pnID == "" {
 		pnID = chi.URLParam(r, "pn_id")
 	}
-	out, err := app.repo.AttachLBPrivateNetwork(lbID, pnID)
+	out, err := app.repoFor(r).AttachLBPrivateNetwork(lbID, pnID)
 	if err != nil {
 		writeCreateError(w, err)
 		return
 	}
 	// Include the LB object - the provider accesses pn.LB.ID after attach.
-	lb, err := app.repo.GetLB(lbID)
+	lb, err := app.repoFor(r).GetLB(lbID)
 	if err == nil {
 		out["lb"] = lb
 	}
@@ -301,12 +321,12 @@ func (app *Application) AttachLBPrivateNetwork(w http.ResponseWriter, r *http.Re
 func (app *Application) ListLBPrivateNetworks(w http.ResponseWriter, r *http.Request) {
 	lbID := chi.URLParam(r, "lb_id")
 	// Validate the LB exists — real API returns 404 for missing LBs.
-	lb, err := app.repo.GetLB(lbID)
+	lb, err := app.repoFor(r).GetLB(lbID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	items, err := app.repo.ListLBPrivateNetworks(lbID)
+	items, err := app.repoFor(r).ListLBPrivateNetworks(lbID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -317,11 +337,11 @@ func (app *Application) ListLBPrivateNetworks(w http.ResponseWriter, r *http.Req
 			items[i]["lb"] = lb
 		}
 	}
-	writeList(w, "private_network", items)
+	writeList(w, r, "private_network", items)
 }
 
 func (app *Application) DeleteLBPrivateNetwork(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteLBPrivateNetwork(chi.URLParam(r, "lb_id"), chi.URLParam(r, "pn_id")); err != nil {
+	if err := app.repoFor(r).DeleteLBPrivateNetwork(chi.URLParam(r, "lb_id"), chi.URLParam(r, "pn_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -341,7 +361,7 @@ func (app *Application) DetachLBPrivateNetwork(w http.ResponseWriter, r *http.Re
 	if pnID == "" {
 		pnID = chi.URLParam(r, "pn_id")
 	}
-	if err := app.repo.DeleteLBPrivateNetwork(chi.URLParam(r, "lb_id"), pnID); err != nil {
+	if err := app.repoFor(r).DeleteLBPrivateNetwork(chi.URLParam(r, "lb_id"), pnID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
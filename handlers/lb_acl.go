@@ -14,7 +14,7 @@ func lbScope(r *http.Request) string {
 }
 
 func (app *Application) GetLBACL(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetLBACL(chi.URLParam(r, "acl_id"))
+	out, err := app.repoFor(r).GetLBACL(chi.URLParam(r, "acl_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -29,7 +29,7 @@ func (app *Application) CreateLBACL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	frontendID := chi.URLParam(r, "frontend_id")
-	out, err := app.repo.CreateLBACL(frontendID, body)
+	out, err := app.repoFor(r).CreateLBACL(frontendID, body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -43,7 +43,7 @@ func (app *Application) UpdateLBACL(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateLBACL(chi.URLParam(r, "acl_id"), body)
+	out, err := app.repoFor(r).UpdateLBACL(chi.URLParam(r, "acl_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -52,7 +52,7 @@ func (app *Application) UpdateLBACL(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) DeleteLBACL(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteLBACL(chi.URLParam(r, "acl_id")); err != nil {
+	if err := app.repoFor(r).DeleteLBACL(chi.URLParam(r, "acl_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -68,7 +68,7 @@ func (app *Application) CreateLBRoute(w http.ResponseWriter, r *http.Request) {
 	// Validate frontend_id and backend_id references exist.
 	var lbID string
 	if frontendID, _ := body["frontend_id"].(string); frontendID != "" {
-		fe, err := app.repo.GetFrontend(frontendID)
+		fe, err := app.repoFor(r).GetFrontend(frontendID)
 		if err != nil {
 			writeCreateError(w, err)
 			return
@@ -76,7 +76,7 @@ func (app *Application) CreateLBRoute(w http.ResponseWriter, r *http.Request) {
 		lbID, _ = fe["lb_id"].(string)
 	}
 	if backendID, _ := body["backend_id"].(string); backendID != "" {
-		be, err := app.repo.GetBackend(backendID)
+		be, err := app.repoFor(r).GetBackend(backendID)
 		if err != nil {
 			writeCreateError(w, err)
 			return
@@ -89,7 +89,7 @@ func (app *Application) CreateLBRoute(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
-	out, err := app.repo.CreateLBRoute(lbID, body)
+	out, err := app.repoFor(r).CreateLBRoute(lbID, body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -98,7 +98,7 @@ func (app *Application) CreateLBRoute(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) GetLBRoute(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetLBRoute(chi.URLParam(r, "route_id"))
+	out, err := app.repoFor(r).GetLBRoute(chi.URLParam(r, "route_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -110,23 +110,23 @@ func (app *Application) ListLBRoutes(w http.ResponseWriter, r *http.Request) {
 	lbID := r.URL.Query().Get("lb_id")
 	frontendID := r.URL.Query().Get("frontend_id")
 	if lbID != "" {
-		if _, err := app.repo.GetLB(lbID); err != nil {
+		if _, err := app.repoFor(r).GetLB(lbID); err != nil {
 			writeDomainError(w, err)
 			return
 		}
 	}
 	if frontendID != "" {
-		if _, err := app.repo.GetFrontend(frontendID); err != nil {
+		if _, err := app.repoFor(r).GetFrontend(frontendID); err != nil {
 			writeDomainError(w, err)
 			return
 		}
 	}
-	items, err := app.repo.ListLBRoutes(lbID, frontendID)
+	items, err := app.repoFor(r).ListLBRoutes(lbID, frontendID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "routes", items)
+	writeList(w, r, "routes", items)
 }
 
 func (app *Application) UpdateLBRoute(w http.ResponseWriter, r *http.Request) {
@@ -135,7 +135,7 @@ func (app *Application) UpdateLBRoute(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateLBRoute(chi.URLParam(r, "route_id"), body)
+	out, err := app.repoFor(r).UpdateLBRoute(chi.URLParam(r, "route_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -144,7 +144,7 @@ func (app *Application) UpdateLBRoute(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) DeleteLBRoute(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteLBRoute(chi.URLParam(r, "route_id")); err != nil {
+	if err := app.repoFor(r).DeleteLBRoute(chi.URLParam(r, "route_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -158,7 +158,7 @@ func (app *Application) CreateLBCertificate(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	lbID := chi.URLParam(r, "lb_id")
-	out, err := app.repo.CreateLBCertificate(lbID, body)
+	out, err := app.repoFor(r).CreateLBCertificate(lbID, body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -167,7 +167,7 @@ func (app *Application) CreateLBCertificate(w http.ResponseWriter, r *http.Reque
 }
 
 func (app *Application) GetLBCertificate(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetLBCertificate(chi.URLParam(r, "certificate_id"))
+	out, err := app.repoFor(r).GetLBCertificate(chi.URLParam(r, "certificate_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -177,16 +177,16 @@ func (app *Application) GetLBCertificate(w http.ResponseWriter, r *http.Request)
 
 func (app *Application) ListLBCertificates(w http.ResponseWriter, r *http.Request) {
 	lbID := chi.URLParam(r, "lb_id")
-	if _, err := app.repo.GetLB(lbID); err != nil {
+	if _, err := app.repoFor(r).GetLB(lbID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	items, err := app.repo.ListLBCertificatesByLB(lbID)
+	items, err := app.repoFor(r).ListLBCertificatesByLB(lbID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "certificates", items)
+	writeList(w, r, "certificates", items)
 }
 
 func (app *Application) UpdateLBCertificate(w http.ResponseWriter, r *http.Request) {
@@ -195,7 +195,7 @@ func (app *Application) UpdateLBCertificate(w http.ResponseWriter, r *http.Reque
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateLBCertificate(chi.URLParam(r, "certificate_id"), body)
+	out, err := app.repoFor(r).UpdateLBCertificate(chi.URLParam(r, "certificate_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -204,7 +204,7 @@ func (app *Application) UpdateLBCertificate(w http.ResponseWriter, r *http.Reque
 }
 
 func (app *Application) DeleteLBCertificate(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteLBCertificate(chi.URLParam(r, "certificate_id")); err != nil {
+	if err := app.repoFor(r).DeleteLBCertificate(chi.URLParam(r, "certificate_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -217,7 +217,7 @@ func (app *Application) UpdateLBHealthCheck(w http.ResponseWriter, r *http.Reque
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateBackend(chi.URLParam(r, "backend_id"), map[string]any{"health_check": body})
+	out, err := app.repoFor(r).UpdateBackend(chi.URLParam(r, "backend_id"), map[string]any{"health_check": body})
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -232,7 +232,7 @@ func (app *Application) SetLBBackendServers(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	servers, _ := body["server_ip"].([]any)
-	out, err := app.repo.UpdateBackend(chi.URLParam(r, "backend_id"), map[string]any{"server_ip": servers})
+	out, err := app.repoFor(r).UpdateBackend(chi.URLParam(r, "backend_id"), map[string]any{"server_ip": servers})
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -241,7 +241,7 @@ func (app *Application) SetLBBackendServers(w http.ResponseWriter, r *http.Reque
 }
 
 func (app *Application) MigrateLB(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetLB(chi.URLParam(r, "lb_id"))
+	out, err := app.repoFor(r).GetLB(chi.URLParam(r, "lb_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -65,7 +65,7 @@ func (app *Application) ListMarketplaceLocalImages(w http.ResponseWriter, r *htt
 			}
 		}
 		if !found {
-			if custom, err := app.repo.ListMarketplaceLabels(); err == nil {
+			if custom, err := app.repoFor(r).ListMarketplaceLabels(); err == nil {
 				for _, l := range custom {
 					if l == imageLabel {
 						found = true
@@ -97,10 +97,7 @@ func (app *Application) ListMarketplaceLocalImages(w http.ResponseWriter, r *htt
 		}
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"local_images": out,
-		"total_count":  len(out),
-	})
+	writeList(w, r, "local_images", out)
 }
 
 func (app *Application) GetMarketplaceLocalImage(w http.ResponseWriter, r *http.Request) {
@@ -108,7 +105,7 @@ func (app *Application) GetMarketplaceLocalImage(w http.ResponseWriter, r *http.
 	// Search known labels + persisted custom labels.
 	allLabels := make([]string, 0, len(knownMarketplaceLabels))
 	allLabels = append(allLabels, knownMarketplaceLabels...)
-	if dynamic, err := app.repo.ListMarketplaceLabels(); err == nil {
+	if dynamic, err := app.repoFor(r).ListMarketplaceLabels(); err == nil {
 		allLabels = append(allLabels, dynamic...)
 	}
 
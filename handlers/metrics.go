@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/redscaresu/mockway/internal/metrics"
+	"github.com/redscaresu/mockway/repository"
+	"github.com/redscaresu/mockway/specs"
+)
+
+// httpMetrics is what /mock/metrics reports about the requests served, by
+// the whole process: a request in a sandbox carries its name in the
+// sandbox label, which is empty for the main repository.
+type httpMetrics struct {
+	requests      *metrics.CounterVec
+	duration      *metrics.HistogramVec
+	unimplemented *metrics.CounterVec
+}
+
+func newHTTPMetrics() *httpMetrics {
+	return &httpMetrics{
+		requests: metrics.NewCounterVec(
+			"mockway_http_requests_total",
+			"Requests served, by service, chi route pattern, method, status and sandbox.",
+			"service", "route", "method", "status", "sandbox",
+		),
+		duration: metrics.NewHistogramVec(
+			"mockway_http_request_duration_seconds",
+			"Request latency, by service, chi route pattern, method, status and sandbox.",
+			metrics.DefaultBuckets,
+			"service", "route", "method", "status", "sandbox",
+		),
+		unimplemented: metrics.NewCounterVec(
+			"mockway_unimplemented_requests_total",
+			"Requests answered 501, by method, path of the operation the specs declare and sandbox.",
+			"method", "path", "sandbox",
+		),
+	}
+}
+
+// statusWriter remembers the status a handler wrote. It passes Flush
+// through so /mock/events keeps streaming.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// collectMetrics counts and times every request. A request whose handler
+// panicked, such as one an abort fault dropped, is counted with status
+// "aborted" before the panic carries on up to net/http.
+func (app *Application) collectMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		served := false
+		defer func() {
+			status := "aborted"
+			if served {
+				if sw.status == 0 {
+					sw.status = http.StatusOK
+				}
+				status = strconv.Itoa(sw.status)
+			}
+			unimplemented := sw.status == http.StatusNotImplemented
+			service, route, operation := requestLabels(r, unimplemented)
+			method := metricMethod(r.Method)
+			sandbox := sandboxName(r)
+			app.metrics.requests.Inc(service, route, method, status, sandbox)
+			app.metrics.duration.Observe(time.Since(start).Seconds(), service, route, method, status, sandbox)
+			if unimplemented {
+				app.metrics.unimplemented.Inc(method, operation, sandbox)
+			}
+		}()
+		next.ServeHTTP(sw, r)
+		served = true
+	})
+}
+
+// requestLabels returns the service, route and operation labels of r.
+// Every value comes from a fixed set, so no client can add series by
+// making up paths: route is the chi route pattern, operation the path of
+// the operation the specs declare for r, and service the first segment of
+// a path matching either; anything else is "unmatched". The specs are only
+// searched for unrouted and unimplemented requests.
+func requestLabels(r *http.Request, unimplemented bool) (service, route, operation string) {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		route = rctx.RoutePattern()
+		// Faults and rate limits answer before routing; look up the
+		// route they stood in for.
+		if route == "" && rctx.Routes != nil {
+			route = rctx.Routes.Find(chi.NewRouteContext(), r.Method, r.URL.Path)
+		}
+	}
+	if route == "/*" {
+		route = ""
+	}
+	if route == "" || unimplemented {
+		if catalog, err := specs.Load(); err == nil {
+			if op := catalog.Find(r.Method, r.URL.Path); op != nil {
+				operation = op.Path
+			}
+		}
+	}
+	if route != "" || operation != "" {
+		service = strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	}
+	return orUnmatched(service), orUnmatched(route), orUnmatched(operation)
+}
+
+func orUnmatched(label string) string {
+	if label == "" {
+		return "unmatched"
+	}
+	return label
+}
+
+// metricMethod is method if it is a standard HTTP method, else "other".
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// Metrics handles GET /mock/metrics in the Prometheus text format.
+func (app *Application) Metrics(w http.ResponseWriter, r *http.Request) {
+	counts, err := app.repoFor(r).TableCounts()
+	if err != nil {
+		writeDomainError(w, err)
+		return
+	}
+	tables := make([]string, 0, len(counts))
+	for table := range counts {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	samples := make([]metrics.Sample, 0, len(tables))
+	for _, table := range tables {
+		samples = append(samples, metrics.Sample{Labels: []string{table}, Value: float64(counts[table])})
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	app.metrics.requests.Write(w)
+	app.metrics.duration.Write(w)
+	app.metrics.unimplemented.Write(w)
+	metrics.WriteGauge(w, "mockway_resources", "Stored resources, by table.", []string{"table"}, samples)
+	repository.SQLiteDuration.Write(w)
+}
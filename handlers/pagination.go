@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Scaleway list endpoints default to 50 items per page and reject page sizes
+// above 100.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// pageParams holds the pagination and ordering options shared by every
+// Scaleway list endpoint. Instance uses per_page, the other APIs page_size;
+// both are accepted everywhere.
+type pageParams struct {
+	page    int
+	perPage int
+	orderBy string
+}
+
+func parsePageParams(r *http.Request) (pageParams, error) {
+	q := r.URL.Query()
+	p := pageParams{page: 1, perPage: defaultPageSize, orderBy: q.Get("order_by")}
+
+	if raw := q.Get("page"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("page must be a positive integer")
+		}
+		p.page = n
+	}
+	for _, name := range []string{"per_page", "page_size"} {
+		raw := q.Get(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPageSize {
+			return p, fmt.Errorf("%s must be between 1 and %d", name, maxPageSize)
+		}
+		p.perPage = n
+	}
+	if p.orderBy != "" && !strings.HasSuffix(p.orderBy, "_asc") && !strings.HasSuffix(p.orderBy, "_desc") {
+		return p, fmt.Errorf("order_by must end in _asc or _desc")
+	}
+	return p, nil
+}
+
+// paginate orders items per p.orderBy and returns the requested page. The
+// input is expected in creation order (listJSON orders by rowid), which is the
+// Scaleway default of created_at_asc.
+func paginate[T any](items []T, p pageParams) []T {
+	out := slices.Clone(items)
+	if p.orderBy != "" {
+		field, desc := strings.CutSuffix(p.orderBy, "_desc")
+		if !desc {
+			field = strings.TrimSuffix(field, "_asc")
+		}
+		if desc {
+			// Reverse first so items with equal keys (timestamps are only
+			// second-precise) still come out newest first.
+			slices.Reverse(out)
+		}
+		slices.SortStableFunc(out, func(a, b T) int {
+			c := compareOrderField(a, b, field)
+			if desc {
+				return -c
+			}
+			return c
+		})
+	}
+
+	start := (p.page - 1) * p.perPage
+	if start >= len(out) {
+		return []T{}
+	}
+	end := min(start+p.perPage, len(out))
+	return out[start:end]
+}
+
+// orderFieldAliases maps the order_by field names to the keys used by APIs
+// that predate the created_at/updated_at convention (Instance).
+var orderFieldAliases = map[string][]string{
+	"created_at": {"created_at", "creation_date"},
+	"updated_at": {"updated_at", "modification_date"},
+}
+
+func orderFieldValue(item any, field string) any {
+	m, ok := item.(map[string]any)
+	if !ok {
+		return nil
+	}
+	keys, ok := orderFieldAliases[field]
+	if !ok {
+		keys = []string{field}
+	}
+	for _, k := range keys {
+		if v, ok := m[k]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+func compareOrderField(a, b any, field string) int {
+	va, vb := orderFieldValue(a, field), orderFieldValue(b, field)
+	if fa, ok := va.(float64); ok {
+		if fb, ok := vb.(float64); ok {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	sa, sb := "", ""
+	if va != nil {
+		sa = fmt.Sprint(va)
+	}
+	if vb != nil {
+		sb = fmt.Sprint(vb)
+	}
+	return strings.Compare(strings.ToLower(sa), strings.ToLower(sb))
+}
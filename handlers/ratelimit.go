@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is one token bucket: Rate tokens per second refill a bucket
+// holding at most Burst tokens. A zero Rate disables the bucket.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// RateLimitConfig configures the optional rate limiter. Global is shared by
+// every request, PerService by every request to one API prefix (instance,
+// vpc, lb, ...), and PerToken gives each X-Auth-Token its own bucket. A
+// request must find a token in every bucket that applies to it.
+type RateLimitConfig struct {
+	Global     RateLimit            `json:"global"`
+	PerService map[string]RateLimit `json:"per_service"`
+	PerToken   RateLimit            `json:"per_token"`
+}
+
+func (l RateLimit) validate(name string) error {
+	if l.Rate < 0 || l.Burst < 0 {
+		return fmt.Errorf("%s: rate and burst must not be negative", name)
+	}
+	if l.Rate > 0 && l.Burst == 0 {
+		return fmt.Errorf("%s: burst must be at least 1", name)
+	}
+	return nil
+}
+
+// Validate rejects negative rates and buckets that could never hold a token.
+func (c RateLimitConfig) Validate() error {
+	if err := c.Global.validate("global"); err != nil {
+		return err
+	}
+	if err := c.PerToken.validate("per_token"); err != nil {
+		return err
+	}
+	for svc, l := range c.PerService {
+		if err := l.validate(svc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseRateLimits parses the --rate-limit flag: comma-separated
+// key=rate[:burst] entries where key is "global", "token" or a service
+// prefix. Burst defaults to the rate rounded up.
+func ParseRateLimits(s string) (RateLimitConfig, error) {
+	cfg := RateLimitConfig{PerService: map[string]RateLimit{}}
+	if strings.TrimSpace(s) == "" {
+		return cfg, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || key == "" {
+			return cfg, fmt.Errorf("invalid rate limit %q (want key=rate[:burst])", part)
+		}
+		rawRate, rawBurst, hasBurst := strings.Cut(value, ":")
+		rate, err := strconv.ParseFloat(rawRate, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid rate for %s: %w", key, err)
+		}
+		l := RateLimit{Rate: rate, Burst: int(math.Ceil(rate))}
+		if hasBurst {
+			if l.Burst, err = strconv.Atoi(rawBurst); err != nil {
+				return cfg, fmt.Errorf("invalid burst for %s: %w", key, err)
+			}
+		}
+		switch key {
+		case "global":
+			cfg.Global = l
+		case "token":
+			cfg.PerToken = l
+		default:
+			cfg.PerService[key] = l
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+type bucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last request, up to Burst.
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
+
+// wait is how long until the bucket holds a whole token again.
+func (b *bucket) wait() time.Duration {
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	cfg     RateLimitConfig
+	buckets map[string]*bucket
+}
+
+func (rl *rateLimiter) config() RateLimitConfig {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.cfg
+}
+
+// configure replaces the config and refills every bucket.
+func (rl *rateLimiter) configure(cfg RateLimitConfig) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.cfg = cfg
+	rl.buckets = map[string]*bucket{}
+}
+
+func (rl *rateLimiter) bucketFor(key string, limit RateLimit, now time.Time) *bucket {
+	b, ok := rl.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		rl.buckets[key] = b
+	}
+	return b
+}
+
+// rateDecision is the outcome for the most constrained bucket a request hit.
+type rateDecision struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	retryAfter time.Duration
+}
+
+func (rl *rateLimiter) allow(service, token string, now time.Time) (rateDecision, bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	type applied struct {
+		key   string
+		limit RateLimit
+	}
+	var checks []applied
+	if rl.cfg.Global.Rate > 0 {
+		checks = append(checks, applied{"global", rl.cfg.Global})
+	}
+	if l := rl.cfg.PerService[service]; l.Rate > 0 {
+		checks = append(checks, applied{"service:" + service, l})
+	}
+	if rl.cfg.PerToken.Rate > 0 {
+		checks = append(checks, applied{"token:" + token, rl.cfg.PerToken})
+	}
+	if len(checks) == 0 {
+		return rateDecision{}, false
+	}
+	// Only spend tokens once every bucket has one, so a throttled request
+	// does not drain the buckets that would have let it through.
+	buckets := make([]*bucket, len(checks))
+	for i, c := range checks {
+		b := rl.bucketFor(c.key, c.limit, now)
+		b.refill(now)
+		if b.tokens < 1 {
+			return rateDecision{limit: c.limit.Burst, retryAfter: b.wait()}, true
+		}
+		buckets[i] = b
+	}
+	d := rateDecision{allowed: true, remaining: math.MaxInt}
+	for _, b := range buckets {
+		b.tokens--
+		if remaining := int(b.tokens); remaining < d.remaining {
+			d.limit, d.remaining = b.limit.Burst, remaining
+		}
+	}
+	return d, true
+}
+
+// limitRate enforces the configured buckets on Scaleway routes. Throttled
+// requests get a 429 with Retry-After; every limited response carries
+// X-RateLimit-Limit and X-RateLimit-Remaining.
+func (app *Application) limitRate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/mock/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		service := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+		d, limited := app.stateFor(r).limiter.allow(service, r.Header.Get("X-Auth-Token"), time.Now())
+		if !limited {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(d.limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(d.remaining))
+		if !d.allowed {
+			secs := int(math.Ceil(d.retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(secs))
+			writeJSON(w, http.StatusTooManyRequests, map[string]any{
+				"message": "too many requests, retry later",
+				"type":    "too_many_requests",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetRateLimits replaces the rate limiter config of the server and the
+// sandboxes created after, e.g. from --rate-limit.
+func (app *Application) SetRateLimits(cfg RateLimitConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	app.state.limiter.configure(cfg)
+	return nil
+}
+
+func rateLimitBody(cfg RateLimitConfig) map[string]any {
+	perService := cfg.PerService
+	if perService == nil {
+		perService = map[string]RateLimit{}
+	}
+	return map[string]any{"global": cfg.Global, "per_service": perService, "per_token": cfg.PerToken}
+}
+
+// GetRateLimits handles GET /mock/ratelimit.
+func (app *Application) GetRateLimits(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, rateLimitBody(app.stateFor(r).limiter.config()))
+}
+
+// PutRateLimits handles PUT /mock/ratelimit. The body replaces the whole
+// config and refills every bucket; an empty object disables limiting.
+func (app *Application) PutRateLimits(w http.ResponseWriter, r *http.Request) {
+	var cfg RateLimitConfig
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": err.Error(), "type": "invalid_argument"})
+		return
+	}
+	limiter := app.stateFor(r).limiter
+	limiter.configure(cfg)
+	writeJSON(w, http.StatusOK, rateLimitBody(limiter.config()))
+}
@@ -5,7 +5,6 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/redscaresu/mockway/repository"
 )
 
 func (app *Application) CreateRDBInstance(w http.ResponseWriter, r *http.Request) {
@@ -16,7 +15,7 @@ func (app *Application) CreateRDBInstance(w http.ResponseWriter, r *http.Request
 	}
 
 	if initEndpoints, ok := body["init_endpoints"]; ok {
-		endpoints, err := repository.BuildRDBEndpointsFromInit(initEndpoints, body["engine"])
+		endpoints, err := app.repoFor(r).BuildRDBEndpointsFromInit(initEndpoints, body["engine"])
 		if err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid init_endpoints", "type": "invalid_argument"})
 			return
@@ -28,7 +27,7 @@ func (app *Application) CreateRDBInstance(w http.ResponseWriter, r *http.Request
 				if pnID == "" {
 					continue
 				}
-				exists, err := app.repo.Exists("private_networks", "id", pnID)
+				exists, err := app.repoFor(r).Exists("private_networks", "id", pnID)
 				if err != nil {
 					writeCreateError(w, err)
 					return
@@ -43,7 +42,7 @@ func (app *Application) CreateRDBInstance(w http.ResponseWriter, r *http.Request
 		delete(body, "init_endpoints")
 	}
 
-	out, err := app.repo.CreateRDBInstance(chi.URLParam(r, "region"), body)
+	out, err := app.repoFor(r).CreateRDBInstance(chi.URLParam(r, "region"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -52,7 +51,7 @@ func (app *Application) CreateRDBInstance(w http.ResponseWriter, r *http.Request
 }
 
 func (app *Application) GetRDBInstance(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetRDBInstance(chi.URLParam(r, "instance_id"))
+	out, err := app.repoFor(r).GetRDBInstance(chi.URLParam(r, "instance_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -61,12 +60,17 @@ func (app *Application) GetRDBInstance(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) ListRDBInstances(w http.ResponseWriter, r *http.Request) {
-	items, err := app.repo.ListRDBInstances(chi.URLParam(r, "region"))
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListRDBInstances(chi.URLParam(r, "region"), filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "instances", items)
+	writeList(w, r, "instances", items)
 }
 
 func (app *Application) UpdateRDBInstance(w http.ResponseWriter, r *http.Request) {
@@ -75,7 +79,7 @@ func (app *Application) UpdateRDBInstance(w http.ResponseWriter, r *http.Request
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateRDBInstance(chi.URLParam(r, "instance_id"), body)
+	out, err := app.repoFor(r).UpdateRDBInstance(chi.URLParam(r, "instance_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -85,7 +89,7 @@ func (app *Application) UpdateRDBInstance(w http.ResponseWriter, r *http.Request
 
 func (app *Application) UpgradeRDBInstance(w http.ResponseWriter, r *http.Request) {
 	instanceID := chi.URLParam(r, "instance_id")
-	current, err := app.repo.GetRDBInstance(instanceID)
+	current, err := app.repoFor(r).GetRDBInstance(instanceID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -99,7 +103,7 @@ func (app *Application) UpgradeRDBInstance(w http.ResponseWriter, r *http.Reques
 		patch["engine"] = engine
 	}
 
-	out, err := app.repo.UpdateRDBInstance(instanceID, patch)
+	out, err := app.repoFor(r).UpdateRDBInstance(instanceID, patch)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -108,7 +112,7 @@ func (app *Application) UpgradeRDBInstance(w http.ResponseWriter, r *http.Reques
 }
 
 func (app *Application) GetRDBCertificate(w http.ResponseWriter, r *http.Request) {
-	if _, err := app.repo.GetRDBInstance(chi.URLParam(r, "instance_id")); err != nil {
+	if _, err := app.repoFor(r).GetRDBInstance(chi.URLParam(r, "instance_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -121,12 +125,12 @@ func (app *Application) GetRDBCertificate(w http.ResponseWriter, r *http.Request
 
 func (app *Application) DeleteRDBInstance(w http.ResponseWriter, r *http.Request) {
 	instanceID := chi.URLParam(r, "instance_id")
-	out, err := app.repo.GetRDBInstance(instanceID)
+	out, err := app.repoFor(r).GetRDBInstance(instanceID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	if err := app.repo.DeleteRDBInstance(instanceID); err != nil {
+	if err := app.repoFor(r).DeleteRDBInstance(instanceID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -140,7 +144,7 @@ func (app *Application) CreateRDBDatabase(w http.ResponseWriter, r *http.Request
 		return
 	}
 	name, _ := body["name"].(string)
-	out, err := app.repo.CreateRDBDatabase(chi.URLParam(r, "instance_id"), name, body)
+	out, err := app.repoFor(r).CreateRDBDatabase(chi.URLParam(r, "instance_id"), name, body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -150,20 +154,20 @@ func (app *Application) CreateRDBDatabase(w http.ResponseWriter, r *http.Request
 
 func (app *Application) ListRDBDatabases(w http.ResponseWriter, r *http.Request) {
 	instanceID := chi.URLParam(r, "instance_id")
-	if _, err := app.repo.GetRDBInstance(instanceID); err != nil {
+	if _, err := app.repoFor(r).GetRDBInstance(instanceID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	items, err := app.repo.ListRDBDatabases(instanceID)
+	items, err := app.repoFor(r).ListRDBDatabases(instanceID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "databases", items)
+	writeList(w, r, "databases", items)
 }
 
 func (app *Application) DeleteRDBDatabase(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteRDBDatabase(chi.URLParam(r, "instance_id"), chi.URLParam(r, "db_name")); err != nil {
+	if err := app.repoFor(r).DeleteRDBDatabase(chi.URLParam(r, "instance_id"), chi.URLParam(r, "db_name")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -177,7 +181,7 @@ func (app *Application) CreateRDBUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	name, _ := body["name"].(string)
-	out, err := app.repo.CreateRDBUser(chi.URLParam(r, "instance_id"), name, body)
+	out, err := app.repoFor(r).CreateRDBUser(chi.URLParam(r, "instance_id"), name, body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -187,16 +191,16 @@ func (app *Application) CreateRDBUser(w http.ResponseWriter, r *http.Request) {
 
 func (app *Application) ListRDBUsers(w http.ResponseWriter, r *http.Request) {
 	instanceID := chi.URLParam(r, "instance_id")
-	if _, err := app.repo.GetRDBInstance(instanceID); err != nil {
+	if _, err := app.repoFor(r).GetRDBInstance(instanceID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	items, err := app.repo.ListRDBUsers(instanceID)
+	items, err := app.repoFor(r).ListRDBUsers(instanceID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "users", items)
+	writeList(w, r, "users", items)
 }
 
 func (app *Application) UpdateRDBUser(w http.ResponseWriter, r *http.Request) {
@@ -205,7 +209,7 @@ func (app *Application) UpdateRDBUser(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateRDBUser(chi.URLParam(r, "instance_id"), chi.URLParam(r, "user_name"), body)
+	out, err := app.repoFor(r).UpdateRDBUser(chi.URLParam(r, "instance_id"), chi.URLParam(r, "user_name"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -214,7 +218,7 @@ func (app *Application) UpdateRDBUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) DeleteRDBUser(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteRDBUser(chi.URLParam(r, "instance_id"), chi.URLParam(r, "user_name")); err != nil {
+	if err := app.repoFor(r).DeleteRDBUser(chi.URLParam(r, "instance_id"), chi.URLParam(r, "user_name")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -228,7 +232,7 @@ func (app *Application) SetRDBACLs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	instanceID := chi.URLParam(r, "instance_id")
-	if _, err := app.repo.GetRDBInstance(instanceID); err != nil {
+	if _, err := app.repoFor(r).GetRDBInstance(instanceID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -236,7 +240,7 @@ func (app *Application) SetRDBACLs(w http.ResponseWriter, r *http.Request) {
 	if rules == nil {
 		rules = []any{}
 	}
-	stored, err := app.repo.SetRDBACLs(instanceID, rules)
+	stored, err := app.repoFor(r).SetRDBACLs(instanceID, rules)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -246,12 +250,12 @@ func (app *Application) SetRDBACLs(w http.ResponseWriter, r *http.Request) {
 
 func (app *Application) ListRDBACLs(w http.ResponseWriter, r *http.Request) {
 	instanceID := chi.URLParam(r, "instance_id")
-	rules, err := app.repo.ListRDBACLs(instanceID)
+	rules, err := app.repoFor(r).ListRDBACLs(instanceID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"rules": rules, "total_count": len(rules)})
+	writeList(w, r, "rules", rules)
 }
 
 func (app *Application) DeleteRDBACLs(w http.ResponseWriter, r *http.Request) {
@@ -269,7 +273,7 @@ func (app *Application) DeleteRDBACLs(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
-	if err := app.repo.DeleteRDBACLs(instanceID, ruleIPs); err != nil {
+	if err := app.repoFor(r).DeleteRDBACLs(instanceID, ruleIPs); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -287,21 +291,21 @@ func (app *Application) SetRDBPrivileges(w http.ResponseWriter, r *http.Request)
 	if privileges == nil {
 		privileges = []any{}
 	}
-	result, err := app.repo.SetRDBPrivileges(instanceID, privileges)
+	result, err := app.repoFor(r).SetRDBPrivileges(instanceID, privileges)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"privileges": result, "total_count": len(result)})
+	writeList(w, r, "privileges", result)
 }
 
 func (app *Application) ListRDBPrivileges(w http.ResponseWriter, r *http.Request) {
 	instanceID := chi.URLParam(r, "instance_id")
-	if _, err := app.repo.GetRDBInstance(instanceID); err != nil {
+	if _, err := app.repoFor(r).GetRDBInstance(instanceID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	result, err := app.repo.ListRDBPrivileges(instanceID)
+	result, err := app.repoFor(r).ListRDBPrivileges(instanceID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -325,7 +329,7 @@ func (app *Application) ListRDBPrivileges(w http.ResponseWriter, r *http.Request
 		}
 		result = filtered
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"privileges": result, "total_count": len(result)})
+	writeList(w, r, "privileges", result)
 }
 
 func (app *Application) SetRDBSettings(w http.ResponseWriter, r *http.Request) {
@@ -336,7 +340,7 @@ func (app *Application) SetRDBSettings(w http.ResponseWriter, r *http.Request) {
 	}
 	// Validate the instance exists — real API returns 404 for missing instances.
 	instanceID := chi.URLParam(r, "instance_id")
-	if _, err := app.repo.GetRDBInstance(instanceID); err != nil {
+	if _, err := app.repoFor(r).GetRDBInstance(instanceID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -356,7 +360,7 @@ func (app *Application) CreateRDBReadReplica(w http.ResponseWriter, r *http.Requ
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateRDBReadReplica(chi.URLParam(r, "region"), chi.URLParam(r, "instance_id"), body)
+	out, err := app.repoFor(r).CreateRDBReadReplica(chi.URLParam(r, "region"), chi.URLParam(r, "instance_id"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -365,7 +369,7 @@ func (app *Application) CreateRDBReadReplica(w http.ResponseWriter, r *http.Requ
 }
 
 func (app *Application) GetRDBReadReplica(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetRDBReadReplica(chi.URLParam(r, "read_replica_id"))
+	out, err := app.repoFor(r).GetRDBReadReplica(chi.URLParam(r, "read_replica_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -374,7 +378,7 @@ func (app *Application) GetRDBReadReplica(w http.ResponseWriter, r *http.Request
 }
 
 func (app *Application) DeleteRDBReadReplica(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.DeleteRDBReadReplica(chi.URLParam(r, "read_replica_id"))
+	out, err := app.repoFor(r).DeleteRDBReadReplica(chi.URLParam(r, "read_replica_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -388,7 +392,7 @@ func (app *Application) CreateRDBReadReplicaEndpoint(w http.ResponseWriter, r *h
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateRDBReadReplicaEndpoint(chi.URLParam(r, "read_replica_id"), body)
+	out, err := app.repoFor(r).CreateRDBReadReplicaEndpoint(chi.URLParam(r, "read_replica_id"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -397,7 +401,7 @@ func (app *Application) CreateRDBReadReplicaEndpoint(w http.ResponseWriter, r *h
 }
 
 func (app *Application) PromoteRDBReadReplica(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.PromoteRDBReadReplica(chi.URLParam(r, "read_replica_id"))
+	out, err := app.repoFor(r).PromoteRDBReadReplica(chi.URLParam(r, "read_replica_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -406,7 +410,7 @@ func (app *Application) PromoteRDBReadReplica(w http.ResponseWriter, r *http.Req
 }
 
 func (app *Application) ResetRDBReadReplica(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.ResetRDBReadReplica(chi.URLParam(r, "read_replica_id"))
+	out, err := app.repoFor(r).ResetRDBReadReplica(chi.URLParam(r, "read_replica_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -422,7 +426,7 @@ func (app *Application) CreateRDBSnapshot(w http.ResponseWriter, r *http.Request
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateRDBSnapshot(chi.URLParam(r, "region"), chi.URLParam(r, "instance_id"), body)
+	out, err := app.repoFor(r).CreateRDBSnapshot(chi.URLParam(r, "region"), chi.URLParam(r, "instance_id"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -431,7 +435,7 @@ func (app *Application) CreateRDBSnapshot(w http.ResponseWriter, r *http.Request
 }
 
 func (app *Application) GetRDBSnapshot(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetRDBSnapshot(chi.URLParam(r, "snapshot_id"))
+	out, err := app.repoFor(r).GetRDBSnapshot(chi.URLParam(r, "snapshot_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -440,12 +444,17 @@ func (app *Application) GetRDBSnapshot(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) ListRDBSnapshots(w http.ResponseWriter, r *http.Request) {
-	items, err := app.repo.ListRDBSnapshots(chi.URLParam(r, "region"))
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListRDBSnapshots(chi.URLParam(r, "region"), filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "snapshots", items)
+	writeList(w, r, "snapshots", items)
 }
 
 func (app *Application) UpdateRDBSnapshot(w http.ResponseWriter, r *http.Request) {
@@ -454,7 +463,7 @@ func (app *Application) UpdateRDBSnapshot(w http.ResponseWriter, r *http.Request
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateRDBSnapshot(chi.URLParam(r, "snapshot_id"), body)
+	out, err := app.repoFor(r).UpdateRDBSnapshot(chi.URLParam(r, "snapshot_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -463,7 +472,7 @@ func (app *Application) UpdateRDBSnapshot(w http.ResponseWriter, r *http.Request
 }
 
 func (app *Application) DeleteRDBSnapshot(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.DeleteRDBSnapshot(chi.URLParam(r, "snapshot_id"))
+	out, err := app.repoFor(r).DeleteRDBSnapshot(chi.URLParam(r, "snapshot_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -477,7 +486,7 @@ func (app *Application) CreateRDBInstanceFromSnapshot(w http.ResponseWriter, r *
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateRDBInstanceFromSnapshot(chi.URLParam(r, "region"), chi.URLParam(r, "snapshot_id"), body)
+	out, err := app.repoFor(r).CreateRDBInstanceFromSnapshot(chi.URLParam(r, "region"), chi.URLParam(r, "snapshot_id"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -497,7 +506,7 @@ func (app *Application) CreateRDBBackup(w http.ResponseWriter, r *http.Request)
 	if instanceID == "" {
 		instanceID, _ = body["instance_id"].(string)
 	}
-	out, err := app.repo.CreateRDBBackup(chi.URLParam(r, "region"), instanceID, body)
+	out, err := app.repoFor(r).CreateRDBBackup(chi.URLParam(r, "region"), instanceID, body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -506,7 +515,7 @@ func (app *Application) CreateRDBBackup(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *Application) GetRDBBackup(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetRDBBackup(chi.URLParam(r, "backup_id"))
+	out, err := app.repoFor(r).GetRDBBackup(chi.URLParam(r, "backup_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -516,12 +525,12 @@ func (app *Application) GetRDBBackup(w http.ResponseWriter, r *http.Request) {
 
 func (app *Application) ListRDBBackups(w http.ResponseWriter, r *http.Request) {
 	instanceID := r.URL.Query().Get("instance_id")
-	items, err := app.repo.ListRDBBackups(chi.URLParam(r, "region"), instanceID)
+	items, err := app.repoFor(r).ListRDBBackups(chi.URLParam(r, "region"), instanceID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "database_backups", items)
+	writeList(w, r, "database_backups", items)
 }
 
 func (app *Application) UpdateRDBBackup(w http.ResponseWriter, r *http.Request) {
@@ -530,7 +539,7 @@ func (app *Application) UpdateRDBBackup(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateRDBBackup(chi.URLParam(r, "backup_id"), body)
+	out, err := app.repoFor(r).UpdateRDBBackup(chi.URLParam(r, "backup_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -539,7 +548,7 @@ func (app *Application) UpdateRDBBackup(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *Application) DeleteRDBBackup(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.DeleteRDBBackup(chi.URLParam(r, "backup_id"))
+	out, err := app.repoFor(r).DeleteRDBBackup(chi.URLParam(r, "backup_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -548,7 +557,7 @@ func (app *Application) DeleteRDBBackup(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *Application) ExportRDBBackup(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.ExportRDBBackup(chi.URLParam(r, "backup_id"))
+	out, err := app.repoFor(r).ExportRDBBackup(chi.URLParam(r, "backup_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -563,7 +572,7 @@ func (app *Application) RestoreRDBBackup(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	instanceID, _ := body["instance_id"].(string)
-	out, err := app.repo.RestoreRDBBackup(instanceID, chi.URLParam(r, "backup_id"), body)
+	out, err := app.repoFor(r).RestoreRDBBackup(instanceID, chi.URLParam(r, "backup_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -574,7 +583,7 @@ func (app *Application) RestoreRDBBackup(w http.ResponseWriter, r *http.Request)
 // --- RDB Certificate, Logs, Endpoints ---
 
 func (app *Application) RenewRDBCertificate(w http.ResponseWriter, r *http.Request) {
-	if _, err := app.repo.GetRDBInstance(chi.URLParam(r, "instance_id")); err != nil {
+	if _, err := app.repoFor(r).GetRDBInstance(chi.URLParam(r, "instance_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -587,11 +596,11 @@ func (app *Application) RenewRDBCertificate(w http.ResponseWriter, r *http.Reque
 }
 
 func (app *Application) PrepareRDBInstanceLogs(w http.ResponseWriter, r *http.Request) {
-	if _, err := app.repo.GetRDBInstance(chi.URLParam(r, "instance_id")); err != nil {
+	if _, err := app.repoFor(r).GetRDBInstance(chi.URLParam(r, "instance_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"logs": []any{}, "total_count": 0})
+	writeList(w, r, "logs", []any{})
 }
 
 func (app *Application) CreateRDBEndpoint(w http.ResponseWriter, r *http.Request) {
@@ -600,7 +609,7 @@ func (app *Application) CreateRDBEndpoint(w http.ResponseWriter, r *http.Request
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateRDBEndpoint(chi.URLParam(r, "instance_id"), body)
+	out, err := app.repoFor(r).CreateRDBEndpoint(chi.URLParam(r, "instance_id"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -609,23 +618,24 @@ func (app *Application) CreateRDBEndpoint(w http.ResponseWriter, r *http.Request
 }
 
 func (app *Application) DeleteRDBEndpoint(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteRDBEndpoint(chi.URLParam(r, "endpoint_id")); err != nil {
+	if err := app.repoFor(r).DeleteRDBEndpoint(chi.URLParam(r, "endpoint_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (app *Application) ListRDBNodeTypes(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]any{
-		"node_types": []any{
-			map[string]any{"name": "DB-DEV-S", "stock_status": "available", "memory": float64(1000000000), "vcpus": float64(2)},
-			map[string]any{"name": "DB-DEV-M", "stock_status": "available", "memory": float64(2000000000), "vcpus": float64(2)},
-			map[string]any{"name": "DB-DEV-L", "stock_status": "available", "memory": float64(4000000000), "vcpus": float64(4)},
-			map[string]any{"name": "DB-GP-XS", "stock_status": "available", "memory": float64(8000000000), "vcpus": float64(4)},
-		},
-		"total_count": 4,
-	})
+// rdbNodeTypes is the node type catalog; strict request validation checks
+// node_type against the same names.
+var rdbNodeTypes = []any{
+	map[string]any{"name": "DB-DEV-S", "stock_status": "available", "memory": float64(1000000000), "vcpus": float64(2)},
+	map[string]any{"name": "DB-DEV-M", "stock_status": "available", "memory": float64(2000000000), "vcpus": float64(2)},
+	map[string]any{"name": "DB-DEV-L", "stock_status": "available", "memory": float64(4000000000), "vcpus": float64(4)},
+	map[string]any{"name": "DB-GP-XS", "stock_status": "available", "memory": float64(8000000000), "vcpus": float64(4)},
+}
+
+func (app *Application) ListRDBNodeTypes(w http.ResponseWriter, r *http.Request) {
+	writeList(w, r, "node_types", rdbNodeTypes)
 }
 
 // CreateRDBReadReplicaTopLevel handles POST /rdb/v1/regions/{region}/read-replicas
@@ -637,7 +647,7 @@ func (app *Application) CreateRDBReadReplicaTopLevel(w http.ResponseWriter, r *h
 		return
 	}
 	instanceID, _ := body["instance_id"].(string)
-	out, err := app.repo.CreateRDBReadReplica(chi.URLParam(r, "region"), instanceID, body)
+	out, err := app.repoFor(r).CreateRDBReadReplica(chi.URLParam(r, "region"), instanceID, body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
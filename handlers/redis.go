@@ -22,7 +22,7 @@ func (app *Application) CreateRedisCluster(w http.ResponseWriter, r *http.Reques
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateRedisCluster(chi.URLParam(r, "zone"), body)
+	out, err := app.repoFor(r).CreateRedisCluster(chi.URLParam(r, "zone"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -31,7 +31,7 @@ func (app *Application) CreateRedisCluster(w http.ResponseWriter, r *http.Reques
 }
 
 func (app *Application) GetRedisCluster(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetRedisCluster(chi.URLParam(r, "cluster_id"))
+	out, err := app.repoFor(r).GetRedisCluster(chi.URLParam(r, "cluster_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -40,12 +40,17 @@ func (app *Application) GetRedisCluster(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *Application) ListRedisClusters(w http.ResponseWriter, r *http.Request) {
-	items, err := app.repo.ListRedisClusters(chi.URLParam(r, "zone"))
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListRedisClusters(chi.URLParam(r, "zone"), filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "clusters", items)
+	writeList(w, r, "clusters", items)
 }
 
 func (app *Application) UpdateRedisCluster(w http.ResponseWriter, r *http.Request) {
@@ -54,7 +59,7 @@ func (app *Application) UpdateRedisCluster(w http.ResponseWriter, r *http.Reques
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateRedisCluster(chi.URLParam(r, "cluster_id"), body)
+	out, err := app.repoFor(r).UpdateRedisCluster(chi.URLParam(r, "cluster_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -63,7 +68,7 @@ func (app *Application) UpdateRedisCluster(w http.ResponseWriter, r *http.Reques
 }
 
 func (app *Application) GetRedisCertificate(w http.ResponseWriter, r *http.Request) {
-	if _, err := app.repo.GetRedisCluster(chi.URLParam(r, "cluster_id")); err != nil {
+	if _, err := app.repoFor(r).GetRedisCluster(chi.URLParam(r, "cluster_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -90,7 +95,7 @@ func (app *Application) MigrateRedisCluster(w http.ResponseWriter, r *http.Reque
 		}
 	}
 	patch["status"] = "ready"
-	out, err := app.repo.UpdateRedisCluster(chi.URLParam(r, "cluster_id"), patch)
+	out, err := app.repoFor(r).UpdateRedisCluster(chi.URLParam(r, "cluster_id"), patch)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -100,12 +105,12 @@ func (app *Application) MigrateRedisCluster(w http.ResponseWriter, r *http.Reque
 
 func (app *Application) DeleteRedisCluster(w http.ResponseWriter, r *http.Request) {
 	clusterID := chi.URLParam(r, "cluster_id")
-	out, err := app.repo.GetRedisCluster(clusterID)
+	out, err := app.repoFor(r).GetRedisCluster(clusterID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	if err := app.repo.DeleteRedisCluster(clusterID); err != nil {
+	if err := app.repoFor(r).DeleteRedisCluster(clusterID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -122,7 +127,7 @@ func (app *Application) SetRedisACLRules(w http.ResponseWriter, r *http.Request)
 	if rules == nil {
 		rules = []any{}
 	}
-	out, err := app.repo.SetRedisACLRules(chi.URLParam(r, "cluster_id"), rules)
+	out, err := app.repoFor(r).SetRedisACLRules(chi.URLParam(r, "cluster_id"), rules)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -140,7 +145,7 @@ func (app *Application) SetRedisEndpoints(w http.ResponseWriter, r *http.Request
 	if endpoints == nil {
 		endpoints = []any{}
 	}
-	out, err := app.repo.SetRedisEndpoints(chi.URLParam(r, "cluster_id"), endpoints)
+	out, err := app.repoFor(r).SetRedisEndpoints(chi.URLParam(r, "cluster_id"), endpoints)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -159,7 +164,7 @@ func (app *Application) SetRedisClusterSettings(w http.ResponseWriter, r *http.R
 	if settings == nil {
 		settings = []any{}
 	}
-	out, err := app.repo.SetRedisClusterSettings(chi.URLParam(r, "cluster_id"), settings)
+	out, err := app.repoFor(r).SetRedisClusterSettings(chi.URLParam(r, "cluster_id"), settings)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -168,7 +173,7 @@ func (app *Application) SetRedisClusterSettings(w http.ResponseWriter, r *http.R
 }
 
 func (app *Application) DeleteRedisEndpoint(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.DeleteRedisEndpoint(chi.URLParam(r, "zone"), chi.URLParam(r, "endpoint_id"))
+	out, err := app.repoFor(r).DeleteRedisEndpoint(chi.URLParam(r, "zone"), chi.URLParam(r, "endpoint_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -176,23 +181,21 @@ func (app *Application) DeleteRedisEndpoint(w http.ResponseWriter, r *http.Reque
 	writeJSON(w, http.StatusOK, out)
 }
 
-func (app *Application) ListRedisClusterVersions(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]any{
-		"versions": []any{
-			map[string]any{"version": "7.0.12", "end_of_life_at": nil, "available_settings": []any{}},
-			map[string]any{"version": "6.2.14", "end_of_life_at": nil, "available_settings": []any{}},
-		},
-		"total_count": 2,
+func (app *Application) ListRedisClusterVersions(w http.ResponseWriter, r *http.Request) {
+	writeList(w, r, "versions", []any{
+		map[string]any{"version": "7.0.12", "end_of_life_at": nil, "available_settings": []any{}},
+		map[string]any{"version": "6.2.14", "end_of_life_at": nil, "available_settings": []any{}},
 	})
 }
 
-func (app *Application) ListRedisNodeTypes(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]any{
-		"node_types": []any{
-			map[string]any{"name": "RED1-MICRO", "stock_status": "available", "memory": float64(1000000000), "vcpus": float64(1)},
-			map[string]any{"name": "RED1-SMALL", "stock_status": "available", "memory": float64(2000000000), "vcpus": float64(2)},
-			map[string]any{"name": "RED1-MEDIUM", "stock_status": "available", "memory": float64(4000000000), "vcpus": float64(4)},
-		},
-		"total_count": 3,
-	})
+// redisNodeTypes is the node type catalog; strict request validation checks
+// node_type against the same names.
+var redisNodeTypes = []any{
+	map[string]any{"name": "RED1-MICRO", "stock_status": "available", "memory": float64(1000000000), "vcpus": float64(1)},
+	map[string]any{"name": "RED1-SMALL", "stock_status": "available", "memory": float64(2000000000), "vcpus": float64(2)},
+	map[string]any{"name": "RED1-MEDIUM", "stock_status": "available", "memory": float64(4000000000), "vcpus": float64(4)},
+}
+
+func (app *Application) ListRedisNodeTypes(w http.ResponseWriter, r *http.Request) {
+	writeList(w, r, "node_types", redisNodeTypes)
 }
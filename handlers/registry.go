@@ -12,7 +12,7 @@ func (app *Application) CreateRegistryNamespace(w http.ResponseWriter, r *http.R
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateRegistryNamespace(chi.URLParam(r, "region"), body)
+	out, err := app.repoFor(r).CreateRegistryNamespace(chi.URLParam(r, "region"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -21,7 +21,7 @@ func (app *Application) CreateRegistryNamespace(w http.ResponseWriter, r *http.R
 }
 
 func (app *Application) GetRegistryNamespace(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetRegistryNamespace(chi.URLParam(r, "namespace_id"))
+	out, err := app.repoFor(r).GetRegistryNamespace(chi.URLParam(r, "namespace_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -30,12 +30,17 @@ func (app *Application) GetRegistryNamespace(w http.ResponseWriter, r *http.Requ
 }
 
 func (app *Application) ListRegistryNamespaces(w http.ResponseWriter, r *http.Request) {
-	items, err := app.repo.ListRegistryNamespaces(chi.URLParam(r, "region"))
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListRegistryNamespaces(chi.URLParam(r, "region"), filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "namespaces", items)
+	writeList(w, r, "namespaces", items)
 }
 
 func (app *Application) UpdateRegistryNamespace(w http.ResponseWriter, r *http.Request) {
@@ -44,7 +49,7 @@ func (app *Application) UpdateRegistryNamespace(w http.ResponseWriter, r *http.R
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateRegistryNamespace(chi.URLParam(r, "namespace_id"), body)
+	out, err := app.repoFor(r).UpdateRegistryNamespace(chi.URLParam(r, "namespace_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -54,12 +59,12 @@ func (app *Application) UpdateRegistryNamespace(w http.ResponseWriter, r *http.R
 
 func (app *Application) DeleteRegistryNamespace(w http.ResponseWriter, r *http.Request) {
 	namespaceID := chi.URLParam(r, "namespace_id")
-	out, err := app.repo.GetRegistryNamespace(namespaceID)
+	out, err := app.repoFor(r).GetRegistryNamespace(namespaceID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	if err := app.repo.DeleteRegistryNamespace(namespaceID); err != nil {
+	if err := app.repoFor(r).DeleteRegistryNamespace(namespaceID); err != nil {
 		writeDomainError(w, err)
 		return
 	}
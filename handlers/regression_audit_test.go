@@ -234,6 +234,17 @@ var knownNonServiceFiles = map[string]bool{
 	"handlers.go":            true,
 	"admin.go":               true,
 	"unimplemented.go":       true,
+	"pagination.go":          true,
+	"filters.go":             true,
+	"faults.go":              true,
+	"ratelimit.go":           true,
+	"journal.go":             true,
+	"validation.go":          true,
+	"generic.go":             true,
+	"events.go":              true,
+	"metrics.go":             true,
+	"transactions.go":        true,
+	"sandboxes.go":           true,
 	"regression_manifest.go": true,
 }
 
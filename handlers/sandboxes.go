@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redscaresu/mockway/repository"
+)
+
+// SandboxHeader selects the sandbox a request runs in. A /sandbox/{name}/
+// path prefix does the same and takes precedence.
+const SandboxHeader = "X-Mockway-Sandbox"
+
+type sandboxKey struct{}
+
+// sandbox is what selectSandbox binds a request to.
+type sandbox struct {
+	name  string
+	repo  *repository.Repository
+	state *mockState
+}
+
+// selectSandbox routes a request naming a sandbox to that sandbox's
+// repository and mockState, opening them on first use. The /sandbox/{name}
+// prefix is stripped before routing, so every route, admin ones included,
+// is served the same way inside a sandbox. Names follow the snapshot name
+// rule; /sandbox//... is a 400 like any other bad name.
+func (app *Application) selectSandbox(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(SandboxHeader)
+		rest, prefixed := strings.CutPrefix(r.URL.Path, "/sandbox/")
+		if prefixed {
+			var path string
+			name, path, _ = strings.Cut(rest, "/")
+			u := *r.URL
+			u.Path = "/" + path
+			u.RawPath = ""
+			r = r.Clone(r.Context())
+			r.URL = &u
+		}
+		// An empty path segment is a bad name, not the main repository.
+		if name == "" && !prefixed {
+			next.ServeHTTP(w, r)
+			return
+		}
+		repo, err := app.repo.Sandbox(name)
+		if err != nil {
+			writeSandboxError(w, err, name)
+			return
+		}
+		sb := sandbox{name: name, repo: repo, state: app.sandboxState(name)}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sandboxKey{}, sb)))
+	})
+}
+
+// sandboxState returns the named sandbox's mockState, creating it with the
+// server's current settings (rate limits, journal size, generic engine
+// and validation modes) but no faults or journal entries.
+func (app *Application) sandboxState(name string) *mockState {
+	app.sandboxMu.Lock()
+	defer app.sandboxMu.Unlock()
+	if s, ok := app.sandboxStates[name]; ok {
+		return s
+	}
+	s := newMockState(app.state.journal.size)
+	s.limiter.configure(app.state.limiter.config())
+	s.generic.Store(app.state.generic.Load())
+	s.validation.responses = app.state.validation.responseMode()
+	s.validation.requests = app.state.validation.strictRequests()
+	app.sandboxStates[name] = s
+	return s
+}
+
+// sandboxName returns the name of the request's sandbox, or "".
+func sandboxName(r *http.Request) string {
+	sb, _ := r.Context().Value(sandboxKey{}).(sandbox)
+	return sb.name
+}
+
+// stateFor returns the mockState of the request's sandbox, or the server's.
+func (app *Application) stateFor(r *http.Request) *mockState {
+	if sb, ok := r.Context().Value(sandboxKey{}).(sandbox); ok {
+		return sb.state
+	}
+	return app.state
+}
+
+// writeSandboxError maps sandbox errors; bad names are a 400 and a
+// sandbox beyond the limit a 409.
+func writeSandboxError(w http.ResponseWriter, err error, name string) {
+	switch {
+	case errors.Is(err, repository.ErrInvalidSandboxName):
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": err.Error(), "type": "invalid_argument"})
+	case errors.Is(err, repository.ErrTooManySandboxes):
+		writeJSON(w, http.StatusConflict, map[string]any{"message": err.Error(), "type": "conflict"})
+	default:
+		writeDomainErrorFor(w, err, "sandbox", name)
+	}
+}
+
+// ListSandboxes handles GET /mock/sandboxes.
+func (app *Application) ListSandboxes(w http.ResponseWriter, _ *http.Request) {
+	names, err := app.repo.Sandboxes()
+	if err != nil {
+		writeDomainError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sandboxes": names})
+}
+
+// DeleteSandbox handles DELETE /mock/sandboxes/{name}: the sandbox's
+// database, snapshots, faults and journal are removed, and its next
+// request starts afresh. Its metrics stay in the server's.
+func (app *Application) DeleteSandbox(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := app.repo.DeleteSandbox(name); err != nil {
+		writeSandboxError(w, err, name)
+		return
+	}
+	app.sandboxMu.Lock()
+	delete(app.sandboxStates, name)
+	app.sandboxMu.Unlock()
+	writeNoContent(w)
+}
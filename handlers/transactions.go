@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redscaresu/mockway/models"
+	"github.com/redscaresu/mockway/repository"
+)
+
+// maxTransactionAttempts bounds how often transact runs a request whose
+// transaction lost an update to a concurrent writer.
+const maxTransactionAttempts = 8
+
+type txRepoKey struct{}
+
+// errRequestFailed rolls back the transaction of a request answered with an
+// error status.
+var errRequestFailed = errors.New("request failed")
+
+// repoFor returns the repository a handler serving r should use: the one
+// bound to the request's transaction, if transact opened one, else the
+// request's sandbox, if it names one.
+func (app *Application) repoFor(r *http.Request) *repository.Repository {
+	if repo, ok := r.Context().Value(txRepoKey{}).(*repository.Repository); ok {
+		return repo
+	}
+	if sb, ok := r.Context().Value(sandboxKey{}).(sandbox); ok {
+		return sb.repo
+	}
+	return app.repo
+}
+
+// transact runs each mutating API request in one transaction, committed
+// only if the response status is below 400, so a request that fails half
+// way leaves nothing behind. The response is held back until the commit.
+// A request that loses an update to a concurrent writer is run again from
+// the start, one retrying request at a time, up to maxTransactionAttempts
+// times, and then answers 409 (resource was modified concurrently), which
+// the client may retry.
+// Reads and admin routes run outside a transaction.
+func (app *Application) transact(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || strings.HasPrefix(r.URL.Path, "/mock/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var body []byte
+		if r.Body != nil {
+			body, _ = io.ReadAll(r.Body)
+			_ = r.Body.Close()
+		}
+		// Routing records the matched pattern and URL parameters in the
+		// route context; a retry starts again from what it held here.
+		rctx := chi.RouteContext(r.Context())
+		var routed chi.Context
+		if rctx != nil {
+			routed = *rctx
+		}
+
+		var (
+			tw       *txWriter
+			err      error
+			retrying *sync.Mutex
+		)
+		for attempt := 1; ; attempt++ {
+			if rctx != nil {
+				*rctx = routed
+			}
+			tw = &txWriter{header: http.Header{}}
+			err = app.repoFor(r).Atomic(func(repo *repository.Repository) error {
+				req := r.WithContext(context.WithValue(r.Context(), txRepoKey{}, repo))
+				req.Body = io.NopCloser(bytes.NewReader(body))
+				next.ServeHTTP(tw, req)
+				if tw.status >= http.StatusBadRequest {
+					return errRequestFailed
+				}
+				return nil
+			})
+			if !errors.Is(err, models.ErrConcurrentUpdate) || attempt == maxTransactionAttempts {
+				break
+			}
+			// Requests that lost once retry one at a time, so they only
+			// race fresh requests and not each other.
+			if retrying == nil {
+				retrying = &app.stateFor(r).retryMu
+				retrying.Lock()
+			}
+			// Back off for a random few milliseconds, so the writers that
+			// collided do not collide again.
+			time.Sleep(time.Duration(1+rand.IntN(attempt*attempt*2)) * time.Millisecond)
+		}
+		if retrying != nil {
+			retrying.Unlock()
+		}
+		if err != nil && !errors.Is(err, errRequestFailed) {
+			writeDomainError(w, err)
+			return
+		}
+		tw.flush(w)
+	})
+}
+
+// txWriter holds a response until its transaction has committed.
+type txWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *txWriter) Header() http.Header { return w.header }
+
+func (w *txWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *txWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *txWriter) flush(dst http.ResponseWriter) {
+	for k, v := range w.header {
+		dst.Header()[k] = v
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	dst.WriteHeader(w.status)
+	_, _ = dst.Write(w.body.Bytes())
+}
@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redscaresu/mockway/specs"
+)
+
+// Response validation modes.
+const (
+	ValidationOff    = "off"
+	ValidationLog    = "log"
+	ValidationStrict = "strict"
+)
+
+// maxViolationRecords bounds /mock/violations; older records are dropped.
+const maxViolationRecords = 1000
+
+// violationRecord is one response that did not match its spec operation.
+type violationRecord struct {
+	Time        time.Time         `json:"time"`
+	Method      string            `json:"method"`
+	Path        string            `json:"path"`
+	OperationID string            `json:"operation_id"`
+	Status      int               `json:"status"`
+	Violations  []specs.Violation `json:"violations"`
+}
+
+type validationState struct {
+	mu         sync.Mutex
+	responses  string
+	requests   bool
+	violations []violationRecord
+}
+
+func (v *validationState) responseMode() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.responses
+}
+
+func (v *validationState) strictRequests() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.requests
+}
+
+func (v *validationState) setResponses(mode string) error {
+	switch mode {
+	case ValidationOff, ValidationLog, ValidationStrict:
+	default:
+		return fmt.Errorf("unknown validation mode %q (valid: off, log, strict)", mode)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.responses = mode
+	return nil
+}
+
+func (v *validationState) setRequests(on bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.requests = on
+}
+
+func (v *validationState) body() map[string]any {
+	return map[string]any{
+		"responses": v.responseMode(),
+		"requests":  v.strictRequests(),
+	}
+}
+
+func (v *validationState) record(rec violationRecord) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.violations) >= maxViolationRecords {
+		v.violations = v.violations[1:]
+	}
+	v.violations = append(v.violations, rec)
+}
+
+// bufferingWriter holds the response back so strict mode can replace it.
+type bufferingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// SetResponseValidation selects how responses are checked against the
+// bundled OpenAPI specs: off, log (record violations) or strict (also
+// replace the response with a 500), for the server and the sandboxes
+// created after.
+func (app *Application) SetResponseValidation(mode string) error {
+	return app.state.validation.setResponses(mode)
+}
+
+// validateResponses checks each JSON response of an implemented route
+// against the schema of its spec operation. Routes without a spec, non-JSON
+// bodies and error responses without a declared schema are skipped.
+func (app *Application) validateResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		validation := app.stateFor(r).validation
+		mode := validation.responseMode()
+		if mode == ValidationOff || strings.HasPrefix(r.URL.Path, "/mock/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		bw := &bufferingWriter{ResponseWriter: w}
+		next.ServeHTTP(bw, r)
+		if bw.status == 0 {
+			bw.status = http.StatusOK
+		}
+
+		violations, op := app.checkResponse(r, bw.status, bw.body.Bytes())
+		if len(violations) > 0 {
+			validation.record(violationRecord{
+				Time:        time.Now().UTC(),
+				Method:      r.Method,
+				Path:        r.URL.Path,
+				OperationID: op.OperationID,
+				Status:      bw.status,
+				Violations:  violations,
+			})
+			log.Printf("[validate] %s %s (%s): %d response shape violation(s), first: %s %s",
+				r.Method, r.URL.Path, op.OperationID, len(violations), violations[0].Path, violations[0].Message)
+			if mode == ValidationStrict {
+				w.Header().Del("Content-Length")
+				writeJSON(w, http.StatusInternalServerError, map[string]any{
+					"message":      "response does not match the " + op.OperationID + " schema",
+					"type":         "response_validation_failed",
+					"operation_id": op.OperationID,
+					"violations":   violations,
+				})
+				return
+			}
+		}
+		w.WriteHeader(bw.status)
+		_, _ = w.Write(bw.body.Bytes())
+	})
+}
+
+// SetStrictRequests toggles request validation: when on, request bodies and
+// query parameters of spec-covered routes are checked before the handler
+// runs and bad ones get Scaleway's invalid_arguments 400. Like
+// SetResponseValidation it sets the server's and new sandboxes' default.
+func (app *Application) SetStrictRequests(on bool) {
+	app.state.validation.setRequests(on)
+}
+
+// productField names a request field whose value must come from one of
+// mockway's product catalogs. The specs type these as plain strings, but
+// the real API rejects names it does not sell.
+type productField struct {
+	prefix      string
+	operationID string
+	// field is a dotted path into the body; "*" steps into every element
+	// of an array.
+	field  string
+	values func() []string
+}
+
+var productFields = []productField{
+	{"/instance/", "CreateServer", "commercial_type", instanceProductNames},
+	{"/k8s/", "CreateCluster", "pools.*.node_type", k8sNodeTypeNames},
+	{"/k8s/", "CreatePool", "node_type", k8sNodeTypeNames},
+	{"/rdb/", "CreateInstance", "node_type", func() []string { return catalogNames(rdbNodeTypes) }},
+	{"/redis/", "CreateCluster", "node_type", func() []string { return catalogNames(redisNodeTypes) }},
+}
+
+func instanceProductNames() []string {
+	out := make([]string, 0, len(compatibleCommercialTypes))
+	for _, t := range compatibleCommercialTypes {
+		out = append(out, t.(string))
+	}
+	return out
+}
+
+// k8sNodeTypeNames are the Instance types plus "external" for Kosmos pools.
+func k8sNodeTypeNames() []string {
+	return append(instanceProductNames(), "external")
+}
+
+func catalogNames(items []any) []string {
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if m, ok := item.(map[string]any); ok {
+			if name, ok := m["name"].(string); ok {
+				out = append(out, name)
+			}
+		}
+	}
+	return out
+}
+
+// normalizeProductName folds the spellings the API treats as equal:
+// "dev1_m", "DEV1-M" and "Dev1-M".
+func normalizeProductName(s string) string {
+	return strings.ToUpper(strings.ReplaceAll(s, "_", "-"))
+}
+
+// checkProductFields reports catalog fields whose value is not a known
+// product. Values of the wrong type are left to the schema check.
+func checkProductFields(op *specs.Operation, body any) []specs.Violation {
+	var out []specs.Violation
+	for _, pf := range productFields {
+		if op.OperationID != pf.operationID || !strings.HasPrefix(op.Path, pf.prefix) {
+			continue
+		}
+		known := map[string]bool{}
+		for _, name := range pf.values() {
+			known[normalizeProductName(name)] = true
+		}
+		var walk func(v any, segs []string, path string)
+		walk = func(v any, segs []string, path string) {
+			if len(segs) == 0 {
+				if s, ok := v.(string); ok && !known[normalizeProductName(s)] {
+					out = append(out, specs.Violation{
+						Path:    path,
+						Message: fmt.Sprintf("%q is not a known product", s),
+						Reason:  specs.ReasonConstraint,
+					})
+				}
+				return
+			}
+			if segs[0] == "*" {
+				items, _ := v.([]any)
+				for i, item := range items {
+					walk(item, segs[1:], fmt.Sprintf("%s[%d]", path, i))
+				}
+				return
+			}
+			if m, ok := v.(map[string]any); ok {
+				if child, ok := m[segs[0]]; ok {
+					walk(child, segs[1:], path+"."+segs[0])
+				}
+			}
+		}
+		walk(body, strings.Split(pf.field, "."), "$")
+	}
+	return out
+}
+
+// argumentName turns a violation path into Scaleway's argument_name form:
+// $.volumes[0].size becomes volumes.0.size.
+func argumentName(path string) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	return strings.NewReplacer("[", ".", "]", "").Replace(name)
+}
+
+// validateRequests rejects requests whose query parameters or JSON body do
+// not satisfy their spec operation. Routes without a spec pass through, as
+// do bodies that are not JSON (the handler answers those itself).
+func (app *Application) validateRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.stateFor(r).validation.strictRequests() || strings.HasPrefix(r.URL.Path, "/mock/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		catalog, err := specs.Load()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		op := catalog.Find(r.Method, r.URL.Path)
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		violations := op.ValidateQuery(r.URL.Query())
+		if op.RequestBody != nil && r.Body != nil {
+			raw, _ := io.ReadAll(r.Body)
+			_ = r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(raw))
+			var body any = map[string]any{}
+			if len(bytes.TrimSpace(raw)) == 0 || json.Unmarshal(raw, &body) == nil {
+				violations = append(violations, op.RequestBody.ValidateRequest(body)...)
+				violations = append(violations, checkProductFields(op, body)...)
+			}
+		}
+		if len(violations) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		details := make([]map[string]any, 0, len(violations))
+		for _, v := range violations {
+			details = append(details, map[string]any{
+				"argument_name": argumentName(v.Path),
+				"reason":        v.Reason,
+				"help_message":  v.Message,
+			})
+		}
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"message": "invalid argument(s)",
+			"type":    "invalid_arguments",
+			"details": details,
+		})
+	})
+}
+
+func (app *Application) checkResponse(r *http.Request, status int, body []byte) ([]specs.Violation, *specs.Operation) {
+	catalog, err := specs.Load()
+	if err != nil {
+		return nil, nil
+	}
+	op := catalog.Find(r.Method, r.URL.Path)
+	if op == nil {
+		return nil, nil
+	}
+	schema, ok := op.Responses[strconv.Itoa(status)]
+	if !ok || schema == nil || len(bytes.TrimSpace(body)) == 0 {
+		return nil, op
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return []specs.Violation{{Path: "$", Message: "response is not valid JSON"}}, op
+	}
+	return schema.Validate(v), op
+}
+
+// ListViolations handles GET /mock/violations.
+func (app *Application) ListViolations(w http.ResponseWriter, r *http.Request) {
+	validation := app.stateFor(r).validation
+	validation.mu.Lock()
+	items := append([]violationRecord{}, validation.violations...)
+	validation.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"violations": items, "total_count": len(items)})
+}
+
+// ClearViolations handles DELETE /mock/violations.
+func (app *Application) ClearViolations(w http.ResponseWriter, r *http.Request) {
+	validation := app.stateFor(r).validation
+	validation.mu.Lock()
+	validation.violations = nil
+	validation.mu.Unlock()
+	writeNoContent(w)
+}
+
+// GetValidation handles GET /mock/validation.
+func (app *Application) GetValidation(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, app.stateFor(r).validation.body())
+}
+
+// PutValidation handles PUT /mock/validation, e.g.
+// {"responses":"strict","requests":true}. Omitted fields keep their current
+// value.
+func (app *Application) PutValidation(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Responses *string `json:"responses"`
+		Requests  *bool   `json:"requests"`
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
+		return
+	}
+	validation := app.stateFor(r).validation
+	if body.Responses != nil {
+		if err := validation.setResponses(*body.Responses); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"message": err.Error(), "type": "invalid_argument"})
+			return
+		}
+	}
+	if body.Requests != nil {
+		validation.setRequests(*body.Requests)
+	}
+	writeJSON(w, http.StatusOK, validation.body())
+}
@@ -12,7 +12,7 @@ func (app *Application) CreateVPC(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateVPC(chi.URLParam(r, "region"), body)
+	out, err := app.repoFor(r).CreateVPC(chi.URLParam(r, "region"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -21,7 +21,7 @@ func (app *Application) CreateVPC(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) GetVPC(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetVPC(chi.URLParam(r, "vpc_id"))
+	out, err := app.repoFor(r).GetVPC(chi.URLParam(r, "vpc_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -30,12 +30,17 @@ func (app *Application) GetVPC(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) ListVPCs(w http.ResponseWriter, r *http.Request) {
-	items, err := app.repo.ListVPCs(chi.URLParam(r, "region"))
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListVPCs(chi.URLParam(r, "region"), filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "vpcs", items)
+	writeList(w, r, "vpcs", items)
 }
 
 func (app *Application) UpdateVPC(w http.ResponseWriter, r *http.Request) {
@@ -44,7 +49,7 @@ func (app *Application) UpdateVPC(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateVPC(chi.URLParam(r, "vpc_id"), body)
+	out, err := app.repoFor(r).UpdateVPC(chi.URLParam(r, "vpc_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -53,7 +58,7 @@ func (app *Application) UpdateVPC(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) DeleteVPC(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteVPC(chi.URLParam(r, "vpc_id")); err != nil {
+	if err := app.repoFor(r).DeleteVPC(chi.URLParam(r, "vpc_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -66,7 +71,7 @@ func (app *Application) CreatePrivateNetwork(w http.ResponseWriter, r *http.Requ
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreatePrivateNetwork(chi.URLParam(r, "region"), body)
+	out, err := app.repoFor(r).CreatePrivateNetwork(chi.URLParam(r, "region"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -75,7 +80,7 @@ func (app *Application) CreatePrivateNetwork(w http.ResponseWriter, r *http.Requ
 }
 
 func (app *Application) GetPrivateNetwork(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetPrivateNetwork(chi.URLParam(r, "pn_id"))
+	out, err := app.repoFor(r).GetPrivateNetwork(chi.URLParam(r, "pn_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -84,12 +89,17 @@ func (app *Application) GetPrivateNetwork(w http.ResponseWriter, r *http.Request
 }
 
 func (app *Application) ListPrivateNetworks(w http.ResponseWriter, r *http.Request) {
-	items, err := app.repo.ListPrivateNetworks(chi.URLParam(r, "region"))
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListPrivateNetworks(chi.URLParam(r, "region"), filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "private_networks", items)
+	writeList(w, r, "private_networks", items)
 }
 
 func (app *Application) UpdatePrivateNetwork(w http.ResponseWriter, r *http.Request) {
@@ -98,7 +108,7 @@ func (app *Application) UpdatePrivateNetwork(w http.ResponseWriter, r *http.Requ
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdatePrivateNetwork(chi.URLParam(r, "pn_id"), body)
+	out, err := app.repoFor(r).UpdatePrivateNetwork(chi.URLParam(r, "pn_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -107,7 +117,7 @@ func (app *Application) UpdatePrivateNetwork(w http.ResponseWriter, r *http.Requ
 }
 
 func (app *Application) DeletePrivateNetwork(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeletePrivateNetwork(chi.URLParam(r, "pn_id")); err != nil {
+	if err := app.repoFor(r).DeletePrivateNetwork(chi.URLParam(r, "pn_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -122,7 +132,7 @@ func (app *Application) CreateVPCRoute(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateVPCRoute(chi.URLParam(r, "region"), body)
+	out, err := app.repoFor(r).CreateVPCRoute(chi.URLParam(r, "region"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -131,7 +141,7 @@ func (app *Application) CreateVPCRoute(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) GetVPCRoute(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetVPCRoute(chi.URLParam(r, "route_id"))
+	out, err := app.repoFor(r).GetVPCRoute(chi.URLParam(r, "route_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -141,12 +151,12 @@ func (app *Application) GetVPCRoute(w http.ResponseWriter, r *http.Request) {
 
 func (app *Application) ListVPCRoutes(w http.ResponseWriter, r *http.Request) {
 	vpcID := r.URL.Query().Get("vpc_id")
-	items, err := app.repo.ListVPCRoutes(chi.URLParam(r, "region"), vpcID)
+	items, err := app.repoFor(r).ListVPCRoutes(chi.URLParam(r, "region"), vpcID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "routes", items)
+	writeList(w, r, "routes", items)
 }
 
 func (app *Application) UpdateVPCRoute(w http.ResponseWriter, r *http.Request) {
@@ -155,7 +165,7 @@ func (app *Application) UpdateVPCRoute(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateVPCRoute(chi.URLParam(r, "route_id"), body)
+	out, err := app.repoFor(r).UpdateVPCRoute(chi.URLParam(r, "route_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -164,7 +174,7 @@ func (app *Application) UpdateVPCRoute(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) DeleteVPCRoute(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteVPCRoute(chi.URLParam(r, "route_id")); err != nil {
+	if err := app.repoFor(r).DeleteVPCRoute(chi.URLParam(r, "route_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -179,7 +189,7 @@ func (app *Application) CreateVPCPublicGateway(w http.ResponseWriter, r *http.Re
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.CreateVPCPublicGateway(chi.URLParam(r, "zone"), body)
+	out, err := app.repoFor(r).CreateVPCPublicGateway(chi.URLParam(r, "zone"), body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -188,7 +198,7 @@ func (app *Application) CreateVPCPublicGateway(w http.ResponseWriter, r *http.Re
 }
 
 func (app *Application) GetVPCPublicGateway(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetVPCPublicGateway(chi.URLParam(r, "gateway_id"))
+	out, err := app.repoFor(r).GetVPCPublicGateway(chi.URLParam(r, "gateway_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -197,12 +207,17 @@ func (app *Application) GetVPCPublicGateway(w http.ResponseWriter, r *http.Reque
 }
 
 func (app *Application) ListVPCPublicGateways(w http.ResponseWriter, r *http.Request) {
-	items, err := app.repo.ListVPCPublicGateways(chi.URLParam(r, "zone"))
+	filters, err := listFilters(r)
+	if err != nil {
+		writeFilterError(w, err)
+		return
+	}
+	items, err := app.repoFor(r).ListVPCPublicGateways(chi.URLParam(r, "zone"), filters...)
 	if err != nil {
 		writeDomainError(w, err)
 		return
 	}
-	writeList(w, "gateways", items)
+	writeList(w, r, "gateways", items)
 }
 
 func (app *Application) UpdateVPCPublicGateway(w http.ResponseWriter, r *http.Request) {
@@ -211,7 +226,7 @@ func (app *Application) UpdateVPCPublicGateway(w http.ResponseWriter, r *http.Re
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateVPCPublicGateway(chi.URLParam(r, "gateway_id"), body)
+	out, err := app.repoFor(r).UpdateVPCPublicGateway(chi.URLParam(r, "gateway_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -220,7 +235,7 @@ func (app *Application) UpdateVPCPublicGateway(w http.ResponseWriter, r *http.Re
 }
 
 func (app *Application) DeleteVPCPublicGateway(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteVPCPublicGateway(chi.URLParam(r, "gateway_id")); err != nil {
+	if err := app.repoFor(r).DeleteVPCPublicGateway(chi.URLParam(r, "gateway_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
@@ -236,7 +251,7 @@ func (app *Application) CreateVPCGatewayNetwork(w http.ResponseWriter, r *http.R
 		return
 	}
 	body["zone"] = chi.URLParam(r, "zone")
-	out, err := app.repo.CreateVPCGatewayNetwork(body)
+	out, err := app.repoFor(r).CreateVPCGatewayNetwork(body)
 	if err != nil {
 		writeCreateError(w, err)
 		return
@@ -245,7 +260,7 @@ func (app *Application) CreateVPCGatewayNetwork(w http.ResponseWriter, r *http.R
 }
 
 func (app *Application) GetVPCGatewayNetwork(w http.ResponseWriter, r *http.Request) {
-	out, err := app.repo.GetVPCGatewayNetwork(chi.URLParam(r, "gateway_network_id"))
+	out, err := app.repoFor(r).GetVPCGatewayNetwork(chi.URLParam(r, "gateway_network_id"))
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -256,7 +271,7 @@ func (app *Application) GetVPCGatewayNetwork(w http.ResponseWriter, r *http.Requ
 func (app *Application) ListVPCGatewayNetworks(w http.ResponseWriter, r *http.Request) {
 	gatewayID := r.URL.Query().Get("gateway_id")
 	zone := chi.URLParam(r, "zone")
-	items, err := app.repo.ListVPCGatewayNetworks(gatewayID)
+	items, err := app.repoFor(r).ListVPCGatewayNetworks(gatewayID)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -271,7 +286,7 @@ func (app *Application) ListVPCGatewayNetworks(w http.ResponseWriter, r *http.Re
 		}
 		items = filtered
 	}
-	writeList(w, "gateway_networks", items)
+	writeList(w, r, "gateway_networks", items)
 }
 
 func (app *Application) UpdateVPCGatewayNetwork(w http.ResponseWriter, r *http.Request) {
@@ -280,7 +295,7 @@ func (app *Application) UpdateVPCGatewayNetwork(w http.ResponseWriter, r *http.R
 		writeJSON(w, http.StatusBadRequest, map[string]any{"message": "invalid json", "type": "invalid_argument"})
 		return
 	}
-	out, err := app.repo.UpdateVPCGatewayNetwork(chi.URLParam(r, "gateway_network_id"), body)
+	out, err := app.repoFor(r).UpdateVPCGatewayNetwork(chi.URLParam(r, "gateway_network_id"), body)
 	if err != nil {
 		writeDomainError(w, err)
 		return
@@ -289,7 +304,7 @@ func (app *Application) UpdateVPCGatewayNetwork(w http.ResponseWriter, r *http.R
 }
 
 func (app *Application) DeleteVPCGatewayNetwork(w http.ResponseWriter, r *http.Request) {
-	if err := app.repo.DeleteVPCGatewayNetwork(chi.URLParam(r, "gateway_network_id")); err != nil {
+	if err := app.repoFor(r).DeleteVPCGatewayNetwork(chi.URLParam(r, "gateway_network_id")); err != nil {
 		writeDomainError(w, err)
 		return
 	}
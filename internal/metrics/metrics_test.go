@@ -0,0 +1,44 @@
+package metrics_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/redscaresu/mockway/internal/metrics"
+)
+
+func TestTextFormat(t *testing.T) {
+	c := metrics.NewCounterVec("hits_total", "Hits.", "path")
+	c.Inc(`/a"b`)
+	c.Inc(`/a"b`)
+	c.Inc("/c")
+	require.Equal(t, float64(2), c.Get(`/a"b`))
+
+	h := metrics.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "op")
+	h.Observe(0.05, "q")
+	h.Observe(0.5, "q")
+	h.Observe(2, "q")
+	require.Equal(t, uint64(3), h.Count("q"))
+
+	var buf bytes.Buffer
+	c.Write(&buf)
+	h.Write(&buf)
+	metrics.WriteGauge(&buf, "rows", "Rows.", []string{"table"}, []metrics.Sample{{Labels: []string{"vpcs"}, Value: 3}})
+	require.Equal(t, `# HELP hits_total Hits.
+# TYPE hits_total counter
+hits_total{path="/a\"b"} 2
+hits_total{path="/c"} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="q",le="0.1"} 1
+latency_seconds_bucket{op="q",le="1"} 2
+latency_seconds_bucket{op="q",le="+Inf"} 3
+latency_seconds_sum{op="q"} 2.55
+latency_seconds_count{op="q"} 3
+# HELP rows Rows.
+# TYPE rows gauge
+rows{table="vpcs"} 3
+`, buf.String())
+}
@@ -5,4 +5,15 @@ import "errors"
 var (
 	ErrNotFound = errors.New("not found")
 	ErrConflict = errors.New("conflict")
+	// ErrConcurrentUpdate is a write to a resource that changed after it
+	// was read; retrying the whole operation may succeed.
+	ErrConcurrentUpdate = errors.New("resource was modified concurrently")
+	// ErrPreconditionFailed is a request the resource's state forbids
+	// outright, whatever else is done first.
+	ErrPreconditionFailed = errors.New("precondition failed")
+
+	ErrUnauthenticated  = errors.New("unauthenticated")
+	ErrPermissionDenied = errors.New("permissions denied")
+	ErrQuotaExceeded    = errors.New("quotas exceeded")
+	ErrOutOfStock       = errors.New("out of stock")
 )
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/redscaresu/mockway/models"
+)
+
+// DefaultProjectID and DefaultOrganizationID identify the project that exists
+// from startup (and after every Reset). They match the SCW_DEFAULT_PROJECT_ID /
+// SCW_DEFAULT_ORGANIZATION_ID values the examples and scripts export, so
+// configs that never create a project keep working.
+const (
+	DefaultProjectID      = "00000000-0000-0000-0000-000000000000"
+	DefaultOrganizationID = "00000000-0000-0000-0000-000000000000"
+)
+
+// seedDefaultProject makes sure the default project exists. It is idempotent.
+func (r *Repository) seedDefaultProject() error {
+	return r.seedDefaultProjectOn(r.db)
+}
+
+// seedDefaultProjectOn runs the seeding insert on db, which may be a
+// transaction.
+func (r *Repository) seedDefaultProjectOn(db interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}) error {
+	now := r.nowRFC3339()
+	b, err := marshalData(map[string]any{
+		"id":              DefaultProjectID,
+		"name":            "default",
+		"organization_id": DefaultOrganizationID,
+		"description":     "cannot_be_deleted",
+		"created_at":      now,
+		"updated_at":      now,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		`INSERT OR IGNORE INTO account_projects (id, organization_id, data) VALUES (?, ?, ?)`,
+		DefaultProjectID, DefaultOrganizationID, b,
+	)
+	return err
+}
+
+func (r *Repository) CreateProject(data map[string]any) (map[string]any, error) {
+	data = cloneMap(data)
+	now := r.nowRFC3339()
+	orgID, _ := data["organization_id"].(string)
+	if orgID == "" {
+		orgID = DefaultOrganizationID
+	}
+	data["organization_id"] = orgID
+	if _, ok := data["description"]; !ok {
+		data["description"] = ""
+	}
+	data["created_at"] = now
+	data["updated_at"] = now
+	return r.createSimple("account_projects", "organization_id", orgID, data)
+}
+
+func (r *Repository) GetProject(id string) (map[string]any, error) {
+	return r.getJSONByID("account_projects", "id", id)
+}
+
+func (r *Repository) ListProjects(filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("account_projects", "", "", filters...)
+}
+
+func (r *Repository) UpdateProject(id string, patch map[string]any) (map[string]any, error) {
+	current, err := r.getJSONByID("account_projects", "id", id)
+	if err != nil {
+		return nil, err
+	}
+	next := patchMerge(current, patch, "id", "organization_id")
+	next["updated_at"] = r.nowRFC3339()
+	if err := r.updateJSONByID("account_projects", "id", id, next); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// DeleteProject removes an empty project. Like the real API it answers
+// conflict while resources still belong to it, and refuses to delete the
+// default project at all.
+func (r *Repository) DeleteProject(id string) error {
+	if id == DefaultProjectID {
+		return fmt.Errorf("%w: the default project cannot be deleted", models.ErrPreconditionFailed)
+	}
+	if _, err := r.getJSONByID("account_projects", "id", id); err != nil {
+		return err
+	}
+	owned, err := r.projectHasResources(id)
+	if err != nil {
+		return err
+	}
+	if owned {
+		return models.ErrConflict
+	}
+	return r.deleteBy("account_projects", "id = ?", id)
+}
+
+func (r *Repository) projectHasResources(id string) (bool, error) {
+	for _, table := range projectScopedTables {
+		q := fmt.Sprintf(
+			`SELECT 1 FROM %s WHERE json_extract(data, '$.project_id') = ? OR json_extract(data, '$.project') = ? LIMIT 1`,
+			table,
+		)
+		var one int
+		err := r.db.QueryRow(q, id, id).Scan(&one)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// checkProjectRef returns ErrNotFound when data names a project (project_id,
+// or project on the Instance API) that does not exist.
+func (r *Repository) checkProjectRef(data map[string]any) error {
+	for _, key := range []string{"project_id", "project"} {
+		id, _ := data[key].(string)
+		if id == "" {
+			continue
+		}
+		ok, err := r.Exists("account_projects", "id", id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return models.ErrNotFound
+		}
+	}
+	return nil
+}
+
+// applyProjectDefaults fills project_id with the default project when the
+// caller did not send one, and organization_id with the organization owning
+// that project. A project_id that does not exist yields ErrNotFound.
+func (r *Repository) applyProjectDefaults(data map[string]any) error {
+	projectID, _ := data["project_id"].(string)
+	if projectID == "" {
+		projectID = DefaultProjectID
+		data["project_id"] = projectID
+	}
+	project, err := r.getJSONByID("account_projects", "id", projectID)
+	if err != nil {
+		return err
+	}
+	if orgID, _ := data["organization_id"].(string); orgID == "" {
+		data["organization_id"] = project["organization_id"]
+	}
+	return nil
+}
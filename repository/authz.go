@@ -0,0 +1,313 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/redscaresu/mockway/models"
+)
+
+// DefaultIAMAdminKey is the bootstrap secret key accepted in enforce mode
+// when no other admin key is configured. It matches the SCW_SECRET_KEY the
+// examples and scripts export, so an unmodified setup can still provision
+// the IAM resources it is about to be checked against.
+const DefaultIAMAdminKey = "00000000-0000-0000-0000-000000000000"
+
+// IAMConfig controls IAM enforcement. When Enforce is false any non-empty
+// X-Auth-Token is accepted, as before.
+type IAMConfig struct {
+	Enforce  bool
+	AdminKey string
+}
+
+// AccessRequest describes what a request wants to do, in IAM terms.
+type AccessRequest struct {
+	Product   string // first path segment: instance, vpc, lb, ...
+	Write     bool
+	ProjectID string // project named by the request, "" when unknown
+}
+
+// PermissionDeniedError reports the permission a bearer is missing. It
+// wraps models.ErrPermissionDenied.
+type PermissionDeniedError struct {
+	Product string
+	Action  string
+}
+
+func (e *PermissionDeniedError) Error() string {
+	return fmt.Sprintf("insufficient permissions: %s %s", e.Action, e.Product)
+}
+
+func (e *PermissionDeniedError) Unwrap() error { return models.ErrPermissionDenied }
+
+// permissionSetProducts maps the product part of a Scaleway permission set
+// name (InstancesFullAccess → Instances) to the API prefixes it covers.
+// AllProducts is handled separately.
+var permissionSetProducts = map[string][]string{
+	"Instances":           {"instance"},
+	"VPC":                 {"vpc", "vpc-gw", "ipam"},
+	"PrivateNetworks":     {"vpc"},
+	"PublicGateways":      {"vpc-gw"},
+	"IPAM":                {"ipam"},
+	"LoadBalancers":       {"lb"},
+	"Kubernetes":          {"k8s"},
+	"RelationalDatabases": {"rdb"},
+	"Redis":               {"redis"},
+	"ContainerRegistry":   {"registry"},
+	"DomainsDNS":          {"domain"},
+	"BlockStorage":        {"block"},
+	"IAM":                 {"iam", "account"},
+	"Project":             {"account"},
+}
+
+// publicProducts are readable by any authenticated bearer; the real
+// marketplace API does not require a permission set.
+var publicProducts = map[string]bool{"marketplace": true}
+
+// permissionSetAllows reports whether the named permission set grants req.
+// Names are <Product><Level> where Level is FullAccess, Manager or ReadOnly.
+func permissionSetAllows(name string, req AccessRequest) bool {
+	var product string
+	write := true
+	switch {
+	case strings.HasSuffix(name, "FullAccess"):
+		product = strings.TrimSuffix(name, "FullAccess")
+	case strings.HasSuffix(name, "Manager"):
+		product = strings.TrimSuffix(name, "Manager")
+	case strings.HasSuffix(name, "ReadOnly"):
+		product = strings.TrimSuffix(name, "ReadOnly")
+		write = false
+	default:
+		return false
+	}
+	if req.Write && !write {
+		return false
+	}
+	if product == "AllProducts" {
+		return true
+	}
+	for _, p := range permissionSetProducts[product] {
+		if p == req.Product {
+			return true
+		}
+	}
+	return false
+}
+
+// IAMEnforcement returns the IAM config currently in effect.
+func (r *Repository) IAMEnforcement() IAMConfig {
+	r.iamMu.RLock()
+	defer r.iamMu.RUnlock()
+	return r.iam
+}
+
+// SetIAMEnforcement replaces the IAM config. An empty AdminKey falls back
+// to DefaultIAMAdminKey.
+func (r *Repository) SetIAMEnforcement(cfg IAMConfig) {
+	if cfg.AdminKey == "" {
+		cfg.AdminKey = DefaultIAMAdminKey
+	}
+	r.iamMu.Lock()
+	defer r.iamMu.Unlock()
+	r.iam = cfg
+}
+
+// Authorize checks secretKey against the stored API keys and evaluates the
+// bearer's policies. It returns models.ErrUnauthenticated when no API key
+// has that secret, and a *PermissionDeniedError when no rule grants req.
+// The admin key is always allowed.
+//
+// A read naming no project is granted by project-scoped rules too; Authorize
+// then returns their projects, and the caller must show only resources in
+// those. A nil slice means req is granted whatever the project. A write
+// naming no project creates in the default project and is checked against
+// it.
+func (r *Repository) Authorize(secretKey string, req AccessRequest) ([]string, error) {
+	if secretKey == r.IAMEnforcement().AdminKey {
+		return nil, nil
+	}
+	var raw []byte
+	err := r.db.QueryRow(
+		`SELECT data FROM iam_api_keys WHERE json_extract(data, '$.secret_key') = ?`, secretKey,
+	).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := unmarshalData(raw)
+	if err != nil {
+		return nil, err
+	}
+	if publicProducts[req.Product] && !req.Write {
+		return nil, nil
+	}
+	if req.Write && req.ProjectID == "" {
+		req.ProjectID = DefaultProjectID
+	}
+
+	policyIDs, err := r.principalPolicyIDs(key)
+	if err != nil {
+		return nil, err
+	}
+	var scoped []string
+	for _, policyID := range policyIDs {
+		rules, err := r.listJSON("iam_rules", "policy_id", policyID)
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range rules {
+			projects, ok := ruleAllows(rule, req)
+			if ok && projects == nil {
+				return nil, nil
+			}
+			scoped = append(scoped, projects...)
+		}
+	}
+	if len(scoped) > 0 {
+		return scoped, nil
+	}
+	action := "read"
+	if req.Write {
+		action = "write"
+	}
+	return nil, &PermissionDeniedError{Product: req.Product, Action: action}
+}
+
+// ResourceProject returns the project of the last of ids naming a row in
+// one of the project-scoped tables, or "" when none does; a path's
+// segments go in as they come, so a child resource wins over its parent.
+// It is one query whatever the number of ids. Like quotas, it counts a row
+// naming no project as the default project's.
+func (r *Repository) ResourceProject(ids ...string) (string, error) {
+	if len(ids) == 0 {
+		return "", nil
+	}
+	values := make([]string, len(ids))
+	var args []any
+	for i, id := range ids {
+		values[i] = "(?, ?)"
+		args = append(args, i, id)
+	}
+	var parts []string
+	for _, t := range Tables {
+		if !t.ProjectScoped || len(t.ID) != 1 {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf(
+			`SELECT s.pos, COALESCE(json_extract(t.data, '$.project_id'), json_extract(t.data, '$.project'), ?) FROM %s t JOIN segs s ON t.%s = s.id`,
+			t.Name, t.ID[0],
+		))
+		args = append(args, DefaultProjectID)
+	}
+	q := "WITH segs(pos, id) AS (VALUES " + strings.Join(values, ", ") + ") " +
+		strings.Join(parts, " UNION ALL ") + " ORDER BY 1 DESC LIMIT 1"
+	var (
+		pos     int
+		project string
+	)
+	err := r.db.QueryRow(q, args...).Scan(&pos, &project)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return project, nil
+}
+
+// principalPolicyIDs returns the policies attached to the API key's bearer:
+// directly to its application or user, or to a group the bearer belongs to.
+func (r *Repository) principalPolicyIDs(key map[string]any) ([]string, error) {
+	appID, _ := key["application_id"].(string)
+	userID, _ := key["user_id"].(string)
+
+	var groupIDs []string
+	if userID != "" {
+		rows, err := r.db.Query(`SELECT group_id FROM iam_group_members WHERE user_id = ?`, userID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			groupIDs = append(groupIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if appID != "" {
+		groups, err := r.listJSON("iam_groups", "", "", Filter{Field: "application_ids", Op: FilterHasAll, Values: []any{appID}})
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			if id, _ := g["id"].(string); id != "" {
+				groupIDs = append(groupIDs, id)
+			}
+		}
+	}
+
+	policies, err := r.listJSON("iam_policies", "", "")
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, p := range policies {
+		id, _ := p["id"].(string)
+		switch {
+		case appID != "" && p["application_id"] == appID,
+			userID != "" && p["user_id"] == userID:
+			out = append(out, id)
+		default:
+			gid, _ := p["group_id"].(string)
+			for _, g := range groupIDs {
+				if gid != "" && gid == g {
+					out = append(out, id)
+					break
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// ruleAllows reports whether a policy rule grants req. Organization-wide
+// rules apply everywhere and yield no projects. Project-scoped rules apply
+// when the request names one of their projects; when it names none, they
+// grant only their own projects, which they return.
+func ruleAllows(rule map[string]any, req AccessRequest) ([]string, bool) {
+	names, _ := rule["permission_set_names"].([]any)
+	granted := false
+	for _, n := range names {
+		if name, ok := n.(string); ok && permissionSetAllows(name, req) {
+			granted = true
+			break
+		}
+	}
+	if !granted {
+		return nil, false
+	}
+	ids, _ := rule["project_ids"].([]any)
+	if len(ids) == 0 {
+		return nil, true
+	}
+	var projects []string
+	for _, v := range ids {
+		id, _ := v.(string)
+		if req.ProjectID == "" {
+			projects = append(projects, id)
+		} else if id == req.ProjectID {
+			return nil, true
+		}
+	}
+	return projects, len(projects) > 0
+}
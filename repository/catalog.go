@@ -0,0 +1,427 @@
+package repository
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Table describes one SQLite table of the repository. Tables is the single
+// list of them: schema creation, the /mock/reset order, the state document,
+// events, project checks, quotas, TableCounts and /mock/tables are all
+// derived from it, so adding a resource means adding one entry here.
+type Table struct {
+	Name string `json:"name"`
+	// Service and Kind place the rows in the state document, under
+	// state[Service][Kind]. Tables with a Service but no Kind are exported
+	// another way (group members inside their groups, marketplace labels,
+	// generic resources); tables with neither are mockway's own bookkeeping.
+	Service string `json:"service,omitempty"`
+	Kind    string `json:"kind,omitempty"`
+	// Type names rows in events and /mock/graph. It defaults to the
+	// singular of Kind.
+	Type string `json:"type,omitempty"`
+	// Scope is the zone or region column, empty for global tables and
+	// those scoped through their parent.
+	Scope string `json:"scope,omitempty"`
+	// ID is the primary key.
+	ID []string `json:"id"`
+	// Parents are the tables the SQL foreign keys point to.
+	Parents []string `json:"parents,omitempty"`
+	// ProjectScoped rows carry their project in their data, as project_id
+	// or, on the Instance API, project. A project is not deleted while
+	// such rows reference it, and IAM rules scoped to projects are checked
+	// against it.
+	ProjectScoped bool `json:"project_scoped,omitempty"`
+	// Quota is the quota kind, as Scaleway's quotas_exceeded details name
+	// it, that counts the table's rows per project.
+	Quota string `json:"quota,omitempty"`
+	// Keep tables survive Reset and replacing imports.
+	Keep bool `json:"keep,omitempty"`
+	// Schema is the column list of CREATE TABLE.
+	Schema string `json:"-"`
+	// cols fill the SQL columns from an imported state document object.
+	cols []fixtureCol
+}
+
+// ResourceType is t.Type, or the singular of t.Kind.
+func (t Table) ResourceType() string {
+	if t.Type != "" {
+		return t.Type
+	}
+	if t.Kind != "" {
+		return singular(t.Kind)
+	}
+	return ""
+}
+
+// Tables lists every table in dependency order: a table's parents are
+// above it, so creating or importing top to bottom satisfies every foreign
+// key and deleting bottom to top never orphans a row.
+var Tables = []Table{
+	{Name: "account_projects", Service: "account", Kind: "projects", ID: []string{"id"}, Schema: `
+		id TEXT PRIMARY KEY,
+		organization_id TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("organization_id")}},
+
+	{Name: "vpcs", Service: "vpc", Kind: "vpcs", Scope: "region", ID: []string{"id"}, ProjectScoped: true, Quota: "vpcs", Schema: `
+		id TEXT PRIMARY KEY,
+		region TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("region")}},
+	{Name: "private_networks", Service: "vpc", Kind: "private_networks", Scope: "region", ID: []string{"id"}, ProjectScoped: true, Quota: "private_networks", Parents: []string{"vpcs"}, Schema: `
+		id TEXT PRIMARY KEY,
+		vpc_id TEXT NOT NULL REFERENCES vpcs(id),
+		region TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("vpc_id"), col("region")}},
+	{Name: "vpc_routes", Service: "vpc", Kind: "routes", Scope: "region", ID: []string{"id"}, ProjectScoped: true, Parents: []string{"vpcs"}, Schema: `
+		id TEXT PRIMARY KEY,
+		vpc_id TEXT NOT NULL REFERENCES vpcs(id),
+		region TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("vpc_id"), col("region")}},
+	{Name: "vpc_public_gateways", Service: "vpc", Kind: "gateways", Scope: "zone", ID: []string{"id"}, ProjectScoped: true, Quota: "public_gateways", Schema: `
+		id TEXT PRIMARY KEY,
+		zone TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("zone")}},
+	{Name: "vpc_gateway_networks", Service: "vpc", Kind: "gateway_networks", ID: []string{"id"}, ProjectScoped: true, Parents: []string{"vpc_public_gateways", "private_networks"}, Schema: `
+		id TEXT PRIMARY KEY,
+		gateway_id TEXT NOT NULL REFERENCES vpc_public_gateways(id),
+		private_network_id TEXT NOT NULL REFERENCES private_networks(id),
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("gateway_id"), col("private_network_id")}},
+
+	{Name: "instance_security_groups", Service: "instance", Kind: "security_groups", Scope: "zone", ID: []string{"id"}, ProjectScoped: true, Quota: "security_groups", Schema: `
+		id TEXT PRIMARY KEY,
+		zone TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("zone")}},
+	{Name: "instance_servers", Service: "instance", Kind: "servers", Scope: "zone", ID: []string{"id"}, ProjectScoped: true, Quota: "instances", Parents: []string{"instance_security_groups"}, Schema: `
+		id TEXT PRIMARY KEY,
+		zone TEXT NOT NULL,
+		security_group_id TEXT REFERENCES instance_security_groups(id) ON DELETE SET NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{
+			col("id"),
+			col("zone"),
+			{name: "security_group_id", optional: true, weak: "instance_security_groups"},
+		}},
+	{Name: "instance_ips", Service: "instance", Kind: "ips", Scope: "zone", ID: []string{"id"}, ProjectScoped: true, Quota: "instance_ips", Parents: []string{"instance_servers"}, Schema: `
+		id TEXT PRIMARY KEY,
+		server_id TEXT REFERENCES instance_servers(id) ON DELETE SET NULL,
+		zone TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), optCol("server_id"), col("zone")}},
+	{Name: "instance_private_nics", Service: "instance", Kind: "private_nics", Scope: "zone", ID: []string{"id"}, Parents: []string{"instance_servers", "private_networks"}, Schema: `
+		id TEXT PRIMARY KEY,
+		server_id TEXT NOT NULL REFERENCES instance_servers(id) ON DELETE CASCADE,
+		private_network_id TEXT NOT NULL REFERENCES private_networks(id),
+		zone TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("server_id"), col("private_network_id"), col("zone")}},
+	{Name: "instance_volumes", Service: "instance", Kind: "volumes", Scope: "zone", ID: []string{"id"}, ProjectScoped: true, Quota: "volumes", Schema: `
+		id TEXT PRIMARY KEY,
+		zone TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("zone")}},
+
+	{Name: "block_volumes", Service: "block", Kind: "volumes", Scope: "zone", ID: []string{"id"}, ProjectScoped: true, Quota: "block_volumes", Schema: `
+		id TEXT PRIMARY KEY,
+		zone TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("zone")}},
+	{Name: "block_snapshots", Service: "block", Kind: "snapshots", Scope: "zone", ID: []string{"id"}, ProjectScoped: true, Quota: "block_snapshots", Parents: []string{"block_volumes"}, Schema: `
+		id TEXT PRIMARY KEY,
+		zone TEXT NOT NULL,
+		volume_id TEXT REFERENCES block_volumes(id),
+		data JSON NOT NULL`,
+		cols: []fixtureCol{
+			col("id"),
+			col("zone"),
+			{name: "volume_id", optional: true, value: func(data map[string]any) string { return nestedID(data, "parent_volume") }},
+		}},
+
+	{Name: "lb_ips", Service: "lb", Kind: "ips", Scope: "zone", ID: []string{"id"}, ProjectScoped: true, Quota: "lb_ips", Schema: `
+		id TEXT PRIMARY KEY,
+		zone TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("zone")}},
+	{Name: "lbs", Service: "lb", Kind: "lbs", Scope: "zone", ID: []string{"id"}, ProjectScoped: true, Quota: "lbs", Schema: `
+		id TEXT PRIMARY KEY,
+		zone TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("zone")}},
+	{Name: "lb_backends", Service: "lb", Kind: "backends", ID: []string{"id"}, Parents: []string{"lbs"}, Schema: `
+		id TEXT PRIMARY KEY,
+		lb_id TEXT NOT NULL REFERENCES lbs(id),
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("lb_id")}},
+	{Name: "lb_frontends", Service: "lb", Kind: "frontends", ID: []string{"id"}, Parents: []string{"lbs"}, Schema: `
+		id TEXT PRIMARY KEY,
+		lb_id TEXT NOT NULL REFERENCES lbs(id),
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("lb_id")}},
+	{Name: "lb_private_networks", Service: "lb", Kind: "private_networks", ID: []string{"lb_id", "private_network_id"}, Parents: []string{"lbs", "private_networks"}, Schema: `
+		lb_id TEXT NOT NULL REFERENCES lbs(id),
+		private_network_id TEXT NOT NULL REFERENCES private_networks(id),
+		data JSON NOT NULL,
+		PRIMARY KEY (lb_id, private_network_id)`,
+		cols: []fixtureCol{col("lb_id"), col("private_network_id")}},
+	{Name: "lb_acls", Service: "lb", Kind: "acls", ID: []string{"id"}, Parents: []string{"lb_frontends"}, Schema: `
+		id TEXT PRIMARY KEY,
+		frontend_id TEXT NOT NULL REFERENCES lb_frontends(id) ON DELETE CASCADE,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("frontend_id")}},
+	{Name: "lb_routes", Service: "lb", Kind: "routes", ID: []string{"id"}, Parents: []string{"lbs"}, Schema: `
+		id TEXT PRIMARY KEY,
+		lb_id TEXT NOT NULL REFERENCES lbs(id) ON DELETE CASCADE,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("lb_id")}},
+	{Name: "lb_certificates", Service: "lb", Kind: "certificates", ID: []string{"id"}, Parents: []string{"lbs"}, Schema: `
+		id TEXT PRIMARY KEY,
+		lb_id TEXT NOT NULL REFERENCES lbs(id) ON DELETE CASCADE,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("lb_id")}},
+
+	{Name: "k8s_clusters", Service: "k8s", Kind: "clusters", Scope: "region", ID: []string{"id"}, ProjectScoped: true, Quota: "k8s_clusters", Parents: []string{"private_networks"}, Schema: `
+		id TEXT PRIMARY KEY,
+		region TEXT NOT NULL,
+		private_network_id TEXT REFERENCES private_networks(id),
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("region"), optCol("private_network_id")}},
+	{Name: "k8s_pools", Service: "k8s", Kind: "pools", Scope: "region", ID: []string{"id"}, ProjectScoped: true, Parents: []string{"k8s_clusters"}, Schema: `
+		id TEXT PRIMARY KEY,
+		cluster_id TEXT NOT NULL REFERENCES k8s_clusters(id) ON DELETE CASCADE,
+		region TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("cluster_id"), col("region")}},
+
+	{Name: "rdb_instances", Service: "rdb", Kind: "instances", Scope: "region", ID: []string{"id"}, ProjectScoped: true, Quota: "rdb_instances", Schema: `
+		id TEXT PRIMARY KEY,
+		region TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("region")}},
+	{Name: "rdb_databases", Service: "rdb", Kind: "databases", ID: []string{"instance_id", "name"}, Parents: []string{"rdb_instances"}, Schema: `
+		instance_id TEXT NOT NULL REFERENCES rdb_instances(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		data JSON NOT NULL,
+		PRIMARY KEY (instance_id, name)`,
+		cols: []fixtureCol{col("instance_id"), col("name")}},
+	{Name: "rdb_users", Service: "rdb", Kind: "users", ID: []string{"instance_id", "name"}, Parents: []string{"rdb_instances"}, Schema: `
+		instance_id TEXT NOT NULL REFERENCES rdb_instances(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		data JSON NOT NULL,
+		PRIMARY KEY (instance_id, name)`,
+		cols: []fixtureCol{col("instance_id"), col("name")}},
+	{Name: "rdb_privileges", Service: "rdb", Kind: "privileges", ID: []string{"instance_id", "user_name", "database_name"}, Parents: []string{"rdb_instances"}, Schema: `
+		instance_id TEXT NOT NULL REFERENCES rdb_instances(id) ON DELETE CASCADE,
+		user_name TEXT NOT NULL,
+		database_name TEXT NOT NULL,
+		data JSON NOT NULL,
+		PRIMARY KEY (instance_id, user_name, database_name)`,
+		cols: []fixtureCol{injectCol("instance_id"), col("user_name"), col("database_name")}},
+	{Name: "rdb_read_replicas", Service: "rdb", Kind: "read_replicas", Scope: "region", ID: []string{"id"}, Parents: []string{"rdb_instances"}, Schema: `
+		id TEXT PRIMARY KEY,
+		instance_id TEXT NOT NULL REFERENCES rdb_instances(id) ON DELETE CASCADE,
+		region TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("instance_id"), col("region")}},
+	{Name: "rdb_snapshots", Service: "rdb", Kind: "snapshots", Scope: "region", ID: []string{"id"}, ProjectScoped: true, Parents: []string{"rdb_instances"}, Schema: `
+		id TEXT PRIMARY KEY,
+		instance_id TEXT NOT NULL REFERENCES rdb_instances(id) ON DELETE CASCADE,
+		region TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("instance_id"), col("region")}},
+	{Name: "rdb_backups", Service: "rdb", Kind: "backups", Scope: "region", ID: []string{"id"}, ProjectScoped: true, Parents: []string{"rdb_instances"}, Schema: `
+		id TEXT PRIMARY KEY,
+		instance_id TEXT NOT NULL REFERENCES rdb_instances(id) ON DELETE CASCADE,
+		region TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("instance_id"), col("region")}},
+	{Name: "rdb_acls", Service: "rdb", Kind: "acls", ID: []string{"instance_id"}, Parents: []string{"rdb_instances"}, Schema: `
+		instance_id TEXT PRIMARY KEY REFERENCES rdb_instances(id) ON DELETE CASCADE,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{injectCol("instance_id")}},
+
+	{Name: "redis_clusters", Service: "redis", Kind: "clusters", Scope: "zone", ID: []string{"id"}, ProjectScoped: true, Quota: "redis_clusters", Schema: `
+		id TEXT PRIMARY KEY,
+		zone TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("zone")}},
+	{Name: "registry_namespaces", Service: "registry", Kind: "namespaces", Scope: "region", ID: []string{"id"}, ProjectScoped: true, Quota: "registry_namespaces", Schema: `
+		id TEXT PRIMARY KEY,
+		region TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("region")}},
+
+	{Name: "iam_applications", Service: "iam", Kind: "applications", ID: []string{"id"}, Schema: `
+		id TEXT PRIMARY KEY,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id")}},
+	{Name: "iam_api_keys", Service: "iam", Kind: "api_keys", ID: []string{"access_key"}, Parents: []string{"iam_applications"}, Schema: `
+		access_key TEXT PRIMARY KEY,
+		application_id TEXT REFERENCES iam_applications(id),
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("access_key"), optCol("application_id")}},
+	{Name: "iam_policies", Service: "iam", Kind: "policies", ID: []string{"id"}, Parents: []string{"iam_applications"}, Schema: `
+		id TEXT PRIMARY KEY,
+		application_id TEXT REFERENCES iam_applications(id),
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), optCol("application_id")}},
+	{Name: "iam_rules", Service: "iam", Kind: "rules", ID: []string{"id"}, Parents: []string{"iam_policies"}, Schema: `
+		id TEXT PRIMARY KEY,
+		policy_id TEXT NOT NULL REFERENCES iam_policies(id) ON DELETE CASCADE,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("policy_id")}},
+	{Name: "iam_ssh_keys", Service: "iam", Kind: "ssh_keys", ID: []string{"id"}, ProjectScoped: true, Schema: `
+		id TEXT PRIMARY KEY,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id")}},
+	{Name: "iam_users", Service: "iam", Kind: "users", ID: []string{"id"}, ProjectScoped: true, Schema: `
+		id TEXT PRIMARY KEY,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id")}},
+	{Name: "iam_groups", Service: "iam", Kind: "groups", ID: []string{"id"}, ProjectScoped: true, Schema: `
+		id TEXT PRIMARY KEY,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id")}},
+	{Name: "iam_group_members", Service: "iam", Type: "group_member", ID: []string{"group_id", "user_id"}, Parents: []string{"iam_groups", "iam_users"}, Schema: `
+		group_id TEXT NOT NULL REFERENCES iam_groups(id) ON DELETE CASCADE,
+		user_id TEXT NOT NULL REFERENCES iam_users(id) ON DELETE CASCADE,
+		PRIMARY KEY (group_id, user_id)`},
+
+	{Name: "dns_zones", Service: "domain", Kind: "dns_zones", ID: []string{"dns_zone"}, ProjectScoped: true, Quota: "dns_zones", Schema: `
+		dns_zone TEXT PRIMARY KEY,
+		domain TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{
+			{name: "dns_zone", value: dnsZoneName},
+			col("domain"),
+		}},
+	{Name: "domain_records", Service: "domain", Kind: "records", ID: []string{"id"}, Schema: `
+		id TEXT PRIMARY KEY,
+		dns_zone TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), injectCol("dns_zone")}},
+
+	{Name: "ipam_ips", Service: "ipam", Kind: "ips", Scope: "region", ID: []string{"id"}, ProjectScoped: true, Schema: `
+		id TEXT PRIMARY KEY,
+		region TEXT NOT NULL,
+		data JSON NOT NULL`,
+		cols: []fixtureCol{col("id"), col("region")}},
+
+	{Name: "marketplace_labels", Service: "marketplace", ID: []string{"label"}, Schema: `
+		label TEXT PRIMARY KEY`},
+	{Name: "generic_resources", Service: "generic", Type: "resource", ID: []string{"path"}, ProjectScoped: true, Schema: `
+		path TEXT PRIMARY KEY,
+		collection TEXT NOT NULL,
+		data JSON NOT NULL`},
+
+	{Name: "lifecycle_transitions", ID: []string{"resource_table", "resource_id"}, Schema: `
+		resource_table TEXT NOT NULL,
+		resource_id TEXT NOT NULL,
+		field TEXT NOT NULL,
+		target TEXT NOT NULL,
+		due_at INTEGER NOT NULL,
+		PRIMARY KEY (resource_table, resource_id)`},
+	{Name: "lifecycle_tombstones", ID: []string{"resource_table", "resource_id"}, Schema: `
+		resource_table TEXT NOT NULL,
+		resource_id TEXT NOT NULL,
+		data JSON NOT NULL,
+		due_at INTEGER NOT NULL,
+		PRIMARY KEY (resource_table, resource_id)`},
+	// resource_events is emptied by discardEvents, not Reset.
+	{Name: "resource_events", ID: []string{"seq"}, Keep: true, Schema: `
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		op TEXT NOT NULL,
+		tbl TEXT,
+		cols JSON,
+		data JSON,
+		old_data JSON`},
+	{Name: "schema_versions", ID: []string{"version"}, Keep: true, Schema: `
+		version INTEGER PRIMARY KEY`},
+}
+
+// fixtureTables are the Tables listed under their own kind in the state
+// document, in dependency order.
+var fixtureTables = func() []Table {
+	var out []Table
+	for _, t := range Tables {
+		if t.Kind != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}()
+
+// projectScopedTables lists the ProjectScoped tables.
+var projectScopedTables = func() []string {
+	var out []string
+	for _, t := range Tables {
+		if t.ProjectScoped {
+			out = append(out, t.Name)
+		}
+	}
+	return out
+}()
+
+// quotaTables maps each quota kind to the table whose rows it counts.
+var quotaTables = func() map[string]string {
+	out := map[string]string{}
+	for _, t := range Tables {
+		if t.Quota != "" {
+			out[t.Quota] = t.Name
+		}
+	}
+	return out
+}()
+
+// stateTables lists every table Reset wipes, children before parents.
+var stateTables = func() []string {
+	var out []string
+	for _, t := range slices.Backward(Tables) {
+		if !t.Keep {
+			out = append(out, t.Name)
+		}
+	}
+	return out
+}()
+
+// createStatement is the CREATE TABLE statement of t.
+func (t Table) createStatement() string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s\n)", t.Name, t.Schema)
+}
+
+// TableInfo is a Table with its current row count, as GET /mock/tables
+// reports it.
+type TableInfo struct {
+	Table
+	Rows int `json:"rows"`
+}
+
+// TableInfo describes every table of Tables with its row count.
+func (r *Repository) TableInfo() ([]TableInfo, error) {
+	out := make([]TableInfo, 0, len(Tables))
+	for _, t := range Tables {
+		var n int
+		if err := r.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", t.Name)).Scan(&n); err != nil {
+			return nil, err
+		}
+		t.Type = t.ResourceType()
+		out = append(out, TableInfo{Table: t, Rows: n})
+	}
+	return out, nil
+}
+
+// services lists the services of the state document in Tables order.
+func services() []string {
+	var out []string
+	for _, t := range Tables {
+		if t.Service != "" && !slices.Contains(out, t.Service) {
+			out = append(out, t.Service)
+		}
+	}
+	return out
+}
@@ -0,0 +1,375 @@
+package repository
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PricingTable prices the resources /mock/cost estimates. Compute prices
+// are per hour; storage prices are per GB per month. Names are matched
+// case-insensitively with "_" and "-" treated alike, so a k8s node_type of
+// "dev1_m" finds DEV1-M.
+type PricingTable struct {
+	Currency      string  `json:"currency,omitempty" yaml:"currency"`
+	HoursPerMonth float64 `json:"hours_per_month,omitempty" yaml:"hours_per_month"`
+	// Servers prices instance commercial types and k8s pool node types.
+	Servers map[string]float64 `json:"servers,omitempty" yaml:"servers"`
+	// Volumes prices instance, block and RDB volume types per GB-month.
+	Volumes            map[string]float64 `json:"volumes,omitempty" yaml:"volumes"`
+	PublicIP           float64            `json:"public_ip,omitempty" yaml:"public_ip"`
+	LoadBalancers      map[string]float64 `json:"load_balancers,omitempty" yaml:"load_balancers"`
+	KubernetesClusters map[string]float64 `json:"kubernetes_clusters,omitempty" yaml:"kubernetes_clusters"`
+	RDBNodeTypes       map[string]float64 `json:"rdb_node_types,omitempty" yaml:"rdb_node_types"`
+	RedisNodeTypes     map[string]float64 `json:"redis_node_types,omitempty" yaml:"redis_node_types"`
+	PublicGateways     map[string]float64 `json:"public_gateways,omitempty" yaml:"public_gateways"`
+}
+
+// DefaultPricing is the bundled price list, in euros. Server prices match
+// the /instance/v1/zones/{zone}/products/servers catalog.
+var DefaultPricing = PricingTable{
+	Currency:      "EUR",
+	HoursPerMonth: 730,
+	Servers: map[string]float64{
+		"DEV1-S": 0.018, "DEV1-M": 0.036, "DEV1-L": 0.072, "DEV1-XL": 0.107,
+		"GP1-XS": 0.06, "GP1-S": 0.09, "GP1-M": 0.18, "GP1-L": 0.36, "GP1-XL": 0.72,
+		"PLAY2-PICO": 0.014, "PLAY2-NANO": 0.027, "PLAY2-MICRO": 0.054,
+		"PRO2-XXS": 0.055, "PRO2-XS": 0.11, "PRO2-S": 0.219, "PRO2-M": 0.438, "PRO2-L": 0.877,
+	},
+	Volumes: map[string]float64{
+		// Local volumes are included in the server or database price.
+		"l_ssd": 0, "lssd": 0, "scratch": 0,
+		"b_ssd": 0.08, "bssd": 0.08, "sbs_volume": 0.08, "sbs_5k": 0.08, "sbs_15k": 0.12,
+	},
+	PublicIP: 0.004,
+	LoadBalancers: map[string]float64{
+		"LB-S": 0.014, "LB-GP-M": 0.055, "LB-GP-L": 0.124, "LB-GP-XL": 0.26,
+	},
+	KubernetesClusters: map[string]float64{
+		"kapsule": 0, "multicloud": 0,
+		"kapsule-dedicated-4": 0.089, "kapsule-dedicated-8": 0.178, "kapsule-dedicated-16": 0.356,
+	},
+	RDBNodeTypes: map[string]float64{
+		"DB-DEV-S": 0.015, "DB-DEV-M": 0.03, "DB-DEV-L": 0.06, "DB-GP-XS": 0.19,
+	},
+	RedisNodeTypes: map[string]float64{
+		"RED1-MICRO": 0.018, "RED1-SMALL": 0.031, "RED1-MEDIUM": 0.069,
+	},
+	PublicGateways: map[string]float64{
+		"VPC-GW-S": 0.01, "VPC-GW-M": 0.03,
+	},
+}
+
+// Validate rejects negative prices.
+func (p PricingTable) Validate() error {
+	if p.HoursPerMonth < 0 {
+		return fmt.Errorf("hours_per_month must not be negative")
+	}
+	if p.PublicIP < 0 {
+		return fmt.Errorf("public_ip price must not be negative")
+	}
+	for section, prices := range p.sections() {
+		for name, price := range prices {
+			if price < 0 {
+				return fmt.Errorf("price of %s %q must not be negative", section, name)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *PricingTable) sections() map[string]map[string]float64 {
+	return map[string]map[string]float64{
+		"servers":             p.Servers,
+		"volumes":             p.Volumes,
+		"load_balancers":      p.LoadBalancers,
+		"kubernetes_clusters": p.KubernetesClusters,
+		"rdb_node_types":      p.RDBNodeTypes,
+		"redis_node_types":    p.RedisNodeTypes,
+		"public_gateways":     p.PublicGateways,
+	}
+}
+
+// merged returns DefaultPricing with the prices set in p laid over it.
+func (p PricingTable) merged() PricingTable {
+	out := DefaultPricing
+	if p.Currency != "" {
+		out.Currency = p.Currency
+	}
+	if p.HoursPerMonth > 0 {
+		out.HoursPerMonth = p.HoursPerMonth
+	}
+	if p.PublicIP > 0 {
+		out.PublicIP = p.PublicIP
+	}
+	mergePrices := func(base, over map[string]float64) map[string]float64 {
+		m := make(map[string]float64, len(base)+len(over))
+		for k, v := range base {
+			m[k] = v
+		}
+		for k, v := range over {
+			m[k] = v
+		}
+		return m
+	}
+	out.Servers = mergePrices(out.Servers, p.Servers)
+	out.Volumes = mergePrices(out.Volumes, p.Volumes)
+	out.LoadBalancers = mergePrices(out.LoadBalancers, p.LoadBalancers)
+	out.KubernetesClusters = mergePrices(out.KubernetesClusters, p.KubernetesClusters)
+	out.RDBNodeTypes = mergePrices(out.RDBNodeTypes, p.RDBNodeTypes)
+	out.RedisNodeTypes = mergePrices(out.RedisNodeTypes, p.RedisNodeTypes)
+	out.PublicGateways = mergePrices(out.PublicGateways, p.PublicGateways)
+	return out
+}
+
+// LoadPricing reads pricing overrides from a YAML file.
+func LoadPricing(path string) (PricingTable, error) {
+	var p PricingTable
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return p, fmt.Errorf("read pricing: %w", err)
+	}
+	if err := yaml.Unmarshal(raw, &p); err != nil {
+		return p, fmt.Errorf("parse pricing: %w", err)
+	}
+	return p, p.Validate()
+}
+
+// Pricing returns the price list in effect: DefaultPricing with the
+// overrides given to SetPricing.
+func (r *Repository) Pricing() PricingTable {
+	r.pricingMu.RLock()
+	defer r.pricingMu.RUnlock()
+	return r.pricing.merged()
+}
+
+// SetPricing replaces the pricing overrides. Prices it leaves out keep
+// their DefaultPricing value; the zero value restores the defaults.
+func (r *Repository) SetPricing(overrides PricingTable) error {
+	if err := overrides.Validate(); err != nil {
+		return err
+	}
+	r.pricingMu.Lock()
+	defer r.pricingMu.Unlock()
+	r.pricing = overrides
+	return nil
+}
+
+// CostItem is the price of one resource. Resources the price list does not
+// cover are reported with a Reason and no price.
+type CostItem struct {
+	Service   string  `json:"service"`
+	Type      string  `json:"type"`
+	ID        string  `json:"id"`
+	Name      string  `json:"name,omitempty"`
+	ProjectID string  `json:"project_id"`
+	Item      string  `json:"item"`
+	Hourly    float64 `json:"hourly"`
+	Monthly   float64 `json:"monthly"`
+	Reason    string  `json:"reason,omitempty"`
+}
+
+// CostTotal is an hourly and monthly sum.
+type CostTotal struct {
+	Hourly  float64 `json:"hourly"`
+	Monthly float64 `json:"monthly"`
+}
+
+// CostEstimate is what the stored resources would cost at the prices in
+// effect, in total and per project, service and resource.
+type CostEstimate struct {
+	Currency  string               `json:"currency"`
+	Total     CostTotal            `json:"total"`
+	Projects  map[string]CostTotal `json:"projects"`
+	Services  map[string]CostTotal `json:"services"`
+	Resources []CostItem           `json:"resources"`
+	Unpriced  []CostItem           `json:"unpriced"`
+}
+
+// Cost prices every billable resource in FullState: instance servers by
+// commercial_type, instance and block volumes by size, flexible IPs, load
+// balancers by type, k8s clusters by type and pools by node_type × size,
+// RDB instances and read replicas by node_type (doubled for HA) plus their
+// volume, Redis clusters by node_type × cluster_size and public gateways by
+// type. Everything else is free.
+func (r *Repository) Cost() (*CostEstimate, error) {
+	state, err := r.FullState()
+	if err != nil {
+		return nil, err
+	}
+	p := r.Pricing()
+	est := &CostEstimate{
+		Currency:  p.Currency,
+		Projects:  map[string]CostTotal{},
+		Services:  map[string]CostTotal{},
+		Resources: []CostItem{},
+		Unpriced:  []CostItem{},
+	}
+	items := func(service, key string) []map[string]any {
+		svc, _ := state[service].(map[string]any)
+		list, _ := svc[key].([]map[string]any)
+		return list
+	}
+	rdbNodeTypes := map[string]string{}
+	for _, inst := range items("rdb", "instances") {
+		id, _ := inst["id"].(string)
+		rdbNodeTypes[id], _ = inst["node_type"].(string)
+	}
+
+	for _, ft := range fixtureTables {
+		for _, obj := range items(ft.Service, ft.Kind) {
+			item := CostItem{Service: ft.Service, Type: singular(ft.Kind)}
+			item.ID, _ = obj["id"].(string)
+			item.Name, _ = obj["name"].(string)
+			item.ProjectID, _ = obj["project_id"].(string)
+			if item.ProjectID == "" {
+				item.ProjectID, _ = obj["project"].(string)
+			}
+			if item.ProjectID == "" {
+				item.ProjectID = DefaultProjectID
+			}
+			var hourly float64
+			var ok bool
+			switch ft.Name {
+			case "instance_servers":
+				item.Item = costString(obj["commercial_type"])
+				hourly, ok = lookupPrice(p.Servers, item.Item)
+			case "instance_volumes":
+				hourly, item.Item, ok = p.volumeHourly(costString(obj["volume_type"]), obj["size"])
+			case "block_volumes":
+				size := obj["size"]
+				if from, _ := obj["from_empty"].(map[string]any); from != nil && from["size"] != nil {
+					size = from["size"]
+				}
+				hourly, item.Item, ok = p.volumeHourly(costString(obj["type"]), size)
+			case "instance_ips":
+				item.Item, hourly, ok = "public ip", p.PublicIP, true
+			case "lbs":
+				item.Item = costString(obj["type"])
+				hourly, ok = lookupPrice(p.LoadBalancers, item.Item)
+			case "k8s_clusters":
+				item.Item = costString(obj["type"])
+				if item.Item == "" {
+					item.Item = "kapsule"
+				}
+				hourly, ok = lookupPrice(p.KubernetesClusters, item.Item)
+			case "k8s_pools":
+				nodeType, size := costString(obj["node_type"]), costNumber(obj["size"], 1)
+				item.Item = fmt.Sprintf("%g × %s", size, nodeType)
+				hourly, ok = lookupPrice(p.Servers, nodeType)
+				hourly *= size
+			case "rdb_instances", "rdb_read_replicas":
+				nodeType := costString(obj["node_type"])
+				if ft.Name == "rdb_read_replicas" {
+					nodeType = rdbNodeTypes[costString(obj["instance_id"])]
+				}
+				nodes := 1.0
+				if ha, _ := obj["is_ha_cluster"].(bool); ha {
+					nodes = 2
+				}
+				item.Item = fmt.Sprintf("%g × %s", nodes, nodeType)
+				hourly, ok = lookupPrice(p.RDBNodeTypes, nodeType)
+				hourly *= nodes
+				if vol, _ := obj["volume"].(map[string]any); vol != nil {
+					volHourly, desc, volOK := p.volumeHourly(costString(vol["type"]), vol["size"])
+					hourly += volHourly
+					item.Item += " + " + desc
+					ok = ok && volOK
+				}
+			case "redis_clusters":
+				nodeType, size := costString(obj["node_type"]), costNumber(obj["cluster_size"], 1)
+				item.Item = fmt.Sprintf("%g × %s", size, nodeType)
+				hourly, ok = lookupPrice(p.RedisNodeTypes, nodeType)
+				hourly *= size
+			case "vpc_public_gateways":
+				item.Item = costString(obj["type"])
+				if item.Item == "" {
+					item.Item = "VPC-GW-S"
+				}
+				hourly, ok = lookupPrice(p.PublicGateways, item.Item)
+			default:
+				continue
+			}
+			if !ok {
+				item.Reason = "no price for " + item.Item
+				est.Unpriced = append(est.Unpriced, item)
+				continue
+			}
+			item.Hourly = roundCost(hourly)
+			item.Monthly = roundCost(hourly * p.HoursPerMonth)
+			est.Resources = append(est.Resources, item)
+			for _, t := range []struct {
+				m   map[string]CostTotal
+				key string
+			}{{est.Projects, item.ProjectID}, {est.Services, item.Service}} {
+				sum := t.m[t.key]
+				sum.Hourly += hourly
+				sum.Monthly += hourly * p.HoursPerMonth
+				t.m[t.key] = sum
+			}
+			est.Total.Hourly += hourly
+			est.Total.Monthly += hourly * p.HoursPerMonth
+		}
+	}
+	for _, m := range []map[string]CostTotal{est.Projects, est.Services} {
+		for k, v := range m {
+			m[k] = CostTotal{Hourly: roundCost(v.Hourly), Monthly: roundCost(v.Monthly)}
+		}
+	}
+	est.Total = CostTotal{Hourly: roundCost(est.Total.Hourly), Monthly: roundCost(est.Total.Monthly)}
+	sort.SliceStable(est.Resources, func(i, j int) bool { return est.Resources[i].Monthly > est.Resources[j].Monthly })
+	return est, nil
+}
+
+// volumeHourly prices size bytes of volumeType, describing it as
+// "<GB> GB <type>".
+func (p PricingTable) volumeHourly(volumeType string, size any) (float64, string, bool) {
+	gb := costNumber(size, 0) / 1e9
+	desc := fmt.Sprintf("%g GB %s", gb, volumeType)
+	perGBMonth, ok := lookupPrice(p.Volumes, volumeType)
+	if !ok || p.HoursPerMonth == 0 {
+		return 0, desc, ok
+	}
+	return perGBMonth * gb / p.HoursPerMonth, desc, true
+}
+
+// lookupPrice finds name in prices, ignoring case and "_" versus "-".
+func lookupPrice(prices map[string]float64, name string) (float64, bool) {
+	if name == "" {
+		return 0, false
+	}
+	if price, ok := prices[name]; ok {
+		return price, true
+	}
+	norm := func(s string) string { return strings.ReplaceAll(strings.ToUpper(s), "_", "-") }
+	for k, price := range prices {
+		if norm(k) == norm(name) {
+			return price, true
+		}
+	}
+	return 0, false
+}
+
+func costString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+// costNumber reads a JSON number, def when v is not one.
+func costNumber(v any, def float64) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return def
+}
+
+func roundCost(v float64) float64 { return math.Round(v*1e4) / 1e4 }
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	crand "crypto/rand"
+	"math/big"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeterministicEpoch is the time the deterministic clock starts at.
+var DeterministicEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// entropySource is the single source of every id, secret and timestamp a
+// Repository generates. By default it is random and follows the wall clock;
+// in deterministic mode it is a seeded PRNG and a frozen clock, so the same
+// requests in the same order produce byte-identical state. Each Repository,
+// sandboxes included, has its own.
+type entropySource struct {
+	mu   sync.Mutex
+	rng  *rand.Rand
+	seed int64
+	// frozen is the deterministic clock; zero means the wall clock.
+	frozen time.Time
+	// offset is how far /mock/clock moved time on top of either clock.
+	offset time.Duration
+}
+
+// ClockState describes the clock and the id source.
+type ClockState struct {
+	Now           time.Time `json:"now"`
+	Deterministic bool      `json:"deterministic"`
+	Seed          int64     `json:"seed"`
+}
+
+// SetDeterministic switches id and secret generation to a PRNG
+// seeded with seed and freezes the clock at DeterministicEpoch. Time then
+// only moves through AdvanceClock and SetClock. Sandboxes opened afterwards
+// start from the same seed. To have the default project stamped by the
+// frozen clock as well, open the repository with NewDeterministic.
+func (r *Repository) SetDeterministic(seed int64) {
+	r.entropy.mu.Lock()
+	defer r.entropy.mu.Unlock()
+	r.entropy.seed = seed
+	r.entropy.reseed()
+}
+
+// SetRandom leaves deterministic mode: ids come from crypto/rand again and
+// the clock follows the wall clock.
+func (r *Repository) SetRandom() {
+	r.entropy.mu.Lock()
+	defer r.entropy.mu.Unlock()
+	r.entropy.rng = nil
+	r.entropy.seed = 0
+	r.entropy.frozen = time.Time{}
+	r.entropy.offset = 0
+}
+
+// deterministicSeed returns the seed when deterministic mode is on.
+func (r *Repository) deterministicSeed() (int64, bool) {
+	r.entropy.mu.Lock()
+	defer r.entropy.mu.Unlock()
+	return r.entropy.seed, r.entropy.rng != nil
+}
+
+// reseed restarts the PRNG and the frozen clock. The caller holds mu.
+func (e *entropySource) reseed() {
+	e.rng = rand.New(rand.NewPCG(uint64(e.seed), uint64(e.seed)))
+	e.frozen = DeterministicEpoch
+	e.offset = 0
+}
+
+// restartEntropy rewinds deterministic mode to its seed so a reset
+// repository generates the same sequence as a fresh one. It does nothing
+// otherwise.
+func (r *Repository) restartEntropy() {
+	r.entropy.mu.Lock()
+	defer r.entropy.mu.Unlock()
+	if r.entropy.rng != nil {
+		r.entropy.reseed()
+	}
+}
+
+// Clock reports the current time and whether deterministic mode is on.
+func (r *Repository) Clock() ClockState {
+	r.entropy.mu.Lock()
+	defer r.entropy.mu.Unlock()
+	return ClockState{Now: r.entropy.now(), Deterministic: r.entropy.rng != nil, Seed: r.entropy.seed}
+}
+
+// AdvanceClock moves the clock forward by d. Lifecycle transitions due in
+// the skipped interval settle on the next read.
+func (r *Repository) AdvanceClock(d time.Duration) {
+	r.entropy.mu.Lock()
+	defer r.entropy.mu.Unlock()
+	r.entropy.offset += d
+}
+
+// SetClock moves the clock to t.
+func (r *Repository) SetClock(t time.Time) {
+	r.entropy.mu.Lock()
+	defer r.entropy.mu.Unlock()
+	if !r.entropy.frozen.IsZero() {
+		r.entropy.frozen = t.UTC()
+		r.entropy.offset = 0
+		return
+	}
+	r.entropy.offset = time.Until(t)
+}
+
+func (e *entropySource) now() time.Time {
+	base := e.frozen
+	if base.IsZero() {
+		base = time.Now()
+	}
+	return base.Add(e.offset).UTC()
+}
+
+// Now is the time r stamps on resources.
+func (r *Repository) Now() time.Time {
+	r.entropy.mu.Lock()
+	defer r.entropy.mu.Unlock()
+	return r.entropy.now()
+}
+
+// NewID returns a random version 4 UUID, drawn from the seeded PRNG in
+// deterministic mode.
+func (r *Repository) NewID() string {
+	r.entropy.mu.Lock()
+	defer r.entropy.mu.Unlock()
+	if r.entropy.rng == nil {
+		return uuid.NewString()
+	}
+	var u uuid.UUID
+	for i := 0; i < len(u); i += 8 {
+		v := r.entropy.rng.Uint64()
+		for j := 0; j < 8; j++ {
+			u[i+j] = byte(v >> (8 * j))
+		}
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u.String()
+}
+
+// randIntn returns a number in [0, n).
+func (r *Repository) randIntn(n int) int {
+	r.entropy.mu.Lock()
+	defer r.entropy.mu.Unlock()
+	if r.entropy.rng != nil {
+		return r.entropy.rng.IntN(n)
+	}
+	v, err := crand.Int(crand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0
+	}
+	return int(v.Int64())
+}
+
+// RandFloat64 returns a number in [0, 1), drawn from the seeded PRNG in
+// deterministic mode.
+func (r *Repository) RandFloat64() float64 {
+	r.entropy.mu.Lock()
+	defer r.entropy.mu.Unlock()
+	if r.entropy.rng != nil {
+		return r.entropy.rng.Float64()
+	}
+	return rand.Float64()
+}
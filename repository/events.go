@@ -0,0 +1,440 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types published on the change feed.
+const (
+	EventCreated        = "created"
+	EventUpdated        = "updated"
+	EventDeleted        = "deleted"
+	EventStatusChanged  = "status_changed"
+	EventCascadeDeleted = "cascade_deleted"
+	// EventReset and EventRestored replace the whole state at once; they
+	// carry no resource and are not preceded by per-resource events.
+	EventReset    = "reset"
+	EventRestored = "restored"
+)
+
+// EventTypes lists every event type, in the order they are documented.
+var EventTypes = []string{
+	EventCreated, EventUpdated, EventDeleted, EventStatusChanged, EventCascadeDeleted, EventReset, EventRestored,
+}
+
+// Event is one change to a stored resource. Data is the resource after the
+// change, or its last state for deletes. Cause names the deleted parent
+// whose foreign key took a cascade_deleted resource with it.
+type Event struct {
+	Seq            int64          `json:"seq"`
+	Type           string         `json:"type"`
+	Time           time.Time      `json:"time"`
+	Service        string         `json:"service,omitempty"`
+	ResourceType   string         `json:"resource_type,omitempty"`
+	ID             string         `json:"id,omitempty"`
+	Data           map[string]any `json:"data,omitempty"`
+	PreviousStatus string         `json:"previous_status,omitempty"`
+	Cause          *EventRef      `json:"cause,omitempty"`
+}
+
+// EventRef identifies a resource in an event.
+type EventRef struct {
+	Service      string `json:"service"`
+	ResourceType string `json:"resource_type"`
+	ID           string `json:"id"`
+}
+
+// Changes are captured by SQLite triggers rather than by each Create/Update/
+// Delete method, so foreign key cascades and SET NULLs, which never pass
+// through Go, are seen too. Every trigger appends a row to resource_events
+// and FlushEvents turns the rows into Events. Reset and restores write a
+// single marker row instead.
+
+// eventTable names a watched table in events.
+type eventTable struct {
+	service      string
+	resourceType string
+}
+
+// eventTables covers every table of Tables with a resource type: the state
+// document plus group memberships and generic resources.
+var eventTables = func() map[string]eventTable {
+	out := map[string]eventTable{}
+	for _, t := range Tables {
+		if typ := t.ResourceType(); typ != "" {
+			out[t.Name] = eventTable{t.Service, typ}
+		}
+	}
+	return out
+}()
+
+func singular(key string) string {
+	if s, ok := strings.CutSuffix(key, "ies"); ok {
+		return s + "y"
+	}
+	return strings.TrimSuffix(key, "s")
+}
+
+// foreignKey is one SQL foreign key column.
+type foreignKey struct {
+	column, parent, parentColumn, onDelete string
+}
+
+// eventSchema is what FlushEvents needs to know about a watched table: its
+// key columns and the foreign keys that cascade deletes into it.
+type eventSchema struct {
+	pk  []string
+	fks []foreignKey
+}
+
+// installEventTriggers creates the insert, update and delete triggers of
+// every watched table; the resource_events outbox is in Tables. Triggers
+// are dropped with their table, so this runs after migrations on every init.
+func (r *Repository) installEventTriggers() error {
+	schemas := map[string]eventSchema{}
+	var triggers []string
+	for table := range eventTables {
+		schema, cols, hasData, err := r.eventTableInfo(table)
+		if err != nil {
+			return err
+		}
+		schemas[table] = schema
+		row := func(ref string) (string, string) {
+			pairs := make([]string, 0, 2*len(cols))
+			for _, c := range cols {
+				pairs = append(pairs, "'"+c+"'", ref+"."+c)
+			}
+			obj := "json_object(" + strings.Join(pairs, ", ") + ")"
+			if hasData {
+				return obj, ref + ".data"
+			}
+			return obj, obj
+		}
+		newCols, newData := row("NEW")
+		oldCols, oldData := row("OLD")
+		stmts := []string{
+			fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS events_%[1]s_insert AFTER INSERT ON %[1]s BEGIN
+				INSERT INTO resource_events (op, tbl, cols, data) VALUES ('insert', '%[1]s', %[2]s, %[3]s);
+			END`, table, newCols, newData),
+			fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS events_%[1]s_update AFTER UPDATE ON %[1]s BEGIN
+				INSERT INTO resource_events (op, tbl, cols, data, old_data) VALUES ('update', '%[1]s', %[2]s, %[3]s, %[4]s);
+			END`, table, newCols, newData, oldData),
+			fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS events_%[1]s_delete AFTER DELETE ON %[1]s BEGIN
+				INSERT INTO resource_events (op, tbl, cols, data) VALUES ('delete', '%[1]s', %[2]s, %[3]s);
+			END`, table, oldCols, oldData),
+		}
+		triggers = append(triggers, stmts...)
+	}
+	// One transaction instead of one sync per trigger keeps init fast.
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range triggers {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("init events: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.events.mu.Lock()
+	r.events.schemas = schemas
+	r.events.mu.Unlock()
+	return nil
+}
+
+// eventTableInfo reads the key columns, cascading foreign keys and plain
+// (non-data) columns of table.
+func (r *Repository) eventTableInfo(table string) (eventSchema, []string, bool, error) {
+	var (
+		schema  eventSchema
+		cols    []string
+		hasData bool
+	)
+	rows, err := r.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return schema, nil, false, err
+	}
+	pk := map[int]string{}
+	for rows.Next() {
+		var (
+			cid, notNull, pkIndex int
+			name, typ             string
+			dflt                  sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pkIndex); err != nil {
+			rows.Close()
+			return schema, nil, false, err
+		}
+		if name == "data" {
+			hasData = true
+			continue
+		}
+		cols = append(cols, name)
+		if pkIndex > 0 {
+			pk[pkIndex] = name
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return schema, nil, false, err
+	}
+	for i := 1; i <= len(pk); i++ {
+		schema.pk = append(schema.pk, pk[i])
+	}
+
+	fks, err := r.foreignKeys(table)
+	if err != nil {
+		return schema, nil, false, err
+	}
+	for _, fk := range fks {
+		if fk.onDelete == "CASCADE" {
+			schema.fks = append(schema.fks, fk)
+		}
+	}
+	return schema, cols, hasData, nil
+}
+
+// foreignKeys reads the SQL foreign keys of table.
+func (r *Repository) foreignKeys(table string) ([]foreignKey, error) {
+	rows, err := r.db.Query(fmt.Sprintf("PRAGMA foreign_key_list(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []foreignKey
+	for rows.Next() {
+		var (
+			id, seq                                 int
+			parent, from, to, onUpdate, onDelete, m string
+		)
+		if err := rows.Scan(&id, &seq, &parent, &from, &to, &onUpdate, &onDelete, &m); err != nil {
+			return nil, err
+		}
+		out = append(out, foreignKey{column: from, parent: parent, parentColumn: to, onDelete: onDelete})
+	}
+	return out, rows.Err()
+}
+
+// eventBus fans flushed events out to subscribers.
+type eventBus struct {
+	mu      sync.Mutex
+	seq     int64
+	subs    map[chan Event]struct{}
+	schemas map[string]eventSchema
+}
+
+// EventBuffer is how many unread events a subscriber may fall behind by
+// before it is dropped.
+const EventBuffer = 1024
+
+// SubscribeEvents returns a channel receiving every change made from now
+// on, and a function ending the subscription. A subscriber that falls
+// EventBuffer events behind has its channel closed.
+func (r *Repository) SubscribeEvents() (<-chan Event, func()) {
+	// Changes still queued predate the subscriber; drop them first.
+	_ = r.FlushEvents()
+	ch := make(chan Event, EventBuffer)
+	r.events.mu.Lock()
+	if r.events.subs == nil {
+		r.events.subs = map[chan Event]struct{}{}
+	}
+	r.events.subs[ch] = struct{}{}
+	r.events.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			r.events.mu.Lock()
+			defer r.events.mu.Unlock()
+			if _, ok := r.events.subs[ch]; ok {
+				delete(r.events.subs, ch)
+				close(ch)
+			}
+		})
+	}
+}
+
+// closeSubscribers ends every subscription, e.g. when the repository closes.
+func (r *Repository) closeSubscribers() {
+	r.events.mu.Lock()
+	defer r.events.mu.Unlock()
+	for ch := range r.events.subs {
+		delete(r.events.subs, ch)
+		close(ch)
+	}
+}
+
+// markEvent queues a stateless event such as reset behind any pending ones.
+func (r *Repository) markEvent(typ string) error {
+	_, err := r.db.Exec(`INSERT INTO resource_events (op) VALUES (?)`, typ)
+	return err
+}
+
+// discardEvents drops queued changes that have not been flushed.
+func (r *Repository) discardEvents() error {
+	_, err := r.db.Exec(`DELETE FROM resource_events`)
+	return err
+}
+
+// PollEvents settles lifecycle transitions that have come due and flushes
+// the resulting events. Streams call it periodically so status changes
+// arrive without a client having to read the resource.
+func (r *Repository) PollEvents() error {
+	if err := r.applyLifecycle(); err != nil {
+		return err
+	}
+	return r.FlushEvents()
+}
+
+// eventRow is one queued resource_events row.
+type eventRow struct {
+	op, table          string
+	cols, data, before map[string]any
+}
+
+// FlushEvents publishes every queued change to the subscribers, oldest
+// first, and clears the queue.
+func (r *Repository) FlushEvents() error {
+	r.events.mu.Lock()
+	defer r.events.mu.Unlock()
+	rows, err := r.db.Query(`SELECT seq, op, tbl, cols, data, old_data FROM resource_events ORDER BY seq`)
+	if err != nil {
+		return err
+	}
+	var (
+		last    int64
+		pending []eventRow
+	)
+	for rows.Next() {
+		var (
+			seq                 int64
+			op                  string
+			table               sql.NullString
+			cols, data, oldData []byte
+		)
+		if err := rows.Scan(&seq, &op, &table, &cols, &data, &oldData); err != nil {
+			rows.Close()
+			return err
+		}
+		last = seq
+		if len(r.events.subs) == 0 {
+			continue
+		}
+		row := eventRow{op: op, table: table.String}
+		row.cols, _ = decodeEventJSON(cols)
+		row.data, _ = decodeEventJSON(data)
+		row.before, _ = decodeEventJSON(oldData)
+		pending = append(pending, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if last == 0 {
+		return nil
+	}
+	if _, err := r.db.Exec(`DELETE FROM resource_events WHERE seq <= ?`, last); err != nil {
+		return err
+	}
+	for _, ev := range r.events.build(pending, r.Now()) {
+		r.events.seq++
+		ev.Seq = r.events.seq
+		for ch := range r.events.subs {
+			select {
+			case ch <- ev:
+			default:
+				delete(r.events.subs, ch)
+				close(ch)
+			}
+		}
+	}
+	return nil
+}
+
+func decodeEventJSON(b []byte) (map[string]any, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	return unmarshalData(b)
+}
+
+// build turns queued rows into events. A deleted row whose cascading
+// parent was deleted in the same batch becomes cascade_deleted.
+func (b *eventBus) build(rows []eventRow, now time.Time) []Event {
+	type colKey struct{ table, column, value string }
+	deleted := map[colKey]eventRow{}
+	for _, row := range rows {
+		if row.op != "delete" {
+			continue
+		}
+		for c, v := range row.cols {
+			if s, ok := v.(string); ok {
+				deleted[colKey{row.table, c, s}] = row
+			}
+		}
+	}
+	out := make([]Event, 0, len(rows))
+	for _, row := range rows {
+		if row.table == "" {
+			out = append(out, Event{Type: row.op, Time: now})
+			continue
+		}
+		ev := b.event(row)
+		ev.Time = now
+		switch row.op {
+		case "insert":
+			ev.Type = EventCreated
+		case "update":
+			ev.Type = EventUpdated
+			if prev, next := eventStatus(row.before), eventStatus(row.data); prev != next {
+				ev.Type = EventStatusChanged
+				ev.PreviousStatus = prev
+			}
+		case "delete":
+			ev.Type = EventDeleted
+			for _, fk := range b.schemas[row.table].fks {
+				v, _ := row.cols[fk.column].(string)
+				if parent, ok := deleted[colKey{fk.parent, fk.parentColumn, v}]; ok && v != "" {
+					ev.Type = EventCascadeDeleted
+					p := b.event(parent)
+					ev.Cause = &EventRef{Service: p.Service, ResourceType: p.ResourceType, ID: p.ID}
+					break
+				}
+			}
+		}
+		out = append(out, ev)
+	}
+	return out
+}
+
+// event fills in what identifies row's resource.
+func (b *eventBus) event(row eventRow) Event {
+	t := eventTables[row.table]
+	ev := Event{Service: t.service, ResourceType: t.resourceType, Data: row.data}
+	if id, ok := row.data["id"].(string); ok && id != "" {
+		ev.ID = id
+		return ev
+	}
+	parts := make([]string, 0, len(b.schemas[row.table].pk))
+	for _, c := range b.schemas[row.table].pk {
+		parts = append(parts, fmt.Sprint(row.cols[c]))
+	}
+	ev.ID = strings.Join(parts, "/")
+	return ev
+}
+
+// eventStatus is the lifecycle field of a resource: status, or state for
+// instance servers and NICs.
+func eventStatus(data map[string]any) string {
+	if s, ok := data["status"].(string); ok {
+		return s
+	}
+	s, _ := data["state"].(string)
+	return s
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FilterOp selects how a Filter is matched against the JSON data column.
+type FilterOp int
+
+const (
+	// FilterEquals matches rows whose field equals the single value.
+	FilterEquals FilterOp = iota
+	// FilterContains is a case-insensitive substring match (used for name).
+	FilterContains
+	// FilterHasAll matches rows whose array field contains every value
+	// (used for tags).
+	FilterHasAll
+	// FilterIn matches rows whose field equals any of the values (used for
+	// id lists such as private_network_ids).
+	FilterIn
+)
+
+// Filter narrows a list query on a top-level field of the stored JSON.
+// Values are compared with SQLite JSON1 semantics, so booleans must be
+// passed as bool and numbers as float64.
+type Filter struct {
+	Field  string
+	Op     FilterOp
+	Values []any
+}
+
+func filterArg(v any) any {
+	if b, ok := v.(bool); ok {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	return v
+}
+
+// sql renders the filter as a WHERE fragment plus its arguments.
+func (f Filter) sql() (string, []any, error) {
+	if f.Field == "" || strings.ContainsAny(f.Field, `"'[]$.`) {
+		return "", nil, fmt.Errorf("invalid filter field %q", f.Field)
+	}
+	path := "$." + f.Field
+	switch f.Op {
+	case FilterEquals:
+		if len(f.Values) != 1 {
+			return "", nil, fmt.Errorf("filter %s: expected one value", f.Field)
+		}
+		return "json_extract(data, ?) = ?", []any{path, filterArg(f.Values[0])}, nil
+	case FilterContains:
+		if len(f.Values) != 1 {
+			return "", nil, fmt.Errorf("filter %s: expected one value", f.Field)
+		}
+		needle := fmt.Sprint(f.Values[0])
+		needle = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(needle)
+		return `LOWER(json_extract(data, ?)) LIKE '%' || LOWER(?) || '%' ESCAPE '\'`, []any{path, needle}, nil
+	case FilterHasAll:
+		parts := make([]string, 0, len(f.Values))
+		args := make([]any, 0, 2*len(f.Values))
+		for _, v := range f.Values {
+			parts = append(parts, "EXISTS (SELECT 1 FROM json_each(data, ?) WHERE value = ?)")
+			args = append(args, path, filterArg(v))
+		}
+		if len(parts) == 0 {
+			return "1 = 1", nil, nil
+		}
+		return strings.Join(parts, " AND "), args, nil
+	case FilterIn:
+		if len(f.Values) == 0 {
+			return "1 = 1", nil, nil
+		}
+		args := []any{path}
+		for _, v := range f.Values {
+			args = append(args, filterArg(v))
+		}
+		return fmt.Sprintf("json_extract(data, ?) IN (%s)", strings.TrimSuffix(strings.Repeat("?, ", len(f.Values)), ", ")), args, nil
+	default:
+		return "", nil, fmt.Errorf("filter %s: unknown op %d", f.Field, f.Op)
+	}
+}
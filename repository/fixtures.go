@@ -0,0 +1,516 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/redscaresu/mockway/models"
+)
+
+// The state document is the JSON shape of /mock/state: services at the top,
+// one array of stored objects per resource kind beneath them. FullState and
+// ExportState emit it and ImportState loads it back, so a fixture written by
+// hand ("a VPC and a private network owned by the platform team") and one
+// exported from a running mockway are the same thing.
+
+// ErrInvalidFixture marks a state document that is not shaped like the one
+// /mock/state emits.
+var ErrInvalidFixture = errors.New("invalid fixture")
+
+// FixtureError reports the object of a state document that could not be
+// imported. Err wraps ErrInvalidFixture, models.ErrNotFound (a reference to
+// a row or project that does not exist) or models.ErrConflict (the row
+// already exists).
+type FixtureError struct {
+	Path string
+	Err  error
+}
+
+func (e *FixtureError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *FixtureError) Unwrap() error {
+	return e.Err
+}
+
+// fixtureCol describes how one SQL column of a state table is filled from
+// the imported object.
+type fixtureCol struct {
+	name string
+	// value extracts the column from the object. When nil the key of the
+	// same name is used, falling back to the id of the nested object it
+	// names (lb_id comes from lb.id).
+	value func(map[string]any) string
+	// optional columns are stored as NULL when the object does not set them.
+	optional bool
+	// weak references are stored as NULL when the referenced row does not
+	// exist, the way UpdateServer treats placeholder security groups.
+	weak string
+	// injected columns are not part of the stored object: the export adds
+	// them so the row can be placed again, and the import strips them.
+	injected bool
+}
+
+func col(name string) fixtureCol       { return fixtureCol{name: name} }
+func optCol(name string) fixtureCol    { return fixtureCol{name: name, optional: true} }
+func injectCol(name string) fixtureCol { return fixtureCol{name: name, injected: true} }
+
+// nestedID returns data[key].id, or "" when data[key] is not an object.
+func nestedID(data map[string]any, key string) string {
+	obj, _ := data[key].(map[string]any)
+	id, _ := obj["id"].(string)
+	return id
+}
+
+// dnsZoneName is the zone a dns_zones object is keyed by: subdomain.domain,
+// or the bare domain.
+func dnsZoneName(data map[string]any) string {
+	domain, _ := data["domain"].(string)
+	if sub, _ := data["subdomain"].(string); sub != "" && domain != "" {
+		return sub + "." + domain
+	}
+	return domain
+}
+
+func (c fixtureCol) resolve(data map[string]any) string {
+	if c.value != nil {
+		return c.value(data)
+	}
+	if s, ok := data[c.name].(string); ok {
+		return s
+	}
+	if stem, ok := strings.CutSuffix(c.name, "_id"); ok {
+		return nestedID(data, stem)
+	}
+	return ""
+}
+
+// FullState returns every stored resource as a state document. API key
+// secrets are left out; ExportState includes them.
+func (r *Repository) FullState() (map[string]any, error) {
+	return r.exportState(false, "")
+}
+
+// ExportState returns the complete state document, API key secrets
+// included. Importing it into an empty mockway with ImportState reproduces
+// the current state exactly.
+func (r *Repository) ExportState() (map[string]any, error) {
+	return r.exportState(true, "")
+}
+
+// exportState builds the state document, or only its service part when
+// service is set.
+func (r *Repository) exportState(secrets bool, service string) (map[string]any, error) {
+	if err := r.applyLifecycle(); err != nil {
+		return nil, err
+	}
+	members, err := r.groupMembers()
+	if err != nil {
+		return nil, err
+	}
+	state := map[string]any{}
+	for _, ft := range fixtureTables {
+		if service != "" && ft.Service != service {
+			continue
+		}
+		items, err := r.exportTable(ft)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			switch ft.Name {
+			case "iam_api_keys":
+				if !secrets {
+					delete(item, "secret_key")
+				}
+			case "iam_groups":
+				id, _ := item["id"].(string)
+				userIDs := members[id]
+				if userIDs == nil {
+					userIDs = []any{}
+				}
+				item["user_ids"] = userIDs
+			}
+		}
+		svc, _ := state[ft.Service].(map[string]any)
+		if svc == nil {
+			svc = map[string]any{}
+			state[ft.Service] = svc
+		}
+		svc[ft.Kind] = items
+	}
+	if service == "" || service == "marketplace" {
+		labels, err := r.ListMarketplaceLabels()
+		if err != nil {
+			return nil, err
+		}
+		if labels == nil {
+			labels = []string{}
+		}
+		state["marketplace"] = map[string]any{"labels": labels}
+	}
+	if service == "" || service == "generic" {
+		generic, err := r.genericState()
+		if err != nil {
+			return nil, err
+		}
+		state["generic"] = generic
+	}
+	return state, nil
+}
+
+// exportTable reads every row of ft in insertion order, adding its injected
+// columns to the object.
+func (r *Repository) exportTable(ft Table) ([]map[string]any, error) {
+	var injected []string
+	for _, c := range ft.cols {
+		if c.injected {
+			injected = append(injected, c.name)
+		}
+	}
+	q := fmt.Sprintf("SELECT %s FROM %s ORDER BY rowid", strings.Join(append(injected, "data"), ", "), ft.Name)
+	rows, err := r.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []map[string]any{}
+	for rows.Next() {
+		vals := make([]string, len(injected))
+		dest := make([]any, 0, len(injected)+1)
+		for i := range vals {
+			dest = append(dest, &vals[i])
+		}
+		var raw []byte
+		dest = append(dest, &raw)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		item, err := unmarshalData(raw)
+		if err != nil {
+			return nil, err
+		}
+		for i, name := range injected {
+			item[name] = vals[i]
+		}
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+// groupMembers maps each IAM group to its member user ids.
+func (r *Repository) groupMembers() (map[string][]any, error) {
+	rows, err := r.db.Query(`SELECT group_id, user_id FROM iam_group_members ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string][]any{}
+	for rows.Next() {
+		var groupID, userID string
+		if err := rows.Scan(&groupID, &userID); err != nil {
+			return nil, err
+		}
+		out[groupID] = append(out[groupID], userID)
+	}
+	return out, rows.Err()
+}
+
+// LoadState reads a state document from a JSON file, e.g. one saved from
+// GET /mock/export.
+func LoadState(path string) (map[string]any, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read state %s: %w", path, err)
+	}
+	state := map[string]any{}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("parse state %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// ImportState inserts every object of a state document in dependency order,
+// inside one transaction: either the whole document is loaded or nothing
+// is. References between objects and to projects must resolve, either
+// within the document or to rows that already exist. With replace the
+// current state is wiped first, so the result matches the document exactly.
+// Objects are stored as given; no defaults are filled in and quotas are not
+// applied. It returns the number of rows inserted per table.
+func (r *Repository) ImportState(state map[string]any, replace bool) (map[string]int, error) {
+	if err := checkStateShape(state); err != nil {
+		return nil, err
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if replace {
+		for _, t := range stateTables {
+			if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s", t)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	counts := map[string]int{}
+	for _, ft := range fixtureTables {
+		svc, _ := state[ft.Service].(map[string]any)
+		items, err := fixtureItems(svc, ft.Service+"."+ft.Kind, ft.Kind)
+		if err != nil {
+			return nil, err
+		}
+		for i, item := range items {
+			path := fmt.Sprintf("%s.%s[%d]", ft.Service, ft.Kind, i)
+			if err := importRow(tx, ft, item); err != nil {
+				return nil, &FixtureError{Path: path, Err: err}
+			}
+			counts[ft.Name]++
+		}
+	}
+
+	if n, err := importMarketplaceLabels(tx, state); err != nil {
+		return nil, err
+	} else if n > 0 {
+		counts["marketplace_labels"] = n
+	}
+	if n, err := importGeneric(tx, state); err != nil {
+		return nil, err
+	} else if n > 0 {
+		counts["generic_resources"] = n
+	}
+
+	if err := r.seedDefaultProjectOn(tx); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// checkStateShape rejects services and resource kinds the state document
+// does not have, so a typo is an error instead of a silently empty import.
+func checkStateShape(state map[string]any) error {
+	known := map[string]map[string]bool{
+		"marketplace": {"labels": true},
+	}
+	for _, ft := range fixtureTables {
+		if known[ft.Service] == nil {
+			known[ft.Service] = map[string]bool{}
+		}
+		known[ft.Service][ft.Kind] = true
+	}
+	services := make([]string, 0, len(state))
+	for svc := range state {
+		services = append(services, svc)
+	}
+	sort.Strings(services)
+	for _, svc := range services {
+		if svc == "generic" {
+			continue
+		}
+		keys, ok := known[svc]
+		if !ok {
+			return &FixtureError{Path: svc, Err: fmt.Errorf("%w: unknown service", ErrInvalidFixture)}
+		}
+		body, ok := state[svc].(map[string]any)
+		if !ok {
+			return &FixtureError{Path: svc, Err: fmt.Errorf("%w: expected an object", ErrInvalidFixture)}
+		}
+		for key := range body {
+			if !keys[key] {
+				return &FixtureError{Path: svc + "." + key, Err: fmt.Errorf("%w: unknown resource kind", ErrInvalidFixture)}
+			}
+		}
+	}
+	return nil
+}
+
+// fixtureItems returns the objects listed under key, which may be absent.
+func fixtureItems(svc map[string]any, path, key string) ([]map[string]any, error) {
+	raw, ok := svc[key]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	list, ok := raw.([]any)
+	if !ok {
+		return nil, &FixtureError{Path: path, Err: fmt.Errorf("%w: expected an array", ErrInvalidFixture)}
+	}
+	out := make([]map[string]any, 0, len(list))
+	for i, v := range list {
+		item, ok := v.(map[string]any)
+		if !ok {
+			return nil, &FixtureError{Path: fmt.Sprintf("%s[%d]", path, i), Err: fmt.Errorf("%w: expected an object", ErrInvalidFixture)}
+		}
+		out = append(out, item)
+	}
+	return out, nil
+}
+
+// importRow inserts one object into ft's table.
+func importRow(tx querier, ft Table, item map[string]any) error {
+	data := cloneMap(item)
+	names := make([]string, 0, len(ft.cols)+1)
+	args := make([]any, 0, len(ft.cols)+1)
+	for _, c := range ft.cols {
+		v := c.resolve(data)
+		if c.injected {
+			delete(data, c.name)
+		}
+		var arg any = v
+		switch {
+		case v == "" && c.optional:
+			arg = nil
+		case v == "":
+			return fmt.Errorf("%w: missing %s", ErrInvalidFixture, c.name)
+		case c.weak != "":
+			ok, err := rowExists(tx, c.weak, "id", v)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				arg = nil
+			}
+		}
+		names = append(names, c.name)
+		args = append(args, arg)
+	}
+
+	if ft.Name != "account_projects" {
+		if err := checkProjectRefTx(tx, data); err != nil {
+			return err
+		}
+	}
+	var userIDs []any
+	if ft.Name == "iam_groups" {
+		userIDs, _ = data["user_ids"].([]any)
+	}
+
+	b, err := marshalData(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidFixture, err)
+	}
+	names = append(names, "data")
+	args = append(args, b)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	q := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", ft.Name, strings.Join(names, ", "), placeholders)
+	if ft.Name == "account_projects" && args[0] == DefaultProjectID {
+		// The default project always exists; the document may restate it.
+		q += " ON CONFLICT(id) DO UPDATE SET organization_id = excluded.organization_id, data = excluded.data"
+	}
+	if _, err := tx.Exec(q, args...); err != nil {
+		return mapInsertSQLError(err)
+	}
+
+	for _, raw := range userIDs {
+		userID, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("%w: user_ids must be strings", ErrInvalidFixture)
+		}
+		if _, err := tx.Exec(`INSERT INTO iam_group_members (group_id, user_id) VALUES (?, ?)`, args[0], userID); err != nil {
+			return mapInsertSQLError(err)
+		}
+	}
+	return nil
+}
+
+// checkProjectRefTx is checkProjectRef for use inside a transaction.
+func checkProjectRefTx(tx querier, data map[string]any) error {
+	for _, key := range []string{"project_id", "project"} {
+		id, _ := data[key].(string)
+		if id == "" {
+			continue
+		}
+		ok, err := rowExists(tx, "account_projects", "id", id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return models.ErrNotFound
+		}
+	}
+	return nil
+}
+
+func rowExists(tx querier, table, idColumn, id string) (bool, error) {
+	var one int
+	err := tx.QueryRow(fmt.Sprintf("SELECT 1 FROM %s WHERE %s = ? LIMIT 1", table, idColumn), id).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func importMarketplaceLabels(tx querier, state map[string]any) (int, error) {
+	svc, _ := state["marketplace"].(map[string]any)
+	raw, _ := svc["labels"].([]any)
+	if svc != nil && svc["labels"] != nil && raw == nil {
+		return 0, &FixtureError{Path: "marketplace.labels", Err: fmt.Errorf("%w: expected an array", ErrInvalidFixture)}
+	}
+	for i, v := range raw {
+		label, ok := v.(string)
+		if !ok {
+			return 0, &FixtureError{Path: fmt.Sprintf("marketplace.labels[%d]", i), Err: fmt.Errorf("%w: expected a string", ErrInvalidFixture)}
+		}
+		if _, err := tx.Exec(`INSERT INTO marketplace_labels (label) VALUES (?)`, label); err != nil {
+			return 0, &FixtureError{Path: fmt.Sprintf("marketplace.labels[%d]", i), Err: mapInsertSQLError(err)}
+		}
+	}
+	return len(raw), nil
+}
+
+// importGeneric loads generic CRUD objects, grouped by collection path as
+// genericState emits them.
+func importGeneric(tx querier, state map[string]any) (int, error) {
+	raw, ok := state["generic"]
+	if !ok || raw == nil {
+		return 0, nil
+	}
+	collections, ok := raw.(map[string]any)
+	if !ok {
+		return 0, &FixtureError{Path: "generic", Err: fmt.Errorf("%w: expected an object", ErrInvalidFixture)}
+	}
+	names := make([]string, 0, len(collections))
+	for name := range collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	n := 0
+	for _, collection := range names {
+		items, err := fixtureItems(collections, "generic."+collection, collection)
+		if err != nil {
+			return 0, err
+		}
+		for i, item := range items {
+			path := fmt.Sprintf("generic.%s[%d]", collection, i)
+			id, _ := item["id"].(string)
+			if id == "" {
+				return 0, &FixtureError{Path: path, Err: fmt.Errorf("%w: missing id", ErrInvalidFixture)}
+			}
+			if err := checkProjectRefTx(tx, item); err != nil {
+				return 0, &FixtureError{Path: path, Err: err}
+			}
+			b, err := marshalData(item)
+			if err != nil {
+				return 0, &FixtureError{Path: path, Err: fmt.Errorf("%w: %v", ErrInvalidFixture, err)}
+			}
+			if _, err := tx.Exec(
+				`INSERT INTO generic_resources (path, collection, data) VALUES (?, ?, ?)`,
+				collection+"/"+id, collection, b,
+			); err != nil {
+				return 0, &FixtureError{Path: path, Err: mapInsertSQLError(err)}
+			}
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/redscaresu/mockway/models"
+)
+
+// Generic resources back the spec-driven CRUD fallback: operations the specs
+// declare but no hand-written handler implements. Each object is keyed by its
+// concrete URL path (e.g. /instance/v1/zones/fr-par-1/placement_groups/<id>)
+// and grouped by the collection path it was created under, so list, get and
+// delete need no per-resource schema.
+
+// CreateGenericResource stores data under collection + "/" + id.
+func (r *Repository) CreateGenericResource(collection, id string, data map[string]any) error {
+	return r.insertJSON("generic_resources", []colVal{
+		{name: "path", val: collection + "/" + id},
+		{name: "collection", val: collection},
+	}, data)
+}
+
+// GetGenericResource returns the object stored at path.
+func (r *Repository) GetGenericResource(path string) (map[string]any, error) {
+	return r.getJSONByID("generic_resources", "path", path)
+}
+
+// ListGenericResources returns the objects created under collection, oldest
+// first.
+func (r *Repository) ListGenericResources(collection string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("generic_resources", "collection", collection, filters...)
+}
+
+// UpdateGenericResource replaces the object stored at path.
+func (r *Repository) UpdateGenericResource(path string, data map[string]any) (map[string]any, error) {
+	if err := r.updateJSONByID("generic_resources", "path", path, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// DeleteGenericResource removes the object at path together with everything
+// created beneath it (its sub-collections).
+func (r *Repository) DeleteGenericResource(path string) error {
+	res, err := r.db.Exec(`DELETE FROM generic_resources WHERE path = ?`, path)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.ErrNotFound
+	}
+	_, err = r.db.Exec(`DELETE FROM generic_resources WHERE substr(path, 1, ?) = ?`, len(path)+1, path+"/")
+	return err
+}
+
+// genericState groups every generic object by collection path for
+// /mock/state.
+func (r *Repository) genericState() (map[string]any, error) {
+	rows, err := r.db.Query(`SELECT collection, data FROM generic_resources ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]any{}
+	for rows.Next() {
+		var (
+			collection string
+			raw        []byte
+		)
+		if err := rows.Scan(&collection, &raw); err != nil {
+			return nil, err
+		}
+		v, err := unmarshalData(raw)
+		if err != nil {
+			return nil, err
+		}
+		items, _ := out[collection].([]any)
+		out[collection] = append(items, v)
+	}
+	return out, rows.Err()
+}
+
+// GenericAncestor returns the nearest stored object whose path is path or
+// one of its prefixes. Actions such as POST .../widgets/{id}/reboot use it to
+// echo the object they act on.
+func (r *Repository) GenericAncestor(path string) (map[string]any, error) {
+	for p := path; strings.Count(p, "/") > 1; p = p[:strings.LastIndex(p, "/")] {
+		obj, err := r.GetGenericResource(p)
+		if err == nil {
+			return obj, nil
+		}
+		if !errors.Is(err, models.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return nil, models.ErrNotFound
+}
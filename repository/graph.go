@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Graph is the stored state as resources and the references between them.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode is one stored resource. Key is service/type/id and is unique
+// across the graph.
+type GraphNode struct {
+	Key       string `json:"key"`
+	Service   string `json:"service"`
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	Name      string `json:"name,omitempty"`
+	ProjectID string `json:"project_id,omitempty"`
+}
+
+// GraphEdge is a reference from one resource to another. Label is the
+// column or JSON path holding the reference.
+type GraphEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Label string `json:"label"`
+}
+
+// graphJoins are tables whose rows only link two resources. They become
+// edges rather than nodes.
+var graphJoins = map[string]struct {
+	from, fromTable, to, toTable, label string
+}{
+	"lb_private_networks": {"lb_id", "lbs", "private_network_id", "private_networks", "private_network"},
+	"iam_group_members":   {"group_id", "iam_groups", "user_id", "iam_users", "member"},
+}
+
+// Graph returns every stored resource and every reference between them:
+// SQL foreign keys, join tables and References.
+// References to resources that do not exist are left out. Projects are not
+// nodes; each node carries its project_id instead.
+func (r *Repository) Graph() (*Graph, error) {
+	if err := r.applyLifecycle(); err != nil {
+		return nil, err
+	}
+	g := &Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	rows := map[string][]tableRow{}
+	keys := map[string][]string{} // table -> node key per row
+	var tables []string
+	for _, t := range Tables {
+		if t.ResourceType() != "" && t.Service != "account" && t.Service != "generic" {
+			tables = append(tables, t.Name)
+		}
+	}
+
+	for _, table := range tables {
+		tableRows, err := scanTableRows(r.db, table, "")
+		if err != nil {
+			return nil, err
+		}
+		rows[table] = tableRows
+		if _, ok := graphJoins[table]; ok {
+			continue
+		}
+		t := eventTables[table]
+		for _, row := range tableRows {
+			id := row.key()
+			node := GraphNode{Key: t.service + "/" + t.resourceType + "/" + id, Service: t.service, Type: t.resourceType, ID: id}
+			node.Name, _ = row.data["name"].(string)
+			node.ProjectID, _ = row.data["project_id"].(string)
+			keys[table] = append(keys[table], node.Key)
+			g.Nodes = append(g.Nodes, node)
+		}
+	}
+
+	// lookup finds the node of table whose field (a column, or a top-level
+	// string in data) equals value.
+	index := map[string]map[string]string{}
+	lookup := func(table, field, value string) (string, bool) {
+		k := table + "." + field
+		idx, ok := index[k]
+		if !ok {
+			idx = map[string]string{}
+			for i, row := range rows[table] {
+				if v := row.field(field); v != "" && i < len(keys[table]) {
+					idx[v] = keys[table][i]
+				}
+			}
+			index[k] = idx
+		}
+		key, ok := idx[value]
+		return key, ok
+	}
+	// A reference found in several places (lbs ip_id and ip[].id, or lbs
+	// ip_id and lb_ips lb_id) is one edge, labelled with the first in
+	// References order.
+	seen := map[[2]string]bool{}
+	addEdge := func(from, to, label string) {
+		if from == to || seen[[2]string{from, to}] || seen[[2]string{to, from}] {
+			return
+		}
+		seen[[2]string{from, to}] = true
+		g.Edges = append(g.Edges, GraphEdge{From: from, To: to, Label: label})
+	}
+
+	for _, table := range tables {
+		if join, ok := graphJoins[table]; ok {
+			for _, row := range rows[table] {
+				from, ok1 := lookup(join.fromTable, "id", row.cols[join.from])
+				to, ok2 := lookup(join.toTable, "id", row.cols[join.to])
+				if ok1 && ok2 {
+					addEdge(from, to, join.label)
+				}
+			}
+			continue
+		}
+		fks, err := r.foreignKeys(table)
+		if err != nil {
+			return nil, err
+		}
+		for i, row := range rows[table] {
+			for _, fk := range fks {
+				if to, ok := lookup(fk.parent, fk.parentColumn, row.cols[fk.column]); ok {
+					addEdge(keys[table][i], to, fk.column)
+				}
+			}
+		}
+	}
+	for _, ref := range References {
+		if _, ok := graphJoins[ref.Table]; ok {
+			continue
+		}
+		label := ref.Path
+		if label == "" {
+			label = ref.Column
+		}
+		for i, row := range rows[ref.Table] {
+			for _, v := range ref.values(row) {
+				if to, ok := lookup(ref.Target, ref.field(), v); ok {
+					addEdge(keys[ref.Table][i], to, label)
+				}
+			}
+		}
+	}
+	return g, nil
+}
+
+// WriteDOT writes the graph in Graphviz DOT, one cluster per project.
+func (g *Graph) WriteDOT(w io.Writer) {
+	fmt.Fprintln(w, "digraph mockway {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	fmt.Fprintln(w, "  node [shape=box];")
+	for i, project := range g.projects() {
+		indent := "  "
+		if project != "" {
+			fmt.Fprintf(w, "  subgraph cluster_%d {\n    label=%q;\n", i, "project "+project)
+			indent = "    "
+		}
+		for _, n := range g.Nodes {
+			if n.ProjectID == project {
+				fmt.Fprintf(w, "%s%q [label=%q];\n", indent, n.Key, n.label())
+			}
+		}
+		if project != "" {
+			fmt.Fprintln(w, "  }")
+		}
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "  %q -> %q [label=%q];\n", e.From, e.To, e.Label)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart, one subgraph per
+// project.
+func (g *Graph) WriteMermaid(w io.Writer) {
+	ids := make(map[string]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		ids[n.Key] = fmt.Sprintf("n%d", i)
+	}
+	fmt.Fprintln(w, "flowchart LR")
+	for i, project := range g.projects() {
+		indent := "  "
+		if project != "" {
+			fmt.Fprintf(w, "  subgraph p%d [\"project %s\"]\n", i, project)
+			indent = "    "
+		}
+		for _, n := range g.Nodes {
+			if n.ProjectID == project {
+				fmt.Fprintf(w, "%s%s[\"%s\"]\n", indent, ids[n.Key], mermaidEscape(n.label()))
+			}
+		}
+		if project != "" {
+			fmt.Fprintln(w, "  end")
+		}
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "  %s -->|%s| %s\n", ids[e.From], mermaidEscape(e.Label), ids[e.To])
+	}
+}
+
+// projects lists the project ids of the nodes in order of appearance;
+// "" (no project) comes first when present.
+func (g *Graph) projects() []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, n := range g.Nodes {
+		if !seen[n.ProjectID] {
+			seen[n.ProjectID] = true
+			out = append(out, n.ProjectID)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i] == "" && out[j] != "" })
+	return out
+}
+
+func (n GraphNode) label() string {
+	label := n.Service + " " + n.Type
+	if n.Name != "" {
+		return label + "\n" + n.Name
+	}
+	return label + "\n" + n.ID
+}
+
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "\n", "<br/>", "|", "#124;")
+
+func mermaidEscape(s string) string { return mermaidEscaper.Replace(s) }
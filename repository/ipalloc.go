@@ -0,0 +1,405 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/redscaresu/mockway/models"
+)
+
+// DefaultPublicIPPool is the range public addresses come from in zones
+// without a pool of their own.
+const DefaultPublicIPPool = "51.15.0.0/16"
+
+// IPPoolConfig sets the IPv4 range public addresses are drawn from. Zones
+// entries override Default for that zone; regional resources (RDB, IPAM)
+// use the pool of the region's first zone. The zero value means
+// DefaultPublicIPPool everywhere.
+type IPPoolConfig struct {
+	Default string            `json:"default"`
+	Zones   map[string]string `json:"zones"`
+}
+
+// Validate rejects pools that are not IPv4 CIDRs.
+func (c IPPoolConfig) Validate() error {
+	check := func(name, cidr string) error {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil || !p.Addr().Is4() {
+			return fmt.Errorf("invalid IP pool %q for %s: expected an IPv4 CIDR such as 51.15.0.0/16", cidr, name)
+		}
+		if p.Bits() > 30 {
+			return fmt.Errorf("IP pool %q for %s is too small: use /30 or larger", cidr, name)
+		}
+		return nil
+	}
+	if c.Default != "" {
+		if err := check("default", c.Default); err != nil {
+			return err
+		}
+	}
+	for zone, cidr := range c.Zones {
+		if err := check(zone, cidr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pool returns the range public addresses in zone come from.
+func (c IPPoolConfig) pool(zone string) netip.Prefix {
+	cidr := c.Zones[zone]
+	if cidr == "" {
+		cidr = c.Default
+	}
+	if cidr == "" {
+		cidr = DefaultPublicIPPool
+	}
+	return netip.MustParsePrefix(cidr).Masked()
+}
+
+// ParseIPPools parses the --ip-pools flag: comma-separated zone=cidr pairs,
+// where the zone "default" sets the fallback pool.
+func ParseIPPools(s string) (IPPoolConfig, error) {
+	cfg := IPPoolConfig{Zones: map[string]string{}}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		zone, cidr, ok := strings.Cut(part, "=")
+		if !ok {
+			return IPPoolConfig{}, fmt.Errorf("invalid IP pool %q: expected zone=cidr", part)
+		}
+		zone, cidr = strings.TrimSpace(zone), strings.TrimSpace(cidr)
+		if zone == "default" {
+			cfg.Default = cidr
+		} else {
+			cfg.Zones[zone] = cidr
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// IPPools returns the public pools currently in effect.
+func (r *Repository) IPPools() IPPoolConfig {
+	r.ipPoolMu.RLock()
+	defer r.ipPoolMu.RUnlock()
+	out := IPPoolConfig{Default: r.ipPools.Default, Zones: map[string]string{}}
+	if out.Default == "" {
+		out.Default = DefaultPublicIPPool
+	}
+	for zone, cidr := range r.ipPools.Zones {
+		out.Zones[zone] = cidr
+	}
+	return out
+}
+
+// SetIPPools replaces the public pools. Addresses already handed out are
+// kept even if they fall outside the new ranges.
+func (r *Repository) SetIPPools(cfg IPPoolConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	zones := make(map[string]string, len(cfg.Zones))
+	for zone, cidr := range cfg.Zones {
+		zones[zone] = cidr
+	}
+	r.ipPoolMu.Lock()
+	defer r.ipPoolMu.Unlock()
+	r.ipPools = IPPoolConfig{Default: cfg.Default, Zones: zones}
+	return nil
+}
+
+// OutOfStockError reports that an address range has no free address left.
+type OutOfStockError struct {
+	Pool string
+}
+
+func (e *OutOfStockError) Error() string {
+	return fmt.Sprintf("no free IP address left in %s", e.Pool)
+}
+
+func (e *OutOfStockError) Unwrap() error { return models.ErrOutOfStock }
+
+// usedAddressesSQL selects every stored address booked in the private
+// network ?1, or every public one when ?1 is "". Addresses are derived from
+// the rows themselves, so deleting a resource (directly or by cascade)
+// releases its addresses with no bookkeeping of its own. Each branch reads
+// only the rows that can hold such an address, and the JSON is walked by
+// SQLite rather than decoded row by row.
+var usedAddressesSQL = strings.Join([]string{
+	`SELECT a.value FROM instance_ips t, json_each(t.data, '$.address') a WHERE ?1 = ''`,
+	`SELECT a.value FROM lb_ips t, json_each(t.data, '$.ip_address') a WHERE ?1 = ''`,
+	`SELECT a.value FROM lbs t, json_each(t.data, '$.ip') ip, json_each(t.data, ip.fullkey || '.ip_address') a WHERE ?1 = ''`,
+	`SELECT a.value FROM instance_private_nics t, json_each(t.data, '$.private_ips') ip, json_each(t.data, ip.fullkey || '.address') a WHERE t.private_network_id = ?1`,
+	`SELECT a.value FROM lb_private_networks t, json_each(t.data, '$.ip_address') a WHERE t.private_network_id = ?1`,
+	endpointAddressesSQL("rdb_instances"),
+	endpointAddressesSQL("rdb_read_replicas"),
+	endpointAddressesSQL("redis_clusters"),
+	// An IPAM IP names its private network directly or through one of its
+	// subnets; a subnet no private network owns leaves it public.
+	`SELECT a.value FROM ipam_ips t, json_each(t.data, '$.address') a WHERE CASE
+		WHEN COALESCE(json_extract(t.data, '$.source.private_network_id'), '') != '' THEN json_extract(t.data, '$.source.private_network_id') = ?1
+		WHEN ?1 = '' THEN COALESCE(json_extract(t.data, '$.source.subnet_id'), '') NOT IN (` + subnetIDsSQL + `)
+		ELSE json_extract(t.data, '$.source.subnet_id') IN (` + subnetIDsSQL + ` WHERE p.id = ?1)
+	END`,
+}, "\nUNION ALL\n")
+
+// subnetIDsSQL selects the subnet ids of the private networks p.
+const subnetIDsSQL = `SELECT json_extract(p.data, s.fullkey || '.id') FROM private_networks p, json_each(p.data, '$.subnets') s`
+
+// endpointAddressesSQL selects the addresses of the RDB or Redis endpoints
+// of table, which carry a single "ip" (RDB) or an "ips" list (Redis), and
+// are private when they name a private network.
+func endpointAddressesSQL(table string) string {
+	const pn = `COALESCE(NULLIF(json_extract(t.data, ep.fullkey || '.private_network.id'), ''), json_extract(t.data, ep.fullkey || '.private_network.private_network_id'), '')`
+	return fmt.Sprintf(`SELECT a.value FROM %s t, json_each(t.data, '$.endpoints') ep, json_each(t.data, ep.fullkey || k.path) a,
+		(SELECT '.ip' AS path UNION ALL SELECT '.ips') k
+		WHERE %s = ?1`, table, pn)
+}
+
+func anySlice(v any) []any {
+	s, _ := v.([]any)
+	return s
+}
+
+// endpointPrivateNetwork returns the private network an RDB or Redis
+// endpoint is attached to, or "" for public endpoints.
+func endpointPrivateNetwork(ep map[string]any) string {
+	pn, ok := ep["private_network"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	if id, _ := pn["id"].(string); id != "" {
+		return id
+	}
+	id, _ := pn["private_network_id"].(string)
+	return id
+}
+
+// ipamPrivateNetwork returns the private network an IPAM IP is booked in,
+// resolving source.subnet_id to the private network that owns the subnet,
+// or "" for public ones.
+func (r *Repository) ipamPrivateNetwork(data map[string]any) (string, error) {
+	source, _ := data["source"].(map[string]any)
+	if id, _ := source["private_network_id"].(string); id != "" {
+		return id, nil
+	}
+	subnetID, _ := source["subnet_id"].(string)
+	if subnetID == "" {
+		return "", nil
+	}
+	var id string
+	err := r.db.QueryRow(`SELECT p.id FROM private_networks p, json_each(p.data, '$.subnets') s
+		WHERE json_extract(p.data, s.fullkey || '.id') = ? LIMIT 1`, subnetID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return id, err
+}
+
+// lockIPs serialises address allocation with the insert that stores it,
+// and returns the unlock. Outside Atomic the read of the used addresses
+// and the insert commit separately, so two callers could otherwise pick
+// the same address. Inside Atomic it does nothing: a transaction that
+// allocated from a snapshot another one has since written to has its
+// insert refused and is run again, and holding a process-wide lock while
+// it waits for the write lock would only stall the others.
+func (r *Repository) lockIPs() func() {
+	if r.db.tx != nil {
+		return func() {}
+	}
+	r.ipMu.Lock()
+	return r.ipMu.Unlock
+}
+
+// ipAllocator hands out addresses for one create call. It reads the used
+// addresses of a private network (or the public ones) the first time it
+// allocates there, and remembers what it handed out, so a resource with
+// several endpoints never gets the same address twice before its row is
+// written. Callers hold lockIPs from newIPAllocator until the row is
+// stored.
+type ipAllocator struct {
+	r     *Repository
+	pools IPPoolConfig
+	used  map[string]map[netip.Addr]bool
+}
+
+func (r *Repository) newIPAllocator() *ipAllocator {
+	return &ipAllocator{r: r, pools: r.IPPools(), used: map[string]map[netip.Addr]bool{}}
+}
+
+// load reads the addresses used in pnID, "" meaning the public ones.
+func (a *ipAllocator) load(pnID string) error {
+	if a.used[pnID] != nil {
+		return nil
+	}
+	rows, err := a.r.db.Query(usedAddressesSQL, pnID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	used := map[netip.Addr]bool{}
+	for rows.Next() {
+		var v any
+		if err := rows.Scan(&v); err != nil {
+			return err
+		}
+		s, _ := v.(string)
+		s, _, _ = strings.Cut(s, "/")
+		if addr, err := netip.ParseAddr(s); err == nil {
+			used[addr] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	a.used[pnID] = used
+	return nil
+}
+
+// public returns the lowest free address of zone's pool, skipping its
+// network and broadcast addresses.
+func (a *ipAllocator) public(zone string) (string, error) {
+	if err := a.load(""); err != nil {
+		return "", err
+	}
+	pool := a.pools.pool(zone)
+	if addr, ok := a.take("", pool, pool.Addr().Next()); ok {
+		return addr, nil
+	}
+	return "", &OutOfStockError{Pool: pool.String()}
+}
+
+// private returns the lowest free address in the IPv4 subnets of the
+// private network pnID, skipping the network, gateway (.1) and broadcast
+// addresses. A missing private network is models.ErrNotFound.
+func (a *ipAllocator) private(pnID string) (string, error) {
+	if pnID == "" {
+		return "", models.ErrNotFound
+	}
+	pn, err := a.r.getJSONByID("private_networks", "id", pnID)
+	if err != nil {
+		return "", err
+	}
+	if err := a.load(pnID); err != nil {
+		return "", err
+	}
+	var tried []string
+	for _, subnet := range privateNetworkSubnets(pn) {
+		if addr, ok := a.take(pnID, subnet, subnet.Addr().Next().Next()); ok {
+			return addr, nil
+		}
+		tried = append(tried, subnet.String())
+	}
+	if len(tried) == 0 {
+		return "", &OutOfStockError{Pool: "private network " + pnID}
+	}
+	return "", &OutOfStockError{Pool: strings.Join(tried, ", ")}
+}
+
+// reserve marks addr as taken in pnID, failing with models.ErrConflict when
+// it already is. It backs user-chosen addresses such as IPAM bookings.
+func (a *ipAllocator) reserve(pnID, addr string) error {
+	if err := a.load(pnID); err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(strings.SplitN(addr, "/", 2)[0])
+	if err != nil {
+		return nil
+	}
+	if a.used[pnID][ip] {
+		return models.ErrConflict
+	}
+	a.mark(pnID, ip)
+	return nil
+}
+
+// take claims the lowest free address of prefix from first up to, but not
+// including, the broadcast address.
+func (a *ipAllocator) take(pnID string, prefix netip.Prefix, first netip.Addr) (string, bool) {
+	last := broadcastAddr(prefix)
+	for addr := first; addr.IsValid() && addr.Less(last); addr = addr.Next() {
+		if !a.used[pnID][addr] {
+			a.mark(pnID, addr)
+			return addr.String(), true
+		}
+	}
+	return "", false
+}
+
+func (a *ipAllocator) mark(pnID string, addr netip.Addr) {
+	a.used[pnID][addr] = true
+}
+
+// endpoints fills in the address of every endpoint that has none: from its
+// private network when attached to one, from zone's public pool otherwise.
+// key is "ip" for RDB endpoints and "ips" (a list) for Redis ones.
+func (a *ipAllocator) endpoints(eps []any, zone, key string) error {
+	for _, item := range eps {
+		ep, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		if v, ok := ep[key]; ok && v != nil && v != "" {
+			continue
+		}
+		var (
+			addr string
+			err  error
+		)
+		if pn := endpointPrivateNetwork(ep); pn != "" {
+			addr, err = a.private(pn)
+		} else {
+			addr, err = a.public(zone)
+		}
+		if err != nil {
+			return err
+		}
+		if key == "ips" {
+			ep[key] = []any{addr}
+		} else {
+			ep[key] = addr
+		}
+	}
+	return nil
+}
+
+// privateNetworkSubnets returns the IPv4 subnets of a private network in
+// address order.
+func privateNetworkSubnets(pn map[string]any) []netip.Prefix {
+	var out []netip.Prefix
+	for _, item := range anySlice(pn["subnets"]) {
+		var cidr string
+		switch s := item.(type) {
+		case string:
+			cidr = s
+		case map[string]any:
+			cidr, _ = s["subnet"].(string)
+		}
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil || !p.Addr().Is4() || p.Bits() > 30 {
+			continue
+		}
+		out = append(out, p.Masked())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Addr().Less(out[j].Addr()) })
+	return out
+}
+
+// broadcastAddr returns the last address of an IPv4 prefix.
+func broadcastAddr(p netip.Prefix) netip.Addr {
+	b := p.Masked().Addr().As4()
+	host := uint32(1)<<(32-p.Bits()) - 1
+	v := (uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])) | host
+	return netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+}
+
+// regionZone is the zone whose public pool regional resources draw from.
+func regionZone(region string) string {
+	return region + "-1"
+}
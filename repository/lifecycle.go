@@ -0,0 +1,302 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redscaresu/mockway/models"
+)
+
+// Lifecycle kinds accepted in LifecycleConfig.PerKind and in the
+// --lifecycle-delays flag.
+const (
+	LifecycleServer       = "server"
+	LifecycleLB           = "lb"
+	LifecycleK8sCluster   = "k8s_cluster"
+	LifecycleK8sPool      = "k8s_pool"
+	LifecycleRDBInstance  = "rdb_instance"
+	LifecycleRedisCluster = "redis_cluster"
+)
+
+// lifecycleKind describes where a resource kind keeps its status and which
+// transient value it reports while being deleted ("" = no tombstone).
+type lifecycleKind struct {
+	table    string
+	field    string
+	deleting string
+}
+
+var lifecycleKinds = map[string]lifecycleKind{
+	LifecycleServer:       {table: "instance_servers", field: "state"},
+	LifecycleLB:           {table: "lbs", field: "status", deleting: "deleting"},
+	LifecycleK8sCluster:   {table: "k8s_clusters", field: "status", deleting: "deleting"},
+	LifecycleK8sPool:      {table: "k8s_pools", field: "status", deleting: "deleting"},
+	LifecycleRDBInstance:  {table: "rdb_instances", field: "status", deleting: "deleting"},
+	LifecycleRedisCluster: {table: "redis_clusters", field: "status", deleting: "deleting"},
+}
+
+// LifecycleConfig controls how long long-running resources stay in their
+// transient states (provisioning, starting, deleting, ...) before settling.
+// A zero delay keeps the historical behaviour: resources are returned in
+// their final state straight away.
+type LifecycleConfig struct {
+	Default time.Duration
+	PerKind map[string]time.Duration
+}
+
+// Delay returns the configured delay for kind, falling back to Default.
+func (c LifecycleConfig) Delay(kind string) time.Duration {
+	if d, ok := c.PerKind[kind]; ok {
+		return d
+	}
+	return c.Default
+}
+
+// Validate rejects negative delays and unknown kinds.
+func (c LifecycleConfig) Validate() error {
+	if c.Default < 0 {
+		return fmt.Errorf("lifecycle delay must not be negative")
+	}
+	for kind, d := range c.PerKind {
+		if _, ok := lifecycleKinds[kind]; !ok {
+			return fmt.Errorf("unknown lifecycle kind %q (valid: %s)", kind, strings.Join(LifecycleKinds(), ", "))
+		}
+		if d < 0 {
+			return fmt.Errorf("lifecycle delay for %q must not be negative", kind)
+		}
+	}
+	return nil
+}
+
+// LifecycleKinds returns the sorted list of kinds that go through simulated
+// transient states.
+func LifecycleKinds() []string {
+	out := make([]string, 0, len(lifecycleKinds))
+	for k := range lifecycleKinds {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ParseLifecycleDelays parses per-kind overrides in the form
+// "lb=5s,k8s_cluster=30s".
+func ParseLifecycleDelays(s string) (map[string]time.Duration, error) {
+	out := map[string]time.Duration{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kind, raw, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid lifecycle delay %q: expected kind=duration", part)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid lifecycle delay %q: %w", part, err)
+		}
+		out[strings.TrimSpace(kind)] = d
+	}
+	return out, nil
+}
+
+func (r *Repository) SetLifecycle(cfg LifecycleConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	perKind := make(map[string]time.Duration, len(cfg.PerKind))
+	for k, v := range cfg.PerKind {
+		perKind[k] = v
+	}
+	r.lifecycleMu.Lock()
+	defer r.lifecycleMu.Unlock()
+	r.lifecycle = LifecycleConfig{Default: cfg.Default, PerKind: perKind}
+	return nil
+}
+
+func (r *Repository) Lifecycle() LifecycleConfig {
+	r.lifecycleMu.RLock()
+	defer r.lifecycleMu.RUnlock()
+	perKind := make(map[string]time.Duration, len(r.lifecycle.PerKind))
+	for k, v := range r.lifecycle.PerKind {
+		perKind[k] = v
+	}
+	return LifecycleConfig{Default: r.lifecycle.Default, PerKind: perKind}
+}
+
+func (r *Repository) lifecycleDelay(kind string) time.Duration {
+	r.lifecycleMu.RLock()
+	defer r.lifecycleMu.RUnlock()
+	return r.lifecycle.Delay(kind)
+}
+
+// startTransition puts a freshly written resource into a transient status and
+// schedules the move back to the status it was stored with. It is a no-op
+// when the kind has no delay configured.
+func (r *Repository) startTransition(kind string, data map[string]any, transient string) (map[string]any, error) {
+	k := lifecycleKinds[kind]
+	id, _ := data["id"].(string)
+	delay := r.lifecycleDelay(kind)
+	if delay <= 0 || id == "" {
+		// Only a transition scheduled under an earlier delay needs dropping;
+		// without one this stays a read.
+		var pending bool
+		if err := r.db.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM lifecycle_transitions WHERE resource_table = ? AND resource_id = ?)`, k.table, id,
+		).Scan(&pending); err != nil {
+			return nil, err
+		}
+		if pending {
+			if _, err := r.db.Exec(`DELETE FROM lifecycle_transitions WHERE resource_table = ? AND resource_id = ?`, k.table, id); err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	}
+	target, _ := data[k.field].(string)
+	out := cloneMap(data)
+	out[k.field] = transient
+	if err := r.updateJSONByID(k.table, "id", id, out); err != nil {
+		return nil, err
+	}
+	if _, err := r.db.Exec(
+		`INSERT OR REPLACE INTO lifecycle_transitions (resource_table, resource_id, field, target, due_at) VALUES (?, ?, ?, ?, ?)`,
+		k.table, id, k.field, target, r.Now().Add(delay).UnixNano(),
+	); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// lifecycleSnapshot captures the last state of a resource about to be deleted
+// so it can be served as a tombstone. Returns nil when no tombstone is needed.
+func (r *Repository) lifecycleSnapshot(kind, id string) map[string]any {
+	k := lifecycleKinds[kind]
+	if k.deleting == "" || r.lifecycleDelay(kind) <= 0 {
+		return nil
+	}
+	data, err := r.getJSONByID(k.table, "id", id)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// tombstone records a deleted resource so Get keeps returning it in its
+// "deleting" status until the delay elapses. The row itself is already gone,
+// so FK checks and cascades stay synchronous.
+func (r *Repository) tombstone(kind, id string, last map[string]any) error {
+	if last == nil {
+		return nil
+	}
+	k := lifecycleKinds[kind]
+	data := cloneMap(last)
+	data[k.field] = k.deleting
+	b, err := marshalData(data)
+	if err != nil {
+		return err
+	}
+	if _, err := r.db.Exec(`DELETE FROM lifecycle_transitions WHERE resource_table = ? AND resource_id = ?`, k.table, id); err != nil {
+		return err
+	}
+	_, err = r.db.Exec(
+		`INSERT OR REPLACE INTO lifecycle_tombstones (resource_table, resource_id, data, due_at) VALUES (?, ?, ?, ?)`,
+		k.table, id, b, r.Now().Add(r.lifecycleDelay(kind)).UnixNano(),
+	)
+	return err
+}
+
+// getWithLifecycle is getJSONByID for lifecycle-managed kinds: a resource that
+// was deleted recently is still visible in its "deleting" status.
+func (r *Repository) getWithLifecycle(kind, id string) (map[string]any, error) {
+	k := lifecycleKinds[kind]
+	data, err := r.getJSONByID(k.table, "id", id)
+	if !errors.Is(err, models.ErrNotFound) {
+		return data, err
+	}
+	var raw []byte
+	err = r.db.QueryRow(
+		`SELECT data FROM lifecycle_tombstones WHERE resource_table = ? AND resource_id = ? AND due_at > ?`,
+		k.table, id, r.Now().UnixNano(),
+	).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalData(raw)
+}
+
+// applyLifecycle settles every transition whose delay has elapsed and drops
+// expired tombstones. It runs lazily before reads so no background goroutine
+// is needed.
+func (r *Repository) applyLifecycle() error {
+	now := r.Now().UnixNano()
+	// Most reads find nothing due and stay read-only, so they do not wait
+	// for the writer.
+	var due bool
+	err := r.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM lifecycle_tombstones WHERE due_at <= ?)
+			OR EXISTS (SELECT 1 FROM lifecycle_transitions WHERE due_at <= ?)`, now, now,
+	).Scan(&due)
+	if err != nil || !due {
+		return err
+	}
+	return r.Atomic(func(tx *Repository) error { return tx.settleLifecycle(now) })
+}
+
+func (r *Repository) settleLifecycle(now int64) error {
+	if _, err := r.db.Exec(`DELETE FROM lifecycle_tombstones WHERE due_at <= ?`, now); err != nil {
+		return err
+	}
+	rows, err := r.db.Query(
+		`SELECT resource_table, resource_id, field, target FROM lifecycle_transitions WHERE due_at <= ?`, now,
+	)
+	if err != nil {
+		return err
+	}
+	type due struct{ table, id, field, target string }
+	var pending []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.table, &d.id, &d.field, &d.target); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range pending {
+		var raw []byte
+		err := r.db.QueryRow(fmt.Sprintf("SELECT data FROM %s WHERE id = ?", d.table), d.id).Scan(&raw)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err == nil {
+			data, err := unmarshalData(raw)
+			if err != nil {
+				return err
+			}
+			data[d.field] = d.target
+			if err := r.updateJSONByID(d.table, "id", d.id, data); err != nil {
+				return err
+			}
+		}
+		if _, err := r.db.Exec(
+			`DELETE FROM lifecycle_transitions WHERE resource_table = ? AND resource_id = ?`, d.table, d.id,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/redscaresu/mockway/models"
+	"gopkg.in/yaml.v3"
+)
+
+// QuotaConfig limits how many resources of each kind a project may hold.
+// PerProject entries override Default for that project; kinds without a
+// limit are unbounded. The zero value disables quotas.
+type QuotaConfig struct {
+	Default    map[string]int            `json:"default" yaml:"default"`
+	PerProject map[string]map[string]int `json:"projects" yaml:"projects"`
+}
+
+// Limit returns the quota for kind in project and whether one is set.
+func (c QuotaConfig) Limit(project, kind string) (int, bool) {
+	if n, ok := c.PerProject[project][kind]; ok {
+		return n, true
+	}
+	n, ok := c.Default[kind]
+	return n, ok
+}
+
+// Validate rejects unknown kinds and negative limits.
+func (c QuotaConfig) Validate() error {
+	check := func(limits map[string]int) error {
+		for kind, n := range limits {
+			if _, ok := quotaTables[kind]; !ok {
+				return fmt.Errorf("unknown quota kind %q (valid: %s)", kind, strings.Join(QuotaKinds(), ", "))
+			}
+			if n < 0 {
+				return fmt.Errorf("quota for %q must not be negative", kind)
+			}
+		}
+		return nil
+	}
+	if err := check(c.Default); err != nil {
+		return err
+	}
+	for _, limits := range c.PerProject {
+		if err := check(limits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QuotaKinds returns the sorted list of resource kinds that accept quotas.
+func QuotaKinds() []string {
+	out := make([]string, 0, len(quotaTables))
+	for k := range quotaTables {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// LoadQuotaConfig reads a QuotaConfig from a YAML file.
+func LoadQuotaConfig(path string) (QuotaConfig, error) {
+	var cfg QuotaConfig
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("read quotas: %w", err)
+	}
+	if err := yaml.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse quotas: %w", err)
+	}
+	return cfg, cfg.Validate()
+}
+
+// QuotaExceededError carries the details of a quotas_exceeded answer. It
+// wraps models.ErrQuotaExceeded.
+type QuotaExceededError struct {
+	Resource string
+	Quota    int
+	Current  int
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota exceeded for %s: %d/%d", e.Resource, e.Current, e.Quota)
+}
+
+func (e *QuotaExceededError) Unwrap() error { return models.ErrQuotaExceeded }
+
+// Quotas returns the quota config currently in effect.
+func (r *Repository) Quotas() QuotaConfig {
+	r.quotaMu.RLock()
+	defer r.quotaMu.RUnlock()
+	return r.quotas
+}
+
+// SetQuotas replaces the quota config. Existing resources are kept even if
+// they exceed the new limits; only later creates are refused.
+func (r *Repository) SetQuotas(cfg QuotaConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	r.quotaMu.Lock()
+	defer r.quotaMu.Unlock()
+	r.quotas = cfg
+	return nil
+}
+
+// checkQuota returns a *QuotaExceededError when inserting data into table
+// would take its project past the configured limit. Rows without a project
+// count against the default project.
+func (r *Repository) checkQuota(table string, data map[string]any) error {
+	cfg := r.Quotas()
+	if len(cfg.Default) == 0 && len(cfg.PerProject) == 0 {
+		return nil
+	}
+	kind := ""
+	for k, t := range quotaTables {
+		if t == table {
+			kind = k
+			break
+		}
+	}
+	if kind == "" {
+		return nil
+	}
+	project := DefaultProjectID
+	if id, _ := data["project_id"].(string); id != "" {
+		project = id
+	} else if id, _ := data["project"].(string); id != "" {
+		project = id
+	}
+	limit, ok := cfg.Limit(project, kind)
+	if !ok {
+		return nil
+	}
+	var current int
+	q := fmt.Sprintf(
+		`SELECT COUNT(*) FROM %s WHERE COALESCE(json_extract(data, '$.project_id'), json_extract(data, '$.project'), ?) = ?`,
+		table,
+	)
+	if err := r.db.QueryRow(q, DefaultProjectID, project).Scan(&current); err != nil {
+		return err
+	}
+	if current >= limit {
+		return &QuotaExceededError{Resource: kind, Quota: limit, Current: current}
+	}
+	return nil
+}
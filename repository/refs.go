@@ -0,0 +1,543 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/redscaresu/mockway/models"
+)
+
+// RefAction is what deleting a referenced resource does to the rows that
+// reference it.
+type RefAction string
+
+const (
+	// RefNone leaves the reference dangling, as Scaleway does.
+	RefNone RefAction = ""
+	// RefRestrict refuses the delete with models.ErrConflict.
+	RefRestrict RefAction = "restrict"
+	// RefCascade deletes the referencing rows along with the target.
+	RefCascade RefAction = "cascade"
+	// RefSetNull clears the reference (and Column, when set).
+	RefSetNull RefAction = "set_null"
+)
+
+// Reference is a reference from rows of Table to rows of Target that
+// SQLite does not enforce, because it lives inside the JSON data or in a
+// column without a foreign key.
+//
+// Path locates it in data: "name[]" walks every element of an array and
+// "*" every value of an object. Column-only references leave Path empty.
+// The values found are matched against Field of Target rows, a column or
+// a top-level data key ("id" unless set).
+type Reference struct {
+	Table  string
+	Path   string
+	Column string
+	Target string
+	Field  string
+	// Required makes creates and updates that introduce a reference to a
+	// missing target fail with models.ErrNotFound.
+	Required bool
+	OnDelete RefAction
+}
+
+// References is every reference mockway checks, cascades and draws in
+// /mock/graph on top of the SQL foreign keys. RefSetNull clears the first
+// path segment, or removes the matching elements of a "name[]" array.
+var References = []Reference{
+	// Instance
+	{Table: "instance_servers", Path: "security_group_id", Column: "security_group_id", Target: "instance_security_groups", Required: true, OnDelete: RefSetNull},
+	{Table: "instance_servers", Path: "security_group.id", Target: "instance_security_groups", OnDelete: RefSetNull},
+	{Table: "instance_servers", Path: "volumes.*.id", Target: "instance_volumes"},
+	{Table: "instance_servers", Path: "volumes.*.id", Target: "block_volumes", OnDelete: RefRestrict},
+	{Table: "instance_ips", Path: "server_id", Column: "server_id", Target: "instance_servers", OnDelete: RefSetNull},
+
+	// Load balancer
+	{Table: "lbs", Path: "ip_id", Target: "lb_ips", Required: true},
+	{Table: "lbs", Path: "ip_ids[]", Target: "lb_ips", Required: true},
+	{Table: "lbs", Path: "ip[].id", Target: "lb_ips"},
+	{Table: "lb_ips", Path: "lb_id", Target: "lbs", OnDelete: RefSetNull},
+	{Table: "lb_private_networks", Column: "lb_id", Target: "lbs", OnDelete: RefCascade},
+	{Table: "lb_frontends", Path: "backend_id", Target: "lb_backends", Required: true, OnDelete: RefRestrict},
+	{Table: "lb_frontends", Path: "certificate_ids[]", Target: "lb_certificates", Required: true, OnDelete: RefRestrict},
+	{Table: "lb_frontends", Path: "certificate_id", Target: "lb_certificates", Required: true, OnDelete: RefRestrict},
+	{Table: "lb_routes", Path: "frontend_id", Target: "lb_frontends", Required: true, OnDelete: RefCascade},
+	{Table: "lb_routes", Path: "backend_id", Target: "lb_backends", Required: true, OnDelete: RefCascade},
+	{Table: "lb_backends", Path: "server_ip[]", Target: "instance_ips", Field: "address"},
+
+	// Private network endpoints
+	{Table: "rdb_instances", Path: "endpoints[].private_network.id", Target: "private_networks", Required: true, OnDelete: RefRestrict},
+	{Table: "rdb_instances", Path: "endpoints[].private_network.private_network_id", Target: "private_networks", Required: true, OnDelete: RefRestrict},
+	{Table: "rdb_read_replicas", Path: "endpoints[].private_network.id", Target: "private_networks", Required: true, OnDelete: RefRestrict},
+	{Table: "rdb_read_replicas", Path: "endpoints[].private_network.private_network_id", Target: "private_networks", Required: true, OnDelete: RefRestrict},
+	// Redis endpoints and IPAM sources accept private networks mockway has
+	// not seen, so provider tests can attach to ids created elsewhere.
+	{Table: "redis_clusters", Path: "endpoints[].private_network.id", Target: "private_networks", OnDelete: RefRestrict},
+	{Table: "vpc_gateway_networks", Path: "ipam_config.ipam_ip_id", Target: "ipam_ips", Required: true, OnDelete: RefRestrict},
+	{Table: "ipam_ips", Path: "source.private_network_id", Target: "private_networks", OnDelete: RefCascade},
+
+	// IAM
+	{Table: "iam_api_keys", Path: "user_id", Target: "iam_users", Required: true, OnDelete: RefCascade},
+	{Table: "iam_policies", Path: "user_id", Target: "iam_users", Required: true, OnDelete: RefCascade},
+	{Table: "iam_policies", Path: "group_id", Target: "iam_groups", Required: true, OnDelete: RefCascade},
+
+	// Domain
+	{Table: "domain_records", Column: "dns_zone", Target: "dns_zones", Field: "dns_zone", OnDelete: RefCascade},
+}
+
+// ReferenceNotFoundError is returned when a create or update references a
+// resource that does not exist. It wraps models.ErrNotFound.
+type ReferenceNotFoundError struct {
+	Table, Path, Target, ID string
+}
+
+func (e *ReferenceNotFoundError) Error() string {
+	return fmt.Sprintf("%s %s: %s %q not found", e.Table, e.Path, e.Target, e.ID)
+}
+
+func (e *ReferenceNotFoundError) Unwrap() error { return models.ErrNotFound }
+
+// Resource names the missing resource the way /mock/state does, e.g.
+// "backend" for lb_backends.
+func (e *ReferenceNotFoundError) Resource() string {
+	if t, ok := eventTables[e.Target]; ok {
+		return t.resourceType
+	}
+	return e.Target
+}
+
+// ReferencedError is returned when a delete is refused because other rows
+// still reference the resource. It wraps models.ErrConflict.
+type ReferencedError struct {
+	Table, ID, By string
+}
+
+func (e *ReferencedError) Error() string {
+	return fmt.Sprintf("%s %q is still referenced by %s", e.Table, e.ID, e.By)
+}
+
+func (e *ReferencedError) Unwrap() error { return models.ErrConflict }
+
+// querier is satisfied by *sql.DB, *sql.Tx, handle and txn.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// values returns the target keys row holds for ref.
+func (ref Reference) values(row tableRow) []string {
+	if ref.Path != "" {
+		return jsonPathValues(row.data, ref.Path)
+	}
+	if v := row.cols[ref.Column]; v != "" {
+		return []string{v}
+	}
+	return nil
+}
+
+func (ref Reference) field() string {
+	if ref.Field == "" {
+		return "id"
+	}
+	return ref.Field
+}
+
+// checkReferences returns a *ReferenceNotFoundError when data, about to be
+// written to table, holds a Required reference to a missing resource.
+// References already present in prev, the row being replaced, are not
+// checked again, so an update never fails over a target deleted earlier.
+func (r *Repository) checkReferences(q querier, table string, data, prev map[string]any) error {
+	for _, ref := range References {
+		if ref.Table != table || !ref.Required || ref.Path == "" {
+			continue
+		}
+		var old []string
+		if prev != nil {
+			old = jsonPathValues(prev, ref.Path)
+		}
+		for _, v := range jsonPathValues(data, ref.Path) {
+			if slices.Contains(old, v) {
+				continue
+			}
+			var one int
+			err := q.QueryRow(fmt.Sprintf("SELECT 1 FROM %s WHERE %s = ? LIMIT 1", ref.Target, fieldExpr(q, ref.Target, ref.field())), v).Scan(&one)
+			if errors.Is(err, sql.ErrNoRows) {
+				return &ReferenceNotFoundError{Table: table, Path: ref.Path, Target: ref.Target, ID: v}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteTx deletes the rows of table matching where, first applying the
+// OnDelete action of every reference to them. It returns models.ErrNotFound
+// when nothing matched.
+func (r *Repository) deleteTx(tx querier, table, where string, args ...any) error {
+	rows, err := scanTableRows(tx, table, where, args...)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return models.ErrNotFound
+	}
+	if err := r.applyDeleteReferences(tx, table, rows); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", table, where), args...); err != nil {
+		return mapDeleteSQLError(err)
+	}
+	return nil
+}
+
+// applyDeleteReferences restricts, cascades or clears the references to
+// rows, which are about to be deleted from table.
+func (r *Repository) applyDeleteReferences(tx querier, table string, rows []tableRow) error {
+	for _, ref := range References {
+		if ref.Target != table || ref.OnDelete == RefNone {
+			continue
+		}
+		keys := map[string]string{}
+		var args []any
+		for _, row := range rows {
+			if k := row.field(ref.field()); k != "" {
+				if _, ok := keys[k]; !ok {
+					args = append(args, k)
+				}
+				keys[k] = row.key()
+			}
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		sources, err := scanTableRows(tx, ref.Table, ref.sourceFilter(len(args)), args...)
+		if err != nil {
+			return err
+		}
+		var cascade []tableRow
+		for _, src := range sources {
+			var hit []string
+			for _, v := range ref.values(src) {
+				if _, ok := keys[v]; ok {
+					hit = append(hit, v)
+				}
+			}
+			if len(hit) == 0 {
+				continue
+			}
+			switch ref.OnDelete {
+			case RefRestrict:
+				return &ReferencedError{Table: table, ID: keys[hit[0]], By: ref.Table + " " + src.key()}
+			case RefCascade:
+				cascade = append(cascade, src)
+			case RefSetNull:
+				if err := clearReference(tx, ref, src, hit); err != nil {
+					return err
+				}
+			}
+		}
+		if len(cascade) > 0 {
+			if err := r.applyDeleteReferences(tx, ref.Table, cascade); err != nil {
+				return err
+			}
+			for _, src := range cascade {
+				if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE rowid = ?", ref.Table), src.rowid); err != nil {
+					return mapDeleteSQLError(err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sourceFilter is the SQL condition selecting the rows of ref.Table whose
+// reference holds one of n keys, bound in order. Paths without "[]" or "*"
+// compare a single json_extract, which referenceIndexes indexes; the
+// others walk their arrays and objects with json_each.
+func (ref Reference) sourceFilter(n int) string {
+	in := "IN (" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+	if ref.Path == "" {
+		return ref.Column + " " + in
+	}
+	return "json_valid(data) AND " + pathFilter("'$'", strings.Split(ref.Path, "."), 0, in)
+}
+
+// pathFilter is the condition that a value at segs below the JSON path
+// root, an SQL string expression, matches in.
+func pathFilter(root string, segs []string, depth int, in string) string {
+	for i, seg := range segs {
+		name, isArray := strings.CutSuffix(seg, "[]")
+		if !isArray && seg != "*" {
+			continue
+		}
+		container, kind := slices.Clone(segs[:i]), "object"
+		if isArray {
+			container, kind = append(container, name), "array"
+		}
+		path := sqlJSONPath(root, container)
+		e := fmt.Sprintf("e%d", depth)
+		return fmt.Sprintf("json_type(data, %s) = '%s' AND EXISTS (SELECT 1 FROM json_each(data, %s) %s WHERE %s)",
+			path, kind, path, e, pathFilter(e+".fullkey", segs[i+1:], depth+1, in))
+	}
+	return fmt.Sprintf("json_extract(data, %s) %s", sqlJSONPath(root, segs), in)
+}
+
+// sqlJSONPath appends segs to root, keeping a literal root literal so the
+// expression matches its index.
+func sqlJSONPath(root string, segs []string) string {
+	if len(segs) == 0 {
+		return root
+	}
+	suffix := "." + strings.Join(segs, ".")
+	if lit, ok := strings.CutSuffix(root, "'"); ok && strings.HasPrefix(lit, "'") {
+		return lit + suffix + "'"
+	}
+	return root + " || '" + suffix + "'"
+}
+
+// referenceIndexes returns the CREATE INDEX statements that let deletes
+// find the rows referencing them without scanning: one per column and per
+// path without "[]" or "*" of a reference with an OnDelete action. Path
+// indexes leave out rows whose data is not valid JSON, so storing one
+// still fails where it is read rather than at the insert.
+func referenceIndexes() []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, ref := range References {
+		if ref.OnDelete == RefNone || strings.Contains(ref.Path, "[]") || strings.Contains(ref.Path, "*") {
+			continue
+		}
+		expr, name, where := ref.Column, ref.Column, ""
+		if ref.Path != "" {
+			expr = fmt.Sprintf("json_extract(data, '$.%s')", ref.Path)
+			name = strings.ReplaceAll(ref.Path, ".", "__")
+			where = " WHERE json_valid(data)"
+		}
+		name = "ref_" + ref.Table + "_" + name
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		out = append(out, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)%s", name, ref.Table, expr, where))
+	}
+	return out
+}
+
+// clearReference removes the values in hit from src's ref and stores it.
+func clearReference(tx querier, ref Reference, src tableRow, hit []string) error {
+	sets := []string{}
+	var args []any
+	if ref.Path != "" {
+		for _, v := range hit {
+			clearJSONPath(src.data, ref.Path, v)
+		}
+		b, err := marshalData(src.data)
+		if err != nil {
+			return err
+		}
+		sets = append(sets, "data = ?")
+		args = append(args, b)
+	}
+	if ref.Column != "" {
+		sets = append(sets, ref.Column+" = NULL")
+	}
+	_, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s WHERE rowid = ?", ref.Table, strings.Join(sets, ", ")), append(args, src.rowid)...)
+	return err
+}
+
+// clearJSONPath clears value from path in data: a "name[]" segment loses
+// the elements holding value, any other first segment is set to null.
+func clearJSONPath(data map[string]any, path, value string) {
+	seg, rest, _ := strings.Cut(path, ".")
+	name, isArray := strings.CutSuffix(seg, "[]")
+	v, ok := data[name]
+	if !ok {
+		return
+	}
+	if !isArray {
+		if slices.Contains(jsonPathValues(v, rest), value) {
+			data[name] = nil
+		}
+		return
+	}
+	kept := []any{}
+	for _, el := range anySlice(v) {
+		if !slices.Contains(jsonPathValues(el, rest), value) {
+			kept = append(kept, el)
+		}
+	}
+	data[name] = kept
+}
+
+// jsonPathValues returns the non-empty strings found at path in data.
+func jsonPathValues(data any, path string) []string {
+	if path == "" {
+		if s, ok := data.(string); ok && s != "" {
+			return []string{s}
+		}
+		return nil
+	}
+	seg, rest, _ := strings.Cut(path, ".")
+	var next []any
+	obj, _ := data.(map[string]any)
+	switch {
+	case seg == "*":
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			next = append(next, obj[k])
+		}
+	case strings.HasSuffix(seg, "[]"):
+		next = anySlice(obj[strings.TrimSuffix(seg, "[]")])
+	default:
+		if v, ok := obj[seg]; ok {
+			next = []any{v}
+		}
+	}
+	var out []string
+	for _, v := range next {
+		out = append(out, jsonPathValues(v, rest)...)
+	}
+	return out
+}
+
+// tableRow is a stored row: its rowid, plain columns as strings and data.
+type tableRow struct {
+	rowid int64
+	pk    []string
+	cols  map[string]string
+	data  map[string]any
+}
+
+// field returns a column, or a top-level string in data.
+func (row tableRow) field(name string) string {
+	if v, ok := row.cols[name]; ok {
+		return v
+	}
+	s, _ := row.data[name].(string)
+	return s
+}
+
+// key identifies row in messages: data.id, or its primary key columns.
+func (row tableRow) key() string {
+	if id, _ := row.data["id"].(string); id != "" {
+		return id
+	}
+	parts := make([]string, 0, len(row.pk))
+	for _, c := range row.pk {
+		parts = append(parts, row.cols[c])
+	}
+	return strings.Join(parts, "/")
+}
+
+// scanTableRows reads the rows of table matching where ("" for all) in
+// insertion order.
+func scanTableRows(q querier, table, where string, args ...any) ([]tableRow, error) {
+	pk := loadTableSchema(q, table).pk
+	query := fmt.Sprintf("SELECT rowid, * FROM %s", table)
+	if where != "" {
+		query += " WHERE " + where
+	}
+	rows, err := q.Query(query+" ORDER BY rowid", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var out []tableRow
+	for rows.Next() {
+		vals := make([]any, len(names))
+		dest := make([]any, len(names))
+		for i := range vals {
+			dest[i] = &vals[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := tableRow{pk: pk, cols: map[string]string{}, data: map[string]any{}}
+		for i, name := range names {
+			switch {
+			case i == 0:
+				row.rowid, _ = vals[i].(int64)
+			case name == "data":
+				var raw []byte
+				switch v := vals[i].(type) {
+				case string:
+					raw = []byte(v)
+				case []byte:
+					raw = v
+				}
+				if row.data, err = unmarshalData(raw); err != nil {
+					return nil, err
+				}
+			case vals[i] != nil:
+				row.cols[name] = fmt.Sprint(vals[i])
+			}
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+// tableColumns caches the columns and primary key of each table. The schema
+// never changes after init, so it is read once per process.
+var tableColumns sync.Map // table -> tableSchema
+
+type tableSchema struct {
+	cols map[string]bool
+	pk   []string
+}
+
+func loadTableSchema(q querier, table string) tableSchema {
+	if s, ok := tableColumns.Load(table); ok {
+		return s.(tableSchema)
+	}
+	s := tableSchema{cols: map[string]bool{}}
+	rows, err := q.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return s
+	}
+	defer rows.Close()
+	pk := map[int]string{}
+	for rows.Next() {
+		var (
+			cid, notNull, pkIndex int
+			name, typ             string
+			dflt                  sql.NullString
+		)
+		if rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pkIndex) != nil {
+			return s
+		}
+		s.cols[name] = true
+		if pkIndex > 0 {
+			pk[pkIndex] = name
+		}
+	}
+	for i := 1; i <= len(pk); i++ {
+		s.pk = append(s.pk, pk[i])
+	}
+	tableColumns.Store(table, s)
+	return s
+}
+
+// fieldExpr is the SQL for field of table: the column, or the data key.
+func fieldExpr(q querier, table, field string) string {
+	if loadTableSchema(q, table).cols[field] {
+		return field
+	}
+	return fmt.Sprintf("json_extract(data, '$.%s')", field)
+}
@@ -1,26 +1,60 @@
 package repository
 
 import (
-	"crypto/rand"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math/big"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/redscaresu/mockway/models"
-	_ "modernc.org/sqlite"
 )
 
+// Repository is the store behind every handler. The one New returns runs
+// each statement on its own pooled connection; the one Atomic hands to its
+// callback runs them all in one transaction. Both share configuration and
+// subscribers.
 type Repository struct {
-	db             *sql.DB
+	*shared
+	db handle
+}
+
+type shared struct {
+	pool atomic.Pointer[sql.DB]
+	// gate is held shared by Atomic and exclusively by swapDB, so the
+	// pool is not swapped under an open transaction.
+	gate sync.RWMutex
+
 	path           string
 	snapshotPath   string
 	cleanupOnClose bool
+
+	lifecycleMu sync.RWMutex
+	lifecycle   LifecycleConfig
+
+	iamMu sync.RWMutex
+	iam   IAMConfig
+
+	quotaMu sync.RWMutex
+	quotas  QuotaConfig
+
+	// ipMu serialises address allocation outside Atomic (see lockIPs).
+	ipMu     sync.Mutex
+	ipPoolMu sync.RWMutex
+	ipPools  IPPoolConfig
+
+	pricingMu sync.RWMutex
+	pricing   PricingTable
+
+	events  eventBus
+	entropy entropySource
+
+	sandboxes sandboxSet
 }
 
 type colVal struct {
@@ -29,6 +63,17 @@ type colVal struct {
 }
 
 func New(path string) (*Repository, error) {
+	return open(path, nil)
+}
+
+// NewDeterministic is New in deterministic mode (see SetDeterministic)
+// from the start, so the default project it seeds is stamped by the frozen
+// clock too and a fresh repository's state depends only on seed.
+func NewDeterministic(path string, seed int64) (*Repository, error) {
+	return open(path, &seed)
+}
+
+func open(path string, seed *int64) (*Repository, error) {
 	actualPath := path
 	cleanupOnClose := false
 	if path == ":memory:" {
@@ -52,11 +97,16 @@ func New(path string) (*Repository, error) {
 		return nil, err
 	}
 
-	r := &Repository{
-		db:             db,
+	s := &shared{
 		path:           actualPath,
 		snapshotPath:   actualPath + ".snapshot",
 		cleanupOnClose: cleanupOnClose,
+		iam:            IAMConfig{AdminKey: DefaultIAMAdminKey},
+	}
+	s.pool.Store(db)
+	r := &Repository{shared: s, db: handle{pool: &s.pool}}
+	if seed != nil {
+		r.SetDeterministic(*seed)
 	}
 	if err := r.init(); err != nil {
 		_ = db.Close()
@@ -69,266 +119,46 @@ func New(path string) (*Repository, error) {
 }
 
 func (r *Repository) Close() error {
-	if r.db == nil {
+	db := r.pool.Load()
+	if db == nil {
 		return nil
 	}
-	err := r.db.Close()
+	r.closeSubscribers()
+	r.closeSandboxes()
+	err := db.Close()
 	if r.cleanupOnClose {
 		_ = os.Remove(r.path)
+		_ = os.Remove(r.path + "-wal")
+		_ = os.Remove(r.path + "-shm")
 		_ = os.Remove(r.snapshotPath)
 		_ = os.Remove(r.path + ".restore")
+		_ = os.RemoveAll(r.snapshotDir())
+		_ = os.RemoveAll(r.sandboxDir())
 	}
 	return err
 }
 
+// maxOpenConns bounds the pool. In WAL mode readers run alongside the one
+// writer, so a parallel client is not serialised behind a single connection.
+const maxOpenConns = 16
+
+// openDB opens a pool on path. Every connection enforces foreign keys and
+// waits for the write lock rather than failing with SQLITE_BUSY. Its
+// transactions are deferred: they read concurrently and only a write takes
+// the lock, so a transaction whose reads another writer has since
+// overtaken fails its write instead of overwriting (see Atomic).
 func openDB(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", path)
-	if err != nil {
-		return nil, fmt.Errorf("open db: %w", err)
-	}
-	db.SetMaxOpenConns(1)
+	dsn := path + "?_pragma=busy_timeout(10000)&_pragma=foreign_keys(1)"
+	db := openTimedDB(dsn)
+	db.SetMaxOpenConns(maxOpenConns)
 	return db, nil
 }
 
 func (r *Repository) init() error {
-	stmts := []string{
-		`PRAGMA foreign_keys = ON`,
-		`CREATE TABLE IF NOT EXISTS vpcs (
-			id TEXT PRIMARY KEY,
-			region TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS private_networks (
-			id TEXT PRIMARY KEY,
-			vpc_id TEXT NOT NULL REFERENCES vpcs(id),
-			region TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS instance_security_groups (
-			id TEXT PRIMARY KEY,
-			zone TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS instance_servers (
-			id TEXT PRIMARY KEY,
-			zone TEXT NOT NULL,
-			security_group_id TEXT REFERENCES instance_security_groups(id) ON DELETE SET NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS instance_ips (
-			id TEXT PRIMARY KEY,
-			server_id TEXT REFERENCES instance_servers(id) ON DELETE SET NULL,
-			zone TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS instance_private_nics (
-			id TEXT PRIMARY KEY,
-			server_id TEXT NOT NULL REFERENCES instance_servers(id) ON DELETE CASCADE,
-			private_network_id TEXT NOT NULL REFERENCES private_networks(id),
-			zone TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS lb_ips (
-			id TEXT PRIMARY KEY,
-			zone TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS lbs (
-			id TEXT PRIMARY KEY,
-			zone TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS lb_frontends (
-			id TEXT PRIMARY KEY,
-			lb_id TEXT NOT NULL REFERENCES lbs(id),
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS lb_backends (
-			id TEXT PRIMARY KEY,
-			lb_id TEXT NOT NULL REFERENCES lbs(id),
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS lb_private_networks (
-			lb_id TEXT NOT NULL REFERENCES lbs(id),
-			private_network_id TEXT NOT NULL REFERENCES private_networks(id),
-			data JSON NOT NULL,
-			PRIMARY KEY (lb_id, private_network_id)
-		)`,
-		`CREATE TABLE IF NOT EXISTS k8s_clusters (
-			id TEXT PRIMARY KEY,
-			region TEXT NOT NULL,
-			private_network_id TEXT REFERENCES private_networks(id),
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS k8s_pools (
-			id TEXT PRIMARY KEY,
-			cluster_id TEXT NOT NULL REFERENCES k8s_clusters(id) ON DELETE CASCADE,
-			region TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS rdb_instances (
-			id TEXT PRIMARY KEY,
-			region TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS rdb_databases (
-			instance_id TEXT NOT NULL REFERENCES rdb_instances(id) ON DELETE CASCADE,
-			name TEXT NOT NULL,
-			data JSON NOT NULL,
-			PRIMARY KEY (instance_id, name)
-		)`,
-		`CREATE TABLE IF NOT EXISTS rdb_users (
-			instance_id TEXT NOT NULL REFERENCES rdb_instances(id) ON DELETE CASCADE,
-			name TEXT NOT NULL,
-			data JSON NOT NULL,
-			PRIMARY KEY (instance_id, name)
-		)`,
-		`CREATE TABLE IF NOT EXISTS rdb_privileges (
-			instance_id TEXT NOT NULL REFERENCES rdb_instances(id) ON DELETE CASCADE,
-			user_name TEXT NOT NULL,
-			database_name TEXT NOT NULL,
-			data JSON NOT NULL,
-			PRIMARY KEY (instance_id, user_name, database_name)
-		)`,
-		`CREATE TABLE IF NOT EXISTS dns_zones (
-			dns_zone TEXT PRIMARY KEY,
-			domain TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS domain_records (
-			id TEXT PRIMARY KEY,
-			dns_zone TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS redis_clusters (
-			id TEXT PRIMARY KEY,
-			zone TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS registry_namespaces (
-			id TEXT PRIMARY KEY,
-			region TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS iam_applications (
-			id TEXT PRIMARY KEY,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS iam_api_keys (
-			access_key TEXT PRIMARY KEY,
-			application_id TEXT REFERENCES iam_applications(id),
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS iam_policies (
-			id TEXT PRIMARY KEY,
-			application_id TEXT REFERENCES iam_applications(id),
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS iam_ssh_keys (
-			id TEXT PRIMARY KEY,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS iam_rules (
-			id TEXT PRIMARY KEY,
-			policy_id TEXT NOT NULL REFERENCES iam_policies(id) ON DELETE CASCADE,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS iam_users (
-			id TEXT PRIMARY KEY,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS iam_groups (
-			id TEXT PRIMARY KEY,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS iam_group_members (
-			group_id TEXT NOT NULL REFERENCES iam_groups(id) ON DELETE CASCADE,
-			user_id TEXT NOT NULL REFERENCES iam_users(id) ON DELETE CASCADE,
-			PRIMARY KEY (group_id, user_id)
-		)`,
-		`CREATE TABLE IF NOT EXISTS instance_volumes (
-			id TEXT PRIMARY KEY,
-			zone TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS block_volumes (
-			id TEXT PRIMARY KEY,
-			zone TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS block_snapshots (
-			id TEXT PRIMARY KEY,
-			zone TEXT NOT NULL,
-			volume_id TEXT REFERENCES block_volumes(id),
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS ipam_ips (
-			id TEXT PRIMARY KEY,
-			region TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS rdb_read_replicas (
-			id TEXT PRIMARY KEY,
-			instance_id TEXT NOT NULL REFERENCES rdb_instances(id) ON DELETE CASCADE,
-			region TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS rdb_snapshots (
-			id TEXT PRIMARY KEY,
-			instance_id TEXT NOT NULL REFERENCES rdb_instances(id) ON DELETE CASCADE,
-			region TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS rdb_acls (
-			instance_id TEXT PRIMARY KEY REFERENCES rdb_instances(id) ON DELETE CASCADE,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS rdb_backups (
-			id TEXT PRIMARY KEY,
-			instance_id TEXT NOT NULL REFERENCES rdb_instances(id) ON DELETE CASCADE,
-			region TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS vpc_routes (
-			id TEXT PRIMARY KEY,
-			vpc_id TEXT NOT NULL REFERENCES vpcs(id),
-			region TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS vpc_public_gateways (
-			id TEXT PRIMARY KEY,
-			zone TEXT NOT NULL,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS vpc_gateway_networks (
-			id TEXT PRIMARY KEY,
-			gateway_id TEXT NOT NULL REFERENCES vpc_public_gateways(id),
-			private_network_id TEXT NOT NULL REFERENCES private_networks(id),
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS marketplace_labels (
-			label TEXT PRIMARY KEY
-		)`,
-		`CREATE TABLE IF NOT EXISTS lb_acls (
-			id TEXT PRIMARY KEY,
-			frontend_id TEXT NOT NULL REFERENCES lb_frontends(id) ON DELETE CASCADE,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS lb_routes (
-			id TEXT PRIMARY KEY,
-			lb_id TEXT NOT NULL REFERENCES lbs(id) ON DELETE CASCADE,
-			data JSON NOT NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS lb_certificates (
-			id TEXT PRIMARY KEY,
-			lb_id TEXT NOT NULL REFERENCES lbs(id) ON DELETE CASCADE,
-			data JSON NOT NULL
-		)`,
-	}
-
-	stmts = append(stmts, `CREATE TABLE IF NOT EXISTS schema_versions (
-		version INTEGER PRIMARY KEY
-	)`)
+	stmts := []string{`PRAGMA journal_mode = WAL`}
+	for _, t := range Tables {
+		stmts = append(stmts, t.createStatement())
+	}
 
 	for _, stmt := range stmts {
 		if _, err := r.db.Exec(stmt); err != nil {
@@ -336,7 +166,21 @@ func (r *Repository) init() error {
 		}
 	}
 
-	return r.migrate()
+	if err := r.migrate(); err != nil {
+		return err
+	}
+	for _, stmt := range referenceIndexes() {
+		if _, err := r.db.Exec(stmt); err != nil {
+			return fmt.Errorf("init indexes: %w", err)
+		}
+	}
+	if err := r.installEventTriggers(); err != nil {
+		return err
+	}
+	if err := r.discardEvents(); err != nil {
+		return err
+	}
+	return r.seedDefaultProject()
 }
 
 // migrate runs versioned schema migrations for tables that already exist but
@@ -398,39 +242,47 @@ func (r *Repository) migrate() error {
 		},
 	}
 
+	// foreign_keys is per connection, so the migrations run on one.
+	ctx := context.Background()
+	conn, err := r.pool.Load().Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	defer conn.Close()
+
 	for _, m := range migrations {
 		var applied int
-		_ = r.db.QueryRow(`SELECT 1 FROM schema_versions WHERE version = ?`, m.version).Scan(&applied)
+		_ = conn.QueryRowContext(ctx, `SELECT 1 FROM schema_versions WHERE version = ?`, m.version).Scan(&applied)
 		if applied == 1 {
 			continue
 		}
 		// Disable FK checks during table recreation to avoid constraint errors
 		// while the _new tables are being populated.
-		if _, err := r.db.Exec(`PRAGMA foreign_keys = OFF`); err != nil {
+		if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
 			return fmt.Errorf("migration %d: disable FK: %w", m.version, err)
 		}
 		for _, stmt := range m.stmts {
-			if _, err := r.db.Exec(stmt); err != nil {
-				_, _ = r.db.Exec(`PRAGMA foreign_keys = ON`)
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				_, _ = conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
 				return fmt.Errorf("migration %d: %w", m.version, err)
 			}
 		}
-		if _, err := r.db.Exec(`PRAGMA foreign_keys = ON`); err != nil {
+		if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`); err != nil {
 			return fmt.Errorf("migration %d: re-enable FK: %w", m.version, err)
 		}
-		if _, err := r.db.Exec(`INSERT INTO schema_versions (version) VALUES (?)`, m.version); err != nil {
+		if _, err := conn.ExecContext(ctx, `INSERT INTO schema_versions (version) VALUES (?)`, m.version); err != nil {
 			return fmt.Errorf("migration %d: record version: %w", m.version, err)
 		}
 	}
 	return nil
 }
 
-func nowRFC3339() string {
-	return time.Now().UTC().Format(time.RFC3339)
+func (r *Repository) nowRFC3339() string {
+	return r.Now().Format(time.RFC3339)
 }
 
-func newID() string {
-	return uuid.NewString()
+func (r *Repository) newID() string {
+	return r.NewID()
 }
 
 func (r *Repository) Exists(table, idColumn, id string) (bool, error) {
@@ -447,65 +299,37 @@ func (r *Repository) Exists(table, idColumn, id string) (bool, error) {
 }
 
 func (r *Repository) Reset() error {
-	tables := []string{
-		"lb_acls",
-		"lb_routes",
-		"lb_certificates",
-		"lb_private_networks",
-		"lb_frontends",
-		"lb_backends",
-		"lbs",
-		"lb_ips",
-		"block_snapshots",
-		"block_volumes",
-		"ipam_ips",
-		"instance_private_nics",
-		"instance_ips",
-		"instance_servers",
-		"instance_security_groups",
-		"k8s_pools",
-		"k8s_clusters",
-		"rdb_backups",
-		"rdb_snapshots",
-		"rdb_read_replicas",
-		"instance_volumes",
-		"marketplace_labels",
-		"rdb_acls",
-		"rdb_privileges",
-		"rdb_databases",
-		"rdb_users",
-		"rdb_instances",
-		"redis_clusters",
-		"registry_namespaces",
-		"iam_rules",
-		"iam_api_keys",
-		"iam_policies",
-		"iam_ssh_keys",
-		"iam_group_members",
-		"iam_groups",
-		"iam_users",
-		"iam_applications",
-		"domain_records",
-		"dns_zones",
-		"vpc_gateway_networks",
-		"vpc_public_gateways",
-		"vpc_routes",
-		"private_networks",
-		"vpcs",
-	}
-
-	if _, err := r.db.Exec(`PRAGMA foreign_keys = OFF`); err != nil {
+	if err := r.FlushEvents(); err != nil {
 		return err
 	}
-	defer func() {
-		_, _ = r.db.Exec(`PRAGMA foreign_keys = ON`)
-	}()
-
-	for _, t := range tables {
-		if _, err := r.db.Exec(fmt.Sprintf("DELETE FROM %s", t)); err != nil {
+	// Rewind first, so the default project is stamped as in a fresh
+	// repository.
+	r.restartEntropy()
+	// stateTables runs children before parents, so the deletes satisfy the
+	// foreign keys without turning them off.
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, t := range stateTables {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s", t)); err != nil {
 			return err
 		}
 	}
+	if err := r.seedDefaultProjectOn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Subscribers get one reset event instead of a delete per row.
+	if err := r.discardEvents(); err != nil {
+		return err
+	}
+	if err := r.markEvent(EventReset); err != nil {
+		return err
+	}
 	return r.clearSnapshot()
 }
 
@@ -520,37 +344,65 @@ func (r *Repository) Snapshot() error {
 }
 
 func (r *Repository) Restore() error {
-	if _, err := os.Stat(r.snapshotPath); err != nil {
+	return r.restoreFrom(r.snapshotPath)
+}
+
+// restoreFrom swaps the live database for a copy of the snapshot file at
+// src. A missing file yields ErrNotFound.
+func (r *Repository) restoreFrom(src string) error {
+	if _, err := os.Stat(src); err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return models.ErrNotFound
 		}
 		return fmt.Errorf("stat snapshot: %w", err)
 	}
+	if err := r.FlushEvents(); err != nil {
+		return err
+	}
 
 	restorePath := r.path + ".restore"
 	if err := os.Remove(restorePath); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return fmt.Errorf("remove stale restore db: %w", err)
 	}
-	if err := copyFile(r.snapshotPath, restorePath); err != nil {
+	if err := copyFile(src, restorePath); err != nil {
 		return fmt.Errorf("copy snapshot: %w", err)
 	}
-	if err := r.db.Close(); err != nil {
+	if err := r.swapDB(restorePath); err != nil {
+		return err
+	}
+	if err := r.init(); err != nil {
+		return err
+	}
+	return r.markEvent(EventRestored)
+}
+
+// swapDB replaces the database file with the one at src and reopens the
+// pool, once no transaction is open.
+func (r *Repository) swapDB(src string) error {
+	r.gate.Lock()
+	defer r.gate.Unlock()
+	if err := r.pool.Load().Close(); err != nil {
 		return fmt.Errorf("close db for restore: %w", err)
 	}
-	if err := os.Rename(restorePath, r.path); err != nil {
+	// The write-ahead log belongs to the database being replaced.
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(r.path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove %s: %w", suffix, err)
+		}
+	}
+	if err := os.Rename(src, r.path); err != nil {
 		db, reopenErr := openDB(r.path)
 		if reopenErr == nil {
-			r.db = db
+			r.pool.Store(db)
 		}
 		return fmt.Errorf("swap restored db: %w", err)
 	}
-
 	db, err := openDB(r.path)
 	if err != nil {
 		return err
 	}
-	r.db = db
-	return r.init()
+	r.pool.Store(db)
+	return nil
 }
 
 func (r *Repository) clearSnapshot() error {
@@ -658,6 +510,15 @@ func mapDeleteSQLError(err error) error {
 }
 
 func (r *Repository) insertJSON(table string, cols []colVal, data map[string]any) error {
+	if err := r.checkProjectRef(data); err != nil {
+		return err
+	}
+	if err := r.checkQuota(table, data); err != nil {
+		return err
+	}
+	if err := r.checkReferences(r.db, table, data, nil); err != nil {
+		return err
+	}
 	b, err := marshalData(data)
 	if err != nil {
 		return err
@@ -687,6 +548,9 @@ func (r *Repository) insertJSON(table string, cols []colVal, data map[string]any
 }
 
 func (r *Repository) getJSONByID(table, idColumn, id string) (map[string]any, error) {
+	if err := r.applyLifecycle(); err != nil {
+		return nil, err
+	}
 	q := fmt.Sprintf("SELECT data FROM %s WHERE %s = ?", table, idColumn)
 	var raw []byte
 	err := r.db.QueryRow(q, id).Scan(&raw)
@@ -696,22 +560,41 @@ func (r *Repository) getJSONByID(table, idColumn, id string) (map[string]any, er
 	if err != nil {
 		return nil, err
 	}
+	if r.db.state != nil {
+		r.db.state.reads[readKey(table, id)] = string(raw)
+	}
 	return unmarshalData(raw)
 }
 
-func (r *Repository) listJSON(table, whereCol, whereVal string) ([]map[string]any, error) {
+func (r *Repository) listJSON(table, whereCol, whereVal string, filters ...Filter) ([]map[string]any, error) {
+	if err := r.applyLifecycle(); err != nil {
+		return nil, err
+	}
+
 	var (
-		rows *sql.Rows
-		err  error
+		where []string
+		args  []any
 	)
+	if whereCol != "" {
+		where = append(where, whereCol+" = ?")
+		args = append(args, whereVal)
+	}
+	for _, f := range filters {
+		clause, fargs, err := f.sql()
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, clause)
+		args = append(args, fargs...)
+	}
 
-	if whereCol == "" {
-		q := fmt.Sprintf("SELECT data FROM %s", table)
-		rows, err = r.db.Query(q)
-	} else {
-		q := fmt.Sprintf("SELECT data FROM %s WHERE %s = ?", table, whereCol)
-		rows, err = r.db.Query(q, whereVal)
+	// Rows come back in insertion order so list endpoints default to
+	// created_at_asc, matching the Scaleway APIs.
+	q := fmt.Sprintf("SELECT data FROM %s", table)
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
 	}
+	rows, err := r.db.Query(q+" ORDER BY rowid", args...)
 	if err != nil {
 		return nil, err
 	}
@@ -735,29 +618,54 @@ func (r *Repository) listJSON(table, whereCol, whereVal string) ([]map[string]an
 	return out, nil
 }
 
+// deleteBy deletes the rows of table matching whereClause, applying the
+// OnDelete action of every Reference to them in the same transaction.
 func (r *Repository) deleteBy(table, whereClause string, args ...any) error {
-	q := fmt.Sprintf("DELETE FROM %s WHERE %s", table, whereClause)
-	res, err := r.db.Exec(q, args...)
-	if err != nil {
-		return mapDeleteSQLError(err)
-	}
-	affected, err := res.RowsAffected()
+	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
-	if affected == 0 {
-		return models.ErrNotFound
+	defer func() { _ = tx.Rollback() }()
+	if err := r.deleteTx(tx, table, whereClause, args...); err != nil {
+		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
+// updateJSONByID replaces the data of a row, provided it still holds what
+// the caller last saw: within Atomic, what getJSONByID returned for it, and
+// otherwise what this call read. The UPDATE compares the stored document
+// with that one, so if another write got there first it fails with
+// models.ErrConcurrentUpdate rather than overwriting it, and within Atomic
+// so does the transaction.
 func (r *Repository) updateJSONByID(table, idColumn, id string, data map[string]any) error {
+	var raw []byte
+	err := r.db.QueryRow(fmt.Sprintf("SELECT data FROM %s WHERE %s = ?", table, idColumn), id).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	expect := string(raw)
+	if r.db.state != nil {
+		if seen, ok := r.db.state.reads[readKey(table, id)]; ok {
+			expect = seen
+		}
+	}
+	prev, err := unmarshalData(raw)
+	if err != nil {
+		return err
+	}
+	if err := r.checkReferences(r.db, table, data, prev); err != nil {
+		return err
+	}
 	b, err := marshalData(data)
 	if err != nil {
 		return err
 	}
-	q := fmt.Sprintf("UPDATE %s SET data = ? WHERE %s = ?", table, idColumn)
-	res, err := r.db.Exec(q, b, id)
+	q := fmt.Sprintf("UPDATE %s SET data = ? WHERE %s = ? AND CAST(data AS TEXT) = ?", table, idColumn)
+	res, err := r.db.Exec(q, b, id, expect)
 	if err != nil {
 		return err
 	}
@@ -766,14 +674,20 @@ func (r *Repository) updateJSONByID(table, idColumn, id string, data map[string]
 		return err
 	}
 	if affected == 0 {
-		return models.ErrNotFound
+		if r.db.state != nil {
+			r.db.state.conflict = true
+		}
+		return models.ErrConcurrentUpdate
+	}
+	if r.db.state != nil {
+		r.db.state.reads[readKey(table, id)] = string(b)
 	}
 	return nil
 }
 
 func (r *Repository) createSimple(table, scopeCol, scopeVal string, data map[string]any, extra ...colVal) (map[string]any, error) {
 	data = cloneMap(data)
-	id := newID()
+	id := r.newID()
 	data["id"] = id
 
 	cols := []colVal{{name: "id", val: id}, {name: scopeCol, val: scopeVal}}
@@ -786,7 +700,7 @@ func (r *Repository) createSimple(table, scopeCol, scopeVal string, data map[str
 
 func (r *Repository) CreateVPC(region string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["created_at"] = now
 	data["updated_at"] = now
 	data["region"] = region
@@ -796,14 +710,14 @@ func (r *Repository) CreateVPC(region string, data map[string]any) (map[string]a
 func (r *Repository) GetVPC(id string) (map[string]any, error) {
 	return r.getJSONByID("vpcs", "id", id)
 }
-func (r *Repository) ListVPCs(region string) ([]map[string]any, error) {
-	return r.listJSON("vpcs", "region", region)
+func (r *Repository) ListVPCs(region string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("vpcs", "region", region, filters...)
 }
 func (r *Repository) DeleteVPC(id string) error { return r.deleteBy("vpcs", "id = ?", id) }
 
 func (r *Repository) CreatePrivateNetwork(region string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["created_at"] = now
 	data["updated_at"] = now
 	data["region"] = region
@@ -819,7 +733,7 @@ func (r *Repository) CreatePrivateNetwork(region string, data map[string]any) (m
 		}
 	}
 	data["subnets"] = []any{map[string]any{
-		"id":         newID(),
+		"id":         r.newID(),
 		"subnet":     subnet,
 		"created_at": now,
 		"updated_at": now,
@@ -831,8 +745,8 @@ func (r *Repository) CreatePrivateNetwork(region string, data map[string]any) (m
 func (r *Repository) GetPrivateNetwork(id string) (map[string]any, error) {
 	return r.getJSONByID("private_networks", "id", id)
 }
-func (r *Repository) ListPrivateNetworks(region string) ([]map[string]any, error) {
-	return r.listJSON("private_networks", "region", region)
+func (r *Repository) ListPrivateNetworks(region string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("private_networks", "region", region, filters...)
 }
 func (r *Repository) DeletePrivateNetwork(id string) error {
 	return r.deleteBy("private_networks", "id = ?", id)
@@ -870,7 +784,7 @@ func (r *Repository) ListMarketplaceLabels() ([]string, error) {
 
 func (r *Repository) CreateVPCRoute(region string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["region"] = region
 	data["created_at"] = now
 	data["updated_at"] = now
@@ -902,7 +816,7 @@ func (r *Repository) UpdateVPCRoute(id string, patch map[string]any) (map[string
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	// Keep vpc_id and region SQL columns in sync.
 	vpcID, _ := next["vpc_id"].(string)
 	region, _ := next["region"].(string)
@@ -927,7 +841,7 @@ func (r *Repository) DeleteVPCRoute(id string) error {
 
 func (r *Repository) CreateVPCPublicGateway(zone string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["zone"] = zone
 	data["status"] = "running"
 	data["created_at"] = now
@@ -948,8 +862,8 @@ func (r *Repository) GetVPCPublicGateway(id string) (map[string]any, error) {
 	return r.getJSONByID("vpc_public_gateways", "id", id)
 }
 
-func (r *Repository) ListVPCPublicGateways(zone string) ([]map[string]any, error) {
-	return r.listJSON("vpc_public_gateways", "zone", zone)
+func (r *Repository) ListVPCPublicGateways(zone string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("vpc_public_gateways", "zone", zone, filters...)
 }
 
 func (r *Repository) UpdateVPCPublicGateway(id string, patch map[string]any) (map[string]any, error) {
@@ -958,7 +872,7 @@ func (r *Repository) UpdateVPCPublicGateway(id string, patch map[string]any) (ma
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	zone, _ := next["zone"].(string)
 	b, err := marshalData(next)
 	if err != nil {
@@ -981,7 +895,7 @@ func (r *Repository) DeleteVPCPublicGateway(id string) error {
 
 func (r *Repository) CreateVPCGatewayNetwork(data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["status"] = "ready"
 	data["created_at"] = now
 	data["updated_at"] = now
@@ -990,7 +904,7 @@ func (r *Repository) CreateVPCGatewayNetwork(data map[string]any) (map[string]an
 	}
 	gatewayID, _ := data["gateway_id"].(string)
 	pnID, _ := data["private_network_id"].(string)
-	id := newID()
+	id := r.newID()
 	data["id"] = id
 	cols := []colVal{
 		{name: "id", val: id},
@@ -1020,7 +934,7 @@ func (r *Repository) UpdateVPCGatewayNetwork(id string, patch map[string]any) (m
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	// Keep gateway_id and private_network_id SQL columns in sync.
 	gatewayID, _ := next["gateway_id"].(string)
 	pnID, _ := next["private_network_id"].(string)
@@ -1043,7 +957,7 @@ func (r *Repository) DeleteVPCGatewayNetwork(id string) error {
 
 func (r *Repository) CreateSecurityGroup(zone string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["zone"] = zone
 	data["created_at"] = now
 	data["updated_at"] = now
@@ -1052,32 +966,14 @@ func (r *Repository) CreateSecurityGroup(zone string, data map[string]any) (map[
 func (r *Repository) GetSecurityGroup(id string) (map[string]any, error) {
 	return r.getJSONByID("instance_security_groups", "id", id)
 }
-func (r *Repository) ListSecurityGroups(zone string) ([]map[string]any, error) {
-	return r.listJSON("instance_security_groups", "zone", zone)
+func (r *Repository) ListSecurityGroups(zone string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("instance_security_groups", "zone", zone, filters...)
 }
-func (r *Repository) DeleteSecurityGroup(id string) error {
-	tx, err := r.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer func() { _ = tx.Rollback() }()
-
-	if err := r.detachSecurityGroupFromServersTx(tx, id); err != nil {
-		return err
-	}
 
-	res, err := tx.Exec(`DELETE FROM instance_security_groups WHERE id = ?`, id)
-	if err != nil {
-		return mapDeleteSQLError(err)
-	}
-	affected, err := res.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if affected == 0 {
-		return models.ErrNotFound
-	}
-	return tx.Commit()
+// DeleteSecurityGroup deletes a security group. Servers using it are
+// detached through References.
+func (r *Repository) DeleteSecurityGroup(id string) error {
+	return r.deleteBy("instance_security_groups", "id = ?", id)
 }
 
 func (r *Repository) UpdateSecurityGroup(id string, patch map[string]any) (map[string]any, error) {
@@ -1086,7 +982,7 @@ func (r *Repository) UpdateSecurityGroup(id string, patch map[string]any) (map[s
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	zone, _ := next["zone"].(string)
 	b, err := marshalData(next)
 	if err != nil {
@@ -1117,16 +1013,16 @@ func (r *Repository) SetSecurityGroupRules(id string, rules any) (map[string]any
 	normalized := rules
 	if ruleSlice, ok := rules.([]any); ok {
 		out := make([]any, len(ruleSlice))
-		for i, r := range ruleSlice {
-			if m, ok := r.(map[string]any); ok {
+		for i, rule := range ruleSlice {
+			if m, ok := rule.(map[string]any); ok {
 				m = cloneMap(m)
 				if m["id"] == nil || m["id"] == "" {
-					m["id"] = uuid.NewString()
+					m["id"] = r.newID()
 				}
 				m["editable"] = true
 				out[i] = m
 			} else {
-				out[i] = r
+				out[i] = rule
 			}
 		}
 		normalized = out
@@ -1151,19 +1047,35 @@ func (r *Repository) GetSecurityGroupRules(id string) ([]any, error) {
 	return rules, nil
 }
 
+// serverTransientStates maps a target server state to the state reported
+// while the action is in progress.
+var serverTransientStates = map[string]string{
+	"running":          "starting",
+	"stopped":          "stopping",
+	"stopped_in_place": "stopping",
+}
+
 func (r *Repository) SetServerState(id, state string) error {
 	server, err := r.getJSONByID("instance_servers", "id", id)
 	if err != nil {
 		return err
 	}
 	server["state"] = state
-	server["modification_date"] = nowRFC3339()
-	return r.updateJSONByID("instance_servers", "id", id, server)
+	server["modification_date"] = r.nowRFC3339()
+	if err := r.updateJSONByID("instance_servers", "id", id, server); err != nil {
+		return err
+	}
+	transient, ok := serverTransientStates[state]
+	if !ok {
+		transient = state
+	}
+	_, err = r.startTransition(LifecycleServer, server, transient)
+	return err
 }
 
 func (r *Repository) CreateServer(zone string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["zone"] = zone
 	data["state"] = "stopped"
 	data["creation_date"] = now
@@ -1202,7 +1114,7 @@ func (r *Repository) CreateServer(zone string, data map[string]any) (map[string]
 	}
 	data["volumes"] = map[string]any{
 		"0": map[string]any{
-			"id":          newID(),
+			"id":          r.newID(),
 			"name":        fmt.Sprintf("%s-vol-0", serverName),
 			"size":        20000000000,
 			"volume_type": "l_ssd",
@@ -1216,7 +1128,7 @@ func (r *Repository) CreateServer(zone string, data map[string]any) (map[string]
 		// Provider dereferences SecurityGroup.ID without nil check (server.go:693).
 		sgObjID := sgID
 		if sgObjID == "" {
-			sgObjID = newID()
+			sgObjID = r.newID()
 		}
 		data["security_group"] = map[string]any{"id": sgObjID, "name": "default"}
 	}
@@ -1246,8 +1158,8 @@ func (r *Repository) CreateServer(zone string, data map[string]any) (map[string]
 func (r *Repository) GetServer(id string) (map[string]any, error) {
 	return r.getJSONByID("instance_servers", "id", id)
 }
-func (r *Repository) ListServers(zone string) ([]map[string]any, error) {
-	return r.listJSON("instance_servers", "zone", zone)
+func (r *Repository) ListServers(zone string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("instance_servers", "zone", zone, filters...)
 }
 func (r *Repository) DeleteServer(id string) error {
 	tx, err := r.db.Begin()
@@ -1256,26 +1168,15 @@ func (r *Repository) DeleteServer(id string) error {
 	}
 	defer func() { _ = tx.Rollback() }()
 
-	if err := r.detachIPsFromServerTx(tx, id); err != nil {
-		return err
-	}
-
 	// Keep behavior consistent on older DB files created before FK CASCADE migration.
 	if _, err := tx.Exec(`DELETE FROM instance_private_nics WHERE server_id = ?`, id); err != nil {
 		return mapDeleteSQLError(err)
 	}
 
-	res, err := tx.Exec(`DELETE FROM instance_servers WHERE id = ?`, id)
-	if err != nil {
-		return mapDeleteSQLError(err)
-	}
-	affected, err := res.RowsAffected()
-	if err != nil {
+	// Attached flexible IPs are detached through References.
+	if err := r.deleteTx(tx, "instance_servers", "id = ?", id); err != nil {
 		return err
 	}
-	if affected == 0 {
-		return models.ErrNotFound
-	}
 	return tx.Commit()
 }
 
@@ -1341,7 +1242,7 @@ func (r *Repository) GetInstanceVolume(zone, volumeID string) (map[string]any, e
 
 func (r *Repository) CreateInstanceVolume(zone string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["zone"] = zone
 	data["state"] = "available"
 	data["creation_date"] = now
@@ -1359,8 +1260,8 @@ func (r *Repository) CreateInstanceVolume(zone string, data map[string]any) (map
 	return r.createSimple("instance_volumes", "zone", zone, data)
 }
 
-func (r *Repository) ListInstanceVolumes(zone string) ([]map[string]any, error) {
-	return r.listJSON("instance_volumes", "zone", zone)
+func (r *Repository) ListInstanceVolumes(zone string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("instance_volumes", "zone", zone, filters...)
 }
 
 func (r *Repository) UpdateInstanceVolume(id string, patch map[string]any) (map[string]any, error) {
@@ -1369,7 +1270,7 @@ func (r *Repository) UpdateInstanceVolume(id string, patch map[string]any) (map[
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["modification_date"] = nowRFC3339()
+	next["modification_date"] = r.nowRFC3339()
 	zone, _ := next["zone"].(string)
 	b, err := marshalData(next)
 	if err != nil {
@@ -1388,97 +1289,15 @@ func (r *Repository) DeleteStandaloneVolume(id string) error {
 	return r.deleteBy("instance_volumes", "id = ?", id)
 }
 
-func (r *Repository) detachIPsFromServerTx(tx *sql.Tx, serverID string) error {
-	rows, err := tx.Query(`SELECT id, data FROM instance_ips WHERE server_id = ?`, serverID)
-	if err != nil {
-		return err
-	}
-	defer rows.Close()
-
-	type update struct {
-		id   string
-		data map[string]any
-	}
-	updates := []update{}
-	for rows.Next() {
-		var (
-			id  string
-			raw []byte
-		)
-		if err := rows.Scan(&id, &raw); err != nil {
-			return err
-		}
-		data, err := unmarshalData(raw)
-		if err != nil {
-			return err
-		}
-		data["server_id"] = nil
-		updates = append(updates, update{id: id, data: data})
-	}
-	if err := rows.Err(); err != nil {
-		return err
-	}
-
-	for _, u := range updates {
-		b, err := marshalData(u.data)
-		if err != nil {
-			return err
-		}
-		if _, err := tx.Exec(`UPDATE instance_ips SET server_id = NULL, data = ? WHERE id = ?`, b, u.id); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (r *Repository) detachSecurityGroupFromServersTx(tx *sql.Tx, sgID string) error {
-	rows, err := tx.Query(`SELECT id, data FROM instance_servers WHERE security_group_id = ?`, sgID)
-	if err != nil {
-		return err
-	}
-	defer rows.Close()
-
-	type update struct {
-		id   string
-		data map[string]any
-	}
-	updates := []update{}
-	for rows.Next() {
-		var (
-			id  string
-			raw []byte
-		)
-		if err := rows.Scan(&id, &raw); err != nil {
-			return err
-		}
-		data, err := unmarshalData(raw)
-		if err != nil {
-			return err
-		}
-		data["security_group_id"] = nil
-		data["security_group"] = nil
-		updates = append(updates, update{id: id, data: data})
-	}
-	if err := rows.Err(); err != nil {
-		return err
-	}
-
-	for _, u := range updates {
-		b, err := marshalData(u.data)
-		if err != nil {
-			return err
-		}
-		if _, err := tx.Exec(`UPDATE instance_servers SET security_group_id = NULL, data = ? WHERE id = ?`, b, u.id); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 func (r *Repository) CreateIP(zone string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
 	data["zone"] = zone
-	data["address"] = fakePublicIP()
+	defer r.lockIPs()()
+	addr, err := r.newIPAllocator().public(zone)
+	if err != nil {
+		return nil, err
+	}
+	data["address"] = addr
 	serverID, _ := data["server_id"].(string)
 	var extras []colVal
 	if serverID != "" {
@@ -1489,8 +1308,8 @@ func (r *Repository) CreateIP(zone string, data map[string]any) (map[string]any,
 func (r *Repository) GetIP(id string) (map[string]any, error) {
 	return r.getJSONByID("instance_ips", "id", id)
 }
-func (r *Repository) ListIPs(zone string) ([]map[string]any, error) {
-	return r.listJSON("instance_ips", "zone", zone)
+func (r *Repository) ListIPs(zone string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("instance_ips", "zone", zone, filters...)
 }
 func (r *Repository) DeleteIP(id string) error { return r.deleteBy("instance_ips", "id = ?", id) }
 
@@ -1499,11 +1318,16 @@ func (r *Repository) CreatePrivateNIC(zone, serverID string, data map[string]any
 	data["server_id"] = serverID
 	data["zone"] = zone
 	data["state"] = "available"
+	pnID, _ := data["private_network_id"].(string)
+	defer r.lockIPs()()
+	addr, err := r.newIPAllocator().private(pnID)
+	if err != nil {
+		return nil, err
+	}
 	data["private_ips"] = []any{map[string]any{
-		"id":      newID(),
-		"address": fakePrivateIP(),
+		"id":      r.newID(),
+		"address": addr,
 	}}
-	pnID, _ := data["private_network_id"].(string)
 	return r.createSimple(
 		"instance_private_nics",
 		"zone",
@@ -1525,13 +1349,16 @@ func (r *Repository) DeletePrivateNIC(id string) error {
 
 func (r *Repository) CreateLB(zone string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["zone"] = zone
 	data["status"] = "ready"
 	data["created_at"] = now
 	data["updated_at"] = now
-	id := newID()
+	id := r.newID()
 	data["id"] = id
+	if err := r.applyProjectDefaults(data); err != nil {
+		return nil, err
+	}
 
 	// Resolve effective IP ID: ip_ids (array, newer field) takes precedence over ip_id (string).
 	resolvedIPID := ""
@@ -1546,18 +1373,26 @@ func (r *Repository) CreateLB(zone string, data map[string]any) (map[string]any,
 		}
 	}
 
+	defer r.lockIPs()()
+
 	// If an IP ID was resolved, use the existing LB IP; otherwise generate one inline.
-	ipEntry := map[string]any{
-		"id":              newID(),
-		"ip_address":      fakePublicIP(),
-		"lb_id":           id,
-		"reverse":         "",
-		"organization_id": "00000000-0000-0000-0000-000000000000",
-		"project_id":      "00000000-0000-0000-0000-000000000000",
-		"zone":            zone,
-		"region":          regionFromZone(zone),
-	}
-	if resolvedIPID != "" {
+	var ipEntry map[string]any
+	if resolvedIPID == "" {
+		addr, err := r.newIPAllocator().public(zone)
+		if err != nil {
+			return nil, err
+		}
+		ipEntry = map[string]any{
+			"id":              r.newID(),
+			"ip_address":      addr,
+			"lb_id":           id,
+			"reverse":         "",
+			"organization_id": data["organization_id"],
+			"project_id":      data["project_id"],
+			"zone":            zone,
+			"region":          regionFromZone(zone),
+		}
+	} else {
 		existing, err := r.GetLBIP(resolvedIPID)
 		if err != nil {
 			return nil, models.ErrNotFound
@@ -1580,11 +1415,13 @@ func (r *Repository) CreateLB(zone string, data map[string]any) (map[string]any,
 			return nil, fmt.Errorf("persist lb_id to lb_ips: %w", err)
 		}
 	}
-	return data, nil
+	return r.startTransition(LifecycleLB, data, "creating")
+}
+func (r *Repository) GetLB(id string) (map[string]any, error) {
+	return r.getWithLifecycle(LifecycleLB, id)
 }
-func (r *Repository) GetLB(id string) (map[string]any, error) { return r.getJSONByID("lbs", "id", id) }
-func (r *Repository) ListLBs(zone string) ([]map[string]any, error) {
-	return r.listJSON("lbs", "zone", zone)
+func (r *Repository) ListLBs(zone string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("lbs", "zone", zone, filters...)
 }
 func (r *Repository) UpdateLB(id string, patch map[string]any) (map[string]any, error) {
 	current, err := r.getJSONByID("lbs", "id", id)
@@ -1592,7 +1429,7 @@ func (r *Repository) UpdateLB(id string, patch map[string]any) (map[string]any,
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	zone, _ := next["zone"].(string)
 	b, err := marshalData(next)
 	if err != nil {
@@ -1608,88 +1445,52 @@ func (r *Repository) UpdateLB(id string, patch map[string]any) (map[string]any,
 }
 
 func (r *Repository) DeleteLB(id string) error {
+	last := r.lifecycleSnapshot(LifecycleLB, id)
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer func() { _ = tx.Rollback() }()
 
-	// Cascade private-network attachments (the Scaleway provider does not
-	// detach them before deleting the LB). Frontends and backends are NOT
-	// cascaded — the provider deletes those explicitly, and 409 correctly
-	// signals if any remain.
-	if _, err := tx.Exec("DELETE FROM lb_private_networks WHERE lb_id = ?", id); err != nil {
-		return mapDeleteSQLError(err)
-	}
-
-	// Detach any LB IPs — clear lb_id so the IP can be reused or deleted.
-	// Read current IP data, clear lb_id, write back.
-	ipRows, err := tx.Query("SELECT id, data FROM lb_ips WHERE zone IN (SELECT zone FROM lbs WHERE id = ?)", id)
-	if err == nil {
-		type ipUpdate struct {
-			id   string
-			data []byte
-		}
-		var updates []ipUpdate
-		for ipRows.Next() {
-			var ipID string
-			var raw []byte
-			if err := ipRows.Scan(&ipID, &raw); err != nil {
-				ipRows.Close()
-				break
-			}
-			ipData, err := unmarshalData(raw)
-			if err != nil {
-				continue
-			}
-			if lbID, _ := ipData["lb_id"].(string); lbID == id {
-				ipData["lb_id"] = nil
-				b, _ := marshalData(ipData)
-				updates = append(updates, ipUpdate{id: ipID, data: b})
-			}
-		}
-		ipRows.Close()
-		for _, u := range updates {
-			if _, err := tx.Exec("UPDATE lb_ips SET data = ? WHERE id = ?", u.data, u.id); err != nil {
-				return fmt.Errorf("detach lb_ip %s: %w", u.id, err)
-			}
-		}
-	}
-
-	res, err := tx.Exec("DELETE FROM lbs WHERE id = ?", id)
-	if err != nil {
-		return mapDeleteSQLError(err)
-	}
-	affected, err := res.RowsAffected()
-	if err != nil {
+	// Private-network attachments are cascaded (the Scaleway provider does
+	// not detach them before deleting the LB) and LB IPs detached through
+	// References. Frontends and backends are NOT cascaded — the provider
+	// deletes those explicitly, and 409 correctly signals if any remain.
+	if err := r.deleteTx(tx, "lbs", "id = ?", id); err != nil {
 		return err
 	}
-	if affected == 0 {
-		return models.ErrNotFound
+	if err := tx.Commit(); err != nil {
+		return err
 	}
-	return tx.Commit()
+	return r.tombstone(LifecycleLB, id, last)
 }
 
 func (r *Repository) CreateLBIP(zone string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["zone"] = zone
-	data["ip_address"] = fakePublicIP()
+	defer r.lockIPs()()
+	addr, err := r.newIPAllocator().public(zone)
+	if err != nil {
+		return nil, err
+	}
+	data["ip_address"] = addr
 	data["status"] = "ready"
 	data["created_at"] = now
 	data["updated_at"] = now
 	data["lb_id"] = nil
 	data["reverse"] = ""
-	data["organization_id"] = "00000000-0000-0000-0000-000000000000"
-	data["project_id"] = "00000000-0000-0000-0000-000000000000"
 	data["region"] = regionFromZone(zone)
+	if err := r.applyProjectDefaults(data); err != nil {
+		return nil, err
+	}
 	return r.createSimple("lb_ips", "zone", zone, data)
 }
 func (r *Repository) GetLBIP(id string) (map[string]any, error) {
 	return r.getJSONByID("lb_ips", "id", id)
 }
-func (r *Repository) ListLBIPs(zone string) ([]map[string]any, error) {
-	return r.listJSON("lb_ips", "zone", zone)
+func (r *Repository) ListLBIPs(zone string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("lb_ips", "zone", zone, filters...)
 }
 func (r *Repository) DeleteLBIP(id string) error {
 	// Block deletion if the IP is attached to a load balancer.
@@ -1706,7 +1507,7 @@ func (r *Repository) DeleteLBIP(id string) error {
 func (r *Repository) CreateFrontend(data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
 	lbID, _ := data["lb_id"].(string)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["created_at"] = now
 	data["updated_at"] = now
 	// The provider accesses res.LB.ID and res.Backend.ID after create.
@@ -1717,11 +1518,9 @@ func (r *Repository) CreateFrontend(data map[string]any) (map[string]any, error)
 		}
 	}
 	if backendID, ok := data["backend_id"].(string); ok && backendID != "" {
-		backend, err := r.GetBackend(backendID)
-		if err != nil {
-			return nil, models.ErrNotFound
+		if backend, err := r.GetBackend(backendID); err == nil {
+			data["backend"] = backend
 		}
-		data["backend"] = backend
 	}
 	return r.createSimple("lb_frontends", "lb_id", lbID, data)
 }
@@ -1731,8 +1530,8 @@ func (r *Repository) GetFrontend(id string) (map[string]any, error) {
 func (r *Repository) ListFrontends() ([]map[string]any, error) {
 	return r.listJSON("lb_frontends", "", "")
 }
-func (r *Repository) ListFrontendsByLB(lbID string) ([]map[string]any, error) {
-	return r.listJSON("lb_frontends", "lb_id", lbID)
+func (r *Repository) ListFrontendsByLB(lbID string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("lb_frontends", "lb_id", lbID, filters...)
 }
 func (r *Repository) UpdateFrontend(id string, patch map[string]any) (map[string]any, error) {
 	current, err := r.getJSONByID("lb_frontends", "id", id)
@@ -1740,14 +1539,15 @@ func (r *Repository) UpdateFrontend(id string, patch map[string]any) (map[string
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	if backendID, ok := next["backend_id"].(string); ok && backendID != "" {
-		backend, err := r.GetBackend(backendID)
-		if err != nil {
-			return nil, models.ErrNotFound
+	if err := r.checkReferences(r.db, "lb_frontends", next, current); err != nil {
+		return nil, err
+	}
+	if backendID, ok := next["backend_id"].(string); ok && backendID != "" {
+		if backend, err := r.GetBackend(backendID); err == nil {
+			next["backend"] = backend
 		}
-		next["backend"] = backend
 	}
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	lbID, _ := next["lb_id"].(string)
 	b, err := marshalData(next)
 	if err != nil {
@@ -1767,7 +1567,7 @@ func (r *Repository) DeleteFrontend(id string) error { return r.deleteBy("lb_fro
 func (r *Repository) CreateBackend(data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
 	lbID, _ := data["lb_id"].(string)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["created_at"] = now
 	data["updated_at"] = now
 	// The provider accesses res.LB.ID after create.
@@ -1811,8 +1611,8 @@ func (r *Repository) GetBackend(id string) (map[string]any, error) {
 func (r *Repository) ListBackends() ([]map[string]any, error) {
 	return r.listJSON("lb_backends", "", "")
 }
-func (r *Repository) ListBackendsByLB(lbID string) ([]map[string]any, error) {
-	return r.listJSON("lb_backends", "lb_id", lbID)
+func (r *Repository) ListBackendsByLB(lbID string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("lb_backends", "lb_id", lbID, filters...)
 }
 func (r *Repository) UpdateBackend(id string, patch map[string]any) (map[string]any, error) {
 	current, err := r.getJSONByID("lb_backends", "id", id)
@@ -1820,7 +1620,7 @@ func (r *Repository) UpdateBackend(id string, patch map[string]any) (map[string]
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	lbID, _ := next["lb_id"].(string)
 	b, err := marshalData(next)
 	if err != nil {
@@ -1838,12 +1638,17 @@ func (r *Repository) UpdateBackend(id string, patch map[string]any) (map[string]
 func (r *Repository) DeleteBackend(id string) error { return r.deleteBy("lb_backends", "id = ?", id) }
 
 func (r *Repository) AttachLBPrivateNetwork(lbID, privateNetworkID string) (map[string]any, error) {
-	now := nowRFC3339()
+	defer r.lockIPs()()
+	addr, err := r.newIPAllocator().private(privateNetworkID)
+	if err != nil {
+		return nil, err
+	}
+	now := r.nowRFC3339()
 	data := map[string]any{
 		"lb_id":              lbID,
 		"private_network_id": privateNetworkID,
 		"status":             "ready",
-		"ip_address":         []any{fakePrivateIP()},
+		"ip_address":         []any{addr},
 		"dhcp_config":        map[string]any{},
 		"static_config":      nil,
 		"created_at":         now,
@@ -1863,7 +1668,7 @@ func (r *Repository) DeleteLBPrivateNetwork(lbID, privateNetworkID string) error
 
 func (r *Repository) CreateCluster(region string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["region"] = region
 	data["status"] = "ready"
 	data["created_at"] = now
@@ -1943,11 +1748,8 @@ func (r *Repository) CreateCluster(region string, data map[string]any) (map[stri
 	if _, ok := data["tags"]; !ok {
 		data["tags"] = []any{}
 	}
-	if _, ok := data["organization_id"]; !ok {
-		data["organization_id"] = "00000000-0000-0000-0000-000000000000"
-	}
-	if _, ok := data["project_id"]; !ok {
-		data["project_id"] = "00000000-0000-0000-0000-000000000000"
+	if err := r.applyProjectDefaults(data); err != nil {
+		return nil, err
 	}
 
 	pnID, _ := data["private_network_id"].(string)
@@ -1955,13 +1757,17 @@ func (r *Repository) CreateCluster(region string, data map[string]any) (map[stri
 	if pnID != "" {
 		extras = append(extras, colVal{name: "private_network_id", val: pnID})
 	}
-	return r.createSimple("k8s_clusters", "region", region, data, extras...)
+	out, err := r.createSimple("k8s_clusters", "region", region, data, extras...)
+	if err != nil {
+		return nil, err
+	}
+	return r.startTransition(LifecycleK8sCluster, out, "creating")
 }
 func (r *Repository) GetCluster(id string) (map[string]any, error) {
-	return r.getJSONByID("k8s_clusters", "id", id)
+	return r.getWithLifecycle(LifecycleK8sCluster, id)
 }
-func (r *Repository) ListClusters(region string) ([]map[string]any, error) {
-	return r.listJSON("k8s_clusters", "region", region)
+func (r *Repository) ListClusters(region string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("k8s_clusters", "region", region, filters...)
 }
 func (r *Repository) UpdateCluster(id string, patch map[string]any) (map[string]any, error) {
 	current, err := r.getJSONByID("k8s_clusters", "id", id)
@@ -1978,7 +1784,7 @@ func (r *Repository) UpdateCluster(id string, patch map[string]any) (map[string]
 		}
 		next["auto_upgrade"] = au
 	}
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	// Keep region and private_network_id SQL columns in sync.
 	region, _ := next["region"].(string)
 	var pnIDArg any
@@ -1999,6 +1805,7 @@ func (r *Repository) UpdateCluster(id string, patch map[string]any) (map[string]
 }
 
 func (r *Repository) DeleteCluster(id string) error {
+	last := r.lifecycleSnapshot(LifecycleK8sCluster, id)
 	res, err := r.db.Exec("DELETE FROM k8s_clusters WHERE id = ?", id)
 	if err != nil {
 		return mapDeleteSQLError(err)
@@ -2010,12 +1817,12 @@ func (r *Repository) DeleteCluster(id string) error {
 	if affected == 0 {
 		return models.ErrNotFound
 	}
-	return nil
+	return r.tombstone(LifecycleK8sCluster, id, last)
 }
 
 func (r *Repository) CreatePool(region, clusterID string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["region"] = region
 	data["cluster_id"] = clusterID
 	data["status"] = "ready"
@@ -2071,13 +1878,17 @@ func (r *Repository) CreatePool(region, clusterID string, data map[string]any) (
 		data["autoscaling"] = false
 	}
 
-	return r.createSimple("k8s_pools", "region", region, data, colVal{name: "cluster_id", val: clusterID})
+	out, err := r.createSimple("k8s_pools", "region", region, data, colVal{name: "cluster_id", val: clusterID})
+	if err != nil {
+		return nil, err
+	}
+	return r.startTransition(LifecycleK8sPool, out, "scaling")
 }
 func (r *Repository) GetPool(id string) (map[string]any, error) {
-	return r.getJSONByID("k8s_pools", "id", id)
+	return r.getWithLifecycle(LifecycleK8sPool, id)
 }
-func (r *Repository) ListPoolsByCluster(clusterID string) ([]map[string]any, error) {
-	return r.listJSON("k8s_pools", "cluster_id", clusterID)
+func (r *Repository) ListPoolsByCluster(clusterID string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("k8s_pools", "cluster_id", clusterID, filters...)
 }
 
 func (r *Repository) ListAllPools() ([]map[string]any, error) {
@@ -2089,7 +1900,7 @@ func (r *Repository) UpdatePool(id string, patch map[string]any) (map[string]any
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	// Keep cluster_id and region SQL columns in sync.
 	clusterID, _ := next["cluster_id"].(string)
 	region, _ := next["region"].(string)
@@ -2106,11 +1917,17 @@ func (r *Repository) UpdatePool(id string, patch map[string]any) (map[string]any
 	return next, nil
 }
 
-func (r *Repository) DeletePool(id string) error { return r.deleteBy("k8s_pools", "id = ?", id) }
+func (r *Repository) DeletePool(id string) error {
+	last := r.lifecycleSnapshot(LifecycleK8sPool, id)
+	if err := r.deleteBy("k8s_pools", "id = ?", id); err != nil {
+		return err
+	}
+	return r.tombstone(LifecycleK8sPool, id, last)
+}
 
 func (r *Repository) CreateRDBInstance(region string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["region"] = region
 	data["status"] = "ready"
 	data["created_at"] = now
@@ -2120,14 +1937,19 @@ func (r *Repository) CreateRDBInstance(region string, data map[string]any) (map[
 		// The provider classifies endpoint type by checking load_balancer != nil.
 		// The id is required for endpoint deletion references.
 		data["endpoints"] = []any{map[string]any{
-			"id":              newID(),
-			"ip":              fakePublicIP(),
+			"id":              r.newID(),
 			"port":            port,
 			"name":            nil,
 			"load_balancer":   map[string]any{},
 			"private_network": nil,
 		}}
 	}
+	defer r.lockIPs()()
+	if eps, ok := data["endpoints"].([]any); ok {
+		if err := r.newIPAllocator().endpoints(eps, regionZone(region), "ip"); err != nil {
+			return nil, err
+		}
+	}
 	// Fields required by the TF provider's ResourceRdbInstanceRead to avoid nil derefs.
 	if _, ok := data["volume"]; !ok {
 		data["volume"] = map[string]any{"type": "lssd", "size": float64(10000000000)}
@@ -2177,11 +1999,8 @@ func (r *Repository) CreateRDBInstance(region string, data map[string]any) (map[
 	if _, ok := data["upgradable_version"]; !ok {
 		data["upgradable_version"] = []any{}
 	}
-	if _, ok := data["organization_id"]; !ok {
-		data["organization_id"] = "00000000-0000-0000-0000-000000000000"
-	}
-	if _, ok := data["project_id"]; !ok {
-		data["project_id"] = "00000000-0000-0000-0000-000000000000"
+	if err := r.applyProjectDefaults(data); err != nil {
+		return nil, err
 	}
 	if _, ok := data["read_replicas"]; !ok {
 		data["read_replicas"] = []any{}
@@ -2189,13 +2008,17 @@ func (r *Repository) CreateRDBInstance(region string, data map[string]any) (map[
 	if _, ok := data["maintenances"]; !ok {
 		data["maintenances"] = []any{}
 	}
-	return r.createSimple("rdb_instances", "region", region, data)
+	out, err := r.createSimple("rdb_instances", "region", region, data)
+	if err != nil {
+		return nil, err
+	}
+	return r.startTransition(LifecycleRDBInstance, out, "provisioning")
 }
 func (r *Repository) GetRDBInstance(id string) (map[string]any, error) {
-	return r.getJSONByID("rdb_instances", "id", id)
+	return r.getWithLifecycle(LifecycleRDBInstance, id)
 }
-func (r *Repository) ListRDBInstances(region string) ([]map[string]any, error) {
-	return r.listJSON("rdb_instances", "region", region)
+func (r *Repository) ListRDBInstances(region string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("rdb_instances", "region", region, filters...)
 }
 func (r *Repository) UpdateRDBInstance(id string, patch map[string]any) (map[string]any, error) {
 	current, err := r.getJSONByID("rdb_instances", "id", id)
@@ -2220,7 +2043,7 @@ func (r *Repository) UpdateRDBInstance(id string, patch map[string]any) (map[str
 			delete(next, key)
 		}
 	}
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	region, _ := next["region"].(string)
 	b, err := marshalData(next)
 	if err != nil {
@@ -2236,6 +2059,7 @@ func (r *Repository) UpdateRDBInstance(id string, patch map[string]any) (map[str
 }
 
 func (r *Repository) DeleteRDBInstance(id string) error {
+	last := r.lifecycleSnapshot(LifecycleRDBInstance, id)
 	res, err := r.db.Exec("DELETE FROM rdb_instances WHERE id = ?", id)
 	if err != nil {
 		return mapDeleteSQLError(err)
@@ -2247,7 +2071,7 @@ func (r *Repository) DeleteRDBInstance(id string) error {
 	if affected == 0 {
 		return models.ErrNotFound
 	}
-	return nil
+	return r.tombstone(LifecycleRDBInstance, id, last)
 }
 
 func (r *Repository) CreateRDBDatabase(instanceID, name string, data map[string]any) (map[string]any, error) {
@@ -2442,7 +2266,7 @@ func (r *Repository) CreateDNSZone(data map[string]any) (map[string]any, error)
 	if subdomain != "" {
 		dnsZone = subdomain + "." + domain
 	}
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["status"] = "active"
 	data["created_at"] = now
 	data["updated_at"] = now
@@ -2455,8 +2279,11 @@ func (r *Repository) CreateDNSZone(data map[string]any) (map[string]any, error)
 	if _, ok := data["ns_master"]; !ok {
 		data["ns_master"] = []any{}
 	}
-	if _, ok := data["project_id"]; !ok {
-		data["project_id"] = "00000000-0000-0000-0000-000000000000"
+	if err := r.applyProjectDefaults(data); err != nil {
+		return nil, err
+	}
+	if err := r.checkQuota("dns_zones", data); err != nil {
+		return nil, err
 	}
 	b, err := marshalData(data)
 	if err != nil {
@@ -2489,7 +2316,7 @@ func (r *Repository) UpdateDNSZone(dnsZone string, patch map[string]any) (map[st
 		return nil, err
 	}
 	next := patchMerge(current, patch, "domain", "subdomain")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	b, err := marshalData(next)
 	if err != nil {
 		return nil, err
@@ -2500,11 +2327,8 @@ func (r *Repository) UpdateDNSZone(dnsZone string, patch map[string]any) (map[st
 	return next, nil
 }
 
+// DeleteDNSZone deletes a zone and, through References, its records.
 func (r *Repository) DeleteDNSZone(dnsZone string) error {
-	// Cascade-delete records in this zone.
-	if _, err := r.db.Exec("DELETE FROM domain_records WHERE dns_zone = ?", dnsZone); err != nil {
-		return err
-	}
 	return r.deleteBy("dns_zones", "dns_zone = ?", dnsZone)
 }
 
@@ -2526,7 +2350,7 @@ func (r *Repository) PatchDomainRecords(dnsZone string, changes []any) ([]map[st
 					continue
 				}
 				recMap = cloneMap(recMap)
-				recMap["id"] = newID()
+				recMap["id"] = r.newID()
 				if err := r.insertJSON("domain_records", []colVal{{name: "id", val: recMap["id"]}, {name: "dns_zone", val: dnsZone}}, recMap); err != nil {
 					return nil, err
 				}
@@ -2563,7 +2387,7 @@ func (r *Repository) PatchDomainRecords(dnsZone string, changes []any) ([]map[st
 						continue
 					}
 					recMap = cloneMap(recMap)
-					recMap["id"] = newID()
+					recMap["id"] = r.newID()
 					if err := r.insertJSON("domain_records", []colVal{{name: "id", val: recMap["id"]}, {name: "dns_zone", val: dnsZone}}, recMap); err != nil {
 						return nil, err
 					}
@@ -2588,10 +2412,10 @@ func (r *Repository) DeleteDomainRecord(id string) error {
 
 func (r *Repository) CreateIAMApplication(data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["created_at"] = now
 	data["updated_at"] = now
-	id := newID()
+	id := r.newID()
 	data["id"] = id
 	if err := r.insertJSON("iam_applications", []colVal{{name: "id", val: id}}, data); err != nil {
 		return nil, err
@@ -2603,8 +2427,8 @@ func (r *Repository) GetIAMApplication(id string) (map[string]any, error) {
 	return r.getJSONByID("iam_applications", "id", id)
 }
 
-func (r *Repository) ListIAMApplications() ([]map[string]any, error) {
-	return r.listJSON("iam_applications", "", "")
+func (r *Repository) ListIAMApplications(filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("iam_applications", "", "", filters...)
 }
 
 func (r *Repository) DeleteIAMApplication(id string) error {
@@ -2613,10 +2437,10 @@ func (r *Repository) DeleteIAMApplication(id string) error {
 
 func (r *Repository) CreateIAMAPIKey(data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
-	accessKey := "SCW" + randomAlphaNum(17)
+	now := r.nowRFC3339()
+	accessKey := "SCW" + r.randomAlphaNum(17)
 	data["access_key"] = accessKey
-	data["secret_key"] = newID()
+	data["secret_key"] = r.newID()
 	data["created_at"] = now
 	data["updated_at"] = now
 
@@ -2642,8 +2466,8 @@ func (r *Repository) GetIAMAPIKey(accessKey string) (map[string]any, error) {
 	return out, nil
 }
 
-func (r *Repository) ListIAMAPIKeys() ([]map[string]any, error) {
-	items, err := r.listJSON("iam_api_keys", "", "")
+func (r *Repository) ListIAMAPIKeys(filters ...Filter) ([]map[string]any, error) {
+	items, err := r.listJSON("iam_api_keys", "", "", filters...)
 	if err != nil {
 		return nil, err
 	}
@@ -2659,11 +2483,11 @@ func (r *Repository) DeleteIAMAPIKey(accessKey string) error {
 
 func (r *Repository) CreateIAMPolicy(data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["created_at"] = now
 	data["updated_at"] = now
 
-	policyID := newID()
+	policyID := r.newID()
 	data["id"] = policyID
 	var appID any
 	if v, ok := data["application_id"].(string); ok && strings.TrimSpace(v) != "" {
@@ -2685,7 +2509,7 @@ func (r *Repository) CreateIAMPolicy(data map[string]any) (map[string]any, error
 					continue
 				}
 				ruleData = cloneMap(ruleData)
-				ruleData["id"] = newID()
+				ruleData["id"] = r.newID()
 				ruleData["policy_id"] = policyID
 				if err := r.insertJSON("iam_rules", []colVal{
 					{name: "id", val: ruleData["id"]},
@@ -2704,8 +2528,8 @@ func (r *Repository) GetIAMPolicy(id string) (map[string]any, error) {
 	return r.getJSONByID("iam_policies", "id", id)
 }
 
-func (r *Repository) ListIAMPolicies() ([]map[string]any, error) {
-	return r.listJSON("iam_policies", "", "")
+func (r *Repository) ListIAMPolicies(filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("iam_policies", "", "", filters...)
 }
 
 func (r *Repository) DeleteIAMPolicy(id string) error {
@@ -2714,7 +2538,7 @@ func (r *Repository) DeleteIAMPolicy(id string) error {
 
 func (r *Repository) CreateIAMRule(data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	id := newID()
+	id := r.newID()
 	data["id"] = id
 	policyID, _ := data["policy_id"].(string)
 	if err := r.insertJSON("iam_rules", []colVal{{name: "id", val: id}, {name: "policy_id", val: policyID}}, data); err != nil {
@@ -2747,7 +2571,7 @@ func (r *Repository) SetIAMRules(policyID string, rules []any) ([]map[string]any
 			continue
 		}
 		ruleMap = cloneMap(ruleMap)
-		id := newID()
+		id := r.newID()
 		ruleMap["id"] = id
 		ruleMap["policy_id"] = policyID
 		data, err := json.Marshal(ruleMap)
@@ -2768,7 +2592,7 @@ func (r *Repository) UpdateVPC(id string, patch map[string]any) (map[string]any,
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	// Keep the indexed region SQL column in sync so ListVPCs filtering stays correct.
 	region, _ := next["region"].(string)
 	b, err := marshalData(next)
@@ -2787,7 +2611,7 @@ func (r *Repository) UpdatePrivateNetwork(id string, patch map[string]any) (map[
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	// Keep vpc_id and region SQL columns in sync so FK cascades and list
 	// filtering remain correct after a PATCH that moves the network to a new VPC.
 	vpcID, _ := next["vpc_id"].(string)
@@ -2815,7 +2639,7 @@ func (r *Repository) UpdateServer(id string, patch map[string]any) (map[string]a
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["modification_date"] = nowRFC3339()
+	next["modification_date"] = r.nowRFC3339()
 
 	// Reconcile security_group and security_group_id so that both JSON fields
 	// and the SQL FK column stay consistent after a patch.
@@ -2950,7 +2774,7 @@ func (r *Repository) UpdateIAMApplication(id string, patch map[string]any) (map[
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	if err := r.updateJSONByID("iam_applications", "id", id, next); err != nil {
 		return nil, err
 	}
@@ -2963,7 +2787,7 @@ func (r *Repository) UpdateIAMAPIKey(accessKey string, patch map[string]any) (ma
 		return nil, err
 	}
 	next := patchMerge(current, patch, "access_key")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	delete(next, "secret_key") // never return secret_key on update
 	// Keep application_id SQL column in sync when the FK field changes.
 	var appIDArg any
@@ -2990,7 +2814,7 @@ func (r *Repository) UpdateIAMPolicy(id string, patch map[string]any) (map[strin
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	// Keep application_id SQL column in sync when the FK field changes.
 	var appIDArg any
 	if v, ok := next["application_id"].(string); ok && v != "" {
@@ -3016,7 +2840,7 @@ func (r *Repository) UpdateIAMSSHKey(id string, patch map[string]any) (map[strin
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	if err := r.updateJSONByID("iam_ssh_keys", "id", id, next); err != nil {
 		return nil, err
 	}
@@ -3025,11 +2849,11 @@ func (r *Repository) UpdateIAMSSHKey(id string, patch map[string]any) (map[strin
 
 func (r *Repository) CreateIAMSSHKey(data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["created_at"] = now
 	data["updated_at"] = now
-	data["fingerprint"] = "256 SHA256:" + randomAlphaNum(32)
-	id := newID()
+	data["fingerprint"] = "256 SHA256:" + r.randomAlphaNum(32)
+	id := r.newID()
 	data["id"] = id
 	if err := r.insertJSON("iam_ssh_keys", []colVal{{name: "id", val: id}}, data); err != nil {
 		return nil, err
@@ -3041,8 +2865,8 @@ func (r *Repository) GetIAMSSHKey(id string) (map[string]any, error) {
 	return r.getJSONByID("iam_ssh_keys", "id", id)
 }
 
-func (r *Repository) ListIAMSSHKeys() ([]map[string]any, error) {
-	return r.listJSON("iam_ssh_keys", "", "")
+func (r *Repository) ListIAMSSHKeys(filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("iam_ssh_keys", "", "", filters...)
 }
 
 func (r *Repository) DeleteIAMSSHKey(id string) error {
@@ -3053,8 +2877,8 @@ func (r *Repository) DeleteIAMSSHKey(id string) error {
 
 func (r *Repository) CreateRedisCluster(zone string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	id := newID()
-	now := nowRFC3339()
+	id := r.newID()
+	now := r.nowRFC3339()
 	data["id"] = id
 	data["zone"] = zone
 	data["status"] = "ready"
@@ -3064,11 +2888,8 @@ func (r *Repository) CreateRedisCluster(zone string, data map[string]any) (map[s
 	if _, ok := data["tls_enabled"]; !ok {
 		data["tls_enabled"] = false
 	}
-	if _, ok := data["organization_id"]; !ok {
-		data["organization_id"] = "00000000-0000-0000-0000-000000000000"
-	}
-	if _, ok := data["project_id"]; !ok {
-		data["project_id"] = "00000000-0000-0000-0000-000000000000"
+	if err := r.applyProjectDefaults(data); err != nil {
+		return nil, err
 	}
 	data["created_at"] = now
 	data["updated_at"] = now
@@ -3080,8 +2901,7 @@ func (r *Repository) CreateRedisCluster(zone string, data map[string]any) (map[s
 	}
 	if _, ok := data["endpoints"]; !ok {
 		data["endpoints"] = []any{map[string]any{
-			"id":   newID(),
-			"ips":  []any{fakePrivateIP()},
+			"id":   r.newID(),
 			"port": float64(6379),
 		}}
 	} else if eps, ok := data["endpoints"].([]any); ok {
@@ -3092,7 +2912,7 @@ func (r *Repository) CreateRedisCluster(zone string, data map[string]any) (map[s
 					m["port"] = float64(6379)
 				}
 				if _, hasID := m["id"]; !hasID {
-					m["id"] = newID()
+					m["id"] = r.newID()
 				}
 			}
 		}
@@ -3107,6 +2927,12 @@ func (r *Repository) CreateRedisCluster(zone string, data map[string]any) (map[s
 		data["user_name"] = "default"
 	}
 
+	defer r.lockIPs()()
+	if eps, ok := data["endpoints"].([]any); ok {
+		if err := r.newIPAllocator().endpoints(eps, zone, "ips"); err != nil {
+			return nil, err
+		}
+	}
 	cols := []colVal{
 		{name: "id", val: id},
 		{name: "zone", val: zone},
@@ -3114,15 +2940,15 @@ func (r *Repository) CreateRedisCluster(zone string, data map[string]any) (map[s
 	if err := r.insertJSON("redis_clusters", cols, data); err != nil {
 		return nil, err
 	}
-	return data, nil
+	return r.startTransition(LifecycleRedisCluster, data, "provisioning")
 }
 
 func (r *Repository) GetRedisCluster(id string) (map[string]any, error) {
-	return r.getJSONByID("redis_clusters", "id", id)
+	return r.getWithLifecycle(LifecycleRedisCluster, id)
 }
 
-func (r *Repository) ListRedisClusters(zone string) ([]map[string]any, error) {
-	return r.listJSON("redis_clusters", "zone", zone)
+func (r *Repository) ListRedisClusters(zone string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("redis_clusters", "zone", zone, filters...)
 }
 
 func (r *Repository) UpdateRedisCluster(id string, patch map[string]any) (map[string]any, error) {
@@ -3131,7 +2957,7 @@ func (r *Repository) UpdateRedisCluster(id string, patch map[string]any) (map[st
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	// Default port to 6379 on any endpoint missing it — the provider may
 	// send endpoints with private_network/ipam_config but omit port.
 	if eps, ok := next["endpoints"].([]any); ok {
@@ -3158,26 +2984,27 @@ func (r *Repository) UpdateRedisCluster(id string, patch map[string]any) (map[st
 }
 
 func (r *Repository) DeleteRedisCluster(id string) error {
-	return r.deleteBy("redis_clusters", "id = ?", id)
+	last := r.lifecycleSnapshot(LifecycleRedisCluster, id)
+	if err := r.deleteBy("redis_clusters", "id = ?", id); err != nil {
+		return err
+	}
+	return r.tombstone(LifecycleRedisCluster, id, last)
 }
 
 // --- IAM Users ---
 
 func (r *Repository) CreateIAMUser(data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["created_at"] = now
 	data["updated_at"] = now
 	if _, ok := data["status"]; !ok {
 		data["status"] = "activated"
 	}
-	if _, ok := data["organization_id"]; !ok {
-		data["organization_id"] = "00000000-0000-0000-0000-000000000000"
-	}
-	if _, ok := data["project_id"]; !ok {
-		data["project_id"] = "00000000-0000-0000-0000-000000000000"
+	if err := r.applyProjectDefaults(data); err != nil {
+		return nil, err
 	}
-	id := newID()
+	id := r.newID()
 	data["id"] = id
 	if err := r.insertJSON("iam_users", []colVal{{name: "id", val: id}}, data); err != nil {
 		return nil, err
@@ -3189,8 +3016,8 @@ func (r *Repository) GetIAMUser(id string) (map[string]any, error) {
 	return r.getJSONByID("iam_users", "id", id)
 }
 
-func (r *Repository) ListIAMUsers() ([]map[string]any, error) {
-	return r.listJSON("iam_users", "", "")
+func (r *Repository) ListIAMUsers(filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("iam_users", "", "", filters...)
 }
 
 func (r *Repository) UpdateIAMUser(id string, patch map[string]any) (map[string]any, error) {
@@ -3199,7 +3026,7 @@ func (r *Repository) UpdateIAMUser(id string, patch map[string]any) (map[string]
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	if err := r.updateJSONByID("iam_users", "id", id, next); err != nil {
 		return nil, err
 	}
@@ -3243,17 +3070,14 @@ func (r *Repository) getIAMGroupWithMembers(id string) (map[string]any, error) {
 
 func (r *Repository) CreateIAMGroup(data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["created_at"] = now
 	data["updated_at"] = now
 	data["user_ids"] = []any{}
-	if _, ok := data["organization_id"]; !ok {
-		data["organization_id"] = "00000000-0000-0000-0000-000000000000"
-	}
-	if _, ok := data["project_id"]; !ok {
-		data["project_id"] = "00000000-0000-0000-0000-000000000000"
+	if err := r.applyProjectDefaults(data); err != nil {
+		return nil, err
 	}
-	id := newID()
+	id := r.newID()
 	data["id"] = id
 	if err := r.insertJSON("iam_groups", []colVal{{name: "id", val: id}}, data); err != nil {
 		return nil, err
@@ -3265,8 +3089,8 @@ func (r *Repository) GetIAMGroup(id string) (map[string]any, error) {
 	return r.getIAMGroupWithMembers(id)
 }
 
-func (r *Repository) ListIAMGroups() ([]map[string]any, error) {
-	items, err := r.listJSON("iam_groups", "", "")
+func (r *Repository) ListIAMGroups(filters ...Filter) ([]map[string]any, error) {
+	items, err := r.listJSON("iam_groups", "", "", filters...)
 	if err != nil {
 		return nil, err
 	}
@@ -3288,7 +3112,7 @@ func (r *Repository) UpdateIAMGroup(id string, patch map[string]any) (map[string
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	if err := r.updateJSONByID("iam_groups", "id", id, next); err != nil {
 		return nil, err
 	}
@@ -3339,7 +3163,7 @@ func (r *Repository) SetIAMGroupMembers(groupID string, userIDs []string) (map[s
 
 func (r *Repository) CreateBlockVolume(zone string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["zone"] = zone
 	data["status"] = "available"
 	data["created_at"] = now
@@ -3368,8 +3192,8 @@ func (r *Repository) GetBlockVolume(id string) (map[string]any, error) {
 	return r.getJSONByID("block_volumes", "id", id)
 }
 
-func (r *Repository) ListBlockVolumes(zone string) ([]map[string]any, error) {
-	return r.listJSON("block_volumes", "zone", zone)
+func (r *Repository) ListBlockVolumes(zone string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("block_volumes", "zone", zone, filters...)
 }
 
 func (r *Repository) UpdateBlockVolume(id string, patch map[string]any) (map[string]any, error) {
@@ -3378,7 +3202,7 @@ func (r *Repository) UpdateBlockVolume(id string, patch map[string]any) (map[str
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	// Recompute perf_iops when the volume type changes.
 	if newType, ok := next["type"].(string); ok {
 		iops := float64(5000)
@@ -3408,7 +3232,7 @@ func (r *Repository) DeleteBlockVolume(id string) error {
 
 func (r *Repository) CreateBlockSnapshot(zone, volumeID string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["zone"] = zone
 	// Provider sends flat "volume_id" on create but its Read function reads
 	// snapshot.ParentVolume.ID (nested). Store as parent_volume so GET round-trips.
@@ -3427,8 +3251,8 @@ func (r *Repository) GetBlockSnapshot(id string) (map[string]any, error) {
 	return r.getJSONByID("block_snapshots", "id", id)
 }
 
-func (r *Repository) ListBlockSnapshots(zone string) ([]map[string]any, error) {
-	return r.listJSON("block_snapshots", "zone", zone)
+func (r *Repository) ListBlockSnapshots(zone string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("block_snapshots", "zone", zone, filters...)
 }
 
 func (r *Repository) UpdateBlockSnapshot(id string, patch map[string]any) (map[string]any, error) {
@@ -3437,7 +3261,7 @@ func (r *Repository) UpdateBlockSnapshot(id string, patch map[string]any) (map[s
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	zone, _ := next["zone"].(string)
 	var volumeIDArg any
 	if pv, ok := next["parent_volume"].(map[string]any); ok {
@@ -3466,20 +3290,42 @@ func (r *Repository) DeleteBlockSnapshot(id string) error {
 
 func (r *Repository) CreateIPAMIP(region string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["region"] = region
 	data["created_at"] = now
 	data["updated_at"] = now
-	if _, ok := data["address"]; !ok {
-		// IPAM address must be CIDR notation — provider uses expandIPNet() to parse it.
-		data["address"] = fakePrivateIP() + "/32"
-	}
 	if _, ok := data["is_ipv6"]; !ok {
 		data["is_ipv6"] = false
 	}
 	if _, ok := data["source"]; !ok {
 		data["source"] = map[string]any{}
 	}
+	defer r.lockIPs()()
+	alloc := r.newIPAllocator()
+	pnID, err := r.ipamPrivateNetwork(data)
+	if err != nil {
+		return nil, err
+	}
+	if addr, ok := data["address"].(string); ok {
+		if err := alloc.reserve(pnID, addr); err != nil {
+			return nil, err
+		}
+	} else if _, ok := data["address"]; !ok {
+		var (
+			addr string
+			err  error
+		)
+		if pnID != "" {
+			addr, err = alloc.private(pnID)
+		} else {
+			addr, err = alloc.public(regionZone(region))
+		}
+		if err != nil {
+			return nil, err
+		}
+		// IPAM address must be CIDR notation — provider uses expandIPNet() to parse it.
+		data["address"] = addr + "/32"
+	}
 	if _, ok := data["resource"]; !ok {
 		data["resource"] = map[string]any{}
 	}
@@ -3490,8 +3336,8 @@ func (r *Repository) GetIPAMIP(id string) (map[string]any, error) {
 	return r.getJSONByID("ipam_ips", "id", id)
 }
 
-func (r *Repository) ListIPAMIPs(region string) ([]map[string]any, error) {
-	return r.listJSON("ipam_ips", "region", region)
+func (r *Repository) ListIPAMIPs(region string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("ipam_ips", "region", region, filters...)
 }
 
 func (r *Repository) UpdateIPAMIP(id string, patch map[string]any) (map[string]any, error) {
@@ -3500,7 +3346,7 @@ func (r *Repository) UpdateIPAMIP(id string, patch map[string]any) (map[string]a
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	b, err := marshalData(next)
 	if err != nil {
 		return nil, err
@@ -3524,7 +3370,7 @@ func (r *Repository) DeleteIPAMIP(id string) error {
 
 func (r *Repository) CreateRDBReadReplica(region, instanceID string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["region"] = region
 	data["instance_id"] = instanceID
 	data["status"] = "ready"
@@ -3533,6 +3379,12 @@ func (r *Repository) CreateRDBReadReplica(region, instanceID string, data map[st
 	if _, ok := data["endpoints"]; !ok {
 		data["endpoints"] = []any{}
 	}
+	defer r.lockIPs()()
+	if eps, ok := data["endpoints"].([]any); ok {
+		if err := r.newIPAllocator().endpoints(eps, regionZone(region), "ip"); err != nil {
+			return nil, err
+		}
+	}
 	return r.createSimple("rdb_read_replicas", "region", region, data, colVal{name: "instance_id", val: instanceID})
 }
 
@@ -3563,10 +3415,15 @@ func (r *Repository) CreateRDBReadReplicaEndpoint(id string, data map[string]any
 	next := cloneMap(current)
 	eps, _ := next["endpoints"].([]any)
 	ep := cloneMap(data)
-	ep["id"] = newID()
+	ep["id"] = r.newID()
+	defer r.lockIPs()()
+	region, _ := next["region"].(string)
+	if err := r.newIPAllocator().endpoints([]any{ep}, regionZone(region), "ip"); err != nil {
+		return nil, err
+	}
 	eps = append(eps, ep)
 	next["endpoints"] = eps
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	if err := r.updateJSONByID("rdb_read_replicas", "id", id, next); err != nil {
 		return nil, err
 	}
@@ -3585,7 +3442,7 @@ func (r *Repository) ResetRDBReadReplica(id string) (map[string]any, error) {
 
 func (r *Repository) CreateRDBSnapshot(region, instanceID string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["region"] = region
 	data["instance_id"] = instanceID
 	data["status"] = "ready"
@@ -3601,8 +3458,8 @@ func (r *Repository) GetRDBSnapshot(id string) (map[string]any, error) {
 	return r.getJSONByID("rdb_snapshots", "id", id)
 }
 
-func (r *Repository) ListRDBSnapshots(region string) ([]map[string]any, error) {
-	return r.listJSON("rdb_snapshots", "region", region)
+func (r *Repository) ListRDBSnapshots(region string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("rdb_snapshots", "region", region, filters...)
 }
 
 func (r *Repository) UpdateRDBSnapshot(id string, patch map[string]any) (map[string]any, error) {
@@ -3611,7 +3468,7 @@ func (r *Repository) UpdateRDBSnapshot(id string, patch map[string]any) (map[str
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	b, err := marshalData(next)
 	if err != nil {
 		return nil, err
@@ -3659,7 +3516,7 @@ func (r *Repository) CreateRDBInstanceFromSnapshot(region, snapshotID string, da
 
 func (r *Repository) CreateRDBBackup(region, instanceID string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["region"] = region
 	data["instance_id"] = instanceID
 	data["status"] = "ready"
@@ -3688,7 +3545,7 @@ func (r *Repository) UpdateRDBBackup(id string, patch map[string]any) (map[strin
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	b, err := marshalData(next)
 	if err != nil {
 		return nil, err
@@ -3722,7 +3579,7 @@ func (r *Repository) ExportRDBBackup(id string) (map[string]any, error) {
 		return nil, err
 	}
 	current["download_url"] = "https://mock-backup-download.scw.cloud/" + id
-	current["download_url_expires_at"] = nowRFC3339()
+	current["download_url_expires_at"] = r.nowRFC3339()
 	return current, nil
 }
 
@@ -3745,23 +3602,19 @@ func (r *Repository) CreateRDBEndpoint(instanceID string, data map[string]any) (
 		return nil, err
 	}
 	ep := cloneMap(data)
-	ep["id"] = newID()
-	// Validate private network reference if present.
-	if pn, ok := ep["private_network"].(map[string]any); ok {
-		pnID, _ := pn["id"].(string)
-		if pnID == "" {
-			pnID, _ = pn["private_network_id"].(string)
-		}
-		if pnID != "" {
-			if _, err := r.GetPrivateNetwork(pnID); err != nil {
-				return nil, models.ErrNotFound
-			}
-		}
+	ep["id"] = r.newID()
+	if err := r.checkReferences(r.db, "rdb_instances", map[string]any{"endpoints": []any{ep}}, nil); err != nil {
+		return nil, err
+	}
+	defer r.lockIPs()()
+	region, _ := instance["region"].(string)
+	if err := r.newIPAllocator().endpoints([]any{ep}, regionZone(region), "ip"); err != nil {
+		return nil, err
 	}
 	eps, _ := instance["endpoints"].([]any)
 	eps = append(eps, ep)
 	instance["endpoints"] = eps
-	instance["updated_at"] = nowRFC3339()
+	instance["updated_at"] = r.nowRFC3339()
 	if err := r.updateJSONByID("rdb_instances", "id", instanceID, instance); err != nil {
 		return nil, err
 	}
@@ -3791,7 +3644,7 @@ func (r *Repository) DeleteRDBEndpoint(endpointID string) error {
 		}
 		if found {
 			inst["endpoints"] = newEps
-			inst["updated_at"] = nowRFC3339()
+			inst["updated_at"] = r.nowRFC3339()
 			if id, ok := inst["id"].(string); ok {
 				if err := r.updateJSONByID("rdb_instances", "id", id, inst); err != nil {
 					return err
@@ -3812,7 +3665,7 @@ func (r *Repository) SetRedisACLRules(clusterID string, rules []any) (map[string
 	}
 	next := cloneMap(current)
 	next["acl_rules"] = rules
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	if err := r.updateJSONByID("redis_clusters", "id", clusterID, next); err != nil {
 		return nil, err
 	}
@@ -3835,7 +3688,7 @@ func (r *Repository) SetRedisEndpoints(clusterID string, endpoints []any) (map[s
 	}
 	next := cloneMap(current)
 	next["endpoints"] = endpoints
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	if err := r.updateJSONByID("redis_clusters", "id", clusterID, next); err != nil {
 		return nil, err
 	}
@@ -3849,7 +3702,7 @@ func (r *Repository) SetRedisClusterSettings(clusterID string, settings []any) (
 	}
 	next := cloneMap(current)
 	next["settings"] = settings
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	if err := r.updateJSONByID("redis_clusters", "id", clusterID, next); err != nil {
 		return nil, err
 	}
@@ -3879,7 +3732,7 @@ func (r *Repository) DeleteRedisEndpoint(zone, endpointID string) (map[string]an
 		}
 		if found {
 			cluster["endpoints"] = newEps
-			cluster["updated_at"] = nowRFC3339()
+			cluster["updated_at"] = r.nowRFC3339()
 			if id, ok := cluster["id"].(string); ok {
 				if err := r.updateJSONByID("redis_clusters", "id", id, cluster); err != nil {
 					return nil, err
@@ -3900,7 +3753,7 @@ func (r *Repository) CreateLBACL(frontendID string, data map[string]any) (map[st
 	data = cloneMap(data)
 	data["frontend_id"] = frontendID
 	data["frontend"] = map[string]any{"id": frontendID}
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["created_at"] = now
 	data["updated_at"] = now
 	return r.createSimple("lb_acls", "frontend_id", frontendID, data)
@@ -3920,7 +3773,7 @@ func (r *Repository) UpdateLBACL(id string, patch map[string]any) (map[string]an
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	frontendID, _ := next["frontend_id"].(string)
 	b, err := marshalData(next)
 	if err != nil {
@@ -3944,7 +3797,7 @@ func (r *Repository) DeleteLBACL(id string) error {
 func (r *Repository) CreateLBRoute(lbID string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
 	data["lb_id"] = lbID
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["created_at"] = now
 	data["updated_at"] = now
 	// The provider's setRouteState dereferences route.Match.Sni etc. without a nil
@@ -3993,24 +3846,16 @@ func (r *Repository) UpdateLBRoute(id string, patch map[string]any) (map[string]
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
-	// Validate frontend_id and backend_id references if changed.
-	var feLBID string
-	if fid, ok := next["frontend_id"].(string); ok && fid != "" {
-		fe, err := r.GetFrontend(fid)
-		if err != nil {
-			return nil, models.ErrNotFound
-		}
-		feLBID, _ = fe["lb_id"].(string)
+	next["updated_at"] = r.nowRFC3339()
+	if err := r.checkReferences(r.db, "lb_routes", next, current); err != nil {
+		return nil, err
 	}
-	if bid, ok := next["backend_id"].(string); ok && bid != "" {
-		be, err := r.GetBackend(bid)
-		if err != nil {
-			return nil, models.ErrNotFound
-		}
-		// Validate backend belongs to the same LB as the frontend.
-		if feLBID != "" {
-			if beLB, _ := be["lb_id"].(string); beLB != feLBID {
+	// Validate backend belongs to the same LB as the frontend.
+	fid, _ := next["frontend_id"].(string)
+	bid, _ := next["backend_id"].(string)
+	if fe, err := r.GetFrontend(fid); err == nil {
+		if be, err := r.GetBackend(bid); err == nil {
+			if feLB, _ := fe["lb_id"].(string); feLB != "" && be["lb_id"] != feLB {
 				return nil, fmt.Errorf("backend does not belong to the same LB as frontend: %w", models.ErrNotFound)
 			}
 		}
@@ -4038,7 +3883,7 @@ func (r *Repository) DeleteLBRoute(id string) error {
 func (r *Repository) CreateLBCertificate(lbID string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
 	data["lb_id"] = lbID
-	now := nowRFC3339()
+	now := r.nowRFC3339()
 	data["created_at"] = now
 	data["updated_at"] = now
 	if _, ok := data["status"]; !ok {
@@ -4085,7 +3930,7 @@ func (r *Repository) UpdateLBCertificate(id string, patch map[string]any) (map[s
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	lbID, _ := next["lb_id"].(string)
 	b, err := marshalData(next)
 	if err != nil {
@@ -4108,8 +3953,8 @@ func (r *Repository) DeleteLBCertificate(id string) error {
 
 func (r *Repository) CreateRegistryNamespace(region string, data map[string]any) (map[string]any, error) {
 	data = cloneMap(data)
-	id := newID()
-	now := nowRFC3339()
+	id := r.newID()
+	now := r.nowRFC3339()
 	data["id"] = id
 	data["region"] = region
 	data["status"] = "ready"
@@ -4119,11 +3964,8 @@ func (r *Repository) CreateRegistryNamespace(region string, data map[string]any)
 	if _, ok := data["is_public"]; !ok {
 		data["is_public"] = false
 	}
-	if _, ok := data["organization_id"]; !ok {
-		data["organization_id"] = "00000000-0000-0000-0000-000000000000"
-	}
-	if _, ok := data["project_id"]; !ok {
-		data["project_id"] = "00000000-0000-0000-0000-000000000000"
+	if err := r.applyProjectDefaults(data); err != nil {
+		return nil, err
 	}
 	data["created_at"] = now
 	data["updated_at"] = now
@@ -4142,8 +3984,8 @@ func (r *Repository) GetRegistryNamespace(id string) (map[string]any, error) {
 	return r.getJSONByID("registry_namespaces", "id", id)
 }
 
-func (r *Repository) ListRegistryNamespaces(region string) ([]map[string]any, error) {
-	return r.listJSON("registry_namespaces", "region", region)
+func (r *Repository) ListRegistryNamespaces(region string, filters ...Filter) ([]map[string]any, error) {
+	return r.listJSON("registry_namespaces", "region", region, filters...)
 }
 
 func (r *Repository) UpdateRegistryNamespace(id string, patch map[string]any) (map[string]any, error) {
@@ -4152,7 +3994,7 @@ func (r *Repository) UpdateRegistryNamespace(id string, patch map[string]any) (m
 		return nil, err
 	}
 	next := patchMerge(current, patch, "id")
-	next["updated_at"] = nowRFC3339()
+	next["updated_at"] = r.nowRFC3339()
 	b, err := marshalData(next)
 	if err != nil {
 		return nil, err
@@ -4172,472 +4014,18 @@ func (r *Repository) DeleteRegistryNamespace(id string) error {
 	return r.deleteBy("registry_namespaces", "id = ?", id)
 }
 
-func (r *Repository) FullState() (map[string]any, error) {
-	servers, err := r.listJSON("instance_servers", "", "")
-	if err != nil {
-		return nil, err
-	}
-	ips, err := r.listJSON("instance_ips", "", "")
-	if err != nil {
-		return nil, err
-	}
-	nics, err := r.listJSON("instance_private_nics", "", "")
-	if err != nil {
-		return nil, err
-	}
-	sgs, err := r.listJSON("instance_security_groups", "", "")
-	if err != nil {
-		return nil, err
-	}
-	instanceVolumes, err := r.listJSON("instance_volumes", "", "")
-	if err != nil {
-		return nil, err
-	}
-	vpcs, err := r.listJSON("vpcs", "", "")
-	if err != nil {
-		return nil, err
-	}
-	pns, err := r.listJSON("private_networks", "", "")
-	if err != nil {
-		return nil, err
-	}
-	lbIPs, err := r.listJSON("lb_ips", "", "")
-	if err != nil {
-		return nil, err
-	}
-	lbs, err := r.listJSON("lbs", "", "")
-	if err != nil {
-		return nil, err
-	}
-	frontends, err := r.listJSON("lb_frontends", "", "")
-	if err != nil {
-		return nil, err
-	}
-	backends, err := r.listJSON("lb_backends", "", "")
-	if err != nil {
-		return nil, err
-	}
-	lbPNs, err := r.listJSON("lb_private_networks", "", "")
-	if err != nil {
-		return nil, err
-	}
-	clusters, err := r.listJSON("k8s_clusters", "", "")
-	if err != nil {
-		return nil, err
-	}
-	pools, err := r.listJSON("k8s_pools", "", "")
-	if err != nil {
-		return nil, err
-	}
-	rdbInstances, err := r.listJSON("rdb_instances", "", "")
-	if err != nil {
-		return nil, err
-	}
-	rdbDatabases, err := r.listJSON("rdb_databases", "", "")
-	if err != nil {
-		return nil, err
-	}
-	rdbUsers, err := r.listJSON("rdb_users", "", "")
-	if err != nil {
-		return nil, err
-	}
-	rdbPrivileges, err := r.listJSON("rdb_privileges", "", "")
-	if err != nil {
-		return nil, err
-	}
-	redisClusters, err := r.listJSON("redis_clusters", "", "")
-	if err != nil {
-		return nil, err
-	}
-	registryNamespaces, err := r.listJSON("registry_namespaces", "", "")
-	if err != nil {
-		return nil, err
-	}
-	iamApplications, err := r.listJSON("iam_applications", "", "")
-	if err != nil {
-		return nil, err
-	}
-	iamAPIKeys, err := r.ListIAMAPIKeys()
-	if err != nil {
-		return nil, err
-	}
-	iamPolicies, err := r.listJSON("iam_policies", "", "")
-	if err != nil {
-		return nil, err
-	}
-	iamSSHKeys, err := r.listJSON("iam_ssh_keys", "", "")
-	if err != nil {
-		return nil, err
-	}
-	domainRecords, err := r.listJSON("domain_records", "", "")
-	if err != nil {
-		return nil, err
-	}
-	iamUsers, err := r.listJSON("iam_users", "", "")
-	if err != nil {
-		return nil, err
-	}
-	iamGroups, err := r.ListIAMGroups()
-	if err != nil {
-		return nil, err
-	}
-	blockVolumes, err := r.listJSON("block_volumes", "", "")
-	if err != nil {
-		return nil, err
-	}
-	blockSnapshots, err := r.listJSON("block_snapshots", "", "")
-	if err != nil {
-		return nil, err
-	}
-	ipamIPs, err := r.listJSON("ipam_ips", "", "")
-	if err != nil {
-		return nil, err
-	}
-	rdbReadReplicas, err := r.listJSON("rdb_read_replicas", "", "")
-	if err != nil {
-		return nil, err
-	}
-	rdbSnapshots, err := r.listJSON("rdb_snapshots", "", "")
-	if err != nil {
-		return nil, err
-	}
-	rdbBackups, err := r.listJSON("rdb_backups", "", "")
-	if err != nil {
-		return nil, err
-	}
-	lbACLs, err := r.listJSON("lb_acls", "", "")
-	if err != nil {
-		return nil, err
-	}
-	lbRoutes, err := r.listJSON("lb_routes", "", "")
-	if err != nil {
-		return nil, err
-	}
-	lbCertificates, err := r.listJSON("lb_certificates", "", "")
-	if err != nil {
-		return nil, err
-	}
-	dnsZones, err := r.listJSON("dns_zones", "", "")
-	if err != nil {
-		return nil, err
-	}
-	vpcRoutes, err := r.listJSON("vpc_routes", "", "")
-	if err != nil {
-		return nil, err
-	}
-	vpcGateways, err := r.listJSON("vpc_public_gateways", "", "")
-	if err != nil {
-		return nil, err
-	}
-	vpcGatewayNetworks, err := r.listJSON("vpc_gateway_networks", "", "")
+// ServiceState returns one service of the FullState document, or
+// models.ErrNotFound when no table of Tables belongs to it.
+func (r *Repository) ServiceState(service string) (map[string]any, error) {
+	state, err := r.exportState(false, service)
 	if err != nil {
 		return nil, err
 	}
-
-	return map[string]any{
-		"instance": map[string]any{
-			"servers":         servers,
-			"ips":             ips,
-			"private_nics":    nics,
-			"security_groups": sgs,
-			"volumes":         instanceVolumes,
-		},
-		"vpc": map[string]any{
-			"vpcs":             vpcs,
-			"private_networks": pns,
-			"routes":           vpcRoutes,
-			"gateways":         vpcGateways,
-			"gateway_networks": vpcGatewayNetworks,
-		},
-		"lb": map[string]any{
-			"ips":              lbIPs,
-			"lbs":              lbs,
-			"frontends":        frontends,
-			"backends":         backends,
-			"private_networks": lbPNs,
-			"acls":             lbACLs,
-			"routes":           lbRoutes,
-			"certificates":     lbCertificates,
-		},
-		"k8s": map[string]any{
-			"clusters": clusters,
-			"pools":    pools,
-		},
-		"rdb": map[string]any{
-			"instances":     rdbInstances,
-			"databases":     rdbDatabases,
-			"users":         rdbUsers,
-			"privileges":    rdbPrivileges,
-			"read_replicas": rdbReadReplicas,
-			"snapshots":     rdbSnapshots,
-			"backups":       rdbBackups,
-		},
-		"redis": map[string]any{
-			"clusters": redisClusters,
-		},
-		"registry": map[string]any{
-			"namespaces": registryNamespaces,
-		},
-		"iam": map[string]any{
-			"applications": iamApplications,
-			"api_keys":     iamAPIKeys,
-			"policies":     iamPolicies,
-			"ssh_keys":     iamSSHKeys,
-			"users":        iamUsers,
-			"groups":       iamGroups,
-		},
-		"domain": map[string]any{
-			"dns_zones": dnsZones,
-			"records":   domainRecords,
-		},
-		"block": map[string]any{
-			"volumes":   blockVolumes,
-			"snapshots": blockSnapshots,
-		},
-		"ipam": map[string]any{
-			"ips": ipamIPs,
-		},
-	}, nil
-}
-
-func (r *Repository) ServiceState(service string) (map[string]any, error) {
-	switch service {
-	case "instance":
-		servers, err := r.listJSON("instance_servers", "", "")
-		if err != nil {
-			return nil, err
-		}
-		ips, err := r.listJSON("instance_ips", "", "")
-		if err != nil {
-			return nil, err
-		}
-		nics, err := r.listJSON("instance_private_nics", "", "")
-		if err != nil {
-			return nil, err
-		}
-		sgs, err := r.listJSON("instance_security_groups", "", "")
-		if err != nil {
-			return nil, err
-		}
-		volumes, err := r.listJSON("instance_volumes", "", "")
-		if err != nil {
-			return nil, err
-		}
-		return map[string]any{
-			"servers":         servers,
-			"ips":             ips,
-			"private_nics":    nics,
-			"security_groups": sgs,
-			"volumes":         volumes,
-		}, nil
-	case "vpc":
-		vpcs, err := r.listJSON("vpcs", "", "")
-		if err != nil {
-			return nil, err
-		}
-		pns, err := r.listJSON("private_networks", "", "")
-		if err != nil {
-			return nil, err
-		}
-		vpcRoutes, err := r.listJSON("vpc_routes", "", "")
-		if err != nil {
-			return nil, err
-		}
-		vpcGateways, err := r.listJSON("vpc_public_gateways", "", "")
-		if err != nil {
-			return nil, err
-		}
-		vpcGatewayNetworks, err := r.listJSON("vpc_gateway_networks", "", "")
-		if err != nil {
-			return nil, err
-		}
-		return map[string]any{
-			"vpcs":             vpcs,
-			"private_networks": pns,
-			"routes":           vpcRoutes,
-			"gateways":         vpcGateways,
-			"gateway_networks": vpcGatewayNetworks,
-		}, nil
-	case "lb":
-		lbIPs, err := r.listJSON("lb_ips", "", "")
-		if err != nil {
-			return nil, err
-		}
-		lbs, err := r.listJSON("lbs", "", "")
-		if err != nil {
-			return nil, err
-		}
-		frontends, err := r.listJSON("lb_frontends", "", "")
-		if err != nil {
-			return nil, err
-		}
-		backends, err := r.listJSON("lb_backends", "", "")
-		if err != nil {
-			return nil, err
-		}
-		lbPNs, err := r.listJSON("lb_private_networks", "", "")
-		if err != nil {
-			return nil, err
-		}
-		lbACLs, err := r.listJSON("lb_acls", "", "")
-		if err != nil {
-			return nil, err
-		}
-		lbRoutes, err := r.listJSON("lb_routes", "", "")
-		if err != nil {
-			return nil, err
-		}
-		lbCertificates, err := r.listJSON("lb_certificates", "", "")
-		if err != nil {
-			return nil, err
-		}
-		return map[string]any{
-			"ips":              lbIPs,
-			"lbs":              lbs,
-			"frontends":        frontends,
-			"backends":         backends,
-			"private_networks": lbPNs,
-			"acls":             lbACLs,
-			"routes":           lbRoutes,
-			"certificates":     lbCertificates,
-		}, nil
-	case "k8s":
-		clusters, err := r.listJSON("k8s_clusters", "", "")
-		if err != nil {
-			return nil, err
-		}
-		pools, err := r.listJSON("k8s_pools", "", "")
-		if err != nil {
-			return nil, err
-		}
-		return map[string]any{
-			"clusters": clusters,
-			"pools":    pools,
-		}, nil
-	case "rdb":
-		instances, err := r.listJSON("rdb_instances", "", "")
-		if err != nil {
-			return nil, err
-		}
-		databases, err := r.listJSON("rdb_databases", "", "")
-		if err != nil {
-			return nil, err
-		}
-		users, err := r.listJSON("rdb_users", "", "")
-		if err != nil {
-			return nil, err
-		}
-		privileges, err := r.listJSON("rdb_privileges", "", "")
-		if err != nil {
-			return nil, err
-		}
-		readReplicas, err := r.listJSON("rdb_read_replicas", "", "")
-		if err != nil {
-			return nil, err
-		}
-		snapshots, err := r.listJSON("rdb_snapshots", "", "")
-		if err != nil {
-			return nil, err
-		}
-		backups, err := r.listJSON("rdb_backups", "", "")
-		if err != nil {
-			return nil, err
-		}
-		return map[string]any{
-			"instances":     instances,
-			"databases":     databases,
-			"users":         users,
-			"privileges":    privileges,
-			"read_replicas": readReplicas,
-			"snapshots":     snapshots,
-			"backups":       backups,
-		}, nil
-	case "redis":
-		clusters, err := r.listJSON("redis_clusters", "", "")
-		if err != nil {
-			return nil, err
-		}
-		return map[string]any{
-			"clusters": clusters,
-		}, nil
-	case "registry":
-		namespaces, err := r.listJSON("registry_namespaces", "", "")
-		if err != nil {
-			return nil, err
-		}
-		return map[string]any{
-			"namespaces": namespaces,
-		}, nil
-	case "iam":
-		applications, err := r.listJSON("iam_applications", "", "")
-		if err != nil {
-			return nil, err
-		}
-		apiKeys, err := r.ListIAMAPIKeys()
-		if err != nil {
-			return nil, err
-		}
-		policies, err := r.listJSON("iam_policies", "", "")
-		if err != nil {
-			return nil, err
-		}
-		sshKeys, err := r.listJSON("iam_ssh_keys", "", "")
-		if err != nil {
-			return nil, err
-		}
-		iamUsers, err := r.listJSON("iam_users", "", "")
-		if err != nil {
-			return nil, err
-		}
-		iamGroups, err := r.ListIAMGroups()
-		if err != nil {
-			return nil, err
-		}
-		return map[string]any{
-			"applications": applications,
-			"api_keys":     apiKeys,
-			"policies":     policies,
-			"ssh_keys":     sshKeys,
-			"users":        iamUsers,
-			"groups":       iamGroups,
-		}, nil
-	case "block":
-		blockVolumes, err := r.listJSON("block_volumes", "", "")
-		if err != nil {
-			return nil, err
-		}
-		blockSnapshots, err := r.listJSON("block_snapshots", "", "")
-		if err != nil {
-			return nil, err
-		}
-		return map[string]any{
-			"volumes":   blockVolumes,
-			"snapshots": blockSnapshots,
-		}, nil
-	case "ipam":
-		ipamIPs, err := r.listJSON("ipam_ips", "", "")
-		if err != nil {
-			return nil, err
-		}
-		return map[string]any{
-			"ips": ipamIPs,
-		}, nil
-	case "domain":
-		dnsZones, err := r.listJSON("dns_zones", "", "")
-		if err != nil {
-			return nil, err
-		}
-		records, err := r.listJSON("domain_records", "", "")
-		if err != nil {
-			return nil, err
-		}
-		return map[string]any{
-			"dns_zones": dnsZones,
-			"records":   records,
-		}, nil
-	default:
+	svc, ok := state[service].(map[string]any)
+	if !ok {
 		return nil, models.ErrNotFound
 	}
+	return svc, nil
 }
 
 // regionFromZone extracts the region prefix from a zone string (e.g.
@@ -4651,23 +4039,14 @@ func regionFromZone(zone string) string {
 	return parts[0] + "-" + parts[1]
 }
 
-func fakePublicIP() string {
-	p := strings.ReplaceAll(newID(), "-", "")
-	return fmt.Sprintf("51.15.%d.%d", int(p[0])%254+1, int(p[1])%254+1)
-}
-
-func fakePrivateIP() string {
-	p := strings.ReplaceAll(newID(), "-", "")
-	return fmt.Sprintf("10.%d.%d.%d", int(p[0])%254+1, int(p[1])%254+1, int(p[2])%254+1)
-}
-
-func BuildRDBEndpointsFromInit(initEndpoints any, engine any) ([]any, error) {
+// BuildRDBEndpointsFromInit turns the init_endpoints of a create request
+// into endpoints. Their addresses are allocated by CreateRDBInstance.
+func (r *Repository) BuildRDBEndpointsFromInit(initEndpoints any, engine any) ([]any, error) {
 	port := rdbPortFromEngine(engine)
 	list, ok := initEndpoints.([]any)
 	if !ok || len(list) == 0 {
 		return []any{map[string]any{
-			"id":            newID(),
-			"ip":            fakePublicIP(),
+			"id":            r.newID(),
 			"port":          port,
 			"load_balancer": map[string]any{},
 		}}, nil
@@ -4682,8 +4061,7 @@ func BuildRDBEndpointsFromInit(initEndpoints any, engine any) ([]any, error) {
 		if !ok {
 			// No private_network block — public endpoint.
 			result = append(result, map[string]any{
-				"id":            newID(),
-				"ip":            fakePublicIP(),
+				"id":            r.newID(),
 				"port":          port,
 				"load_balancer": map[string]any{},
 			})
@@ -4698,8 +4076,7 @@ func BuildRDBEndpointsFromInit(initEndpoints any, engine any) ([]any, error) {
 			return nil, fmt.Errorf("invalid init_endpoints: private_network present but missing id")
 		}
 		result = append(result, map[string]any{
-			"id":              newID(),
-			"ip":              fakePrivateIP(),
+			"id":              r.newID(),
 			"port":            port,
 			"private_network": map[string]any{"id": pnID},
 		})
@@ -4715,17 +4092,12 @@ func rdbPortFromEngine(engine any) float64 {
 	return float64(5432)
 }
 
-func randomAlphaNum(n int) string {
+func (r *Repository) randomAlphaNum(n int) string {
 	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
 	var b strings.Builder
 	b.Grow(n)
-	max := big.NewInt(int64(len(alphabet)))
 	for i := 0; i < n; i++ {
-		v, err := rand.Int(rand.Reader, max)
-		if err != nil {
-			return strings.Repeat("A", n)
-		}
-		b.WriteByte(alphabet[v.Int64()])
+		b.WriteByte(alphabet[r.randIntn(len(alphabet))])
 	}
 	return b.String()
 }
@@ -1,11 +1,18 @@
 package repository_test
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/redscaresu/mockway/models"
 	"github.com/redscaresu/mockway/repository"
@@ -138,6 +145,200 @@ func TestSnapshotAndRestore(t *testing.T) {
 	require.Equal(t, "baseline", vpcs[0]["name"])
 }
 
+func TestNamedSnapshots(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "repo.db")
+	repo, err := repository.New(dbPath)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	baseline, err := repo.CreateNamedSnapshot("baseline")
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"account_projects": 1}, baseline.ResourceCounts)
+
+	_, err = repo.CreateVPC("fr-par", map[string]any{"name": "applied"})
+	require.NoError(t, err)
+	applied, err := repo.CreateNamedSnapshot("post-apply")
+	require.NoError(t, err)
+	require.Equal(t, 1, applied.ResourceCounts["vpcs"])
+	require.Equal(t, 2, applied.TotalResources)
+
+	// Both checkpoints coexist and survive Reset.
+	require.NoError(t, repo.Reset())
+	list, err := repo.ListNamedSnapshots()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, "baseline", list[0].Name)
+	require.Equal(t, "post-apply", list[1].Name)
+
+	require.NoError(t, repo.RestoreNamedSnapshot("post-apply"))
+	vpcs, err := repo.ListVPCs("fr-par")
+	require.NoError(t, err)
+	require.Len(t, vpcs, 1)
+	require.NoError(t, repo.RestoreNamedSnapshot("baseline"))
+	vpcs, err = repo.ListVPCs("fr-par")
+	require.NoError(t, err)
+	require.Empty(t, vpcs)
+
+	require.NoError(t, repo.DeleteNamedSnapshot("baseline"))
+	require.ErrorIs(t, repo.DeleteNamedSnapshot("baseline"), models.ErrNotFound)
+	require.ErrorIs(t, repo.RestoreNamedSnapshot("baseline"), models.ErrNotFound)
+	_, err = repo.CreateNamedSnapshot("../escape")
+	require.ErrorIs(t, err, repository.ErrInvalidSnapshotName)
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer src.Close()
+
+	vpc, err := src.CreateVPC("fr-par", map[string]any{"name": "platform"})
+	require.NoError(t, err)
+	pn, err := src.CreatePrivateNetwork("fr-par", map[string]any{"name": "pn", "vpc_id": vpc["id"]})
+	require.NoError(t, err)
+	sg, err := src.CreateSecurityGroup("fr-par-1", map[string]any{"name": "sg"})
+	require.NoError(t, err)
+	server, err := src.CreateServer("fr-par-1", map[string]any{"name": "srv", "security_group_id": sg["id"]})
+	require.NoError(t, err)
+	_, err = src.CreateIP("fr-par-1", map[string]any{"server_id": server["id"]})
+	require.NoError(t, err)
+	_, err = src.CreatePrivateNIC("fr-par-1", server["id"].(string), map[string]any{"private_network_id": pn["id"]})
+	require.NoError(t, err)
+	lb, err := src.CreateLB("fr-par-1", map[string]any{"name": "lb"})
+	require.NoError(t, err)
+	backend, err := src.CreateBackend(map[string]any{"name": "be", "lb_id": lb["id"], "forward_port": 80})
+	require.NoError(t, err)
+	_, err = src.CreateFrontend(map[string]any{"name": "fe", "lb_id": lb["id"], "backend_id": backend["id"]})
+	require.NoError(t, err)
+	_, err = src.AttachLBPrivateNetwork(lb["id"].(string), pn["id"].(string))
+	require.NoError(t, err)
+	rdb, err := src.CreateRDBInstance("fr-par", map[string]any{"name": "db", "engine": "PostgreSQL-15"})
+	require.NoError(t, err)
+	_, err = src.CreateRDBDatabase(rdb["id"].(string), "app", map[string]any{})
+	require.NoError(t, err)
+	_, err = src.CreateRDBUser(rdb["id"].(string), "alice", map[string]any{})
+	require.NoError(t, err)
+	_, err = src.SetRDBPrivileges(rdb["id"].(string), []any{map[string]any{"user_name": "alice", "database_name": "app", "permission": "all"}})
+	require.NoError(t, err)
+	_, err = src.SetRDBACLs(rdb["id"].(string), []any{map[string]any{"ip": "10.0.0.0/24"}})
+	require.NoError(t, err)
+	_, err = src.CreateDNSZone(map[string]any{"domain": "example.com", "subdomain": "app"})
+	require.NoError(t, err)
+	_, err = src.PatchDomainRecords("app.example.com", []any{map[string]any{"add": map[string]any{"records": []any{map[string]any{"name": "www", "type": "A", "data": "1.2.3.4"}}}}})
+	require.NoError(t, err)
+	app, err := src.CreateIAMApplication(map[string]any{"name": "ci"})
+	require.NoError(t, err)
+	_, err = src.CreateIAMAPIKey(map[string]any{"application_id": app["id"]})
+	require.NoError(t, err)
+	_, err = src.CreateIAMPolicy(map[string]any{"name": "p", "application_id": app["id"], "rules": []any{map[string]any{"permission_set_names": []any{"AllProductsFullAccess"}}}})
+	require.NoError(t, err)
+	user, err := src.CreateIAMUser(map[string]any{"email": "a@example.com"})
+	require.NoError(t, err)
+	group, err := src.CreateIAMGroup(map[string]any{"name": "ops"})
+	require.NoError(t, err)
+	_, err = src.AddIAMGroupMember(group["id"].(string), user["id"].(string))
+	require.NoError(t, err)
+	volume, err := src.CreateBlockVolume("fr-par-1", map[string]any{"name": "vol"})
+	require.NoError(t, err)
+	_, err = src.CreateBlockSnapshot("fr-par-1", volume["id"].(string), map[string]any{"name": "snap"})
+	require.NoError(t, err)
+	require.NoError(t, src.AddMarketplaceLabel("ubuntu_jammy"))
+	require.NoError(t, src.CreateGenericResource("/instance/v1/zones/fr-par-1/placement_groups", "pg-1", map[string]any{"id": "pg-1", "name": "pg"}))
+
+	exported, err := src.ExportState()
+	require.NoError(t, err)
+	keys := exported["iam"].(map[string]any)["api_keys"].([]map[string]any)
+	require.NotEmpty(t, keys[0]["secret_key"])
+	full, err := src.FullState()
+	require.NoError(t, err)
+	require.NotContains(t, full["iam"].(map[string]any)["api_keys"].([]map[string]any)[0], "secret_key")
+
+	// Round-trip through JSON, as a fixture file would.
+	raw, err := json.Marshal(exported)
+	require.NoError(t, err)
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(raw, &doc))
+
+	dst, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer dst.Close()
+	counts, err := dst.ImportState(doc, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, counts["vpcs"])
+	require.Equal(t, 1, counts["iam_rules"])
+
+	reexported, err := dst.ExportState()
+	require.NoError(t, err)
+	want, err := json.Marshal(exported)
+	require.NoError(t, err)
+	got, err := json.Marshal(reexported)
+	require.NoError(t, err)
+	require.JSONEq(t, string(want), string(got))
+
+	// Imported rows behave like created ones: references and cascades hold.
+	_, err = dst.GetIAMGroup(group["id"].(string))
+	require.NoError(t, err)
+	require.ErrorIs(t, dst.DeleteVPC(vpc["id"].(string)), models.ErrConflict)
+}
+
+func TestImportStateValidation(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	seed := map[string]any{
+		"vpc": map[string]any{
+			"vpcs": []any{map[string]any{"id": "vpc-1", "region": "fr-par", "name": "platform", "project_id": repository.DefaultProjectID}},
+			"private_networks": []any{map[string]any{
+				"id": "pn-1", "vpc_id": "vpc-1", "region": "fr-par", "name": "shared", "project_id": repository.DefaultProjectID,
+			}},
+		},
+	}
+	counts, err := repo.ImportState(seed, false)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"vpcs": 1, "private_networks": 1}, counts)
+	pn, err := repo.GetPrivateNetwork("pn-1")
+	require.NoError(t, err)
+	require.Equal(t, "shared", pn["name"])
+
+	// Importing again collides with the existing rows.
+	_, err = repo.ImportState(seed, false)
+	var fe *repository.FixtureError
+	require.ErrorAs(t, err, &fe)
+	require.Equal(t, "vpc.vpcs[0]", fe.Path)
+	require.ErrorIs(t, err, models.ErrConflict)
+
+	// A dangling reference fails the whole document; nothing is inserted.
+	_, err = repo.ImportState(map[string]any{
+		"vpc": map[string]any{
+			"vpcs":             []any{map[string]any{"id": "vpc-2", "region": "fr-par"}},
+			"private_networks": []any{map[string]any{"id": "pn-2", "vpc_id": "vpc-missing", "region": "fr-par"}},
+		},
+	}, false)
+	require.ErrorAs(t, err, &fe)
+	require.Equal(t, "vpc.private_networks[0]", fe.Path)
+	require.ErrorIs(t, err, models.ErrNotFound)
+	_, err = repo.GetVPC("vpc-2")
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	_, err = repo.ImportState(map[string]any{
+		"vpc": map[string]any{"vpcs": []any{map[string]any{"id": "vpc-3", "region": "fr-par", "project_id": "no-such-project"}}},
+	}, false)
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	_, err = repo.ImportState(map[string]any{"vpc": map[string]any{"vpcs": []any{map[string]any{"id": "vpc-4"}}}}, false)
+	require.ErrorIs(t, err, repository.ErrInvalidFixture)
+	_, err = repo.ImportState(map[string]any{"vpcs": []any{}}, false)
+	require.ErrorIs(t, err, repository.ErrInvalidFixture)
+
+	// replace starts from an empty state but keeps the default project.
+	_, err = repo.ImportState(map[string]any{}, true)
+	require.NoError(t, err)
+	_, err = repo.GetVPC("vpc-1")
+	require.ErrorIs(t, err, models.ErrNotFound)
+	_, err = repo.GetProject(repository.DefaultProjectID)
+	require.NoError(t, err)
+}
+
 func TestRestoreWithoutSnapshotReturnsNotFound(t *testing.T) {
 	dbPath := filepath.Join(t.TempDir(), "repo.db")
 	repo, err := repository.New(dbPath)
@@ -320,7 +521,7 @@ func TestServiceStateAllBranches(t *testing.T) {
 	_, err = repo.CreateIAMSSHKey(map[string]any{"name": "k", "public_key": "ssh-ed25519 AAAA"})
 	require.NoError(t, err)
 
-	for _, svc := range []string{"instance", "vpc", "lb", "k8s", "rdb", "iam"} {
+	for _, svc := range []string{"account", "instance", "vpc", "lb", "k8s", "rdb", "iam"} {
 		st, err := repo.ServiceState(svc)
 		require.NoError(t, err)
 		require.NotEmpty(t, st)
@@ -470,12 +671,16 @@ func TestRepositoryCRUDWrappersCoverage(t *testing.T) {
 }
 
 func TestRDBEndpointHelpersAndRandom(t *testing.T) {
-	publicEPs, err := repository.BuildRDBEndpointsFromInit(nil, "PostgreSQL-15")
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	publicEPs, err := repo.BuildRDBEndpointsFromInit(nil, "PostgreSQL-15")
 	require.NoError(t, err)
 	require.Len(t, publicEPs, 1)
 	require.Equal(t, float64(5432), publicEPs[0].(map[string]any)["port"])
 
-	mysqlEPs, err := repository.BuildRDBEndpointsFromInit([]any{map[string]any{
+	mysqlEPs, err := repo.BuildRDBEndpointsFromInit([]any{map[string]any{
 		"private_network": map[string]any{"id": "pn-1"},
 	}}, "MySQL-8")
 	require.NoError(t, err)
@@ -485,24 +690,24 @@ func TestRDBEndpointHelpersAndRandom(t *testing.T) {
 	require.Equal(t, "pn-1", mysqlEP["private_network"].(map[string]any)["id"])
 
 	// Empty map without private_network falls back to public endpoint.
-	fallbackEPs, err := repository.BuildRDBEndpointsFromInit([]any{map[string]any{}}, "PostgreSQL-15")
+	fallbackEPs, err := repo.BuildRDBEndpointsFromInit([]any{map[string]any{}}, "PostgreSQL-15")
 	require.NoError(t, err)
 	require.Len(t, fallbackEPs, 1)
 	require.Equal(t, float64(5432), fallbackEPs[0].(map[string]any)["port"])
 
 	// Empty private_network (no id) is rejected as invalid input.
-	_, err = repository.BuildRDBEndpointsFromInit([]any{map[string]any{
+	_, err = repo.BuildRDBEndpointsFromInit([]any{map[string]any{
 		"private_network": map[string]any{},
 	}}, "PostgreSQL-15")
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "missing id")
 
 	// Non-map entry still returns an error.
-	_, err = repository.BuildRDBEndpointsFromInit([]any{"bad"}, "PostgreSQL-15")
+	_, err = repo.BuildRDBEndpointsFromInit([]any{"bad"}, "PostgreSQL-15")
 	require.Error(t, err)
 
 	// "private_network_id" alias works as an alternative to "id".
-	aliasEPs, err := repository.BuildRDBEndpointsFromInit([]any{map[string]any{
+	aliasEPs, err := repo.BuildRDBEndpointsFromInit([]any{map[string]any{
 		"private_network": map[string]any{"private_network_id": "pn-2"},
 	}}, "PostgreSQL-15")
 	require.NoError(t, err)
@@ -662,3 +867,948 @@ func TestPatchMergeSkipsTopLevelNull(t *testing.T) {
 	require.True(t, ok, "auto_upgrade should survive a null patch")
 	require.Equal(t, true, au["enabled"], "auto_upgrade.enabled should survive")
 }
+
+func TestLifecycleTransitions(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	require.NoError(t, repo.SetLifecycle(repository.LifecycleConfig{
+		Default: time.Hour,
+		PerKind: map[string]time.Duration{repository.LifecycleRDBInstance: 50 * time.Millisecond},
+	}))
+
+	lb, err := repo.CreateLB("fr-par-1", map[string]any{"name": "lb"})
+	require.NoError(t, err)
+	require.Equal(t, "creating", lb["status"])
+	got, err := repo.GetLB(lb["id"].(string))
+	require.NoError(t, err)
+	require.Equal(t, "creating", got["status"])
+
+	inst, err := repo.CreateRDBInstance("fr-par", map[string]any{"name": "db", "engine": "PostgreSQL-15"})
+	require.NoError(t, err)
+	require.Equal(t, "provisioning", inst["status"])
+	require.Eventually(t, func() bool {
+		got, err := repo.GetRDBInstance(inst["id"].(string))
+		return err == nil && got["status"] == "ready"
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Deletes are immediate for FK purposes but Get keeps serving a
+	// "deleting" tombstone until the delay elapses.
+	require.NoError(t, repo.DeleteLB(lb["id"].(string)))
+	got, err = repo.GetLB(lb["id"].(string))
+	require.NoError(t, err)
+	require.Equal(t, "deleting", got["status"])
+	lbs, err := repo.ListLBs("fr-par-1")
+	require.NoError(t, err)
+	require.Empty(t, lbs)
+	require.ErrorIs(t, repo.DeleteLB(lb["id"].(string)), models.ErrNotFound)
+
+	require.NoError(t, repo.DeleteRDBInstance(inst["id"].(string)))
+	require.Eventually(t, func() bool {
+		_, err := repo.GetRDBInstance(inst["id"].(string))
+		return errors.Is(err, models.ErrNotFound)
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestDeterministicMode(t *testing.T) {
+	build := func(repo *repository.Repository) []byte {
+		t.Helper()
+		vpc, err := repo.CreateVPC("fr-par", map[string]any{"name": "v"})
+		require.NoError(t, err)
+		_, err = repo.CreatePrivateNetwork("fr-par", map[string]any{"name": "pn", "vpc_id": vpc["id"]})
+		require.NoError(t, err)
+		_, err = repo.CreateIP("fr-par-1", map[string]any{})
+		require.NoError(t, err)
+		_, err = repo.CreateIAMAPIKey(map[string]any{})
+		require.NoError(t, err)
+		state, err := repo.ExportState()
+		require.NoError(t, err)
+		b, err := json.Marshal(state)
+		require.NoError(t, err)
+		return b
+	}
+
+	first, err := repository.NewDeterministic(":memory:", 42)
+	require.NoError(t, err)
+	defer first.Close()
+	golden := build(first)
+	vpcs, err := first.ListVPCs("fr-par")
+	require.NoError(t, err)
+	require.Equal(t, "2025-01-01T00:00:00Z", vpcs[0]["created_at"])
+
+	// Reset rewinds the sequence, and a fresh process with the same seed
+	// produces the same state.
+	require.NoError(t, first.Reset())
+	require.JSONEq(t, string(golden), string(build(first)))
+	second, err := repository.NewDeterministic(":memory:", 42)
+	require.NoError(t, err)
+	defer second.Close()
+	require.JSONEq(t, string(golden), string(build(second)))
+
+	third, err := repository.NewDeterministic(":memory:", 7)
+	require.NoError(t, err)
+	defer third.Close()
+	require.NotEqual(t, string(golden), string(build(third)))
+
+	// The clock only moves when told to, and lifecycle transitions follow it.
+	require.NoError(t, third.SetLifecycle(repository.LifecycleConfig{Default: time.Minute}))
+	lb, err := third.CreateLB("fr-par-1", map[string]any{"name": "lb"})
+	require.NoError(t, err)
+	got, err := third.GetLB(lb["id"].(string))
+	require.NoError(t, err)
+	require.Equal(t, "creating", got["status"])
+	third.AdvanceClock(time.Minute)
+	require.Equal(t, repository.DeterministicEpoch.Add(time.Minute), third.Clock().Now)
+	require.Equal(t, repository.DeterministicEpoch, second.Clock().Now, "each repository has its own clock")
+	got, err = third.GetLB(lb["id"].(string))
+	require.NoError(t, err)
+	require.NotEqual(t, "creating", got["status"])
+
+	// A sandbox starts from the seed, and resetting it leaves its parent's
+	// sequence alone.
+	sb, err := first.Sandbox("a")
+	require.NoError(t, err)
+	require.JSONEq(t, string(golden), string(build(sb)))
+	require.NoError(t, sb.Reset())
+	_, err = first.CreateVPC("fr-par", map[string]any{"name": "after"})
+	require.NoError(t, err)
+	vpcs, err = first.ListVPCs("fr-par")
+	require.NoError(t, err)
+	require.Len(t, vpcs, 2)
+}
+
+func TestLifecycleServerStates(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	require.NoError(t, repo.SetLifecycle(repository.LifecycleConfig{Default: 50 * time.Millisecond}))
+
+	server, err := repo.CreateServer("fr-par-1", map[string]any{"name": "web"})
+	require.NoError(t, err)
+	require.Equal(t, "stopped", server["state"])
+	id := server["id"].(string)
+
+	require.NoError(t, repo.SetServerState(id, "running"))
+	got, err := repo.GetServer(id)
+	require.NoError(t, err)
+	require.Equal(t, "starting", got["state"])
+	require.Eventually(t, func() bool {
+		got, err := repo.GetServer(id)
+		return err == nil && got["state"] == "running"
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, repo.SetServerState(id, "stopped"))
+	got, err = repo.GetServer(id)
+	require.NoError(t, err)
+	require.Equal(t, "stopping", got["state"])
+}
+
+func TestLifecycleDisabledByDefault(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	cluster, err := repo.CreateCluster("fr-par", map[string]any{"name": "k8s"})
+	require.NoError(t, err)
+	require.Equal(t, "ready", cluster["status"])
+	require.NoError(t, repo.DeleteCluster(cluster["id"].(string)))
+	_, err = repo.GetCluster(cluster["id"].(string))
+	require.ErrorIs(t, err, models.ErrNotFound)
+}
+
+// Reads settle due transitions lazily, but with nothing due they must not
+// write, or every GET would queue behind the writer.
+func TestLifecycleReadsDoNotWrite(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+	require.NoError(t, repo.SetLifecycle(repository.LifecycleConfig{Default: time.Hour}))
+
+	server, err := repo.CreateServer("fr-par-1", map[string]any{"name": "web"})
+	require.NoError(t, err)
+	id := server["id"].(string)
+	require.NoError(t, repo.FlushEvents())
+
+	err = repo.Atomic(func(tx *repository.Repository) error {
+		done := make(chan error, 1)
+		go func() {
+			if _, err := repo.GetServer(id); err != nil {
+				done <- err
+				return
+			}
+			if _, err := repo.ListServers("fr-par-1"); err != nil {
+				done <- err
+				return
+			}
+			done <- repo.PollEvents()
+		}()
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("read waited for the open write transaction")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestLifecycleConfigValidation(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	require.Error(t, repo.SetLifecycle(repository.LifecycleConfig{Default: -time.Second}))
+	require.Error(t, repo.SetLifecycle(repository.LifecycleConfig{PerKind: map[string]time.Duration{"nope": time.Second}}))
+
+	delays, err := repository.ParseLifecycleDelays("lb=5s, k8s_cluster=30s")
+	require.NoError(t, err)
+	require.Equal(t, map[string]time.Duration{"lb": 5 * time.Second, "k8s_cluster": 30 * time.Second}, delays)
+	_, err = repository.ParseLifecycleDelays("lb")
+	require.Error(t, err)
+	_, err = repository.ParseLifecycleDelays("lb=soon")
+	require.Error(t, err)
+}
+
+func TestListFilters(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	for _, v := range []map[string]any{
+		{"name": "Main_VPC", "tags": []any{"a", "b"}, "is_default": true},
+		{"name": "other", "tags": []any{"b"}, "is_default": false},
+		{"name": "main-2", "tags": []any{}, "is_default": false},
+	} {
+		_, err := repo.CreateVPC("fr-par", v)
+		require.NoError(t, err)
+	}
+	names := func(filters ...repository.Filter) []string {
+		items, err := repo.ListVPCs("fr-par", filters...)
+		require.NoError(t, err)
+		out := []string{}
+		for _, it := range items {
+			out = append(out, it["name"].(string))
+		}
+		return out
+	}
+
+	require.Equal(t, []string{"Main_VPC", "main-2"}, names(repository.Filter{Field: "name", Op: repository.FilterContains, Values: []any{"MAIN"}}))
+	// LIKE wildcards in the needle are matched literally.
+	require.Equal(t, []string{"Main_VPC"}, names(repository.Filter{Field: "name", Op: repository.FilterContains, Values: []any{"n_v"}}))
+	require.Equal(t, []string{"Main_VPC", "other"}, names(repository.Filter{Field: "tags", Op: repository.FilterHasAll, Values: []any{"b"}}))
+	require.Equal(t, []string{"Main_VPC"}, names(repository.Filter{Field: "tags", Op: repository.FilterHasAll, Values: []any{"b", "a"}}))
+	require.Equal(t, []string{"Main_VPC"}, names(repository.Filter{Field: "is_default", Op: repository.FilterEquals, Values: []any{true}}))
+	require.Equal(t, []string{"other", "main-2"}, names(repository.Filter{Field: "name", Op: repository.FilterIn, Values: []any{"main-2", "other"}}))
+
+	_, err = repo.ListVPCs("fr-par", repository.Filter{Field: "name') OR 1=1 --", Op: repository.FilterEquals, Values: []any{"x"}})
+	require.Error(t, err)
+}
+
+func TestProjectValidationAndOwnership(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	_, err = repo.CreateVPC("fr-par", map[string]any{"name": "v", "project_id": "missing"})
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	project, err := repo.CreateProject(map[string]any{"name": "p", "organization_id": "org-1"})
+	require.NoError(t, err)
+	projectID := project["id"].(string)
+
+	cluster, err := repo.CreateCluster("fr-par", map[string]any{"name": "c", "project_id": projectID})
+	require.NoError(t, err)
+	require.Equal(t, "org-1", cluster["organization_id"])
+
+	require.ErrorIs(t, repo.DeleteProject(projectID), models.ErrConflict)
+	require.NoError(t, repo.DeleteCluster(cluster["id"].(string)))
+	require.NoError(t, repo.DeleteProject(projectID))
+	require.ErrorIs(t, repo.DeleteProject(projectID), models.ErrNotFound)
+	require.ErrorIs(t, repo.DeleteProject(repository.DefaultProjectID), models.ErrPreconditionFailed)
+
+	// Reset keeps the default project around.
+	require.NoError(t, repo.Reset())
+	_, err = repo.GetProject(repository.DefaultProjectID)
+	require.NoError(t, err)
+}
+
+func TestLoadQuotaConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`default:
+  instances: 2
+projects:
+  11111111-1111-1111-1111-111111111111:
+    instances: 5
+`), 0o600))
+
+	cfg, err := repository.LoadQuotaConfig(path)
+	require.NoError(t, err)
+	n, ok := cfg.Limit("11111111-1111-1111-1111-111111111111", "instances")
+	require.True(t, ok)
+	require.Equal(t, 5, n)
+	n, ok = cfg.Limit(repository.DefaultProjectID, "instances")
+	require.True(t, ok)
+	require.Equal(t, 2, n)
+	_, ok = cfg.Limit(repository.DefaultProjectID, "lbs")
+	require.False(t, ok)
+
+	require.NoError(t, os.WriteFile(path, []byte("default:\n  servers: 1\n"), 0o600))
+	_, err = repository.LoadQuotaConfig(path)
+	require.ErrorContains(t, err, `unknown quota kind "servers"`)
+}
+
+func TestIPAllocation(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	_, err = repository.ParseIPPools("fr-par-1=10.0.0.0/33")
+	require.Error(t, err)
+	pools, err := repository.ParseIPPools("default=198.51.100.0/24,fr-par-1=192.0.2.0/30")
+	require.NoError(t, err)
+	require.NoError(t, repo.SetIPPools(pools))
+
+	// A /30 pool holds two usable addresses, handed out lowest first.
+	a, err := repo.CreateIP("fr-par-1", map[string]any{})
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.1", a["address"])
+	b, err := repo.CreateLBIP("fr-par-1", map[string]any{})
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.2", b["ip_address"])
+	_, err = repo.CreateIP("fr-par-1", map[string]any{})
+	var stock *repository.OutOfStockError
+	require.ErrorAs(t, err, &stock)
+	require.ErrorIs(t, err, models.ErrOutOfStock)
+	require.Equal(t, "192.0.2.0/30", stock.Pool)
+
+	// Deleting an IP releases its address.
+	require.NoError(t, repo.DeleteIP(a["id"].(string)))
+	c, err := repo.CreateIP("fr-par-1", map[string]any{})
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.1", c["address"])
+
+	// Other zones fall back to the default pool; regional resources use
+	// their region's first zone.
+	d, err := repo.CreateIP("nl-ams-1", map[string]any{})
+	require.NoError(t, err)
+	require.Equal(t, "198.51.100.1", d["address"])
+
+	// Private addresses come from the private network's own subnet, skip
+	// the gateway and are unique per network only.
+	vpc, err := repo.CreateVPC("fr-par", map[string]any{"name": "v"})
+	require.NoError(t, err)
+	pn1, err := repo.CreatePrivateNetwork("fr-par", map[string]any{"name": "a", "vpc_id": vpc["id"], "ipv4_subnet": map[string]any{"subnet": "10.1.0.0/29"}})
+	require.NoError(t, err)
+	pn2, err := repo.CreatePrivateNetwork("fr-par", map[string]any{"name": "b", "vpc_id": vpc["id"]})
+	require.NoError(t, err)
+	book := func(pn map[string]any, address string) (map[string]any, error) {
+		data := map[string]any{"source": map[string]any{"private_network_id": pn["id"]}}
+		if address != "" {
+			data["address"] = address
+		}
+		return repo.CreateIPAMIP("fr-par", data)
+	}
+	ip, err := book(pn1, "")
+	require.NoError(t, err)
+	require.Equal(t, "10.1.0.2/32", ip["address"])
+	ip, err = book(pn2, "")
+	require.NoError(t, err)
+	require.Equal(t, "172.16.0.2/32", ip["address"])
+	// A booking that names only a subnet counts in that subnet's network.
+	subnetID := pn2["subnets"].([]any)[0].(map[string]any)["id"]
+	_, err = repo.CreateIPAMIP("fr-par", map[string]any{"source": map[string]any{"subnet_id": subnetID}, "address": "172.16.0.3/32"})
+	require.NoError(t, err)
+	ip, err = book(pn2, "")
+	require.NoError(t, err)
+	require.Equal(t, "172.16.0.4/32", ip["address"])
+	_, err = book(pn1, "10.1.0.3/32")
+	require.NoError(t, err)
+	_, err = book(pn1, "10.1.0.3/32")
+	require.ErrorIs(t, err, models.ErrConflict)
+	ip, err = book(pn1, "")
+	require.NoError(t, err)
+	require.Equal(t, "10.1.0.4/32", ip["address"])
+
+	// RDB endpoints get one address each, public or private.
+	eps, err := repo.BuildRDBEndpointsFromInit([]any{
+		map[string]any{"private_network": map[string]any{"id": pn1["id"]}},
+		map[string]any{"private_network": map[string]any{"id": pn1["id"]}},
+	}, "PostgreSQL-15")
+	require.NoError(t, err)
+	db, err := repo.CreateRDBInstance("fr-par", map[string]any{"name": "db", "engine": "PostgreSQL-15", "endpoints": eps})
+	require.NoError(t, err)
+	got := db["endpoints"].([]any)
+	require.Equal(t, "10.1.0.5", got[0].(map[string]any)["ip"])
+	require.Equal(t, "10.1.0.6", got[1].(map[string]any)["ip"])
+	_, err = book(pn1, "")
+	require.ErrorIs(t, err, models.ErrOutOfStock)
+
+	_, err = repo.CreateRDBInstance("fr-par", map[string]any{"name": "db2", "engine": "PostgreSQL-15"})
+	require.ErrorIs(t, err, models.ErrOutOfStock)
+}
+
+func TestEventFeed(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	events, cancel := repo.SubscribeEvents()
+	defer cancel()
+	drain := func() []repository.Event {
+		require.NoError(t, repo.FlushEvents())
+		var out []repository.Event
+		for {
+			select {
+			case ev := <-events:
+				out = append(out, ev)
+			default:
+				return out
+			}
+		}
+	}
+
+	vpc, err := repo.CreateVPC("fr-par", map[string]any{"name": "v"})
+	require.NoError(t, err)
+	got := drain()
+	require.Len(t, got, 1)
+	require.Equal(t, repository.EventCreated, got[0].Type)
+	require.Equal(t, "vpc", got[0].Service)
+	require.Equal(t, "vpc", got[0].ResourceType)
+	require.Equal(t, vpc["id"], got[0].ID)
+	require.Equal(t, "v", got[0].Data["name"])
+
+	_, err = repo.UpdateVPC(vpc["id"].(string), map[string]any{"name": "w"})
+	require.NoError(t, err)
+	got = drain()
+	require.Len(t, got, 1)
+	require.Equal(t, repository.EventUpdated, got[0].Type)
+	require.Equal(t, "w", got[0].Data["name"])
+
+	pn, err := repo.CreatePrivateNetwork("fr-par", map[string]any{"name": "pn", "vpc_id": vpc["id"]})
+	require.NoError(t, err)
+	server, err := repo.CreateServer("fr-par-1", map[string]any{"name": "srv"})
+	require.NoError(t, err)
+	serverID := server["id"].(string)
+	nic, err := repo.CreatePrivateNIC("fr-par-1", serverID, map[string]any{"private_network_id": pn["id"]})
+	require.NoError(t, err)
+	drain()
+
+	// The NIC goes with its server through the foreign key cascade.
+	require.NoError(t, repo.DeleteServer(serverID))
+	byType := map[string]repository.Event{}
+	for _, ev := range drain() {
+		byType[ev.Type+"/"+ev.ResourceType] = ev
+	}
+	require.Equal(t, serverID, byType["deleted/server"].ID)
+	cascade := byType["cascade_deleted/private_nic"]
+	require.Equal(t, nic["id"], cascade.ID)
+	require.Equal(t, &repository.EventRef{Service: "instance", ResourceType: "server", ID: serverID}, cascade.Cause)
+
+	// Lifecycle transitions surface as status changes.
+	require.NoError(t, repo.SetLifecycle(repository.LifecycleConfig{Default: time.Millisecond}))
+	cluster, err := repo.CreateRedisCluster("fr-par-1", map[string]any{"name": "r"})
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, repo.PollEvents())
+	var changed *repository.Event
+	for _, ev := range drain() {
+		if ev.Type == repository.EventStatusChanged && ev.ID == cluster["id"] {
+			changed = &ev
+		}
+	}
+	require.NotNil(t, changed)
+	require.Equal(t, "provisioning", changed.PreviousStatus)
+	require.Equal(t, "ready", changed.Data["status"])
+
+	// Reset is one event, not a delete per row.
+	require.NoError(t, repo.Reset())
+	got = drain()
+	require.NotEmpty(t, got)
+	require.Equal(t, repository.EventReset, got[0].Type)
+	for _, ev := range got[1:] {
+		require.Equal(t, repository.EventCreated, ev.Type)
+	}
+
+	// Sequence numbers keep increasing across flushes.
+	_, err = repo.CreateVPC("fr-par", map[string]any{"name": "x"})
+	require.NoError(t, err)
+	last := drain()
+	require.Greater(t, last[0].Seq, got[len(got)-1].Seq)
+}
+
+func TestGraph(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	vpc, err := repo.CreateVPC("fr-par", map[string]any{"name": "v"})
+	require.NoError(t, err)
+	pn, err := repo.CreatePrivateNetwork("fr-par", map[string]any{"name": "pn", "vpc_id": vpc["id"]})
+	require.NoError(t, err)
+	sg, err := repo.CreateSecurityGroup("fr-par-1", map[string]any{"name": "sg"})
+	require.NoError(t, err)
+	server, err := repo.CreateServer("fr-par-1", map[string]any{"name": "srv", "security_group_id": sg["id"]})
+	require.NoError(t, err)
+	nic, err := repo.CreatePrivateNIC("fr-par-1", server["id"].(string), map[string]any{"private_network_id": pn["id"]})
+	require.NoError(t, err)
+	cluster, err := repo.CreateCluster("fr-par", map[string]any{"name": "k", "private_network_id": pn["id"]})
+	require.NoError(t, err)
+	pool, err := repo.CreatePool("fr-par", cluster["id"].(string), map[string]any{"name": "p"})
+	require.NoError(t, err)
+	ip, err := repo.CreateLBIP("fr-par-1", map[string]any{})
+	require.NoError(t, err)
+	lb, err := repo.CreateLB("fr-par-1", map[string]any{"name": "lb", "ip_id": ip["id"]})
+	require.NoError(t, err)
+	rdb, err := repo.CreateRDBInstance("fr-par", map[string]any{
+		"name":      "db",
+		"endpoints": []any{map[string]any{"private_network": map[string]any{"id": pn["id"]}}},
+	})
+	require.NoError(t, err)
+
+	g, err := repo.Graph()
+	require.NoError(t, err)
+	keys := map[string]bool{}
+	for _, n := range g.Nodes {
+		keys[n.Key] = true
+	}
+	key := func(service, typ string, obj map[string]any) string {
+		k := service + "/" + typ + "/" + obj["id"].(string)
+		require.True(t, keys[k], "missing node %s", k)
+		return k
+	}
+	edges := map[repository.GraphEdge]bool{}
+	for _, e := range g.Edges {
+		require.True(t, keys[e.From], "edge from unknown node %s", e.From)
+		require.True(t, keys[e.To], "edge to unknown node %s", e.To)
+		edges[e] = true
+	}
+	want := []repository.GraphEdge{
+		{From: key("instance", "server", server), To: key("instance", "security_group", sg), Label: "security_group_id"},
+		{From: key("instance", "private_nic", nic), To: key("instance", "server", server), Label: "server_id"},
+		{From: key("instance", "private_nic", nic), To: key("vpc", "private_network", pn), Label: "private_network_id"},
+		{From: key("vpc", "private_network", pn), To: key("vpc", "vpc", vpc), Label: "vpc_id"},
+		{From: key("k8s", "pool", pool), To: key("k8s", "cluster", cluster), Label: "cluster_id"},
+		{From: key("k8s", "cluster", cluster), To: key("vpc", "private_network", pn), Label: "private_network_id"},
+		{From: key("lb", "lb", lb), To: key("lb", "ip", ip), Label: "ip_id"},
+		{From: key("rdb", "instance", rdb), To: key("vpc", "private_network", pn), Label: "endpoints[].private_network.id"},
+	}
+	for _, e := range want {
+		require.True(t, edges[e], "missing edge %+v", e)
+	}
+
+	var dot, mermaid bytes.Buffer
+	g.WriteDOT(&dot)
+	require.Contains(t, dot.String(), fmt.Sprintf("%q -> %q", want[0].From, want[0].To))
+	g.WriteMermaid(&mermaid)
+	require.True(t, strings.HasPrefix(mermaid.String(), "flowchart LR\n"))
+	require.Contains(t, mermaid.String(), "-->|vpc_id|")
+}
+
+func TestCost(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	proj, err := repo.CreateProject(map[string]any{"name": "web"})
+	require.NoError(t, err)
+	project := proj["id"].(string)
+	_, err = repo.CreateServer("fr-par-1", map[string]any{"name": "web", "commercial_type": "DEV1-S", "project_id": project})
+	require.NoError(t, err)
+	_, err = repo.CreateServer("fr-par-1", map[string]any{"name": "odd", "commercial_type": "NOPE-1"})
+	require.NoError(t, err)
+	// The Instance API names the project in "project".
+	apiProj, err := repo.CreateProject(map[string]any{"name": "api"})
+	require.NoError(t, err)
+	apiProject := apiProj["id"].(string)
+	_, err = repo.CreateServer("fr-par-1", map[string]any{"name": "api", "commercial_type": "DEV1-S", "project": apiProject})
+	require.NoError(t, err)
+	cluster, err := repo.CreateCluster("fr-par", map[string]any{"name": "k"})
+	require.NoError(t, err)
+	_, err = repo.CreatePool("fr-par", cluster["id"].(string), map[string]any{"name": "p", "node_type": "gp1_xs", "size": float64(3)})
+	require.NoError(t, err)
+	_, err = repo.CreateRDBInstance("fr-par", map[string]any{
+		"name":          "db",
+		"node_type":     "DB-DEV-S",
+		"is_ha_cluster": true,
+		"volume":        map[string]any{"type": "bssd", "size": float64(50000000000)},
+	})
+	require.NoError(t, err)
+	_, err = repo.CreateIP("fr-par-1", map[string]any{})
+	require.NoError(t, err)
+
+	est, err := repo.Cost()
+	require.NoError(t, err)
+	require.Equal(t, "EUR", est.Currency)
+	byName := map[string]repository.CostItem{}
+	for _, item := range est.Resources {
+		byName[item.Type+"/"+item.Name] = item
+	}
+	require.Equal(t, 0.018, byName["server/web"].Hourly)
+	require.Equal(t, project, byName["server/web"].ProjectID)
+	require.InDelta(t, 0.018*730, byName["server/web"].Monthly, 1e-9)
+	require.Equal(t, 0.18, byName["pool/p"].Hourly)
+	require.Equal(t, "3 × gp1_xs", byName["pool/p"].Item)
+	// Two HA nodes plus 50 GB of bssd at 0.08 per GB-month.
+	require.InDelta(t, 2*0.015*730+50*0.08, byName["instance/db"].Monthly, 1e-3)
+	require.Len(t, est.Unpriced, 1)
+	require.Equal(t, "odd", est.Unpriced[0].Name)
+	require.Equal(t, 0.018, est.Projects[project].Hourly)
+	require.Equal(t, apiProject, byName["server/api"].ProjectID)
+	require.Equal(t, 0.018, est.Projects[apiProject].Hourly)
+	require.InDelta(t, 0.18, est.Services["k8s"].Hourly, 1e-9)
+	var sum float64
+	for _, item := range est.Resources {
+		sum += item.Hourly
+	}
+	require.InDelta(t, sum, est.Total.Hourly, 1e-3)
+
+	// Overrides are laid over the bundled prices.
+	require.NoError(t, repo.SetPricing(repository.PricingTable{Servers: map[string]float64{"NOPE-1": 1}}))
+	est, err = repo.Cost()
+	require.NoError(t, err)
+	require.Empty(t, est.Unpriced)
+	require.Equal(t, 0.018, repo.Pricing().Servers["DEV1-S"])
+	require.Error(t, repo.SetPricing(repository.PricingTable{LoadBalancers: map[string]float64{"LB-S": -1}}))
+}
+
+func TestReferences(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	vpc, err := repo.CreateVPC("fr-par", map[string]any{"name": "v"})
+	require.NoError(t, err)
+	pn, err := repo.CreatePrivateNetwork("fr-par", map[string]any{"name": "pn", "vpc_id": vpc["id"]})
+	require.NoError(t, err)
+	pnID := pn["id"].(string)
+	lb, err := repo.CreateLB("fr-par-1", map[string]any{"name": "lb"})
+	require.NoError(t, err)
+	lbID := lb["id"].(string)
+
+	// 404 on create: an embedded id that does not exist.
+	_, err = repo.CreateFrontend(map[string]any{"lb_id": lbID, "backend_id": "missing"})
+	var notFound *repository.ReferenceNotFoundError
+	require.ErrorAs(t, err, &notFound)
+	require.ErrorIs(t, err, models.ErrNotFound)
+	require.Equal(t, "backend", notFound.Resource())
+	require.Equal(t, "missing", notFound.ID)
+	rdb, err := repo.CreateRDBInstance("fr-par", map[string]any{"name": "db"})
+	require.NoError(t, err)
+	_, err = repo.CreateRDBEndpoint(rdb["id"].(string), map[string]any{"private_network": map[string]any{"id": "missing"}})
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	// 409 on delete while referenced.
+	backend, err := repo.CreateBackend(map[string]any{"lb_id": lbID, "name": "b"})
+	require.NoError(t, err)
+	frontend, err := repo.CreateFrontend(map[string]any{"lb_id": lbID, "backend_id": backend["id"]})
+	require.NoError(t, err)
+	err = repo.DeleteBackend(backend["id"].(string))
+	var referenced *repository.ReferencedError
+	require.ErrorAs(t, err, &referenced)
+	require.ErrorIs(t, err, models.ErrConflict)
+	require.Equal(t, "lb_frontends "+frontend["id"].(string), referenced.By)
+	require.NoError(t, repo.DeleteFrontend(frontend["id"].(string)))
+	require.NoError(t, repo.DeleteBackend(backend["id"].(string)))
+
+	_, err = repo.CreateRDBEndpoint(rdb["id"].(string), map[string]any{"private_network": map[string]any{"id": pnID}})
+	require.NoError(t, err)
+	require.ErrorIs(t, repo.DeletePrivateNetwork(pnID), models.ErrConflict)
+	require.NoError(t, repo.DeleteRDBInstance(rdb["id"].(string)))
+
+	// Cascade: IPAM IPs go with their private network, API keys with their user.
+	ipamIP, err := repo.CreateIPAMIP("fr-par", map[string]any{"source": map[string]any{"private_network_id": pnID}})
+	require.NoError(t, err)
+	require.NoError(t, repo.DeletePrivateNetwork(pnID))
+	_, err = repo.GetIPAMIP(ipamIP["id"].(string))
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	user, err := repo.CreateIAMUser(map[string]any{"email": "u@example.com"})
+	require.NoError(t, err)
+	key, err := repo.CreateIAMAPIKey(map[string]any{"user_id": user["id"]})
+	require.NoError(t, err)
+	require.NoError(t, repo.DeleteIAMUser(user["id"].(string)))
+	_, err = repo.GetIAMAPIKey(key["access_key"].(string))
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	// Set null: servers lose a deleted security group.
+	sg, err := repo.CreateSecurityGroup("fr-par-1", map[string]any{"name": "sg"})
+	require.NoError(t, err)
+	server, err := repo.CreateServer("fr-par-1", map[string]any{"name": "srv", "security_group": map[string]any{"id": sg["id"]}})
+	require.NoError(t, err)
+	require.NoError(t, repo.DeleteSecurityGroup(sg["id"].(string)))
+	server, err = repo.GetServer(server["id"].(string))
+	require.NoError(t, err)
+	require.Nil(t, server["security_group"])
+}
+
+// TestReferenceIndexes checks that deletes find the rows referencing them
+// through an index rather than by scanning the referencing table.
+func TestReferenceIndexes(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "repo.db")
+	repo, err := repository.New(dbPath)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	db, err := sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+	defer db.Close()
+
+	for query, index := range map[string]string{
+		`SELECT rowid, * FROM ipam_ips WHERE json_valid(data) AND json_extract(data, '$.source.private_network_id') IN (?)`: "ref_ipam_ips_source__private_network_id",
+		`SELECT rowid, * FROM lb_frontends WHERE json_valid(data) AND json_extract(data, '$.backend_id') IN (?)`:            "ref_lb_frontends_backend_id",
+		`SELECT rowid, * FROM domain_records WHERE dns_zone IN (?)`:                                                         "ref_domain_records_dns_zone",
+	} {
+		var plan []string
+		rows, err := db.Query("EXPLAIN QUERY PLAN "+query, "a")
+		require.NoError(t, err)
+		for rows.Next() {
+			var id, parent, unused int
+			var detail string
+			require.NoError(t, rows.Scan(&id, &parent, &unused, &detail))
+			plan = append(plan, detail)
+		}
+		require.NoError(t, rows.Err())
+		rows.Close()
+		require.Contains(t, strings.Join(plan, "\n"), "USING INDEX "+index, query)
+	}
+}
+
+// TestTableCatalog checks that repository.Tables covers every table of the
+// schema and matches it, and that Reset, FullState and ServiceState follow it.
+func TestTableCatalog(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "repo.db")
+	repo, err := repository.New(dbPath)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	db, err := sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	require.NoError(t, err)
+	var live []string
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		live = append(live, name)
+	}
+	require.NoError(t, rows.Err())
+	rows.Close()
+
+	declared := map[string]int{}
+	for i, table := range repository.Tables {
+		declared[table.Name] = i
+	}
+	require.Len(t, declared, len(repository.Tables), "duplicate table in the catalog")
+	for _, name := range live {
+		require.Contains(t, declared, name, "table %s is missing from repository.Tables", name)
+	}
+	require.Len(t, live, len(repository.Tables))
+
+	for i, table := range repository.Tables {
+		var pk []string
+		var scope bool
+		info, err := db.Query(fmt.Sprintf(`SELECT name, pk FROM pragma_table_info('%s') WHERE pk > 0 OR name = ? ORDER BY pk`, table.Name), table.Scope)
+		require.NoError(t, err)
+		for info.Next() {
+			var name string
+			var n int
+			require.NoError(t, info.Scan(&name, &n))
+			if n > 0 {
+				pk = append(pk, name)
+			} else {
+				scope = true
+			}
+		}
+		info.Close()
+		require.Equal(t, table.ID, pk, "%s primary key", table.Name)
+		require.True(t, table.Scope == "" || scope || slices.Contains(pk, table.Scope), "%s has no %s column", table.Name, table.Scope)
+
+		fks, err := db.Query(fmt.Sprintf(`SELECT DISTINCT "table" FROM pragma_foreign_key_list('%s')`, table.Name))
+		require.NoError(t, err)
+		var parents []string
+		for fks.Next() {
+			var parent string
+			require.NoError(t, fks.Scan(&parent))
+			parents = append(parents, parent)
+			require.Less(t, declared[parent], i, "%s is listed before its parent %s", table.Name, parent)
+		}
+		fks.Close()
+		require.ElementsMatch(t, table.Parents, parents, "%s parents", table.Name)
+	}
+
+	// Quotas count project-scoped rows, whose project can be looked up by
+	// their one-column key.
+	var kinds []string
+	for _, table := range repository.Tables {
+		if table.ProjectScoped {
+			require.Len(t, table.ID, 1, "%s is project-scoped but has a composite key", table.Name)
+			require.NotEmpty(t, table.Service, "%s is project-scoped but not exported", table.Name)
+		}
+		if table.Quota != "" {
+			require.True(t, table.ProjectScoped, "%s has a quota but no project", table.Name)
+			kinds = append(kinds, table.Quota)
+		}
+	}
+	require.ElementsMatch(t, repository.QuotaKinds(), kinds)
+
+	// Every kind is in the state document, and each service's state is its
+	// part of it.
+	vpc, err := repo.CreateVPC("fr-par", map[string]any{"name": "v"})
+	require.NoError(t, err)
+	_, err = repo.CreatePrivateNetwork("fr-par", map[string]any{"name": "pn", "vpc_id": vpc["id"]})
+	require.NoError(t, err)
+	_, err = repo.CreateServer("fr-par-1", map[string]any{"name": "srv"})
+	require.NoError(t, err)
+	require.NoError(t, repo.AddMarketplaceLabel("ubuntu"))
+	state, err := repo.FullState()
+	require.NoError(t, err)
+	for _, table := range repository.Tables {
+		if table.Service == "" {
+			continue
+		}
+		require.Contains(t, state, table.Service)
+		if table.Kind != "" {
+			require.Contains(t, state[table.Service], table.Kind)
+		}
+		svc, err := repo.ServiceState(table.Service)
+		require.NoError(t, err)
+		require.Equal(t, state[table.Service], svc)
+	}
+	_, err = repo.ServiceState("billing")
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	// Reset empties every table but the kept ones and the default project.
+	require.NoError(t, repo.Reset())
+	tables, err := repo.TableInfo()
+	require.NoError(t, err)
+	require.Len(t, tables, len(repository.Tables))
+	for _, table := range tables {
+		switch {
+		case table.Keep:
+		case table.Name == "account_projects":
+			require.Equal(t, 1, table.Rows)
+		default:
+			require.Zero(t, table.Rows, "%s not emptied by Reset", table.Name)
+		}
+	}
+}
+
+func TestAtomic(t *testing.T) {
+	repo, err := repository.New(":memory:")
+	require.NoError(t, err)
+	defer repo.Close()
+
+	// An error rolls back every write, including those of nested calls.
+	boom := errors.New("boom")
+	err = repo.Atomic(func(tx *repository.Repository) error {
+		if _, err := tx.CreateVPC("fr-par", map[string]any{"name": "rolled-back"}); err != nil {
+			return err
+		}
+		return tx.Atomic(func(inner *repository.Repository) error {
+			require.Same(t, tx, inner)
+			return boom
+		})
+	})
+	require.ErrorIs(t, err, boom)
+	vpcs, err := repo.ListVPCs("fr-par")
+	require.NoError(t, err)
+	require.Empty(t, vpcs)
+
+	// Readers on the pool run while the transaction is open and do not see
+	// its writes until it commits.
+	err = repo.Atomic(func(tx *repository.Repository) error {
+		if _, err := tx.CreateVPC("fr-par", map[string]any{"name": "committed"}); err != nil {
+			return err
+		}
+		done := make(chan []map[string]any)
+		go func() {
+			vpcs, _ := repo.ListVPCs("fr-par")
+			done <- vpcs
+		}()
+		select {
+		case vpcs := <-done:
+			require.Empty(t, vpcs)
+		case <-time.After(5 * time.Second):
+			t.Fatal("read blocked behind the open transaction")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	vpcs, err = repo.ListVPCs("fr-par")
+	require.NoError(t, err)
+	require.Len(t, vpcs, 1)
+	require.Equal(t, "committed", vpcs[0]["name"])
+
+	// A transaction that read a row another writer has since updated fails
+	// its own update instead of overwriting the newer version.
+	id := vpcs[0]["id"].(string)
+	err = repo.Atomic(func(tx *repository.Repository) error {
+		if _, err := tx.GetVPC(id); err != nil {
+			return err
+		}
+		if _, err := repo.UpdateVPC(id, map[string]any{"name": "theirs"}); err != nil {
+			return err
+		}
+		_, err := tx.UpdateVPC(id, map[string]any{"name": "mine"})
+		return err
+	})
+	require.ErrorIs(t, err, models.ErrConcurrentUpdate)
+	vpc, err := repo.GetVPC(id)
+	require.NoError(t, err)
+	require.Equal(t, "theirs", vpc["name"])
+}
+
+func TestSandbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mockway.sqlite")
+	repo, err := repository.New(path)
+	require.NoError(t, err)
+	require.NoError(t, repo.SetQuotas(repository.QuotaConfig{Default: map[string]int{"vpcs": 1}}))
+
+	sb, err := repo.Sandbox("ci-1")
+	require.NoError(t, err)
+	again, err := repo.Sandbox("ci-1")
+	require.NoError(t, err)
+	require.Same(t, sb, again)
+	require.Equal(t, repo.Quotas(), sb.Quotas(), "a new sandbox starts with the root's settings")
+
+	_, err = sb.CreateVPC("fr-par", map[string]any{"name": "in-sandbox"})
+	require.NoError(t, err)
+	vpcs, err := repo.ListVPCs("fr-par")
+	require.NoError(t, err)
+	require.Empty(t, vpcs)
+
+	_, err = repo.Sandbox("../escape")
+	require.ErrorIs(t, err, repository.ErrInvalidSandboxName)
+	_, err = repo.Sandbox("")
+	require.ErrorIs(t, err, repository.ErrInvalidSandboxName)
+	require.ErrorIs(t, repo.DeleteSandbox("missing"), models.ErrNotFound)
+
+	// A file-backed server finds its sandboxes again after a restart.
+	require.NoError(t, repo.Close())
+	repo, err = repository.New(path)
+	require.NoError(t, err)
+	defer repo.Close()
+	names, err := repo.Sandboxes()
+	require.NoError(t, err)
+	require.Equal(t, []string{"ci-1"}, names)
+	sb, err = repo.Sandbox("ci-1")
+	require.NoError(t, err)
+	vpcs, err = sb.ListVPCs("fr-par")
+	require.NoError(t, err)
+	require.Len(t, vpcs, 1)
+
+	// The limit counts the sandboxes on disk, not just the open ones.
+	repo.SetMaxSandboxes(1)
+	_, err = repo.Sandbox("ci-2")
+	require.ErrorIs(t, err, repository.ErrTooManySandboxes)
+
+	require.NoError(t, repo.DeleteSandbox("ci-1"))
+	names, err = repo.Sandboxes()
+	require.NoError(t, err)
+	require.Empty(t, names)
+}
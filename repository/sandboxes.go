@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/redscaresu/mockway/models"
+)
+
+// DefaultMaxSandboxes is how many sandboxes a Repository holds unless
+// SetMaxSandboxes says otherwise.
+const DefaultMaxSandboxes = 64
+
+var (
+	// ErrInvalidSandboxName rejects names that would not make a safe file name.
+	ErrInvalidSandboxName = errors.New("sandbox names must be " + nameRule)
+	// ErrTooManySandboxes rejects a new sandbox once the limit is reached.
+	ErrTooManySandboxes = errors.New("sandbox limit reached; delete one with DELETE /mock/sandboxes/{name}")
+)
+
+// sandboxSet holds the sandboxes a Repository has opened, by name, and
+// how many it may have.
+type sandboxSet struct {
+	mu   sync.Mutex
+	open map[string]*Repository
+	max  int
+}
+
+// SetMaxSandboxes caps how many sandboxes r holds; creating one more
+// fails with ErrTooManySandboxes. n <= 0 restores DefaultMaxSandboxes.
+func (r *Repository) SetMaxSandboxes(n int) {
+	r.sandboxes.mu.Lock()
+	defer r.sandboxes.mu.Unlock()
+	r.sandboxes.max = n
+}
+
+// MaxSandboxes returns the cap SetMaxSandboxes set.
+func (r *Repository) MaxSandboxes() int {
+	r.sandboxes.mu.Lock()
+	defer r.sandboxes.mu.Unlock()
+	return r.maxSandboxesLocked()
+}
+
+func (r *Repository) maxSandboxesLocked() int {
+	if r.sandboxes.max <= 0 {
+		return DefaultMaxSandboxes
+	}
+	return r.sandboxes.max
+}
+
+// sandboxDir holds one database per sandbox next to the main one, with
+// the sandbox's own snapshots beside it.
+func (r *Repository) sandboxDir() string {
+	return r.path + ".sandboxes"
+}
+
+func (r *Repository) sandboxPath(name string) (string, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return "", ErrInvalidSandboxName
+	}
+	return filepath.Join(r.sandboxDir(), name+".sqlite"), nil
+}
+
+// Sandbox returns the named sandbox: a Repository with a database of its
+// own, so nothing done through it is visible through r or another sandbox.
+// It is created on first use, empty but for the default project, with r's
+// lifecycle delays, IAM, quota, IP pool and pricing settings and, in
+// deterministic mode, a clock and ids starting afresh from r's seed;
+// changing those afterwards affects only the one changed. A file-backed r
+// keeps its sandboxes across restarts. Creating a sandbox when r already
+// has MaxSandboxes of them fails with ErrTooManySandboxes.
+func (r *Repository) Sandbox(name string) (*Repository, error) {
+	path, err := r.sandboxPath(name)
+	if err != nil {
+		return nil, err
+	}
+	r.sandboxes.mu.Lock()
+	defer r.sandboxes.mu.Unlock()
+	if sb, ok := r.sandboxes.open[name]; ok {
+		return sb, nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		names, err := r.Sandboxes()
+		if err != nil {
+			return nil, err
+		}
+		if len(names) >= r.maxSandboxesLocked() {
+			return nil, ErrTooManySandboxes
+		}
+	}
+	if err := os.MkdirAll(r.sandboxDir(), 0o700); err != nil {
+		return nil, fmt.Errorf("create sandbox dir: %w", err)
+	}
+	var sb *Repository
+	if seed, ok := r.deterministicSeed(); ok {
+		sb, err = NewDeterministic(path, seed)
+	} else {
+		sb, err = New(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open sandbox %s: %w", name, err)
+	}
+	sb.cleanupOnClose = r.cleanupOnClose
+	sb.lifecycle = r.Lifecycle()
+	sb.iam = r.IAMEnforcement()
+	sb.quotas = r.Quotas()
+	sb.ipPools = r.IPPools()
+	r.pricingMu.RLock()
+	sb.pricing = r.pricing
+	r.pricingMu.RUnlock()
+
+	if r.sandboxes.open == nil {
+		r.sandboxes.open = map[string]*Repository{}
+	}
+	r.sandboxes.open[name] = sb
+	return sb, nil
+}
+
+// Sandboxes lists the names of the sandboxes r has a database for.
+func (r *Repository) Sandboxes() ([]string, error) {
+	entries, err := os.ReadDir(r.sandboxDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read sandbox dir: %w", err)
+	}
+	names := []string{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".sqlite")
+		if ok && !e.IsDir() && snapshotNamePattern.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DeleteSandbox closes the named sandbox and removes its database and
+// snapshots. Requests still using it fail. A sandbox with no database
+// yields ErrNotFound.
+func (r *Repository) DeleteSandbox(name string) error {
+	path, err := r.sandboxPath(name)
+	if err != nil {
+		return err
+	}
+	r.sandboxes.mu.Lock()
+	defer r.sandboxes.mu.Unlock()
+	sb, ok := r.sandboxes.open[name]
+	if !ok {
+		if _, err := os.Stat(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return models.ErrNotFound
+			}
+			return fmt.Errorf("stat sandbox: %w", err)
+		}
+		if sb, err = New(path); err != nil {
+			return fmt.Errorf("open sandbox %s: %w", name, err)
+		}
+	}
+	delete(r.sandboxes.open, name)
+	sb.cleanupOnClose = true
+	return sb.Close()
+}
+
+// closeSandboxes closes every open sandbox.
+func (r *Repository) closeSandboxes() {
+	r.sandboxes.mu.Lock()
+	defer r.sandboxes.mu.Unlock()
+	for name, sb := range r.sandboxes.open {
+		_ = sb.Close()
+		delete(r.sandboxes.open, name)
+	}
+}
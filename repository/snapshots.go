@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redscaresu/mockway/models"
+)
+
+// nameRule describes the names snapshotNamePattern accepts.
+const nameRule = "1-64 letters, digits, '.', '_' or '-' and not start with '.'"
+
+// ErrInvalidSnapshotName rejects names that would not make a safe file name.
+var ErrInvalidSnapshotName = errors.New("snapshot names must be " + nameRule)
+
+// snapshotNamePattern accepts the names of snapshots and sandboxes, which
+// are both used as file names.
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,63}$`)
+
+// SnapshotInfo describes a named snapshot. ResourceCounts holds the row
+// count of every non-empty resource table at the time it was taken.
+type SnapshotInfo struct {
+	Name           string         `json:"name"`
+	CreatedAt      time.Time      `json:"created_at"`
+	ResourceCounts map[string]int `json:"resource_counts"`
+	TotalResources int            `json:"total_resources"`
+}
+
+// snapshotDir holds named snapshots next to the database, one .sqlite copy
+// plus a .json metadata file per name. Unlike the single /mock/snapshot
+// file they survive Reset, so a baseline can be restored after a wipe.
+func (r *Repository) snapshotDir() string {
+	return r.path + ".snapshots"
+}
+
+func (r *Repository) namedSnapshotPaths(name string) (db, meta string, err error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return "", "", ErrInvalidSnapshotName
+	}
+	base := filepath.Join(r.snapshotDir(), name)
+	return base + ".sqlite", base + ".json", nil
+}
+
+// CreateNamedSnapshot copies the current state to the named snapshot,
+// replacing any snapshot of the same name.
+func (r *Repository) CreateNamedSnapshot(name string) (SnapshotInfo, error) {
+	dbPath, metaPath, err := r.namedSnapshotPaths(name)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	if err := os.MkdirAll(r.snapshotDir(), 0o700); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("create snapshot dir: %w", err)
+	}
+	counts, total, err := r.resourceCounts()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	if err := os.Remove(dbPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return SnapshotInfo{}, fmt.Errorf("remove old snapshot: %w", err)
+	}
+	if _, err := r.db.Exec(`VACUUM main INTO ` + sqliteStringLiteral(dbPath)); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("snapshot db: %w", err)
+	}
+	info := SnapshotInfo{
+		Name:           name,
+		CreatedAt:      r.Now(),
+		ResourceCounts: counts,
+		TotalResources: total,
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	if err := os.WriteFile(metaPath, b, 0o600); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("write snapshot metadata: %w", err)
+	}
+	return info, nil
+}
+
+// GetNamedSnapshot returns the metadata of one named snapshot.
+func (r *Repository) GetNamedSnapshot(name string) (SnapshotInfo, error) {
+	_, metaPath, err := r.namedSnapshotPaths(name)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	b, err := os.ReadFile(metaPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return SnapshotInfo{}, models.ErrNotFound
+	}
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	var info SnapshotInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("read snapshot metadata: %w", err)
+	}
+	return info, nil
+}
+
+// ListNamedSnapshots returns every named snapshot, oldest first.
+func (r *Repository) ListNamedSnapshots() ([]SnapshotInfo, error) {
+	entries, err := os.ReadDir(r.snapshotDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return []SnapshotInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := []SnapshotInfo{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		info, err := r.GetNamedSnapshot(name)
+		if err != nil {
+			if errors.Is(err, models.ErrNotFound) || errors.Is(err, ErrInvalidSnapshotName) {
+				continue
+			}
+			return nil, err
+		}
+		out = append(out, info)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+// RestoreNamedSnapshot replaces the current state with the named snapshot.
+// The snapshot is kept, so it can be restored again.
+func (r *Repository) RestoreNamedSnapshot(name string) error {
+	dbPath, _, err := r.namedSnapshotPaths(name)
+	if err != nil {
+		return err
+	}
+	return r.restoreFrom(dbPath)
+}
+
+// DeleteNamedSnapshot removes the named snapshot.
+func (r *Repository) DeleteNamedSnapshot(name string) error {
+	dbPath, metaPath, err := r.namedSnapshotPaths(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(metaPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return models.ErrNotFound
+		}
+		return err
+	}
+	if err := os.Remove(dbPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// resourceCounts counts the rows of every non-empty resource table.
+func (r *Repository) resourceCounts() (map[string]int, int, error) {
+	all, err := r.TableCounts()
+	if err != nil {
+		return nil, 0, err
+	}
+	counts := map[string]int{}
+	total := 0
+	for table, n := range all {
+		if n > 0 {
+			counts[table] = n
+			total += n
+		}
+	}
+	return counts, total, nil
+}
+
+// TableCounts counts the rows of every resource table, empty ones included.
+func (r *Repository) TableCounts() (map[string]int, error) {
+	counts := map[string]int{}
+	for _, t := range Tables {
+		if t.Service == "" {
+			continue
+		}
+		var n int
+		if err := r.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", t.Name)).Scan(&n); err != nil {
+			return nil, err
+		}
+		counts[t.Name] = n
+	}
+	return counts, nil
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+
+	"github.com/redscaresu/mockway/internal/metrics"
+	"modernc.org/sqlite"
+)
+
+// SQLiteDuration times the statements every repository runs, by operation:
+// exec, query (until the first row is ready), begin and commit.
+var SQLiteDuration = metrics.NewHistogramVec(
+	"mockway_sqlite_duration_seconds",
+	"Time spent in SQLite per statement.",
+	metrics.DefaultBuckets,
+	"op",
+)
+
+// timedConnector opens SQLite connections whose statements are recorded in
+// SQLiteDuration.
+type timedConnector struct {
+	dsn    string
+	driver *sqlite.Driver
+}
+
+func (c timedConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &timedConn{conn: conn}, nil
+}
+
+func (c timedConnector) Driver() driver.Driver { return c.driver }
+
+// openTimedDB is sql.Open("sqlite", dsn) with statement timing.
+func openTimedDB(dsn string) *sql.DB {
+	return sql.OpenDB(timedConnector{dsn: dsn, driver: &sqlite.Driver{}})
+}
+
+func observeSQL(op string, start time.Time) {
+	SQLiteDuration.Observe(time.Since(start).Seconds(), op)
+}
+
+// timedConn forwards to the SQLite connection, timing each call. It
+// implements the same optional interfaces, so database/sql takes the same
+// paths it would without it.
+type timedConn struct {
+	conn driver.Conn
+}
+
+func (c *timedConn) Prepare(query string) (driver.Stmt, error) { return c.conn.Prepare(query) }
+func (c *timedConn) Close() error                              { return c.conn.Close() }
+
+func (c *timedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *timedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	defer observeSQL("begin", time.Now())
+	tx, err := c.conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return timedTx{tx}, nil
+}
+
+func (c *timedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+}
+
+func (c *timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	defer observeSQL("exec", time.Now())
+	return c.conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c *timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	defer observeSQL("query", time.Now())
+	return c.conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func (c *timedConn) Ping(ctx context.Context) error {
+	return c.conn.(driver.Pinger).Ping(ctx)
+}
+
+func (c *timedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *timedConn) IsValid() bool {
+	if v, ok := c.conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+type timedTx struct {
+	tx driver.Tx
+}
+
+func (t timedTx) Commit() error {
+	defer observeSQL("commit", time.Now())
+	return t.tx.Commit()
+}
+
+func (t timedTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+var (
+	_ driver.ConnBeginTx        = (*timedConn)(nil)
+	_ driver.ConnPrepareContext = (*timedConn)(nil)
+	_ driver.ExecerContext      = (*timedConn)(nil)
+	_ driver.QueryerContext     = (*timedConn)(nil)
+	_ driver.Pinger             = (*timedConn)(nil)
+	_ driver.SessionResetter    = (*timedConn)(nil)
+	_ driver.Validator          = (*timedConn)(nil)
+)
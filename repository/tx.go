@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/redscaresu/mockway/models"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// handle runs statements on the pool, or on tx when the Repository was
+// handed out by Atomic, in which case state tracks the transaction.
+type handle struct {
+	pool  *atomic.Pointer[sql.DB]
+	tx    *sql.Tx
+	state *txState
+}
+
+func (h handle) q() querier {
+	if h.tx != nil {
+		return h.tx
+	}
+	return h.pool.Load()
+}
+
+// Exec runs a statement. Inside Atomic, a write SQLite refuses because
+// another transaction committed since this one first read (or holds the
+// write lock it would need) fails with models.ErrConcurrentUpdate.
+func (h handle) Exec(query string, args ...any) (sql.Result, error) {
+	res, err := h.q().Exec(query, args...)
+	if h.state != nil && isBusy(err) {
+		h.state.conflict = true
+		return nil, fmt.Errorf("%w: %v", models.ErrConcurrentUpdate, err)
+	}
+	return res, err
+}
+
+// isBusy reports whether err is SQLITE_BUSY or one of its extended codes,
+// such as SQLITE_BUSY_SNAPSHOT.
+func isBusy(err error) bool {
+	var se *sqlite.Error
+	return errors.As(err, &se) && se.Code()&0xff == sqlite3.SQLITE_BUSY
+}
+
+func (h handle) Query(query string, args ...any) (*sql.Rows, error) {
+	return h.q().Query(query, args...)
+}
+
+func (h handle) QueryRow(query string, args ...any) *sql.Row {
+	return h.q().QueryRow(query, args...)
+}
+
+// txn is an open transaction: *sql.Tx on the pool, or a savepoint inside
+// Atomic.
+type txn interface {
+	querier
+	Commit() error
+	Rollback() error
+}
+
+// Begin starts a transaction. Pool transactions are deferred (see openDB).
+// Inside Atomic it opens a savepoint, so a method's own rollback undoes
+// only its own writes.
+func (h handle) Begin() (txn, error) {
+	if h.tx == nil {
+		return h.pool.Load().Begin()
+	}
+	if _, err := h.tx.Exec(`SAVEPOINT nested`); err != nil {
+		return nil, err
+	}
+	return &savepoint{Tx: h.tx}, nil
+}
+
+// savepoint releases or rolls back the innermost savepoint named nested;
+// SQLite resolves a repeated name to the most recent one, so they nest.
+type savepoint struct {
+	*sql.Tx
+	done bool
+}
+
+func (s *savepoint) Commit() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	_, err := s.Exec(`RELEASE nested`)
+	return err
+}
+
+func (s *savepoint) Rollback() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	if _, err := s.Exec(`ROLLBACK TO nested`); err != nil {
+		return err
+	}
+	_, err := s.Exec(`RELEASE nested`)
+	return err
+}
+
+// txState is what Atomic tracks for its transaction: the data of each row
+// read through getJSONByID, which updateJSONByID expects to overwrite, and
+// whether a write found the data changed under it.
+type txState struct {
+	reads    map[string]string
+	conflict bool
+}
+
+// Atomic runs fn in one transaction: fn's Repository runs every statement
+// in it, and it commits if fn returns nil and rolls back otherwise. The
+// transaction is deferred, so it reads without blocking anyone and takes
+// the write lock at its first write. If by then another transaction has
+// committed since this one first read, or an update in fn found its row
+// changed since fn read it, the transaction is rolled back and Atomic
+// returns models.ErrConcurrentUpdate, whatever fn returned; the caller may
+// run it again. Calling Atomic on fn's Repository just calls fn.
+func (r *Repository) Atomic(fn func(*Repository) error) error {
+	if r.db.tx != nil {
+		return fn(r)
+	}
+	r.gate.RLock()
+	defer r.gate.RUnlock()
+
+	tx, err := r.pool.Load().Begin()
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	state := &txState{reads: map[string]string{}}
+	err = fn(&Repository{shared: r.shared, db: handle{pool: &r.pool, tx: tx, state: state}})
+	if state.conflict {
+		return models.ErrConcurrentUpdate
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		if isBusy(err) {
+			return models.ErrConcurrentUpdate
+		}
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+func readKey(table, id string) string { return table + "/" + id }
@@ -0,0 +1,299 @@
+// Package specs embeds the Scaleway OpenAPI documents mockway is built
+// against and exposes the bits the handlers need at runtime: operations
+// (method + templated path), their parameters, and request/response schemas.
+//
+// The YAML files are the same ones scripts/spec_diff.py reads; refresh them
+// there and the embedded copy follows on the next build.
+package specs
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed *.yml
+var files embed.FS
+
+// Schema is the subset of an OpenAPI schema object mockway understands.
+// References are resolved after loading; use Resolve to follow them.
+type Schema struct {
+	Ref                  string             `yaml:"$ref"`
+	Type                 string             `yaml:"type"`
+	Format               string             `yaml:"format"`
+	Nullable             bool               `yaml:"nullable"`
+	Enum                 []any              `yaml:"enum"`
+	Default              any                `yaml:"default"`
+	Required             []string           `yaml:"required"`
+	Properties           map[string]*Schema `yaml:"properties"`
+	Items                *Schema            `yaml:"items"`
+	AdditionalProperties *Additional        `yaml:"additionalProperties"`
+
+	resolved *Schema
+}
+
+// Additional is an additionalProperties value: either a boolean or a schema.
+type Additional struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+func (a *Additional) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&a.Allowed)
+	}
+	a.Allowed = true
+	a.Schema = &Schema{}
+	return node.Decode(a.Schema)
+}
+
+// Resolve follows $ref chains and returns the concrete schema. It returns
+// the receiver when there is nothing to follow.
+func (s *Schema) Resolve() *Schema {
+	for i := 0; s != nil && s.resolved != nil && i < 32; i++ {
+		s = s.resolved
+	}
+	return s
+}
+
+// Parameter is a path or query parameter of an operation.
+type Parameter struct {
+	Name     string  `yaml:"name"`
+	In       string  `yaml:"in"`
+	Required bool    `yaml:"required"`
+	Schema   *Schema `yaml:"schema"`
+}
+
+type mediaType struct {
+	Schema *Schema `yaml:"schema"`
+}
+
+type body struct {
+	Required bool                 `yaml:"required"`
+	Content  map[string]mediaType `yaml:"content"`
+}
+
+// Operation is one method + path pair from a spec.
+type Operation struct {
+	Method      string
+	Path        string
+	OperationID string `yaml:"operationId"`
+	Parameters  []Parameter
+	// RequestBody is the JSON request schema, nil when the operation has no body.
+	RequestBody *Schema
+	// RequestBodyRequired mirrors requestBody.required.
+	RequestBodyRequired bool
+	// Responses maps status codes ("200", "204", ...) to their JSON schema
+	// (nil for bodiless responses).
+	Responses map[string]*Schema
+
+	segments []string
+}
+
+type rawOperation struct {
+	OperationID string          `yaml:"operationId"`
+	Parameters  []Parameter     `yaml:"parameters"`
+	RequestBody *body           `yaml:"requestBody"`
+	Responses   map[string]body `yaml:"responses"`
+}
+
+type document struct {
+	Paths      map[string]map[string]rawOperation `yaml:"paths"`
+	Components struct {
+		Schemas map[string]*Schema `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+// Catalog indexes every operation across the embedded specs.
+type Catalog struct {
+	ops     []*Operation
+	schemas map[string]*Schema
+}
+
+var (
+	loadOnce sync.Once
+	loaded   *Catalog
+	loadErr  error
+)
+
+// Load parses the embedded specs once and returns the shared catalog.
+func Load() (*Catalog, error) {
+	loadOnce.Do(func() {
+		loaded, loadErr = parse(files)
+	})
+	return loaded, loadErr
+}
+
+func parse(fsys fs.FS) (*Catalog, error) {
+	names, err := fs.Glob(fsys, "*.yml")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	c := &Catalog{schemas: map[string]*Schema{}}
+	for _, name := range names {
+		raw, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var doc document
+		if err := yaml.Unmarshal(raw, &doc); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", name, err)
+		}
+		for k, s := range doc.Components.Schemas {
+			c.schemas[k] = s
+		}
+		for path, methods := range doc.Paths {
+			for method, raw := range methods {
+				op := &Operation{
+					Method:      strings.ToUpper(method),
+					Path:        path,
+					OperationID: raw.OperationID,
+					Parameters:  raw.Parameters,
+					Responses:   map[string]*Schema{},
+					segments:    splitPath(path),
+				}
+				if raw.RequestBody != nil {
+					op.RequestBody = raw.RequestBody.Content["application/json"].Schema
+					op.RequestBodyRequired = raw.RequestBody.Required
+				}
+				for code, resp := range raw.Responses {
+					op.Responses[code] = resp.Content["application/json"].Schema
+				}
+				c.ops = append(c.ops, op)
+			}
+		}
+	}
+	for _, s := range c.schemas {
+		c.link(s, 0)
+	}
+	for _, op := range c.ops {
+		for _, p := range op.Parameters {
+			c.link(p.Schema, 0)
+		}
+		c.link(op.RequestBody, 0)
+		for _, s := range op.Responses {
+			c.link(s, 0)
+		}
+	}
+	sort.Slice(c.ops, func(i, j int) bool {
+		if c.ops[i].Path != c.ops[j].Path {
+			return c.ops[i].Path < c.ops[j].Path
+		}
+		return c.ops[i].Method < c.ops[j].Method
+	})
+	return c, nil
+}
+
+// link wires $ref pointers to the component schemas they name.
+func (c *Catalog) link(s *Schema, depth int) {
+	if s == nil || depth > 64 {
+		return
+	}
+	if s.Ref != "" {
+		s.resolved = c.schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		return
+	}
+	for _, p := range s.Properties {
+		c.link(p, depth+1)
+	}
+	c.link(s.Items, depth+1)
+	if s.AdditionalProperties != nil {
+		c.link(s.AdditionalProperties.Schema, depth+1)
+	}
+}
+
+// Operations returns every operation, sorted by path then method.
+func (c *Catalog) Operations() []*Operation { return c.ops }
+
+// Find returns the operation matching a concrete request path such as
+// /vpc/v2/regions/fr-par/private-networks. Literal segments win over
+// templated ones when several operations match.
+func (c *Catalog) Find(method, path string) *Operation {
+	segs := splitPath(path)
+	var best *Operation
+	bestLiterals := -1
+	for _, op := range c.ops {
+		if op.Method != method || len(op.segments) != len(segs) {
+			continue
+		}
+		literals, ok := matchSegments(op.segments, segs)
+		if ok && literals > bestLiterals {
+			best, bestLiterals = op, literals
+		}
+	}
+	return best
+}
+
+func splitPath(p string) []string {
+	return strings.Split(strings.Trim(p, "/"), "/")
+}
+
+func matchSegments(tmpl, segs []string) (int, bool) {
+	literals := 0
+	for i, t := range tmpl {
+		if strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}") {
+			if segs[i] == "" {
+				return 0, false
+			}
+			continue
+		}
+		if t != segs[i] {
+			return 0, false
+		}
+		literals++
+	}
+	return literals, true
+}
+
+// QueryParameters returns the operation's query parameters.
+func (op *Operation) QueryParameters() []Parameter {
+	var out []Parameter
+	for _, p := range op.Parameters {
+		if p.In == "query" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// SuccessResponse returns the schema of the first 2xx response, resolved.
+func (op *Operation) SuccessResponse() *Schema {
+	for _, code := range []string{"200", "201", "202"} {
+		if s, ok := op.Responses[code]; ok {
+			return s.Resolve()
+		}
+	}
+	return nil
+}
+
+// ListItems returns the key and item schema of a List operation's response,
+// e.g. ("private_networks", PrivateNetwork). ok is false for non-list
+// responses.
+func (op *Operation) ListItems() (key string, item *Schema, ok bool) {
+	if op.Method != http.MethodGet || !strings.HasPrefix(op.OperationID, "List") {
+		return "", nil, false
+	}
+	resp := op.SuccessResponse()
+	if resp == nil {
+		return "", nil, false
+	}
+	keys := make([]string, 0, len(resp.Properties))
+	for k := range resp.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := resp.Properties[k].Resolve()
+		if p.Type == "array" && p.Items != nil && p.Items.Resolve().Type == "object" {
+			return k, p.Items.Resolve(), true
+		}
+	}
+	return "", nil, false
+}
@@ -0,0 +1,137 @@
+package specs_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/redscaresu/mockway/specs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadAndFind(t *testing.T) {
+	c, err := specs.Load()
+	require.NoError(t, err)
+	require.NotEmpty(t, c.Operations())
+
+	op := c.Find(http.MethodGet, "/vpc/v2/regions/fr-par/private-networks")
+	require.NotNil(t, op)
+	require.Equal(t, "ListPrivateNetworks", op.OperationID)
+
+	names := map[string]bool{}
+	for _, p := range op.QueryParameters() {
+		names[p.Name] = true
+	}
+	require.True(t, names["name"])
+	require.True(t, names["tags"])
+	require.True(t, names["vpc_id"])
+
+	key, item, ok := op.ListItems()
+	require.True(t, ok)
+	require.Equal(t, "private_networks", key)
+	require.Contains(t, item.Properties, "vpc_id")
+
+	get := c.Find(http.MethodGet, "/vpc/v2/regions/fr-par/private-networks/some-id")
+	require.NotNil(t, get)
+	require.Equal(t, "GetPrivateNetwork", get.OperationID)
+	_, _, ok = get.ListItems()
+	require.False(t, ok)
+
+	require.Nil(t, c.Find(http.MethodGet, "/nope/v1/things"))
+}
+
+func TestFindPrefersLiteralSegments(t *testing.T) {
+	c, err := specs.Load()
+	require.NoError(t, err)
+
+	op := c.Find(http.MethodGet, "/instance/v1/zones/fr-par-1/products/servers")
+	require.NotNil(t, op)
+	require.Equal(t, "ListServersTypes", op.OperationID)
+}
+
+func TestSchemaRefsResolve(t *testing.T) {
+	c, err := specs.Load()
+	require.NoError(t, err)
+
+	op := c.Find(http.MethodGet, "/k8s/v1/regions/fr-par/clusters/abc")
+	require.NotNil(t, op)
+	resp := op.SuccessResponse()
+	require.NotNil(t, resp)
+	require.Equal(t, "object", resp.Type)
+	require.Equal(t, "string", resp.Properties["id"].Resolve().Type)
+}
+
+func TestSchemaValidate(t *testing.T) {
+	c, err := specs.Load()
+	require.NoError(t, err)
+
+	op := c.Find(http.MethodGet, "/vpc/v2/regions/fr-par/vpcs/abc")
+	require.NotNil(t, op)
+	schema := op.SuccessResponse()
+
+	require.Empty(t, schema.Validate(map[string]any{
+		"id":         "abc",
+		"name":       "v",
+		"tags":       []any{"a"},
+		"is_default": false,
+		"extra":      "unknown keys are allowed",
+	}))
+
+	violations := schema.Validate(map[string]any{
+		"name":       float64(3),
+		"tags":       []any{"a", true},
+		"is_default": nil,
+	})
+	require.Equal(t, []specs.Violation{
+		{Path: "$.is_default", Message: "null is not allowed for boolean", Reason: specs.ReasonConstraint},
+		{Path: "$.name", Message: "expected string, got integer", Reason: specs.ReasonConstraint},
+		{Path: "$.tags[1]", Message: "expected string, got boolean", Reason: specs.ReasonConstraint},
+	}, violations)
+
+	// Enums are checked on nested values too.
+	op = c.Find(http.MethodGet, "/instance/v1/zones/fr-par-1/servers/abc")
+	require.NotNil(t, op)
+	violations = op.SuccessResponse().Validate(map[string]any{"server": map[string]any{"state": "melting"}})
+	require.Len(t, violations, 1)
+	require.Equal(t, "$.server.state", violations[0].Path)
+}
+
+func TestValidateRequest(t *testing.T) {
+	c, err := specs.Load()
+	require.NoError(t, err)
+
+	op := c.Find(http.MethodPost, "/instance/v1/zones/fr-par-1/servers")
+	require.NotNil(t, op)
+	require.Empty(t, op.RequestBody.ValidateRequest(map[string]any{
+		"name":            "web",
+		"commercial_type": "DEV1-S",
+		"volumes":         map[string]any{"0": map[string]any{"size": float64(20000000000), "volume_type": "l_ssd"}},
+		"tags":            nil,
+	}))
+
+	violations := op.RequestBody.ValidateRequest(map[string]any{
+		"name":      "web",
+		"boot_type": "sideways",
+		"bogus":     true,
+		"volumes":   map[string]any{"0": map[string]any{"size": float64(-1)}},
+	})
+	reasons := map[string]string{}
+	for _, v := range violations {
+		reasons[v.Path] = v.Reason
+	}
+	require.Equal(t, map[string]string{
+		"$.commercial_type": specs.ReasonRequired,
+		"$.bogus":           specs.ReasonUnknown,
+		"$.boot_type":       specs.ReasonConstraint,
+		"$.volumes.0.size":  specs.ReasonConstraint,
+	}, reasons)
+
+	// Query parameters are parsed to their declared type first.
+	op = c.Find(http.MethodGet, "/k8s/v1/regions/fr-par/clusters")
+	require.NotNil(t, op)
+	require.Empty(t, op.ValidateQuery(url.Values{"order_by": {"name_asc"}, "page": {"2"}, "per_page_alias": {"x"}}))
+	violations = op.ValidateQuery(url.Values{"order_by": {"sideways"}, "page": {"two"}})
+	require.Len(t, violations, 2)
+	require.Equal(t, "$.order_by", violations[0].Path)
+	require.Equal(t, "$.page", violations[1].Path)
+}
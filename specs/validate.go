@@ -0,0 +1,264 @@
+package specs
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Violation is one place where a JSON value does not match its schema.
+// Path is a JSONPath-like location such as $.servers[0].state. Reason
+// classifies it the way Scaleway's invalid_arguments details do: required,
+// unknown, constraint (type, enum, range) or format.
+type Violation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+}
+
+// Violation reasons.
+const (
+	ReasonRequired   = "required"
+	ReasonUnknown    = "unknown"
+	ReasonConstraint = "constraint"
+	ReasonFormat     = "format"
+)
+
+// maxViolations bounds how much a single badly shaped payload can report.
+const maxViolations = 50
+
+// validator walks a value against a schema. In request mode it is stricter
+// than the response check: keys the schema does not declare are rejected,
+// formats (date-time, integer widths) are checked, and null stands for an
+// omitted optional field.
+type validator struct {
+	request bool
+	out     []Violation
+}
+
+// Validate checks a decoded JSON value against the schema: types, required
+// keys, enums, nested objects and array items. Unknown keys are allowed
+// unless additionalProperties is false.
+func (s *Schema) Validate(v any) []Violation {
+	vd := &validator{}
+	vd.validate(s, "$", v, 0)
+	return vd.out
+}
+
+// ValidateRequest checks a decoded request body the way the real API does:
+// like Validate, but also rejecting undeclared keys and malformed formats.
+func (s *Schema) ValidateRequest(v any) []Violation {
+	vd := &validator{request: true}
+	vd.validate(s, "$", v, 0)
+	return vd.out
+}
+
+// ValidateQuery checks the query string against the operation's declared
+// query parameters: required ones must be present, and values must parse as
+// the parameter type and satisfy its enum and format. Undeclared parameters
+// are ignored, since clients routinely send pagination aliases.
+func (op *Operation) ValidateQuery(q url.Values) []Violation {
+	vd := &validator{request: true}
+	for _, p := range op.QueryParameters() {
+		path := "$." + p.Name
+		raw, present := q[p.Name]
+		if !present || len(raw) == 0 {
+			if p.Required {
+				vd.add(path, ReasonRequired, "required parameter is missing")
+			}
+			continue
+		}
+		s := p.Schema.Resolve()
+		if s == nil {
+			continue
+		}
+		if s.Type == "array" {
+			for i, r := range raw {
+				item := s.Items.Resolve()
+				if v, ok := vd.parseQueryValue(item, fmt.Sprintf("%s[%d]", path, i), r); ok {
+					vd.validate(item, fmt.Sprintf("%s[%d]", path, i), v, 0)
+				}
+			}
+			continue
+		}
+		if v, ok := vd.parseQueryValue(s, path, raw[0]); ok {
+			vd.validate(s, path, v, 0)
+		}
+	}
+	return vd.out
+}
+
+// parseQueryValue converts a query string value to the JSON type its schema
+// declares.
+func (vd *validator) parseQueryValue(s *Schema, path, raw string) (any, bool) {
+	if s == nil {
+		return raw, true
+	}
+	switch s.Type {
+	case "integer", "number":
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			vd.add(path, ReasonConstraint, "expected %s, got %q", s.Type, raw)
+			return nil, false
+		}
+		return f, true
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			vd.add(path, ReasonConstraint, "expected boolean, got %q", raw)
+			return nil, false
+		}
+		return b, true
+	default:
+		return raw, true
+	}
+}
+
+func (vd *validator) add(path, reason, format string, args ...any) {
+	if len(vd.out) >= maxViolations {
+		return
+	}
+	vd.out = append(vd.out, Violation{Path: path, Message: fmt.Sprintf(format, args...), Reason: reason})
+}
+
+func (vd *validator) validate(s *Schema, path string, v any, depth int) {
+	s = s.Resolve()
+	if s == nil || depth > 64 || len(vd.out) >= maxViolations {
+		return
+	}
+	if v == nil {
+		if !vd.request && !s.Nullable && s.Type != "" {
+			vd.add(path, ReasonConstraint, "null is not allowed for %s", s.Type)
+		}
+		return
+	}
+	if got := jsonType(v); s.Type != "" && !typeMatches(s.Type, v) {
+		vd.add(path, ReasonConstraint, "expected %s, got %s", s.Type, got)
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		vd.add(path, ReasonConstraint, "%v is not one of %v", v, s.Enum)
+	}
+	if vd.request {
+		vd.checkFormat(s, path, v)
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		for _, key := range s.Required {
+			if val, ok := t[key]; !ok || (vd.request && val == nil) {
+				vd.add(path+"."+key, ReasonRequired, "required key is missing")
+			}
+		}
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := path + "." + k
+			if prop, ok := s.Properties[k]; ok {
+				vd.validate(prop, child, t[k], depth+1)
+				continue
+			}
+			if prop := placeholderProperty(s); prop != nil {
+				vd.validate(prop, child, t[k], depth+1)
+				continue
+			}
+			ap := s.AdditionalProperties
+			switch {
+			case ap != nil && !ap.Allowed:
+				vd.add(child, ReasonUnknown, "unexpected key")
+			case ap != nil && ap.Schema != nil:
+				vd.validate(ap.Schema, child, t[k], depth+1)
+			case ap == nil && vd.request && len(s.Properties) > 0:
+				vd.add(child, ReasonUnknown, "unknown field")
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range t {
+				vd.validate(s.Items, fmt.Sprintf("%s[%d]", path, i), item, depth+1)
+			}
+		}
+	}
+}
+
+// placeholderProperty returns the value schema of a map the specs describe
+// with a single templated key, e.g. volumes: {"<volumeKey>": {...}}.
+func placeholderProperty(s *Schema) *Schema {
+	if len(s.Properties) != 1 {
+		return nil
+	}
+	for k, prop := range s.Properties {
+		if strings.HasPrefix(k, "<") && strings.HasSuffix(k, ">") {
+			return prop
+		}
+	}
+	return nil
+}
+
+// intRanges are the bounds of the integer formats used by the specs.
+var intRanges = map[string][2]float64{
+	"int32":  {math.MinInt32, math.MaxInt32},
+	"uint32": {0, math.MaxUint32},
+	"int64":  {math.MinInt64, math.MaxInt64},
+	"uint64": {0, math.MaxUint64},
+}
+
+func (vd *validator) checkFormat(s *Schema, path string, v any) {
+	switch t := v.(type) {
+	case string:
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, t); err != nil {
+				vd.add(path, ReasonFormat, "%q is not an RFC 3339 date-time", t)
+			}
+		}
+	case float64:
+		if r, ok := intRanges[s.Format]; ok && (t < r[0] || t > r[1]) {
+			vd.add(path, ReasonConstraint, "%v is out of range for %s", t, s.Format)
+		}
+	}
+}
+
+func jsonType(v any) string {
+	switch t := v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if t == math.Trunc(t) {
+			return "integer"
+		}
+		return "number"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func typeMatches(want string, v any) bool {
+	got := jsonType(v)
+	switch want {
+	case "number":
+		return got == "number" || got == "integer"
+	default:
+		return got == want
+	}
+}
+
+func inEnum(enum []any, v any) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 
@@ -19,10 +20,16 @@ func NewTestServer(t *testing.T) (*httptest.Server, func()) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	return NewTestServerFor(t, repo)
+}
+
+// NewTestServerFor is NewTestServer serving repo; cleanup closes it.
+func NewTestServerFor(t *testing.T, repo *repository.Repository) (*httptest.Server, func()) {
+	t.Helper()
 	app := handlers.NewApplication(repo)
 	r := chi.NewRouter()
 	app.RegisterRoutes(r)
-	r.NotFound(handlers.UnimplementedHandler)
+	r.NotFound(app.Fallback)
 	r.MethodNotAllowed(handlers.UnimplementedHandler)
 	ts := httptest.NewServer(r)
 	cleanup := func() {
@@ -87,6 +94,46 @@ func RestoreState(t *testing.T, ts *httptest.Server) {
 	}
 }
 
+// CreateSnapshot saves the current state as the named snapshot (replacing
+// one of the same name) and returns its metadata.
+func CreateSnapshot(t *testing.T, ts *httptest.Server, name string) map[string]any {
+	t.Helper()
+	status, body := doJSON(t, ts, http.MethodPost, "/mock/snapshots/"+url.PathEscape(name), nil)
+	if status != http.StatusCreated {
+		t.Fatalf("create snapshot %q: expected 201, got %d: %v", name, status, body)
+	}
+	return body
+}
+
+// RestoreSnapshot replaces the current state with the named snapshot.
+func RestoreSnapshot(t *testing.T, ts *httptest.Server, name string) {
+	t.Helper()
+	status, body := doJSON(t, ts, http.MethodPost, "/mock/snapshots/"+url.PathEscape(name)+"/restore", nil)
+	if status != http.StatusNoContent {
+		t.Fatalf("restore snapshot %q: expected 204, got %d: %v", name, status, body)
+	}
+}
+
+// DeleteSnapshot removes the named snapshot.
+func DeleteSnapshot(t *testing.T, ts *httptest.Server, name string) {
+	t.Helper()
+	status, body := doJSON(t, ts, http.MethodDelete, "/mock/snapshots/"+url.PathEscape(name), nil)
+	if status != http.StatusNoContent {
+		t.Fatalf("delete snapshot %q: expected 204, got %d: %v", name, status, body)
+	}
+}
+
+// ListSnapshots returns the metadata of every named snapshot, oldest first.
+func ListSnapshots(t *testing.T, ts *httptest.Server) []any {
+	t.Helper()
+	status, body := doJSON(t, ts, http.MethodGet, "/mock/snapshots", nil)
+	if status != http.StatusOK {
+		t.Fatalf("list snapshots: expected 200, got %d", status)
+	}
+	items, _ := body["snapshots"].([]any)
+	return items
+}
+
 func GetState(t *testing.T, ts *httptest.Server) map[string]any {
 	t.Helper()
 	status, body := doJSON(t, ts, http.MethodGet, "/mock/state", nil)
@@ -96,7 +143,23 @@ func GetState(t *testing.T, ts *httptest.Server) map[string]any {
 	return body
 }
 
+// DoWithToken sends a request with the given X-Auth-Token instead of the
+// default test token. An empty token sends no header at all.
+func DoWithToken(t *testing.T, ts *httptest.Server, method, path, token string, body any) (int, map[string]any) {
+	t.Helper()
+	return doJSONWithToken(t, ts, method, path, token, body)
+}
+
 func doJSON(t *testing.T, ts *httptest.Server, method, path string, payload any) (int, map[string]any) {
+	t.Helper()
+	token := ""
+	if !strings.HasPrefix(path, "/mock/") {
+		token = "test-token"
+	}
+	return doJSONWithToken(t, ts, method, path, token, payload)
+}
+
+func doJSONWithToken(t *testing.T, ts *httptest.Server, method, path, token string, payload any) (int, map[string]any) {
 	t.Helper()
 	var bodyBytes []byte
 	if payload != nil {
@@ -114,8 +177,8 @@ func doJSON(t *testing.T, ts *httptest.Server, method, path string, payload any)
 	if payload != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
-	if !strings.HasPrefix(path, "/mock/") {
-		req.Header.Set("X-Auth-Token", "test-token")
+	if token != "" {
+		req.Header.Set("X-Auth-Token", token)
 	}
 
 	resp, err := http.DefaultClient.Do(req)